@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchVideoExts the extensions of the files picked up from a batch directory
+var batchVideoExts = map[string]bool{
+	".mp4":  true,
+	".mov":  true,
+	".m4v":  true,
+	".avi":  true,
+	".mkv":  true,
+	".webm": true,
+}
+
+// batchColumns the columns a batch list can have, other options apply to every job
+var batchColumns = []string{"input", "identifier", "title", "subtitle", "start"}
+
+// batchFlags flags that aren't passed on to the jobs of a batch, each job is given its own
+// input and output, the job file has already been applied and the flags that do nothing but
+// report are handled, or refused, before the batch starts
+var batchFlags = map[string]bool{
+	"batch":     true,
+	"batchjobs": true,
+	"input":     true,
+	"output":    true,
+	"job":       true,
+	"dump-job":  true,
+	"verifydir": true,
+	"version":   true,
+}
+
+// batchRow a video in a batch and the options that are different for it, empty options are
+// left as they are for the whole batch
+type batchRow struct {
+	Input      string `json:"input"`
+	Identifier string `json:"identifier"`
+	Title      string `json:"title"`
+	Subtitle   string `json:"subtitle"`
+	Start      *int   `json:"start"`
+}
+
+// batchResult how a job of the batch went, written to batch.json
+type batchResult struct {
+	Identifier string  `json:"identifier"`
+	Input      string  `json:"input"`
+	Output     string  `json:"output"`
+	OK         bool    `json:"ok"`
+	Error      string  `json:"error,omitempty"`
+	Seconds    float64 `json:"seconds"`
+	Log        string  `json:"log"`
+}
+
+// batchReport the summary of a batch, written to batch.json in the batch output directory
+type batchReport struct {
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Jobs      []batchResult `json:"jobs"`
+}
+
+// loadBatch returns the videos of a batch from a directory of videos, or a .csv or .json list.
+// Relative inputs in a list are relative to the directory of the list. Each video is made
+// into a book named by its identifier, which is the file name of the video if not given
+func loadBatch(p string) ([]batchRow, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batch: %s", err)
+	}
+
+	var rows []batchRow
+	if fi.IsDir() {
+		files, err := ioutil.ReadDir(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read batch directory: %s, %s", p, err)
+		}
+		for _, f := range files {
+			if f.IsDir() || !batchVideoExts[strings.ToLower(filepath.Ext(f.Name()))] {
+				continue
+			}
+			rows = append(rows, batchRow{Input: path.Join(p, f.Name())})
+		}
+		if len(rows) == 0 {
+			return nil, fmt.Errorf("no videos found in batch directory: %s", p)
+		}
+	} else {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read batch list: %s", err)
+		}
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".csv":
+			rows, err = parseBatchCSV(b)
+		case ".json":
+			dec := json.NewDecoder(bytes.NewReader(b))
+			dec.DisallowUnknownFields()
+			err = dec.Decode(&rows)
+		default:
+			return nil, fmt.Errorf("batch list must be a .csv or .json file: %s", p)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid batch list: %s, %s", p, err)
+		}
+		if len(rows) == 0 {
+			return nil, fmt.Errorf("no videos listed in batch list: %s", p)
+		}
+		for i := range rows {
+			if rows[i].Input == "" {
+				return nil, fmt.Errorf("invalid batch list: %s, row %d has no input", p, i+1)
+			}
+			if !filepath.IsAbs(rows[i].Input) {
+				rows[i].Input = path.Join(filepath.Dir(p), rows[i].Input)
+			}
+		}
+	}
+
+	seen := make(map[string]int)
+	for i := range rows {
+		r := &rows[i]
+		if r.Identifier == "" {
+			r.Identifier = strings.TrimSuffix(filepath.Base(r.Input), filepath.Ext(r.Input))
+		}
+		if strings.ContainsAny(r.Identifier, `/\`) || r.Identifier == "." || r.Identifier == ".." {
+			return nil, fmt.Errorf("row %d, identifier %q can't be used as a directory name", i+1, r.Identifier)
+		}
+		if j, ok := seen[r.Identifier]; ok {
+			return nil, fmt.Errorf("rows %d and %d have the same identifier %q, each book needs its own", j+1, i+1, r.Identifier)
+		}
+		seen[r.Identifier] = i
+		if r.Start != nil && *r.Start < 0 {
+			return nil, fmt.Errorf("row %d, start must not be negative, %d invalid value", i+1, *r.Start)
+		}
+	}
+	return rows, nil
+}
+
+// parseBatchCSV reads a CSV list with a header row naming the columns
+func parseBatchCSV(b []byte) ([]batchRow, error) {
+	r := csv.NewReader(bytes.NewReader(b))
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("missing header row")
+	}
+
+	header := records[0]
+	for i, c := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(c))
+		known := false
+		for _, k := range batchColumns {
+			known = known || header[i] == k
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown column %q, columns can be %s", c, strings.Join(batchColumns, ", "))
+		}
+	}
+
+	var rows []batchRow
+	for n, record := range records[1:] {
+		var row batchRow
+		for i, v := range record {
+			v = strings.TrimSpace(v)
+			switch header[i] {
+			case "input":
+				row.Input = v
+			case "identifier":
+				row.Identifier = v
+			case "title":
+				row.Title = v
+			case "subtitle":
+				row.Subtitle = v
+			case "start":
+				if v == "" {
+					continue
+				}
+				start, err := strconv.Atoi(v)
+				if err != nil {
+					return nil, fmt.Errorf("line %d, start must be a whole number of seconds, %s invalid value", n+2, v)
+				}
+				row.Start = &start
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// batchArgs returns the command line of the job for the row. Every option set for the batch,
+// on the command line or by the job file, is passed on, followed by the options of the row
+// which override them
+func batchArgs(row batchRow, outputDir string) []string {
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if !batchFlags[f.Name] {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	args = append(args, "-input="+row.Input, "-identifier="+row.Identifier, "-output="+outputDir)
+	if row.Title != "" {
+		args = append(args, "-line1text="+row.Title)
+	}
+	if row.Subtitle != "" {
+		args = append(args, "-line2text="+row.Subtitle)
+	}
+	if row.Start != nil {
+		args = append(args, "-starttime="+strconv.Itoa(*row.Start))
+	}
+	return args
+}
+
+// runBatch makes a book from each row, running up to nJobs at a time. Each job runs as its own
+// fbconvert process in its own directory under output, named by its identifier, with its
+// output written to a log file beside it. A summary is written to batch.json in output, and
+// an error is returned if any of the jobs failed
+func runBatch(rows []batchRow, output string, nJobs int, infoLog *log.Logger) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find fbconvert executable: %s", err)
+	}
+	err = os.MkdirAll(output, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create output dir: %s, %s", output, err)
+	}
+
+	results := make([]batchResult, len(rows))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < nJobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = runBatchJob(exe, rows[i], output)
+				if results[i].OK {
+					infoLog.Printf("%s done in %.0fs", results[i].Identifier, results[i].Seconds)
+				} else {
+					infoLog.Printf("%s failed: %s", results[i].Identifier, results[i].Error)
+				}
+			}
+		}()
+	}
+	for i := range rows {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	report := batchReport{Jobs: results}
+	for _, r := range results {
+		if r.OK {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+	}
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	p := path.Join(output, "batch.json")
+	err = ioutil.WriteFile(p, b, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write batch report: %s, %s", p, err)
+	}
+
+	infoLog.Printf("%d of %d books made, report written to %s", report.Succeeded, len(rows), p)
+	if report.Failed > 0 {
+		var failed []string
+		for _, r := range results {
+			if !r.OK {
+				failed = append(failed, r.Identifier)
+			}
+		}
+		sort.Strings(failed)
+		return fmt.Errorf("%d of %d books failed: %s", report.Failed, len(rows), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// runBatchJob runs fbconvert for a single row of the batch
+func runBatchJob(exe string, row batchRow, output string) (res batchResult) {
+	dir := path.Join(output, row.Identifier)
+	res = batchResult{
+		Identifier: row.Identifier,
+		Input:      row.Input,
+		Output:     dir,
+		Log:        path.Join(output, row.Identifier+".log"),
+	}
+	start := time.Now()
+	defer func() {
+		res.Seconds = time.Since(start).Seconds()
+	}()
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		res.Error = fmt.Sprintf("failed to create output dir: %s, %s", dir, err)
+		return res
+	}
+	logFile, err := os.Create(res.Log)
+	if err != nil {
+		res.Error = fmt.Sprintf("failed to create log: %s, %s", res.Log, err)
+		return res
+	}
+	defer logFile.Close()
+
+	var out bytes.Buffer
+	cmd := exec.Command(exe, batchArgs(row, dir)...)
+	cmd.Stdout = io.MultiWriter(logFile, &out)
+	cmd.Stderr = cmd.Stdout
+	err = cmd.Run()
+	if err != nil {
+		res.Error = lastError(out.String())
+		if res.Error == "" {
+			res.Error = err.Error()
+		}
+		return res
+	}
+	res.OK = true
+	return res
+}
+
+// lastError returns the last error logged by a job, without its prefix
+func lastError(output string) string {
+	msg := ""
+	s := bufio.NewScanner(strings.NewReader(output))
+	for s.Scan() {
+		if line := s.Text(); strings.HasPrefix(line, "ERR: ") {
+			msg = strings.TrimPrefix(line, "ERR: ")
+		}
+	}
+	return msg
+}
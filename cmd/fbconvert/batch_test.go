@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadBatchDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Only videos are picked up, whatever the case of their extension
+	for _, name := range []string{"b.MOV", "a.mp4", "notes.txt"} {
+		if err := ioutil.WriteFile(path.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(path.Join(dir, "c.mkv"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := loadBatch(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []batchRow{
+		{Input: path.Join(dir, "a.mp4"), Identifier: "a"},
+		{Input: path.Join(dir, "b.MOV"), Identifier: "b"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("got %+v, want %+v", rows, want)
+	}
+
+	empty := path.Join(dir, "c.mkv")
+	_, err = loadBatch(empty)
+	if want := "no videos found in batch directory: " + empty; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	_, err = loadBatch(path.Join(dir, "missing"))
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to read batch: ") {
+		t.Errorf("got error %v, want the batch to fail to read", err)
+	}
+}
+
+func TestLoadBatchList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	zero, ten := 0, 10
+	tests := []struct {
+		file string
+		text string
+		want []batchRow
+		err  string
+	}{
+		{
+			// Relative inputs are relative to the list, and the identifier defaults to the
+			// file name of the video
+			"list.csv",
+			"Input, identifier,title,subtitle,start\nvideos/a.mp4,,Our trip,2024,10\n/abs/b.mov,bee,,,\nc.mp4,,,,0\n",
+			[]batchRow{
+				{Input: path.Join(dir, "videos/a.mp4"), Identifier: "a", Title: "Our trip", Subtitle: "2024", Start: &ten},
+				{Input: "/abs/b.mov", Identifier: "bee"},
+				{Input: path.Join(dir, "c.mp4"), Identifier: "c", Start: &zero},
+			},
+			"",
+		},
+		{
+			"list.json",
+			`[{"input": "a.mp4", "title": "Our trip", "start": 10}, {"input": "/abs/b.mov", "identifier": "bee"}]`,
+			[]batchRow{
+				{Input: path.Join(dir, "a.mp4"), Identifier: "a", Title: "Our trip", Start: &ten},
+				{Input: "/abs/b.mov", Identifier: "bee"},
+			},
+			"",
+		},
+		{"typo.json", `[{"input": "a.mp4", "titel": "Our trip"}]`, nil, `invalid batch list: DIR/typo.json, json: unknown field "titel"`},
+		{"list.txt", "a.mp4\n", nil, "batch list must be a .csv or .json file: DIR/list.txt"},
+		{"empty.json", "[]", nil, "no videos listed in batch list: DIR/empty.json"},
+		{"header.csv", "input,title\n", nil, "no videos listed in batch list: DIR/header.csv"},
+		{"noinput.csv", "input,title\na.mp4,A\n,B\n", nil, "invalid batch list: DIR/noinput.csv, row 2 has no input"},
+		{"same.csv", "input,identifier\na.mp4,\nother/a.mov,\n", nil, `rows 1 and 2 have the same identifier "a", each book needs its own`},
+		{"slash.csv", "input,identifier\na.mp4,a/b\n", nil, `row 1, identifier "a/b" can't be used as a directory name`},
+		{"dots.csv", "input,identifier\na.mp4,..\n", nil, `row 1, identifier ".." can't be used as a directory name`},
+		{"negative.json", `[{"input": "a.mp4", "start": -1}]`, nil, "row 1, start must not be negative, -1 invalid value"},
+		{"column.csv", "input,titel\na.mp4,A\n", nil, `invalid batch list: DIR/column.csv, unknown column "titel", columns can be input, identifier, title, subtitle, start`},
+		{"start.csv", "input,start\na.mp4,1\nb.mp4,1.5\n", nil, "invalid batch list: DIR/start.csv, line 3, start must be a whole number of seconds, 1.5 invalid value"},
+		{"blank.csv", "", nil, "invalid batch list: DIR/blank.csv, missing header row"},
+	}
+	for _, tt := range tests {
+		p := path.Join(dir, tt.file)
+		if err := ioutil.WriteFile(p, []byte(tt.text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := loadBatch(p)
+		if tt.err != "" {
+			want := strings.Replace(tt.err, "DIR", dir, 1)
+			if err == nil || err.Error() != want {
+				t.Errorf("%s: got error %v, want %q", tt.file, err, want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.file, err)
+			continue
+		}
+		if !reflect.DeepEqual(rows, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.file, rows, tt.want)
+		}
+	}
+}
+
+func TestBatchArgs(t *testing.T) {
+	defer testFlags()()
+
+	// The batch's own options aren't passed on, each job is given its own input and output
+	for name, v := range map[string]string{"fps": "12", "clean": "true", "label": "{index}", "input": "/videos", "batch": "/videos", "batchjobs": "4"} {
+		if err := flag.Set(name, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := 5
+	got := batchArgs(batchRow{Input: "/videos/a.mp4", Identifier: "a", Title: "Our trip", Subtitle: "2024", Start: &start}, "/books/a")
+	want := []string{
+		"-clean=true", "-fps=12", "-label={index}",
+		"-input=/videos/a.mp4", "-identifier=a", "-output=/books/a",
+		"-line1text=Our trip", "-line2text=2024", "-starttime=5",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// Empty options of a row leave the batch's options as they are
+	got = batchArgs(batchRow{Input: "/videos/b.mp4", Identifier: "b"}, "/books/b")
+	want = []string{"-clean=true", "-fps=12", "-label={index}", "-input=/videos/b.mp4", "-identifier=b", "-output=/books/b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestLastError(t *testing.T) {
+	out := "INFO: extracting\nERR: failed to extract frames: exit status 1\nERR: flag provided but not defined\n  -bgcolor string\n"
+	if got, want := lastError(out), "flag provided but not defined"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := lastError("INFO: All done\n"); got != "" {
+		t.Errorf("got %q, want no error", got)
+	}
+}
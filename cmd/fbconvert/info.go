@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/markdaws/go-flipbook/pkg/composite"
+	"github.com/markdaws/go-flipbook/pkg/ffmpeg"
+	"github.com/markdaws/go-flipbook/pkg/plan"
+)
+
+// jobInfo is written to info.json in the output directory once the job completes, it is a
+// manifest of everything that went into the book and every file that came out, so other tools
+// can work from it rather than the file names
+type jobInfo struct {
+	Version       string                `json:"version,omitempty"`
+	Input         *inputInfo            `json:"input,omitempty"`
+	TimeRange     *timeRange            `json:"timeRange,omitempty"`
+	Layout        *layoutInfo           `json:"layout,omitempty"`
+	Pipeline      []pipelineStep        `json:"pipeline,omitempty"`
+	NFrames       int                   `json:"nFrames"`
+	FrameAR       float64               `json:"frameAR"`
+	Plan          *plan.Plan            `json:"plan,omitempty"`
+	SceneCuts     []int                 `json:"sceneCuts,omitempty"`
+	DroppedFrames []string              `json:"droppedFrames,omitempty"`
+	TrimmedFrames []string              `json:"trimmedFrames,omitempty"`
+	Identifier    string                `json:"identifier,omitempty"`
+	FPS           float64               `json:"fps,omitempty"`
+	SheetWidth    int                   `json:"sheetWidth,omitempty"`
+	SheetHeight   int                   `json:"sheetHeight,omitempty"`
+	ReversePages  bool                  `json:"reversePages,omitempty"`
+	ReverseFrames bool                  `json:"reverseFrames,omitempty"`
+	Duplex        string                `json:"duplex,omitempty"`
+	Sheets        []composite.SheetInfo `json:"sheets,omitempty"`
+	Outputs       []outputInfo          `json:"outputs,omitempty"`
+}
+
+// inputInfo the video the frames were extracted from
+type inputInfo struct {
+	Path string `json:"path"`
+
+	// Probe what ffprobe reports about the video, nil if ffprobe isn't installed
+	Probe *ffmpeg.ProbeInfo `json:"probe,omitempty"`
+}
+
+// timeRange the part of the video the frames were extracted from, in seconds
+type timeRange struct {
+	Start  float64 `json:"start"`
+	Length float64 `json:"length"`
+}
+
+// layoutInfo how the frames are laid out on the sheets
+type layoutInfo struct {
+	Name           string         `json:"name"`
+	Page           composite.Page `json:"page"`
+	FramesPerSheet int            `json:"framesPerSheet"`
+	Orientation    string         `json:"orientation"`
+	SheetFormat    string         `json:"sheetFormat"`
+}
+
+// pipelineStep a step that changed the frames, in the order the steps were applied
+type pipelineStep struct {
+	Name     string      `json:"name"`
+	Settings interface{} `json:"settings,omitempty"`
+}
+
+// frameSteps the pipeline steps that rewrite or remove the frame files, rather than only
+// picking which frames are printed
+var frameSteps = map[string]bool{
+	"stabilize": true,
+	"select":    true,
+	"lut":       true,
+	"adjust":    true,
+	"effect":    true,
+}
+
+// isLUTStep returns true if the step graded the frames with a LUT, either before the
+// adjustments or as the lut effect
+func isLUTStep(step pipelineStep) bool {
+	if step.Name == "lut" {
+		return true
+	}
+	settings, ok := step.Settings.(map[string]interface{})
+	return ok && step.Name == "effect" && settings["name"] == composite.EffectLUT
+}
+
+// outputInfo a file written to the output directory, with its checksum so copies can be checked
+type outputInfo struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// renderInfo returns the parts of the job needed to verify its sheets
+func (j jobInfo) renderInfo() composite.RenderInfo {
+	return composite.RenderInfo{
+		NFrames:       j.NFrames,
+		FrameAR:       j.FrameAR,
+		Sheets:        j.Sheets,
+		SheetWidth:    j.SheetWidth,
+		SheetHeight:   j.SheetHeight,
+		ReversePages:  j.ReversePages,
+		ReverseFrames: j.ReverseFrames,
+		Duplex:        j.Duplex,
+	}
+}
+
+// checksumOutputs returns the size and SHA-256 of each of the named files in dir
+func checksumOutputs(dir string, names []string) ([]outputInfo, error) {
+	var outputs []outputInfo
+	for _, name := range names {
+		p := path.Join(dir, name)
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open output: %s, %s", p, err)
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read output: %s, %s", p, err)
+		}
+		outputs = append(outputs, outputInfo{
+			Name:   name,
+			Size:   n,
+			SHA256: hex.EncodeToString(h.Sum(nil)),
+		})
+	}
+	return outputs, nil
+}
+
+func readInfo(path string) (jobInfo, error) {
+	var info jobInfo
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(b, &info)
+	return info, err
+}
+
+func writeInfo(path string, info jobInfo) error {
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(path, b, 0644)
+	return err
+}
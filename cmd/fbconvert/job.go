@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// jobKey a key of a job file and the flag it sets, keys in a section are written
+// section.key. Keys that are lists are joined with commas, the way the flag takes them. Keys
+// that are paths are relative to the directory of the job file, not the working directory
+type jobKey struct {
+	key  string
+	flag string
+	list bool
+	path bool
+}
+
+// jobSchema every key a job file can have, in the order they are written by -dump-job
+var jobSchema = []jobKey{
+	{key: "input", flag: "input", path: true},
+	{key: "output", flag: "output", path: true},
+	{key: "identifier", flag: "identifier"},
+	{key: "skipVideo", flag: "skipvideo"},
+	{key: "clean", flag: "clean"},
+	{key: "cleanFrames", flag: "cleanframes"},
+
+	{key: "time.start", flag: "starttime"},
+	{key: "time.length", flag: "maxlength"},
+	{key: "time.fps", flag: "fps"},
+	{key: "time.timing", flag: "timing", path: true},
+
+	{key: "plan.sheets", flag: "sheets"},
+	{key: "plan.frames", flag: "frames"},
+	{key: "plan.thickness", flag: "thickness"},
+	{key: "plan.paperThickness", flag: "paperthickness"},
+
+	{key: "layout.name", flag: "layout"},
+	{key: "layout.margins", flag: "margins", list: true},
+	{key: "layout.orientation", flag: "orientation"},
+	{key: "layout.bgColor", flag: "bgcolor"},
+	{key: "layout.duplex", flag: "duplex"},
+	{key: "layout.duplexPdf", flag: "duplexpdf"},
+	{key: "layout.reversePages", flag: "reversepages"},
+	{key: "layout.reverseFrames", flag: "reverseframes"},
+
+	{key: "sheets.format", flag: "sheetformat"},
+	{key: "sheets.name", flag: "sheetname"},
+	{key: "sheets.frameName", flag: "framename"},
+	{key: "sheets.jpegQuality", flag: "jpegquality"},
+	{key: "sheets.chromaSubsampling", flag: "chromasubsampling"},
+	{key: "sheets.bitDepth", flag: "bitdepth"},
+	{key: "sheets.colorProfile", flag: "colorprofile"},
+	{key: "sheets.cmyk", flag: "cmyk"},
+	{key: "sheets.outputProfile", flag: "outputprofile", path: true},
+
+	{key: "frames.stabilize", flag: "stabilize"},
+	{key: "frames.stabilizeSmoothing", flag: "stabilizesmoothing"},
+	{key: "frames.select", flag: "selectframes"},
+	{key: "frames.dedupe", flag: "dedupe"},
+	{key: "frames.sceneCut", flag: "scenecut"},
+	{key: "frames.stopAtCut", flag: "stopatcut"},
+
+	{key: "effects.effect", flag: "effect"},
+	{key: "effects.lut", flag: "lut", path: true},
+	{key: "effects.lutInterp", flag: "lutinterp"},
+	{key: "effects.brightness", flag: "brightness"},
+	{key: "effects.contrast", flag: "contrast"},
+	{key: "effects.gamma", flag: "gamma"},
+	{key: "effects.saturation", flag: "saturation"},
+	{key: "effects.levels", flag: "levels", list: true},
+	{key: "effects.autoLevels", flag: "autolevels"},
+	{key: "effects.normalize", flag: "normalize"},
+	{key: "effects.grayscale", flag: "grayscale"},
+	{key: "effects.sepia", flag: "sepia"},
+
+	{key: "fonts.path", flag: "fontpath", path: true},
+	{key: "fonts.fallback", flag: "fallbackfonts", list: true, path: true},
+
+	{key: "cover.enabled", flag: "cover"},
+	{key: "cover.template", flag: "covertemplate", path: true},
+	{key: "cover.name", flag: "covername"},
+	{key: "cover.line1", flag: "line1text"},
+	{key: "cover.line2", flag: "line2text"},
+	{key: "cover.encoded", flag: "titleencoded"},
+
+	{key: "backCover.enabled", flag: "backcover"},
+	{key: "backCover.template", flag: "backcovertemplate", path: true},
+
+	{key: "pages.blank", flag: "blankpages"},
+	{key: "pages.credits", flag: "credits", path: true},
+
+	{key: "labels.text", flag: "label"},
+	{key: "labels.font", flag: "labelfont", path: true},
+	{key: "labels.size", flag: "labelsize"},
+	{key: "labels.color", flag: "labelcolor"},
+	{key: "labels.rotation", flag: "labelrotation"},
+
+	{key: "captions.path", flag: "captions", path: true},
+	{key: "captions.stream", flag: "captionstream"},
+	{key: "captions.font", flag: "captionfont", path: true},
+	{key: "captions.size", flag: "captionsize"},
+	{key: "captions.color", flag: "captioncolor"},
+	{key: "captions.outline", flag: "captionoutline"},
+	{key: "captions.position", flag: "captionposition"},
+	{key: "captions.maxLines", flag: "captionmaxlines"},
+
+	{key: "binding.width", flag: "bindingwidth"},
+	{key: "binding.side", flag: "bindingside"},
+	{key: "binding.style", flag: "bindingstyle"},
+	{key: "binding.color", flag: "bindingcolor"},
+	{key: "binding.image", flag: "bindingimage", path: true},
+
+	{key: "markers.kind", flag: "markers"},
+	{key: "markers.width", flag: "markerwidth"},
+	{key: "markers.color", flag: "markercolor"},
+	{key: "markers.chapters", flag: "chapters", list: true},
+
+	{key: "outputs.gif", flag: "gif"},
+	{key: "outputs.previews", flag: "previews", list: true},
+	{key: "outputs.previewWidth", flag: "previewwidth"},
+	{key: "outputs.simulator", flag: "simulator"},
+	{key: "outputs.proof", flag: "proof"},
+	{key: "outputs.proofColumns", flag: "proofcolumns"},
+	{key: "outputs.proofRows", flag: "proofrows"},
+	{key: "outputs.verify", flag: "verify"},
+	{key: "outputs.verifyGif", flag: "verifygif"},
+}
+
+// loadJob reads a .json, .yaml or .yml job file and sets the flags from its values, except
+// flags given on the command line, which override the file. Every key is checked against
+// jobSchema and the type of its flag before any flag is set. Relative paths in the file are
+// relative to the directory of the file
+func loadJob(p string) error {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return fmt.Errorf("failed to read job file: %s", err)
+	}
+
+	var raw interface{}
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".json":
+		err = json.Unmarshal(b, &raw)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(b, &raw)
+	default:
+		return fmt.Errorf("job file must be a .json, .yaml or .yml file: %s", p)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse job file: %s, %s", p, err)
+	}
+	if raw == nil {
+		return nil
+	}
+
+	values, err := jobValues(raw)
+	if err != nil {
+		return fmt.Errorf("invalid job file: %s, %s", p, err)
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for _, k := range jobSchema {
+		v, ok := values[k.flag]
+		if !ok || set[k.flag] {
+			continue
+		}
+		if k.path {
+			v = resolvePaths(v, filepath.Dir(p))
+		}
+		err = flag.Set(k.flag, v)
+		if err != nil {
+			return fmt.Errorf("invalid job file: %s, %s: %s", p, k.key, err)
+		}
+	}
+	return nil
+}
+
+// resolvePaths returns the comma separated paths with each relative path joined to dir
+func resolvePaths(v, dir string) string {
+	if v == "" {
+		return v
+	}
+	paths := strings.Split(v, ",")
+	for i, p := range paths {
+		p = strings.TrimSpace(p)
+		if p != "" && !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		paths[i] = p
+	}
+	return strings.Join(paths, ",")
+}
+
+// jobValues checks the keys and values of a parsed job file and returns the flag values they
+// set, by flag name
+func jobValues(raw interface{}) (map[string]string, error) {
+	keys := make(map[string]jobKey)
+	sections := make(map[string][]string)
+	var topKeys []string
+	for _, k := range jobSchema {
+		keys[k.key] = k
+		i := strings.Index(k.key, ".")
+		if i == -1 {
+			topKeys = append(topKeys, k.key)
+			continue
+		}
+		section := k.key[:i]
+		if _, ok := sections[section]; !ok {
+			topKeys = append(topKeys, section)
+		}
+		sections[section] = append(sections[section], k.key[i+1:])
+	}
+
+	top, ok := jobMap(raw)
+	if !ok {
+		return nil, fmt.Errorf("a job must be a map of keys to values")
+	}
+
+	values := make(map[string]string)
+	for _, name := range sortedKeys(top) {
+		v := top[name]
+		if _, ok := sections[name]; ok {
+			m, ok := jobMap(v)
+			if !ok {
+				return nil, fmt.Errorf("%s: must be a map of %s", name, strings.Join(sections[name], ", "))
+			}
+			for _, sub := range sortedKeys(m) {
+				k, ok := keys[name+"."+sub]
+				if !ok {
+					return nil, fmt.Errorf("%s.%s: unknown key, %s can have %s", name, sub, name, strings.Join(sections[name], ", "))
+				}
+				s, err := jobValue(k, m[sub])
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s", k.key, err)
+				}
+				values[k.flag] = s
+			}
+			continue
+		}
+
+		k, ok := keys[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown key, a job can have %s", name, strings.Join(topKeys, ", "))
+		}
+		s, err := jobValue(k, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", k.key, err)
+		}
+		values[k.flag] = s
+	}
+	return values, nil
+}
+
+// jobValue returns the value as the string the flag of the key takes, checking it is the
+// type the flag expects
+func jobValue(k jobKey, v interface{}) (string, error) {
+	f := flag.Lookup(k.flag)
+	if list, ok := v.([]interface{}); ok {
+		if !k.list {
+			return "", fmt.Errorf("must be a single value, not a list")
+		}
+		var items []string
+		for _, item := range list {
+			s, err := scalarValue(f, item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return scalarValue(f, v)
+}
+
+func scalarValue(f *flag.Flag, v interface{}) (string, error) {
+	switch f.Value.(flag.Getter).Get().(type) {
+	case bool:
+		b, ok := v.(bool)
+		if !ok {
+			return "", fmt.Errorf("must be true or false, %v invalid value", v)
+		}
+		return strconv.FormatBool(b), nil
+
+	case int:
+		switch n := v.(type) {
+		case int:
+			return strconv.Itoa(n), nil
+		case float64:
+			if n == math.Trunc(n) {
+				return strconv.Itoa(int(n)), nil
+			}
+		}
+		return "", fmt.Errorf("must be a whole number, %v invalid value", v)
+
+	case float64:
+		switch n := v.(type) {
+		case int:
+			return strconv.Itoa(n), nil
+		case float64:
+			return strconv.FormatFloat(n, 'g', -1, 64), nil
+		}
+		return "", fmt.Errorf("must be a number, %v invalid value", v)
+
+	default:
+		// Text such as 420 or 1.5 is parsed as a number, it is taken as written
+		switch s := v.(type) {
+		case string:
+			return s, nil
+		case int:
+			return strconv.Itoa(s), nil
+		case float64:
+			return strconv.FormatFloat(s, 'g', -1, 64), nil
+		}
+		return "", fmt.Errorf("must be text, %v invalid value", v)
+	}
+}
+
+// jobMap returns the value as a map with string keys, YAML maps have keys of any type
+func jobMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			out[fmt.Sprint(k)] = v
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// sortedKeys returns the keys of the map in order, so the first error found is always the same
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// dumpJob returns the effective job as YAML, every flag that isn't at its default value,
+// whether it was set by the job file or the command line
+func dumpJob() ([]byte, error) {
+	var job yaml.MapSlice
+	sectionIndex := make(map[string]int)
+	for _, k := range jobSchema {
+		f := flag.Lookup(k.flag)
+		if f.Value.String() == f.DefValue {
+			continue
+		}
+
+		var v interface{} = f.Value.(flag.Getter).Get()
+		if k.list {
+			var items []interface{}
+			for _, s := range strings.Split(f.Value.String(), ",") {
+				items = append(items, listItem(strings.TrimSpace(s)))
+			}
+			v = items
+		}
+
+		i := strings.Index(k.key, ".")
+		if i == -1 {
+			job = append(job, yaml.MapItem{Key: k.key, Value: v})
+			continue
+		}
+		section, key := k.key[:i], k.key[i+1:]
+		si, ok := sectionIndex[section]
+		if !ok {
+			si = len(job)
+			sectionIndex[section] = si
+			job = append(job, yaml.MapItem{Key: section, Value: yaml.MapSlice{}})
+		}
+		job[si].Value = append(job[si].Value.(yaml.MapSlice), yaml.MapItem{Key: key, Value: v})
+	}
+	return yaml.Marshal(job)
+}
+
+// listItem returns an item of a comma separated flag as a number if it is one, so margins
+// and times are written as numbers
+func listItem(s string) interface{} {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return n
+	}
+	return s
+}
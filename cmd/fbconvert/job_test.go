@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// testFlags replaces the command line flags with a fresh set of the flags the tests use, of
+// the same types as main defines them, and returns a func that puts the original flags back
+func testFlags() func() {
+	saved := flag.CommandLine
+
+	fs := flag.NewFlagSet("fbconvert", flag.ContinueOnError)
+	for _, name := range []string{"input", "output", "identifier", "fontpath", "fallbackfonts", "line1text", "line2text", "margins", "label", "job", "batch"} {
+		fs.String(name, "", "")
+	}
+	for _, name := range []string{"clean", "skipvideo", "dump-job"} {
+		fs.Bool(name, false, "")
+	}
+	fs.Int("fps", 6, "")
+	fs.Int("starttime", 0, "")
+	fs.Int("batchjobs", 1, "")
+	fs.Float64("thickness", 0, "")
+	flag.CommandLine = fs
+	return func() { flag.CommandLine = saved }
+}
+
+func TestJobValues(t *testing.T) {
+	defer testFlags()()
+
+	// The values are as encoding/json gives them, numbers are float64
+	raw := map[string]interface{}{
+		"input": "in.mp4",
+		"clean": true,
+		"time":  map[string]interface{}{"fps": 12.0, "start": 3},
+		"plan":  map[string]interface{}{"thickness": 1},
+		"layout": map[string]interface{}{
+			"margins": []interface{}{0.5, 1.0, "0.25"},
+		},
+		// Text that looks like a number is taken as written
+		"labels": map[string]interface{}{"text": 420},
+	}
+	got, err := jobValues(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"input":     "in.mp4",
+		"clean":     "true",
+		"fps":       "12",
+		"starttime": "3",
+		"thickness": "1",
+		"margins":   "0.5,1,0.25",
+		"label":     "420",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// YAML maps can have keys of any type
+	var yamlRaw interface{}
+	err = yaml.Unmarshal([]byte("time:\n  fps: 8\n1: x\n"), &yamlRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = jobValues(yamlRaw)
+	if err == nil || !strings.HasPrefix(err.Error(), "1: unknown key, a job can have input, output, identifier, ") {
+		t.Fatalf("got error %v, want the key 1 to be unknown", err)
+	}
+}
+
+func TestJobValuesErrors(t *testing.T) {
+	defer testFlags()()
+
+	tests := []struct {
+		name string
+		raw  interface{}
+		err  string
+	}{
+		{"not a map", []interface{}{"input"}, "a job must be a map of keys to values"},
+		{"section", map[string]interface{}{"time": 12}, "time: must be a map of start, length, fps, timing"},
+		{"section key", map[string]interface{}{"time": map[string]interface{}{"fsp": 12}}, "time.fsp: unknown key, time can have start, length, fps, timing"},
+		{"bool", map[string]interface{}{"clean": "yes"}, "clean: must be true or false, yes invalid value"},
+		{"int", map[string]interface{}{"time": map[string]interface{}{"fps": 12.5}}, "time.fps: must be a whole number, 12.5 invalid value"},
+		{"int text", map[string]interface{}{"time": map[string]interface{}{"fps": "12"}}, "time.fps: must be a whole number, 12 invalid value"},
+		{"float", map[string]interface{}{"plan": map[string]interface{}{"thickness": "thick"}}, "plan.thickness: must be a number, thick invalid value"},
+		{"text", map[string]interface{}{"labels": map[string]interface{}{"text": true}}, "labels.text: must be text, true invalid value"},
+		{"list", map[string]interface{}{"input": []interface{}{"a.mp4", "b.mp4"}}, "input: must be a single value, not a list"},
+		{"list item", map[string]interface{}{"layout": map[string]interface{}{"margins": []interface{}{0.5, false}}}, "layout.margins: must be text, false invalid value"},
+		// Keys are checked in order, so the same file always gives the same error
+		{"first error", map[string]interface{}{"clean": 1, "bogus": 1}, "bogus: unknown key"},
+	}
+	for _, tt := range tests {
+		_, err := jobValues(tt.raw)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestResolvePaths(t *testing.T) {
+	dir := filepath.FromSlash("/jobs/book")
+	tests := []struct {
+		v    string
+		want string
+	}{
+		{"", ""},
+		{"in.mp4", filepath.Join(dir, "in.mp4")},
+		{"../fonts/a.ttf", filepath.FromSlash("/jobs/fonts/a.ttf")},
+		{filepath.FromSlash("/abs/in.mp4"), filepath.FromSlash("/abs/in.mp4")},
+		// Lists are resolved item by item, spaces after the commas are dropped
+		{"a.ttf, " + filepath.FromSlash("/abs/b.ttf") + ",", filepath.Join(dir, "a.ttf") + "," + filepath.FromSlash("/abs/b.ttf") + ","},
+	}
+	for _, tt := range tests {
+		if got := resolvePaths(tt.v, dir); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestLoadJob(t *testing.T) {
+	defer testFlags()()
+	dir, err := ioutil.TempDir("", "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "job.yaml")
+	err = ioutil.WriteFile(p, []byte("input: videos/in.mp4\nidentifier: book\ntime:\n  fps: 8\nfonts:\n  fallback: [a.ttf, b.ttf]\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Options given on the command line override the file
+	if err := flag.Set("identifier", "cli"); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadJob(p); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"input":         filepath.Join(dir, "videos/in.mp4"),
+		"identifier":    "cli",
+		"fps":           "8",
+		"fallbackfonts": filepath.Join(dir, "a.ttf") + "," + filepath.Join(dir, "b.ttf"),
+	}
+	for name, v := range want {
+		if got := flag.Lookup(name).Value.String(); got != v {
+			t.Errorf("%s: got %q, want %q", name, got, v)
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	if err := ioutil.WriteFile(bad, []byte(`{"time": {"fps": "fast"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = loadJob(bad)
+	if want := "invalid job file: " + bad + ", time.fps: must be a whole number, fast invalid value"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+
+	err = loadJob(filepath.Join(dir, "job.txt"))
+	if want := "failed to read job file: "; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
+	"bytes"
 	"encoding/base64"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -12,8 +12,17 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/disintegration/imaging"
+	"github.com/markdaws/go-flipbook/pkg/captions"
 	"github.com/markdaws/go-flipbook/pkg/composite"
 	"github.com/markdaws/go-flipbook/pkg/ffmpeg"
+	"github.com/markdaws/go-flipbook/pkg/icc"
+	"github.com/markdaws/go-flipbook/pkg/naming"
+	"github.com/markdaws/go-flipbook/pkg/plan"
+	"github.com/markdaws/go-flipbook/pkg/selection"
+	"github.com/markdaws/go-flipbook/pkg/stabilize"
+	"github.com/markdaws/go-flipbook/pkg/timing"
+	"github.com/markdaws/go-flipbook/pkg/typeset"
 )
 
 // Injected by the build process
@@ -27,33 +36,105 @@ func main() {
 
 	//optional
 	fontPath := flag.String("fontpath", "", "Path to the font file used for the text on the front cover (must be a ttf file). If not specified, HelveticaNeue will be used")
+	fallbackFonts := flag.String("fallbackfonts", "", "Comma separated paths to fonts, or directories of fonts, used in order for characters the main font doesn't have, e.g. a CJK or emoji font")
 	line1Text := flag.String("line1text", "", "Text to display on line 1 of the flipbook cover")
 	line2Text := flag.String("line2text", "", "Text to display on line 2 of the flipbook cover")
 	titleEncoded := flag.Bool("titleencoded", false, "If true, the line1text and line2text are expected to be base64 encoded strings, useful for untrusted input")
-	effect := flag.String("effect", "", "An image processing effect to apply to each frame. Values can be 'oil|pixelate|edge|cartoon|pencil'")
+	effect := flag.String("effect", "", "An image processing effect to apply to each frame. Values can be 'oil|pixelate|edge|cartoon|pencil|lut', lut applies the lut option after the adjustments and to the cover")
+	lutPath := flag.String("lut", "", "Path to an Adobe .cube 1D or 3D LUT file used to colour grade each frame, before the adjustments unless the effect is lut")
+	lutInterp := flag.String("lutinterp", "tetrahedral", "How colours between 3D LUT entries are interpolated. Values can be 'trilinear|tetrahedral'")
+	sheetFormat := flag.String("sheetformat", "jpg", "The file format of the composite sheets. Values can be 'jpg|png|tiff|webp|pdf', png, tiff and webp are lossless")
+	sheetName := flag.String("sheetname", naming.DefaultSheet, "Template for the file names of the sheets, to match what a print service expects. Fields can be {identifier} {index} {number} {total} {ext}, numbers can be given a width e.g. {number:4}")
+	frameName := flag.String("framename", naming.DefaultFrame, "Template for the file names of the extracted frames, must end with .png. Fields are the same as sheetname")
+	coverName := flag.String("covername", naming.DefaultCover, "Template for the file name of the cover image. Fields can be {identifier} {ext}")
+	jpegQuality := flag.Int("jpegquality", 90, "The quality of jpg sheets, 1 to 100")
+	chromaSubsampling := flag.String("chromasubsampling", "420", "The chroma subsampling of jpg sheets. Values can be '444|422|420', 444 keeps coloured edges sharp but makes larger files")
+	bitDepth := flag.Int("bitdepth", 8, "The bits per channel of png and tiff sheets. Values can be '8|16'")
+	colorProfile := flag.String("colorprofile", "", "Embeds an ICC profile in the sheets so print shops know the colour space. Values can be 'srgb|adobergb', for adobergb the sheets are converted")
+	cmyk := flag.Bool("cmyk", false, "If true, the sheets are converted to CMYK for professional printing, requires a sheetformat of tiff or pdf")
+	outputProfile := flag.String("outputprofile", "", "Path to the ICC profile of the printer, used to convert to CMYK and embedded in the sheets. Without one the CMYK colours will not be accurate")
+	brightness := flag.Float64("brightness", 0, "Percentage change in brightness applied to each frame, -100 to 100")
+	contrast := flag.Float64("contrast", 0, "Percentage change in contrast applied to each frame, -100 to 100")
+	gamma := flag.Float64("gamma", 1, "Gamma correction applied to each frame, values above 1 lighten the midtones, which helps if prints come out darker than on screen")
+	saturation := flag.Float64("saturation", 0, "Percentage change in saturation applied to each frame, -100 to 100")
+	levels := flag.String("levels", "", "Input levels that become black and white in the format low,high e.g. 16,235")
+	autoLevels := flag.Bool("autolevels", false, "If true, the levels of each frame are stretched to use the full range")
+	normalize := flag.Bool("normalize", false, "If true, levels are computed across the whole clip and applied to every frame so exposure is consistent page to page")
+	grayscale := flag.Bool("grayscale", false, "If true, frames are converted to grayscale")
+	sepia := flag.Bool("sepia", false, "If true, frames are given a sepia tone")
 	fps := flag.Int("fps", 15, "The number of frames to generate per second of video. Min 1, max 60")
 	clean := flag.Bool("clean", false, "If true, all files in the output directory are deleted before generating new items")
 	cleanFrames := flag.Bool("cleanframes", false, "If true, deletes all of the individual video frames after compositing")
 	bgColor := flag.String("bgcolor", "white", "The background color of the image (for border). Can be white|black")
-	skipVideo := flag.Bool("skipvideo", false, "If true frames are not extracted and the input option is not required")
+	skipVideo := flag.Bool("skipvideo", false, "If true frames are not extracted and the input option is not required, the frames already in the output directory named by framename are used")
 	cover := flag.Bool("cover", false, "If true, a cover page is added to the rendered frames")
+	coverTemplatePath := flag.String("covertemplate", "", "Path to a JSON cover template, describing the cover background and text blocks. If not specified, the first frame is blurred and line1text and line2text are drawn in the top left")
+	backCover := flag.Bool("backcover", false, "If true, a back cover is added as the last frame of the book")
+	backCoverTemplatePath := flag.String("backcovertemplate", "", "Path to a JSON cover template for the back cover, the same format as covertemplate. If not specified, the last frame is blurred with \"The End\" in the middle")
+	creditsPath := flag.String("credits", "", "Path to a JSON file containing an array of cover templates, one for each credit page added at the end of the book before the back cover")
+	blankPages := flag.Int("blankpages", 0, "The number of blank pages added at the end of the book, before any credit pages")
 	startTime := flag.Int("starttime", 0, "The start time in the input video to use as the start of the flip book")
+	duplex := flag.String("duplex", "", "Prints frames on both sides of each sheet, so the book uses half the paper and is half as thick. The value is the edge the printer turns the sheets over on, 'long|short', check the printer settings. The front and back of each sheet are written one after the other")
+	duplexPDF := flag.Bool("duplexpdf", false, "If true, the fronts and backs of duplex sheets are written to a single PDF ready for a duplex printer, instead of a file for each side. Requires duplex and a sheetformat of pdf")
+	markers := flag.String("markers", "", "Prints markers along the flip edge of each frame that line up into a gauge on the edge of the bound book. Values can be 'progress|thumb|tabs', progress is a bar that grows through the book, thumb a mark that moves along the edge and tabs a coloured tab for each chapter")
+	markerWidth := flag.Float64("markerwidth", 0, "The width in inches of the markers, measured in from the flip edge. If 0, 0.08 is used")
+	markerColor := flag.String("markercolor", "#000000", "The colour of progress and thumb markers as #rrggbb or #rrggbbaa")
+	chaptersFlag := flag.String("chapters", "", "Comma separated times in seconds in the video where chapters start for tab markers, e.g. 12.5,30. If not specified and scenecut is set, each scene is a chapter")
+	orientation := flag.String("orientation", "side", "How the book is bound. Values can be 'side|top', top bound books are bound along the top like a calendar and flipped from the bottom, each frame is printed turned a quarter turn so the sheets are cut the same way")
 	layout := flag.String("layout", "4x6x3", "Determines how the flip book pages should be laid out. Values are 4x6x3, which gives 3 frames per 6x4 photo size, each 4x2, the other option is letter which is 12 frames laid out on a 8.5x11, each frame is 4.25x2. You can also specify letter-business which prints business size cards 3.5x2 on a letter paper, 10 cards per sheet")
 	margins := flag.String("margins", "", "Allows the caller to specify margins around the images. You may need to change the default values for your printer, if it does something like automatically expand the image to make it fill the full page. The format should be top,right,bottom,left")
 	maxLength := flag.Int("maxlength", 5, "The maximum length of the input video to process in seconds")
+	sheets := flag.Int("sheets", 0, "Plans the book to use this many printed sheets, the fps is computed so the starttime to starttime+maxlength range fills them exactly. The fps option is ignored")
+	nFrames := flag.Int("frames", 0, "Plans the book to contain this many frames, rounded to whole sheets, the fps is computed from the starttime and maxlength values. The fps option is ignored")
+	thickness := flag.Float64("thickness", 0, "Plans the book to be this many inches thick, requires paperthickness. The fps option is ignored")
+	stabilizeMethod := flag.String("stabilize", "", "Removes camera shake before compositing. Values can be 'auto|vidstab|deshake|go', vidstab and deshake use ffmpeg filters, go is built in and works with any ffmpeg. auto picks the best available")
+	stabilizeSmoothing := flag.Int("stabilizesmoothing", 15, "The number of frames either side of a frame used to smooth the camera path when using the go stabilizer, 0 locks the camera in place")
+	selectFrames := flag.Int("selectframes", 0, "If greater than zero, the number of frames to keep from the extracted frames, picked so fast moving sections get more pages than static ones. Use with a high fps to give more frames to choose from")
+	dedupe := flag.Float64("dedupe", 0, "Drops frames that differ from the previous frame by less than this amount, 0 to 1. 0 disables, 0.01 is a good starting point")
+	sceneCut := flag.Float64("scenecut", 0, "Detects scene cuts where consecutive frames differ by more than this amount, 0 to 1. 0 disables, 0.4 is a good starting point. Cuts are recorded in info.json")
+	stopAtCut := flag.Bool("stopatcut", false, "If true, the book ends at the first scene cut, requires scenecut")
+	captionsPath := flag.String("captions", "", "Path to an SRT or WebVTT file, the caption spoken at the time of each frame is printed on it")
+	captionStream := flag.Int("captionstream", -1, "The index of a subtitle stream in the input video to use as captions, 0 for the first. Only text subtitles are supported")
+	captionFont := flag.String("captionfont", "", "Path to the font file, or a directory of fonts, used for captions. If not specified, the fontpath font is used")
+	captionSize := flag.Float64("captionsize", 9, "The font size of captions in points, long captions are shrunk to fit captionmaxlines")
+	captionColor := flag.String("captioncolor", "#ffffff", "The colour of captions as #rrggbb or #rrggbbaa")
+	captionOutline := flag.String("captionoutline", "#000000", "The colour of the outline around captions as #rrggbb or #rrggbbaa, or none")
+	captionPosition := flag.String("captionposition", "bottom", "Where captions are printed on the frame. Values can be 'top|bottom'")
+	captionMaxLines := flag.Int("captionmaxlines", 2, "The most lines a caption can wrap onto")
+	label := flag.String("label", composite.DefaultLabel, "The label printed on the strip beside each frame, empty for no labels. Fields can be {index} {timecode} {sheet} {stack} {identifier}, other text is printed as is")
+	labelFont := flag.String("labelfont", "", "Path to the font file, or a directory of fonts, used for labels. If not specified, the fontpath font is used")
+	labelSize := flag.Float64("labelsize", 7, "The font size of labels in points, labels are shrunk to fit the strip")
+	labelColor := flag.String("labelcolor", "#ffffff", "The colour of labels as #rrggbb or #rrggbbaa")
+	labelRotation := flag.Int("labelrotation", 0, "The angle in degrees labels are turned anticlockwise. Values can be '0|90|180|270', 90 and 270 run along the strip")
+	bindingWidth := flag.Float64("bindingwidth", 0, "The width in inches of the binding strip on each frame, the part held by the clip or glue. Frames are scaled to fit beside it. If 0, a third of an inch is used, 100 pixels at 300 DPI")
+	bindingSide := flag.String("bindingside", "", "The edge of each frame the binding strip is on. Values can be 'left|right|top'. If not specified, left is used, or top for top bound books")
+	bindingStyle := flag.String("bindingstyle", "solid", "How the binding strip is filled. Values can be 'solid|pattern|image|none', none leaves it blank")
+	bindingColor := flag.String("bindingcolor", "#000000", "The colour of a solid binding strip, or the stripes of a pattern, as #rrggbb or #rrggbbaa")
+	bindingImage := flag.String("bindingimage", "", "Path to an image, such as a logo, that fills the binding strip when bindingstyle is image")
+	timingPath := flag.String("timing", "", "Path to a .json or .yaml timing script that changes the sampling rate of time ranges, or holds frames for several pages. Times are in seconds from the start of the video")
+	paperThickness := flag.Float64("paperthickness", 0.01, "The thickness of the paper in inches, used to plan and estimate the book thickness")
 	identifier := flag.String("identifier", "", "A string that will be printed on each frame, for easy identification")
 	reversePages := flag.Bool("reversepages", false, "If true, the lowest numbered output page will contain the last frames. Useful if you print and don't want to have to manually reverse the printed stack for assembly, so you end up with page 1 on top")
 	reverseFrames := flag.Bool("reverseframes", false, "If true, frame 0 will be printed last, in this case you flip from the end of the book to the front to view the scene, which I have found is easier than flipping front to back")
-	//gif := flag.Bool("gif", false, "If true, an animated GIF will be created from the individual frames")
+	gif := flag.Bool("gif", false, "If true, an animated GIF preview of the book is created, the same as adding gif to previews")
+	previewsFlag := flag.String("previews", "", "Comma separated formats of animated previews of the book to create from the finished frames. Values can be 'gif|apng|webp|mp4', mp4 requires ffmpeg")
+	previewWidth := flag.Int("previewwidth", 0, "The width in pixels of the animated previews and the simulator. If 0, 480 is used")
+	proof := flag.Bool("proof", false, "If true, contact sheets are created showing every finished frame in the order the book is flipped, with its index, timecode, and the stack and sheet it is printed on, to review the book before printing")
+	proofColumns := flag.Int("proofcolumns", composite.DefaultProofColumns, "The number of thumbnails across each contact sheet")
+	proofRows := flag.Int("proofrows", composite.DefaultProofRows, "The number of rows of thumbnails on each contact sheet, more frames go on further sheets")
+	verify := flag.Bool("verify", false, "If true, stacking, cutting and assembling the printed sheets is simulated once they are rendered, and the job fails if the frames would end up out of order")
+	verifyDir := flag.String("verifydir", "", "Path to the output directory of an earlier job to verify from its info.json, nothing else is done. The input option is not required")
+	verifyGIF := flag.Bool("verifygif", false, "If true, verifying also crops every frame from the written sheets in the order of the assembled book into an animated GIF, to watch the result of cutting them up. Cannot be used with pdf or cmyk sheets")
+	simulator := flag.Bool("simulator", false, "If true, an HTML page is created next to info.json that flips through the book page by page, to see how it will feel before printing. It works offline and can be shared as a single file")
+	jobPath := flag.String("job", "", "Path to a .json or .yaml job file holding the options of the job, options given on the command line override it")
+	dumpJobFlag := flag.Bool("dump-job", false, "If true, the effective job is printed as YAML, the job file with the command line options applied, and nothing else is done")
+	batch := flag.String("batch", "", "Path to a directory of videos, or a .csv or .json list of videos with the columns input, identifier, title, subtitle and start, to make a book from each. Every other option, except verifydir, applies to all of the books, each is made in its own directory under output named by its identifier and a summary is written to batch.json")
+	batchJobs := flag.Int("batchjobs", 2, "The number of books of a batch that are made at the same time")
 	ver := flag.Bool("version", false, "Displays the app version number")
 	verbose := flag.Bool("verbose", false, "Prints verbose output as the process is running")
 
 	flag.Parse()
 
-	//TODO: re-enable
-	noGIF := false
-	gif := &noGIF
-
 	infoLog := log.New(os.Stdout, "INFO: ", 0)
 	errLog := log.New(os.Stderr, "ERR: ", 0)
 	var verLog *log.Logger
@@ -68,22 +149,304 @@ func main() {
 		return
 	}
 
+	if *jobPath != "" {
+		err := loadJob(*jobPath)
+		if err != nil {
+			errLog.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if *dumpJobFlag {
+		b, err := dumpJob()
+		if err != nil {
+			errLog.Println("failed to dump job:", err)
+			os.Exit(1)
+		}
+		fmt.Print(string(b))
+		return
+	}
+
+	if *batch != "" {
+		if *output == "" || *skipVideo {
+			errLog.Println("--batch requires --output and cannot be used with --skipvideo")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		if *verifyDir != "" {
+			errLog.Println("--batch cannot be used with --verifydir, verify the output directory of each book on its own")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		if *batchJobs < 1 {
+			errLog.Println("--batchjobs must be at least 1")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		rows, err := loadBatch(*batch)
+		if err != nil {
+			errLog.Println(err)
+			os.Exit(1)
+		}
+		err = runBatch(rows, *output, *batchJobs, infoLog)
+		if err != nil {
+			errLog.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *verifyDir != "" {
+		job, err := readInfo(path.Join(*verifyDir, naming.Manifest))
+		if err != nil {
+			errLog.Println("failed to read info.json:", err)
+			os.Exit(1)
+		}
+		_, err = verifySheets(*verifyDir, job, *verifyGIF, naming.Scheme{}, infoLog)
+		if err != nil {
+			errLog.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	validateFlags(*bgColor, *input, *output, *effect, *fps, *skipVideo, verLog, errLog)
 
 	fontBytes := loadFont(*fontPath, errLog)
 
-	if *clean {
-		cleanOutput(*output, verLog, errLog)
+	sheetWriter, err := composite.NewSheetWriter(*sheetFormat, *jpegQuality, *chromaSubsampling, *bitDepth)
+	if err != nil {
+		errLog.Println("invalid sheet options:", err)
+		flag.PrintDefaults()
+		os.Exit(1)
 	}
 
-	var frames []os.FileInfo
-	if !*skipVideo {
-		var err error
-		frames, err = ffmpeg.VideoFilter(*input, *output, *identifier, *fps, uint(*startTime), *maxLength, verLog)
+	switch *duplex {
+	case "", composite.DuplexLong, composite.DuplexShort:
+	default:
+		errLog.Println("invalid duplex option:", *duplex)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *duplexPDF && (*duplex == "" || *sheetFormat != "pdf") {
+		errLog.Println("--duplexpdf requires --duplex and --sheetformat=pdf")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	// The GIF is cropped from the written sheets, so they have to be readable
+	if *verifyGIF && (sheetWriter.Ext() == "pdf" || *cmyk) {
+		errLog.Println("--verifygif cannot be used with pdf or --cmyk sheets, they cannot be read back")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var previews []string
+	if *previewsFlag != "" {
+		for _, p := range strings.Split(*previewsFlag, ",") {
+			previews = append(previews, strings.TrimSpace(p))
+		}
+	}
+	for _, p := range previews {
+		switch p {
+		case composite.PreviewGIF, composite.PreviewAPNG, composite.PreviewWebP:
+		case composite.PreviewMP4:
+			if installed, _ := ffmpeg.FFMPEGIsInstalled(); !installed {
+				errLog.Println("an mp4 preview requires ffmpeg, please install then re-run")
+				os.Exit(1)
+			}
+		default:
+			errLog.Println("invalid previews option:", p)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+	if *proofColumns < 1 || *proofRows < 1 {
+		errLog.Println("--proofcolumns and --proofrows must be at least 1")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *previewWidth < 0 {
+		errLog.Println("--previewwidth must not be negative")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	names, err := naming.NewScheme(*sheetName, *frameName, *coverName)
+	if err != nil {
+		errLog.Println(err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *stopAtCut && *sceneCut == 0 {
+		errLog.Println("--stopatcut requires --scenecut")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *skipVideo && (*selectFrames != 0 || *dedupe != 0 || *sceneCut != 0) {
+		errLog.Println("--selectframes, --dedupe and --scenecut cannot be used with --skipvideo")
+		os.Exit(1)
+	}
+
+	stabilizer := ""
+	if *stabilizeMethod != "" {
+		if *skipVideo {
+			errLog.Println("--stabilize cannot be used with --skipvideo")
+			os.Exit(1)
+		}
+		stabilizer = pickStabilizer(*stabilizeMethod, errLog)
+		verLog.Println("stabilizing with:", stabilizer)
+	}
+
+	var bookPlan *plan.Plan
+	if *sheets != 0 || *nFrames != 0 || *thickness != 0 {
+		// The plan fixes the number of frames extracted, dropping any of them afterwards would
+		// print fewer sheets than planned
+		if *selectFrames != 0 || *dedupe != 0 || *stopAtCut {
+			errLog.Println("--selectframes, --dedupe and --stopatcut cannot be used with --sheets, --frames or --thickness")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+
+		perSheet, err := framesPerSheet(*layout)
 		if err != nil {
-			errLog.Println("failed to extract frames:", err)
+			errLog.Println(err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+
+		p, err := plan.New(plan.Request{
+			Sheets:         *sheets,
+			Frames:         *nFrames,
+			Thickness:      *thickness,
+			PaperThickness: *paperThickness,
+			FramesPerSheet: perSheet,
+			Duplex:         *duplex != "",
+			StartTime:      *startTime,
+			Duration:       *maxLength,
+		})
+		if err != nil {
+			errLog.Println("failed to plan book:", err)
+			os.Exit(1)
+		}
+		bookPlan = &p
+		infoLog.Println("Plan:", bookPlan)
+	}
+
+	var script timing.Script
+	if *timingPath != "" {
+		if *skipVideo {
+			errLog.Println("--timing cannot be used with --skipvideo")
+			os.Exit(1)
+		}
+
+		script, err = timing.Load(*timingPath)
+		if err != nil {
+			errLog.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	markerStyle := composite.MarkerStyle{
+		Kind:  *markers,
+		Width: float32(*markerWidth),
+		Color: *markerColor,
+	}
+	if err := markerStyle.Validate(); err != nil {
+		errLog.Println("invalid marker options:", err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var chapterTimes []float64
+	if *chaptersFlag != "" {
+		if *skipVideo {
+			errLog.Println("--chapters cannot be used with --skipvideo")
+			os.Exit(1)
+		}
+		chapterTimes, err = parseChapters(*chaptersFlag)
+		if err != nil {
+			errLog.Println(err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+
+	var captionFonts typeset.Fonts
+	if *captionFont != "" {
+		captionFonts, err = typeset.Load(*captionFont)
+		if err != nil {
+			errLog.Println("--captionfont", err)
+			os.Exit(1)
+		}
+	}
+	captionStyle := composite.CaptionStyle{
+		Fonts:    captionFonts,
+		Size:     *captionSize,
+		Color:    *captionColor,
+		Outline:  *captionOutline,
+		Position: *captionPosition,
+		MaxLines: *captionMaxLines,
+	}
+	if err := captionStyle.Validate(); err != nil {
+		errLog.Println("invalid caption options:", err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var labelFonts typeset.Fonts
+	if *labelFont != "" {
+		labelFonts, err = typeset.Load(*labelFont)
+		if err != nil {
+			errLog.Println("--labelfont", err)
+			os.Exit(1)
+		}
+	}
+	labels := composite.LabelStyle{
+		Disabled: *label == "",
+		Template: *label,
+		Fonts:    labelFonts,
+		Size:     *labelSize,
+		Color:    *labelColor,
+		Rotation: *labelRotation,
+	}
+	if err := labels.Validate(); err != nil {
+		errLog.Println("invalid label options:", err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	binding := composite.Binding{
+		Width: float32(*bindingWidth),
+		Side:  *bindingSide,
+		Style: *bindingStyle,
+		Color: *bindingColor,
+	}
+	if *bindingImage != "" {
+		binding.Image, err = imaging.Open(*bindingImage)
+		if err != nil {
+			errLog.Println("failed to open bindingimage:", err)
+			os.Exit(1)
+		}
+	}
+	if err := binding.Validate(); err != nil {
+		errLog.Println("invalid binding options:", err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	switch *orientation {
+	case composite.OrientationSide:
+	case composite.OrientationTop:
+		if *bindingSide != "" && *bindingSide != composite.BindingTop {
+			errLog.Println("top bound books are bound along the top edge, bindingside must be top")
+			flag.PrintDefaults()
 			os.Exit(1)
 		}
+	default:
+		errLog.Println("invalid orientation option:", *orientation)
+		flag.PrintDefaults()
+		os.Exit(1)
 	}
 
 	bgColorComp := *bgColor
@@ -93,106 +456,393 @@ func main() {
 
 	line1, line2 := encodeTitles(*titleEncoded, *line1Text, *line2Text, errLog)
 
-	compOpts := composite.Options{
-		GIF:           *gif,
-		BGColor:       bgColorComp,
-		OutputDir:     *output,
-		InputDir:      *output,
-		Line1Text:     line1,
-		Line2Text:     line2,
-		Identifier:    *identifier,
-		FontBytes:     fontBytes,
-		ReversePages:  *reversePages,
-		ReverseFrames: *reverseFrames,
-		Cover:         *cover,
-		Effect:        *effect,
-		VerLog:        verLog,
+	adjust := composite.Adjustments{
+		Brightness: *brightness,
+		Contrast:   *contrast,
+		Gamma:      *gamma,
+		Saturation: *saturation,
+		AutoLevels: *autoLevels,
+		Normalize:  *normalize,
+		Grayscale:  *grayscale,
+		Sepia:      *sepia,
+	}
+	if *levels != "" {
+		low, high, err := parseLevels(*levels)
+		if err != nil {
+			errLog.Println("invalid levels option:", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		adjust.LevelsLow = low
+		adjust.LevelsHigh = high
+	}
+	if err := adjust.Validate(); err != nil {
+		errLog.Println("invalid adjustment options:", err)
+		os.Exit(1)
 	}
 
-	var err error
-	var info composite.RenderInfo
+	var printProfile *icc.OutputProfile
+	if *outputProfile != "" {
+		if !*cmyk {
+			errLog.Println("--outputprofile requires --cmyk")
+			os.Exit(1)
+		}
 
-	switch *layout {
-	case "4x6x3":
-		top := float32(0.0)
-		right := float32(0.0)
-		bottom := float32(0.0)
-		left := float32(0.0)
-		if *margins != "" {
-			top, right, bottom, left, err = parseMargins(*margins)
-			if err != nil {
-				errLog.Println("invalid margins option")
-				flag.PrintDefaults()
-				os.Exit(1)
-			}
+		b, err := ioutil.ReadFile(*outputProfile)
+		if err != nil {
+			errLog.Println("--outputprofile cannot open profile:", *outputProfile)
+			os.Exit(1)
 		}
-		page := composite.Page{
-			Width:        4,
-			Height:       6,
-			MarginTop:    top,
-			MarginRight:  right,
-			MarginBottom: bottom,
-			MarginLeft:   left,
-			DPI:          300,
+		printProfile, err = icc.ParseOutputProfile(b)
+		if err != nil {
+			errLog.Println("invalid output profile:", err)
+			os.Exit(1)
 		}
-		compOpts.Page = page
-		info, err = composite.To4x6x3(compOpts)
+	}
 
-	case "letter":
-		top := float32(0.0)
-		right := float32(0.0)
-		bottom := float32(1.0)
-		left := float32(0.0)
-		if *margins != "" {
-			top, right, bottom, left, err = parseMargins(*margins)
-			if err != nil {
-				errLog.Println("invalid margins option")
-				flag.PrintDefaults()
+	var coverTemplate *composite.CoverTemplate
+	if *coverTemplatePath != "" {
+		if !*cover {
+			errLog.Println("--covertemplate requires --cover")
+			os.Exit(1)
+		}
+
+		coverTemplate, err = composite.LoadCoverTemplate(*coverTemplatePath)
+		if err != nil {
+			errLog.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	var backCoverTemplate *composite.CoverTemplate
+	if *backCoverTemplatePath != "" {
+		if !*backCover {
+			errLog.Println("--backcovertemplate requires --backcover")
+			os.Exit(1)
+		}
+
+		backCoverTemplate, err = composite.LoadCoverTemplate(*backCoverTemplatePath)
+		if err != nil {
+			errLog.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if *blankPages < 0 {
+		errLog.Println("--blankpages must be zero or greater")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	var pages []*composite.CoverTemplate
+	for i := 0; i < *blankPages; i++ {
+		pages = append(pages, composite.BlankPageTemplate())
+	}
+	if *creditsPath != "" {
+		credits, err := composite.LoadPageTemplates(*creditsPath)
+		if err != nil {
+			errLog.Println(err)
+			os.Exit(1)
+		}
+		pages = append(pages, credits...)
+	}
+
+	var fallbacks typeset.Fonts
+	if *fallbackFonts != "" {
+		fallbacks, err = typeset.Load(strings.Split(*fallbackFonts, ",")...)
+		if err != nil {
+			errLog.Println("--fallbackfonts", err)
+			os.Exit(1)
+		}
+	}
+
+	var lut *composite.LUT
+	if *lutPath != "" {
+		switch *lutInterp {
+		case "trilinear", "tetrahedral":
+		default:
+			errLog.Println("invalid lutinterp option:", *lutInterp)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+
+		var err error
+		lut, err = composite.LoadCube(*lutPath)
+		if err != nil {
+			errLog.Println(err)
+			os.Exit(1)
+		}
+	}
+	if *effect == composite.EffectLUT && lut == nil {
+		errLog.Println("the lut effect needs a LUT file, set with --lut")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	// The LUT and adjustments overwrite the frames, so with --skipvideo the frames may already
+	// be graded by an earlier job. The steps it recorded in info.json are carried over and a
+	// step is never applied to the same frames twice
+	var earlierSteps []pipelineStep
+	if *skipVideo {
+		earlier, err := readInfo(path.Join(*output, naming.Manifest))
+		if err != nil && !os.IsNotExist(err) {
+			errLog.Println("failed to read info.json:", err)
+			os.Exit(1)
+		}
+		for _, step := range earlier.Pipeline {
+			if !frameSteps[step.Name] {
+				continue
+			}
+			if (isLUTStep(step) && lut != nil) || (step.Name == "adjust" && !adjust.IsZero()) {
+				errLog.Printf("the frames in %s already have the %s step applied, extract them again to change it", *output, step.Name)
 				os.Exit(1)
 			}
+			earlierSteps = append(earlierSteps, step)
 		}
+	}
+
+	page, err := layoutPage(*layout, *margins)
+	if err != nil {
+		errLog.Println(err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
 
-		page := composite.Page{
-			Width:        8.5,
-			Height:       11,
-			MarginTop:    top,
-			MarginRight:  right,
-			MarginBottom: bottom,
-			MarginLeft:   left,
-			DPI:          300,
+	// Captions from a subtitle stream are extracted by ffmpeg, so they are loaded once every
+	// other option has been checked
+	var track captions.Track
+	if *captionsPath != "" || *captionStream >= 0 {
+		if *skipVideo {
+			errLog.Println("--captions and --captionstream cannot be used with --skipvideo")
+			os.Exit(1)
+		}
+		if *captionsPath != "" && *captionStream >= 0 {
+			errLog.Println("--captions and --captionstream cannot be used together")
+			os.Exit(1)
 		}
-		compOpts.Page = page
-		info, err = composite.ToLetter(compOpts)
 
-	case "letter-business":
-		top := float32(0.5)
-		right := float32(0.5)
-		bottom := float32(0.5)
-		left := float32(0.5)
-		if *margins != "" {
-			top, right, bottom, left, err = parseMargins(*margins)
+		track = loadCaptions(*captionsPath, *input, *captionStream, errLog)
+		verLog.Println(len(track), "captions loaded")
+	}
+
+	extractFPS := float64(*fps)
+	extractStart := float64(*startTime)
+	if bookPlan != nil {
+		extractFPS = bookPlan.FPS
+		extractStart = float64(bookPlan.StartTime)
+	}
+
+	compOpts := composite.Options{
+		GIF:               *gif,
+		Previews:          previews,
+		PreviewFPS:        extractFPS,
+		PreviewWidth:      *previewWidth,
+		Simulator:         *simulator,
+		Proof:             *proof,
+		ProofColumns:      *proofColumns,
+		ProofRows:         *proofRows,
+		BGColor:           bgColorComp,
+		OutputDir:         *output,
+		InputDir:          *output,
+		Line1Text:         line1,
+		Line2Text:         line2,
+		Identifier:        *identifier,
+		FontBytes:         fontBytes,
+		FallbackFonts:     fallbacks,
+		Captions:          track,
+		CaptionStyle:      captionStyle,
+		Labels:            labels,
+		Binding:           binding,
+		Orientation:       *orientation,
+		Markers:           markerStyle,
+		Duplex:            *duplex,
+		DuplexPDF:         *duplexPDF,
+		ReversePages:      *reversePages,
+		ReverseFrames:     *reverseFrames,
+		Cover:             *cover,
+		CoverTemplate:     coverTemplate,
+		BackCover:         *backCover,
+		BackCoverTemplate: backCoverTemplate,
+		Pages:             pages,
+		Effect:            *effect,
+		Adjust:            adjust,
+		LUT:               lut,
+		LUTInterpolation:  *lutInterp,
+		SheetWriter:       sheetWriter,
+		Naming:            names,
+		ColorProfile:      *colorProfile,
+		CMYK:              *cmyk,
+		OutputProfile:     printProfile,
+		Page:              page,
+		VerLog:            verLog,
+	}
+
+	// The number of frames isn't known until they are extracted, so the names are checked
+	// against the most there can be, and again once they are extracted
+	if !*skipVideo {
+		n := *fps * *maxLength
+		if bookPlan != nil {
+			n = bookPlan.Frames
+		}
+		err = checkNames(compOpts, *layout, n, *verifyGIF)
+		if err != nil {
+			errLog.Println("invalid naming:", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+
+	if *clean {
+		cleanOutput(*output, verLog, errLog)
+	}
+
+	var frames []os.FileInfo
+	if !*skipVideo {
+		videoInput := *input
+		videoStart := uint(*startTime)
+		if bookPlan != nil {
+			videoStart = uint(bookPlan.StartTime)
+		}
+
+		// The ffmpeg stabilizers work on the video, so the time range is first written to a stabilized
+		// copy that the frames are then extracted from
+		var tmpDir string
+		if stabilizer == "vidstab" || stabilizer == "deshake" {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "fbconvert")
+			if err != nil {
+				errLog.Println("failed to create temp dir:", err)
+				os.Exit(1)
+			}
+
+			videoInput = path.Join(tmpDir, "stabilized.mkv")
+			err = ffmpeg.Stabilize(*input, videoInput, stabilizer, videoStart, *maxLength, verLog)
 			if err != nil {
-				errLog.Println("invalid margins option")
-				flag.PrintDefaults()
+				os.RemoveAll(tmpDir)
+				errLog.Println("failed to stabilize video:", err)
 				os.Exit(1)
 			}
+			videoStart = 0
 		}
-		page := composite.Page{
-			Width:        8.5,
-			Height:       11,
-			MarginTop:    top,
-			MarginRight:  right,
-			MarginBottom: bottom,
-			MarginLeft:   left,
-			DPI:          300,
+
+		var err error
+		if bookPlan != nil {
+			frames, err = ffmpeg.VideoFilterRate(videoInput, *output, *identifier, bookPlan.FPSNum, bookPlan.FPSDen,
+				videoStart, bookPlan.Frames, verLog)
+		} else {
+			frames, err = ffmpeg.VideoFilter(videoInput, *output, *identifier, *fps, videoStart, *maxLength, verLog)
 		}
-		compOpts.Page = page
-		info, err = composite.ToLetter(compOpts)
+		if tmpDir != "" {
+			os.RemoveAll(tmpDir)
+		}
+		if err != nil {
+			errLog.Println("failed to extract frames:", err)
+			os.Exit(1)
+		}
+
+		err = checkNames(compOpts, *layout, len(frames), *verifyGIF)
+		if err != nil {
+			errLog.Println("invalid naming:", err)
+			os.Exit(1)
+		}
+
+		frames, err = names.RenameFrames(*output, *identifier, frames)
+		if err != nil {
+			errLog.Println("failed to name frames:", err)
+			os.Exit(1)
+		}
+	} else {
+		// The output directory also holds the sheets and other files of earlier jobs, so only
+		// the files named as frames are used
+		var err error
+		frames, err = names.ListFrames(*output, *identifier)
+		if err != nil {
+			errLog.Println(err)
+			os.Exit(1)
+		}
+	}
 
+	if stabilizer == "go" {
+		err := stabilize.Frames(*output, frames, stabilize.Options{
+			Smoothing: *stabilizeSmoothing,
+			VerLog:    verLog,
+		})
+		if err != nil {
+			errLog.Println("failed to stabilize frames:", err)
+			os.Exit(1)
+		}
+	}
+
+	var sel *selection.Result
+	if *selectFrames != 0 || *dedupe != 0 || *sceneCut != 0 {
+		res, err := selection.Select(*output, frames, selection.Options{
+			Frames:             *selectFrames,
+			DuplicateThreshold: *dedupe,
+			CutThreshold:       *sceneCut,
+			StopAtCut:          *stopAtCut,
+			VerLog:             verLog,
+		})
+		if err != nil {
+			errLog.Println("failed to select frames:", err)
+			os.Exit(1)
+		}
+
+		// The compositing step uses every frame in the output directory, so dropped frames have to be removed
+		cleanVideoFrames(*output, res.Dropped, verLog, errLog)
+		frames = res.Kept
+		sel = &res
+	}
+
+	// Frames are extracted at a fixed rate, so the time of each frame comes from its index in the
+	// extracted sequence, which may differ from its index in frames if some were dropped
+	times := make([]float64, len(frames))
+	for i := range frames {
+		index := i
+		if sel != nil {
+			index = sel.KeptIndex[i]
+		}
+		times[i] = extractStart + float64(index)/extractFPS
+	}
+
+	timedFrames := frames
+	if *timingPath != "" {
+		timedFrames, err = timing.Apply(script, frames, times, extractFPS)
+		if err != nil {
+			errLog.Println("failed to apply timing script:", err)
+			os.Exit(1)
+		}
+		verLog.Println(len(frames), "frames retimed to", len(timedFrames), "pages")
+	}
+
+	// Held frames share a file, so a frame has the same time on every page it appears on
+	var frameTimes map[string]float64
+	if !*skipVideo {
+		frameTimes = make(map[string]float64, len(frames))
+		for i, f := range frames {
+			frameTimes[f.Name()] = times[i]
+		}
+	}
+
+	var chapters map[string]bool
+	if chapterTimes != nil {
+		chapters = chapterStarts(chapterTimes, frames, times)
+	} else if sel != nil {
+		chapters = make(map[string]bool)
+		for _, c := range sel.Cuts {
+			chapters[frames[c].Name()] = true
+		}
+	}
+
+	compOpts.Frames = timedFrames
+	compOpts.FrameTimes = frameTimes
+	compOpts.Chapters = chapters
+
+	var info composite.RenderInfo
+
+	switch *layout {
+	case "4x6x3":
+		info, err = composite.To4x6x3(compOpts)
 	default:
-		errLog.Println("invalid layout value:", *layout)
-		flag.PrintDefaults()
-		os.Exit(1)
+		info, err = composite.ToLetter(compOpts)
 	}
 
 	if err != nil {
@@ -200,11 +850,117 @@ func main() {
 		os.Exit(1)
 	}
 
-	err = writeInfo(path.Join(*output, "info.json"), info)
+	perSheet, _ := framesPerSheet(*layout)
+	job := jobInfo{
+		Version: version,
+		Layout: &layoutInfo{
+			Name:           *layout,
+			Page:           page,
+			FramesPerSheet: perSheet,
+			Orientation:    *orientation,
+			SheetFormat:    *sheetFormat,
+		},
+		NFrames:       info.NFrames,
+		FrameAR:       info.FrameAR,
+		Plan:          bookPlan,
+		Identifier:    *identifier,
+		FPS:           extractFPS,
+		SheetWidth:    info.SheetWidth,
+		SheetHeight:   info.SheetHeight,
+		ReversePages:  info.ReversePages,
+		ReverseFrames: info.ReverseFrames,
+		Duplex:        info.Duplex,
+		Sheets:        info.Sheets,
+		TrimmedFrames: info.Trimmed,
+	}
+	if !*skipVideo {
+		job.Input = &inputInfo{Path: *input}
+		probe, err := ffmpeg.Probe(*input)
+		if err != nil {
+			verLog.Println("input not probed:", err)
+		} else {
+			job.Input.Probe = probe
+		}
+
+		length := float64(*maxLength)
+		if bookPlan != nil {
+			length = float64(bookPlan.Duration)
+		}
+		job.TimeRange = &timeRange{Start: extractStart, Length: length}
+	}
+	if sel != nil {
+		job.SceneCuts = sel.Cuts
+		for _, f := range sel.Dropped {
+			job.DroppedFrames = append(job.DroppedFrames, f.Name())
+		}
+	}
+
+	// The steps that changed the frames, in the order they were applied
+	job.Pipeline = append(job.Pipeline, earlierSteps...)
+	if stabilizer != "" {
+		settings := map[string]interface{}{"method": stabilizer}
+		if stabilizer == "go" {
+			settings["smoothing"] = *stabilizeSmoothing
+		}
+		job.Pipeline = append(job.Pipeline, pipelineStep{Name: "stabilize", Settings: settings})
+	}
+	if sel != nil {
+		job.Pipeline = append(job.Pipeline, pipelineStep{Name: "select", Settings: map[string]interface{}{
+			"frames":    *selectFrames,
+			"dedupe":    *dedupe,
+			"sceneCut":  *sceneCut,
+			"stopAtCut": *stopAtCut,
+		}})
+	}
+	if *timingPath != "" {
+		job.Pipeline = append(job.Pipeline, pipelineStep{Name: "timing", Settings: map[string]interface{}{"path": *timingPath}})
+	}
+	if lut != nil && *effect != composite.EffectLUT {
+		job.Pipeline = append(job.Pipeline, pipelineStep{Name: "lut", Settings: map[string]interface{}{
+			"path":          *lutPath,
+			"interpolation": *lutInterp,
+		}})
+	}
+	if !adjust.IsZero() {
+		job.Pipeline = append(job.Pipeline, pipelineStep{Name: "adjust", Settings: adjust})
+	}
+	if *effect == composite.EffectLUT {
+		job.Pipeline = append(job.Pipeline, pipelineStep{Name: "effect", Settings: map[string]interface{}{
+			"name":          *effect,
+			"path":          *lutPath,
+			"interpolation": *lutInterp,
+		}})
+	} else if *effect != "" {
+		job.Pipeline = append(job.Pipeline, pipelineStep{Name: "effect", Settings: map[string]interface{}{"name": *effect}})
+	}
+
+	// The assembled book is written before the outputs are listed so it is checksummed with
+	// them. info.json is written even if verification fails, so the sheets can be checked
+	// again with verifydir
+	outputs := info.Outputs
+	var verifyErr error
+	if *verify || *verifyGIF {
+		var assembled string
+		assembled, verifyErr = verifySheets(*output, job, *verifyGIF, names, infoLog)
+		if assembled != "" {
+			outputs = append(outputs, assembled)
+		}
+	}
+
+	job.Outputs, err = checksumOutputs(*output, outputs)
+	if err != nil {
+		errLog.Println(err)
+		os.Exit(1)
+	}
+	err = writeInfo(path.Join(*output, naming.Manifest), job)
 	if err != nil {
 		errLog.Println("failed to write info.json:", err)
 		os.Exit(1)
 	}
+	if verifyErr != nil {
+		errLog.Println(verifyErr)
+		os.Exit(1)
+	}
 
 	if *cleanFrames {
 		cleanVideoFrames(*output, frames, verLog, errLog)
@@ -213,20 +969,95 @@ func main() {
 	infoLog.Println("All done")
 }
 
-func writeInfo(path string, info composite.RenderInfo) error {
-	b, err := json.MarshalIndent(struct {
-		NFrames int     `json:"nFrames"`
-		FrameAR float64 `json:"frameAR"`
-	}{
-		NFrames: info.NFrames,
-		FrameAR: info.FrameAR,
-	}, "", "  ")
+// verifySheets checks the sheets in dir assemble into the book in order, and if gif is true
+// crops the frames from the sheets into an animation of the assembled book and returns its name
+func verifySheets(dir string, job jobInfo, gif bool, names naming.Scheme, infoLog *log.Logger) (string, error) {
+	info := job.renderInfo()
+	err := composite.Verify(info)
+	if err != nil {
+		return "", fmt.Errorf("sheets failed verification: %s", err)
+	}
+	nSheets := 0
+	for _, s := range info.Sheets {
+		if !s.Back {
+			nSheets++
+		}
+	}
+	infoLog.Println("Verified", nSheets, "sheets assemble into", info.NFrames, "frames in order")
+
+	if !gif {
+		return "", nil
+	}
+	name := names.AssembledName(job.Identifier)
+	p := path.Join(dir, name)
+	var b bytes.Buffer
+	err = composite.Reconstruct(&b, info, dir, job.FPS, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to reconstruct the book from the sheets: %s", err)
+	}
+	err = ioutil.WriteFile(p, b.Bytes(), 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to save: %s, %s", p, err)
+	}
+	infoLog.Println("Assembled book written to", p)
+	return name, nil
+}
+
+// checkNames returns an error if any two files written for a book of n frames would have the
+// same name. Checked against every kind of file, since they all share the output directory
+func checkNames(opts composite.Options, layout string, n int, assembled bool) error {
+	perSheet, err := framesPerSheet(layout)
 	if err != nil {
 		return err
 	}
+	files := composite.OutputFiles(opts, perSheet, n)
+	files.Frames = n
+	files.Assembled = assembled
+	return opts.Naming.Validate(opts.Identifier, files)
+}
+
+// framesPerSheet returns the number of frames each layout prints on a single sheet
+func framesPerSheet(layout string) (int, error) {
+	switch layout {
+	case "4x6x3":
+		return 3, nil
+	case "letter", "letter-business":
+		return 10, nil
+	default:
+		return 0, fmt.Errorf("invalid layout value: %s", layout)
+	}
+}
 
-	err = ioutil.WriteFile(path, b, 0644)
-	return err
+// pickStabilizer returns the stabilizer to use, for auto the ffmpeg filters are preferred
+// if the installed ffmpeg supports them, falling back to the built in stabilizer
+func pickStabilizer(method string, errLog *log.Logger) string {
+	switch method {
+	case "auto":
+		if ffmpeg.HasFilter("vidstabdetect") {
+			return "vidstab"
+		}
+		if ffmpeg.HasFilter("deshake") {
+			return "deshake"
+		}
+		return "go"
+	case "vidstab", "deshake":
+		filter := method
+		if method == "vidstab" {
+			filter = "vidstabdetect"
+		}
+		if !ffmpeg.HasFilter(filter) {
+			errLog.Printf("--stabilize %s is not supported by the installed ffmpeg, use auto or go", method)
+			os.Exit(1)
+		}
+		return method
+	case "go":
+		return method
+	default:
+		errLog.Println("invalid stabilize option:", method)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	return ""
 }
 
 func cleanOutput(output string, verLog, errLog *log.Logger) {
@@ -253,6 +1084,38 @@ func cleanOutput(output string, verLog, errLog *log.Logger) {
 	}
 }
 
+// loadCaptions reads the captions from a file, or extracts them from a subtitle stream of the
+// input video if p is empty
+func loadCaptions(p, input string, stream int, errLog *log.Logger) captions.Track {
+	if p != "" {
+		track, err := captions.Load(p)
+		if err != nil {
+			errLog.Println(err)
+			os.Exit(1)
+		}
+		return track
+	}
+
+	tmpDir, err := ioutil.TempDir("", "fbconvert")
+	if err != nil {
+		errLog.Println("failed to create temp dir:", err)
+		os.Exit(1)
+	}
+
+	p = path.Join(tmpDir, "captions.srt")
+	err = ffmpeg.ExtractSubtitles(input, p, stream)
+	var track captions.Track
+	if err == nil {
+		track, err = captions.Load(p)
+	}
+	os.RemoveAll(tmpDir)
+	if err != nil {
+		errLog.Println(err)
+		os.Exit(1)
+	}
+	return track
+}
+
 func encodeTitles(encode bool, line1, line2 string, errLog *log.Logger) (string, string) {
 	if encode {
 		b, err := base64.StdEncoding.DecodeString(line1)
@@ -299,7 +1162,7 @@ func validateFlags(bgColor, input, output, effect string, fps int, skipVideo boo
 	}
 
 	switch effect {
-	case "oil", "pixelate", "cartoon", "edge", "pencil", "":
+	case "oil", "pixelate", "cartoon", "edge", "pencil", composite.EffectLUT, "":
 	default:
 		errLog.Println("invalid effect option:", effect)
 		flag.PrintDefaults()
@@ -319,6 +1182,61 @@ func cleanVideoFrames(output string, frames []os.FileInfo, verLog, errLog *log.L
 	}
 }
 
+// parseChapters parses a comma separated list of times in seconds where chapters start
+func parseChapters(s string) ([]float64, error) {
+	var times []float64
+	for _, part := range strings.Split(s, ",") {
+		t, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chapter time: %s", part)
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+// chapterStarts returns the frames that start each chapter, the first frame at or after the
+// time of each chapter
+func chapterStarts(chapterTimes []float64, frames []os.FileInfo, times []float64) map[string]bool {
+	chapters := make(map[string]bool)
+	for _, t := range chapterTimes {
+		for i, f := range frames {
+			if times[i] >= t {
+				chapters[f.Name()] = true
+				break
+			}
+		}
+	}
+	return chapters
+}
+
+// layoutPage returns the page of a layout, with the default margins of the layout unless
+// margins are given as top,right,bottom,left
+func layoutPage(layout, margins string) (composite.Page, error) {
+	page := composite.Page{DPI: 300}
+	switch layout {
+	case "4x6x3":
+		page.Width, page.Height = 4, 6
+	case "letter":
+		page.Width, page.Height = 8.5, 11
+		page.MarginBottom = 1
+	case "letter-business":
+		page.Width, page.Height = 8.5, 11
+		page.MarginTop, page.MarginRight, page.MarginBottom, page.MarginLeft = 0.5, 0.5, 0.5, 0.5
+	default:
+		return composite.Page{}, fmt.Errorf("invalid layout value: %s", layout)
+	}
+
+	if margins != "" {
+		top, right, bottom, left, err := parseMargins(margins)
+		if err != nil {
+			return composite.Page{}, fmt.Errorf("invalid margins option: %s", err)
+		}
+		page.MarginTop, page.MarginRight, page.MarginBottom, page.MarginLeft = top, right, bottom, left
+	}
+	return page, nil
+}
+
 func parseMargins(margins string) (float32, float32, float32, float32, error) {
 	parts := strings.Split(margins, ",")
 	if len(parts) != 4 {
@@ -345,6 +1263,24 @@ func parseMargins(margins string) (float32, float32, float32, float32, error) {
 	return float32(top), float32(right), float32(bottom), float32(left), nil
 }
 
+func parseLevels(levels string) (uint8, uint8, error) {
+	parts := strings.Split(levels, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid levels: %s, must be in the format low,high", levels)
+	}
+
+	low, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 8)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid low level value: %s, must be 0 to 255", parts[0])
+	}
+	high, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 8)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid high level value: %s, must be 0 to 255", parts[1])
+	}
+
+	return uint8(low), uint8(high), nil
+}
+
 func loadFont(fontPath string, errLog *log.Logger) []byte {
 	var fontBytes []byte
 	if fontPath != "" {
@@ -363,5 +1299,10 @@ func loadFont(fontPath string, errLog *log.Logger) []byte {
 			os.Exit(1)
 		}
 	}
+
+	if _, err := typeset.Parse(fontBytes); err != nil {
+		errLog.Println("--fontpath invalid font file:", err)
+		os.Exit(1)
+	}
 	return fontBytes
 }
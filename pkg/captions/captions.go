@@ -0,0 +1,174 @@
+package captions
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Cue is a caption shown between two times
+type Cue struct {
+	// Start the time in seconds in the video the caption appears
+	Start float64
+
+	// End the time in seconds in the video the caption disappears, exclusive
+	End float64
+
+	// Text the caption, with any formatting removed. Lines are separated by new lines
+	Text string
+}
+
+// Track is a list of cues, sorted by start time
+type Track []Cue
+
+// Load reads an SRT or WebVTT file, the format is detected from the contents
+func Load(p string) (Track, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read captions: %s", err)
+	}
+
+	t, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse captions: %s, %s", p, err)
+	}
+	return t, nil
+}
+
+// Parse parses SRT or WebVTT captions. WebVTT files start with a WEBVTT header, anything
+// else is parsed as SRT
+func Parse(b []byte) (Track, error) {
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+	text := strings.Replace(string(b), "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+
+	vtt := strings.HasPrefix(text, "WEBVTT")
+	var t Track
+	for i, block := range splitBlocks(text) {
+		lines := strings.Split(block, "\n")
+		if vtt && i == 0 {
+			// The header, which may be followed by metadata
+			continue
+		}
+		if vtt && (strings.HasPrefix(lines[0], "NOTE") || lines[0] == "STYLE" || lines[0] == "REGION") {
+			continue
+		}
+
+		// An optional identifier, the cue number in SRT files, comes before the timings
+		timings := 0
+		if !strings.Contains(lines[0], "-->") {
+			timings = 1
+		}
+		if timings >= len(lines) || !strings.Contains(lines[timings], "-->") {
+			return nil, fmt.Errorf("cue %d has no timings: %q", i+1, lines[0])
+		}
+
+		parts := strings.SplitN(lines[timings], "-->", 2)
+		start, err := parseTime(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("cue %d: %s", i+1, err)
+		}
+		// WebVTT cue settings such as position follow the end time
+		endFields := strings.Fields(parts[1])
+		if len(endFields) == 0 {
+			return nil, fmt.Errorf("cue %d has no end time", i+1)
+		}
+		end, err := parseTime(endFields[0])
+		if err != nil {
+			return nil, fmt.Errorf("cue %d: %s", i+1, err)
+		}
+		if end < start {
+			return nil, fmt.Errorf("cue %d ends before it starts", i+1)
+		}
+
+		var textLines []string
+		for _, l := range lines[timings+1:] {
+			if l = stripFormatting(l); l != "" {
+				textLines = append(textLines, l)
+			}
+		}
+		t = append(t, Cue{Start: start, End: end, Text: strings.Join(textLines, "\n")})
+	}
+
+	sort.SliceStable(t, func(i, j int) bool { return t[i].Start < t[j].Start })
+	return t, nil
+}
+
+// At returns the caption shown at the time in seconds, or an empty string if there isn't one.
+// If cues overlap their text is joined, earliest first
+func (t Track) At(seconds float64) string {
+	var text []string
+	for _, c := range t {
+		if c.Start > seconds {
+			break
+		}
+		if seconds < c.End && c.Text != "" {
+			text = append(text, c.Text)
+		}
+	}
+	return strings.Join(text, "\n")
+}
+
+// splitBlocks returns the blocks of non blank lines
+func splitBlocks(text string) []string {
+	var blocks []string
+	var cur []string
+	s := bufio.NewScanner(strings.NewReader(text))
+	s.Buffer(nil, len(text)+1)
+	for s.Scan() {
+		l := strings.TrimRight(s.Text(), " \t")
+		if l == "" {
+			if len(cur) > 0 {
+				blocks = append(blocks, strings.Join(cur, "\n"))
+				cur = nil
+			}
+			continue
+		}
+		cur = append(cur, l)
+	}
+	if len(cur) > 0 {
+		blocks = append(blocks, strings.Join(cur, "\n"))
+	}
+	return blocks
+}
+
+// parseTime parses hh:mm:ss,mmm (SRT) or [hh:]mm:ss.mmm (WebVTT) into seconds
+func parseTime(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	fields := strings.Split(strings.Replace(s, ",", ".", 1), ":")
+	if len(fields) < 2 || len(fields) > 3 {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+
+	var seconds float64
+	for _, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid time: %s", s)
+		}
+		seconds = seconds*60 + v
+	}
+	return seconds, nil
+}
+
+var (
+	// Tags such as <i>, <font color="red">, <c.yellow> and WebVTT timestamps <00:01.500>
+	tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+	// SSA style overrides that some SRT files contain, such as {\an8}
+	overridePattern = regexp.MustCompile(`\{\\[^}]*\}`)
+
+	entities = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&nbsp;", " ", "&lrm;", "‎", "&rlm;", "‏")
+)
+
+// stripFormatting removes markup from a line of caption text
+func stripFormatting(l string) string {
+	l = tagPattern.ReplaceAllString(l, "")
+	l = overridePattern.ReplaceAllString(l, "")
+	return strings.TrimSpace(entities.Replace(l))
+}
@@ -0,0 +1,136 @@
+package captions
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want Track
+	}{
+		{
+			"srt",
+			"\xef\xbb\xbf1\r\n00:00:01,500 --> 00:00:03,000\r\n<i>Hello</i> {\\an8}there\r\nsecond line\r\n\r\n" +
+				"2\r\n00:01:02,250 --> 00:01:04,000\r\nFish &amp; chips\r\n",
+			Track{
+				{Start: 1.5, End: 3, Text: "Hello there\nsecond line"},
+				{Start: 62.25, End: 64, Text: "Fish & chips"},
+			},
+		},
+		{
+			// Cues are sorted, and a cue may have no text
+			"srt out of order",
+			"2\n00:00:05,000 --> 00:00:06,000\nlater\n\n1\n00:00:01,000 --> 00:00:02,000\n\n\n3\n00:00:03,000 --> 00:00:04,000\n<b></b>\n",
+			Track{
+				{Start: 1, End: 2},
+				{Start: 3, End: 4},
+				{Start: 5, End: 6, Text: "later"},
+			},
+		},
+		{
+			"vtt",
+			"WEBVTT - captions\nKind: captions\n\nNOTE a comment\nspanning lines\n\nSTYLE\n::cue { color: yellow }\n\n" +
+				"intro\n00:01.000 --> 00:02.500 position:10% align:start\n<v Roger>Hi <c.yellow>there</c> <00:01.500>you\n\n" +
+				"01:00:00.000 --> 01:00:01.000\nan hour in\n",
+			Track{
+				{Start: 1, End: 2.5, Text: "Hi there you"},
+				{Start: 3600, End: 3601, Text: "an hour in"},
+			},
+		},
+		{"empty", "", nil},
+		{"vtt header only", "WEBVTT\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		err  string
+	}{
+		{"no timings", "1\nHello\n", `cue 1 has no timings: "1"`},
+		{"only a number", "1\n00:00:01,000 --> 00:00:02,000\nHi\n\n2\n", `cue 2 has no timings: "2"`},
+		{"bad start", "1\n00:00:x,000 --> 00:00:02,000\nHi\n", "cue 1: invalid time: 00:00:x,000"},
+		{"bad end", "1\n00:00:01,000 --> 2\nHi\n", "cue 1: invalid time: 2"},
+		{"too many fields", "1\n00:00:00:01,000 --> 00:00:02,000\nHi\n", "cue 1: invalid time: 00:00:00:01,000"},
+		{"negative", "1\n00:-1:01,000 --> 00:00:02,000\nHi\n", "cue 1: invalid time: 00:-1:01,000"},
+		{"no end", "1\n00:00:01,000 -->\nHi\n", "cue 1 has no end time"},
+		{"backwards", "1\n00:00:03,000 --> 00:00:02,000\nHi\n", "cue 1 ends before it starts"},
+		{"vtt cue", "WEBVTT\n\n00:01.000 --> 00:02.000\nHi\n\n00:0a.000 --> 00:04.000\nThere\n", "cue 3: invalid time: 00:0a.000"},
+	}
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.text))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestAt(t *testing.T) {
+	track := Track{
+		{Start: 1, End: 3, Text: "first"},
+		{Start: 2, End: 4, Text: "second"},
+		{Start: 4, End: 5},
+		{Start: 6, End: 7, Text: "third"},
+	}
+	tests := []struct {
+		seconds float64
+		want    string
+	}{
+		{0, ""},
+		{1, "first"},
+		// Overlapping cues are joined, earliest first
+		{2.5, "first\nsecond"},
+		// The end is exclusive
+		{3, "second"},
+		{4.5, ""},
+		{5.5, ""},
+		{6.999, "third"},
+		{7, ""},
+	}
+	for _, tt := range tests {
+		if got := track.At(tt.seconds); got != tt.want {
+			t.Errorf("at %g: got %q, want %q", tt.seconds, got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "captions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := path.Join(dir, "bad.srt")
+	if err := ioutil.WriteFile(p, []byte("1\nHello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Load(p)
+	if want := "failed to parse captions: " + p + `, cue 1 has no timings: "1"`; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+
+	_, err = Load(path.Join(dir, "missing.srt"))
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to read captions: ") {
+		t.Errorf("got error %v, want the file to fail to read", err)
+	}
+}
@@ -0,0 +1,6 @@
+package captions
+
+/*
+Package captions reads SRT and WebVTT subtitle files, so the words spoken in a clip can be
+printed on the frames they were spoken over
+*/
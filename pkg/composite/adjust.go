@@ -0,0 +1,231 @@
+package composite
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path"
+
+	"github.com/disintegration/imaging"
+)
+
+// normalizeClip the fraction of the darkest and brightest pixels ignored when computing
+// auto levels, so a few specular highlights or dead pixels don't stop the stretch
+const normalizeClip = 0.005
+
+// Adjustments defines colour and tone changes applied to every frame before compositing,
+// the zero value leaves the frames unchanged
+type Adjustments struct {
+	// Brightness percentage change in brightness, -100 to 100
+	Brightness float64 `json:"brightness,omitempty"`
+
+	// Contrast percentage change in contrast, -100 to 100
+	Contrast float64 `json:"contrast,omitempty"`
+
+	// Gamma gamma correction, values above 1 lighten the midtones, 0 or 1 leaves them unchanged
+	Gamma float64 `json:"gamma,omitempty"`
+
+	// Saturation percentage change in saturation, -100 to 100
+	Saturation float64 `json:"saturation,omitempty"`
+
+	// LevelsLow input level, 0 to 255, that becomes black
+	LevelsLow uint8 `json:"levelsLow,omitempty"`
+
+	// LevelsHigh input level, 0 to 255, that becomes white. 0 leaves the levels unchanged
+	LevelsHigh uint8 `json:"levelsHigh,omitempty"`
+
+	// AutoLevels if true, the levels of each frame are stretched to use the full range
+	AutoLevels bool `json:"autoLevels,omitempty"`
+
+	// Normalize if true, the levels are computed across all of the frames and the same
+	// stretch applied to each, so exposure is consistent from page to page
+	Normalize bool `json:"normalize,omitempty"`
+
+	// Grayscale if true, frames are converted to grayscale
+	Grayscale bool `json:"grayscale,omitempty"`
+
+	// Sepia if true, frames are given a sepia tone
+	Sepia bool `json:"sepia,omitempty"`
+}
+
+// IsZero returns true if the adjustments leave the frames unchanged
+func (a Adjustments) IsZero() bool {
+	return a.Brightness == 0 && a.Contrast == 0 && (a.Gamma == 0 || a.Gamma == 1) &&
+		a.Saturation == 0 && a.LevelsHigh == 0 && !a.AutoLevels && !a.Normalize &&
+		!a.Grayscale && !a.Sepia
+}
+
+// Validate returns an error if any of the adjustment values are out of range
+func (a Adjustments) Validate() error {
+	if a.Brightness < -100 || a.Brightness > 100 {
+		return fmt.Errorf("brightness must be between -100 and 100, %g invalid value", a.Brightness)
+	}
+	if a.Contrast < -100 || a.Contrast > 100 {
+		return fmt.Errorf("contrast must be between -100 and 100, %g invalid value", a.Contrast)
+	}
+	if a.Saturation < -100 || a.Saturation > 100 {
+		return fmt.Errorf("saturation must be between -100 and 100, %g invalid value", a.Saturation)
+	}
+	if a.Gamma < 0 {
+		return fmt.Errorf("gamma must not be negative, %g invalid value", a.Gamma)
+	}
+	if a.LevelsHigh != 0 && a.LevelsHigh <= a.LevelsLow {
+		return fmt.Errorf("levels high must be greater than levels low")
+	}
+	if a.Grayscale && a.Sepia {
+		return fmt.Errorf("grayscale and sepia cannot both be used")
+	}
+	if a.AutoLevels && a.Normalize {
+		return fmt.Errorf("auto levels and normalize cannot both be used")
+	}
+	return nil
+}
+
+// adjustFrames applies the adjustments to each of the frames, overwriting the frame files
+func adjustFrames(opts Options, frames []os.FileInfo) error {
+	a := opts.Adjust
+	if err := a.Validate(); err != nil {
+		return err
+	}
+
+	low, high := 0.0, 1.0
+	if a.LevelsHigh != 0 {
+		low = float64(a.LevelsLow) / 255
+		high = float64(a.LevelsHigh) / 255
+	}
+
+	if a.Normalize {
+		var hist [256]int
+		seen := make(map[string]bool)
+		for _, f := range frames {
+			if seen[f.Name()] {
+				continue
+			}
+			seen[f.Name()] = true
+
+			p := path.Join(opts.InputDir, f.Name())
+			img, err := imaging.Open(p)
+			if err != nil {
+				return fmt.Errorf("failed to load frame: %s, %s", p, err)
+			}
+			addToHistogram(&hist, img)
+		}
+		low, high = histogramLevels(hist)
+		opts.VerLog.Printf("normalizing levels across clip, low: %.3f, high: %.3f", low, high)
+	}
+
+	adjusted := make(map[string]bool)
+	for _, f := range frames {
+		// Held frames appear more than once, but must only be adjusted once
+		if adjusted[f.Name()] {
+			continue
+		}
+		adjusted[f.Name()] = true
+
+		p := path.Join(opts.InputDir, f.Name())
+		opts.VerLog.Println("Adjusting:", p)
+		img, err := imaging.Open(p)
+		if err != nil {
+			return fmt.Errorf("failed to load frame: %s, %s", p, err)
+		}
+
+		frameLow, frameHigh := low, high
+		if a.AutoLevels {
+			var hist [256]int
+			addToHistogram(&hist, img)
+			frameLow, frameHigh = histogramLevels(hist)
+		}
+
+		err = imaging.Save(adjustImage(img, a, frameLow, frameHigh), p)
+		if err != nil {
+			return fmt.Errorf("failed to save adjusted frame: %s, %s", p, err)
+		}
+	}
+	return nil
+}
+
+func adjustImage(img image.Image, a Adjustments, low, high float64) *image.NRGBA {
+	dst := imaging.Clone(img)
+
+	if low != 0 || high != 1 {
+		dst = imaging.AdjustFunc(dst, func(c color.NRGBA) color.NRGBA {
+			level := func(v uint8) uint8 {
+				return clampUint8((float64(v)/255 - low) / (high - low) * 255)
+			}
+			return color.NRGBA{R: level(c.R), G: level(c.G), B: level(c.B), A: c.A}
+		})
+	}
+	if a.Gamma != 0 && a.Gamma != 1 {
+		dst = imaging.AdjustGamma(dst, a.Gamma)
+	}
+	if a.Brightness != 0 {
+		dst = imaging.AdjustBrightness(dst, a.Brightness)
+	}
+	if a.Contrast != 0 {
+		dst = imaging.AdjustContrast(dst, a.Contrast)
+	}
+	if a.Saturation != 0 {
+		dst = imaging.AdjustSaturation(dst, a.Saturation)
+	}
+	if a.Grayscale {
+		dst = imaging.Grayscale(dst)
+	}
+	if a.Sepia {
+		dst = imaging.AdjustFunc(dst, func(c color.NRGBA) color.NRGBA {
+			r, g, b := float64(c.R), float64(c.G), float64(c.B)
+			return color.NRGBA{
+				R: clampUint8(0.393*r + 0.769*g + 0.189*b),
+				G: clampUint8(0.349*r + 0.686*g + 0.168*b),
+				B: clampUint8(0.272*r + 0.534*g + 0.131*b),
+				A: c.A,
+			}
+		})
+	}
+	return dst
+}
+
+// addToHistogram adds the luminance of every pixel in the image to the histogram
+func addToHistogram(hist *[256]int, img image.Image) {
+	gray := imaging.Grayscale(img)
+	for i := 0; i < len(gray.Pix); i += 4 {
+		hist[gray.Pix[i]]++
+	}
+}
+
+// histogramLevels returns the low and high levels, 0 to 1, that stretch the histogram to
+// cover the full range, ignoring the most extreme pixels
+func histogramLevels(hist [256]int) (float64, float64) {
+	total := 0
+	for _, n := range hist {
+		total += n
+	}
+	clip := int(float64(total) * normalizeClip)
+
+	low, count := 0, 0
+	for ; low < 255; low++ {
+		count += hist[low]
+		if count > clip {
+			break
+		}
+	}
+
+	high := 255
+	count = 0
+	for ; high > 0; high-- {
+		count += hist[high]
+		if count > clip {
+			break
+		}
+	}
+
+	if high <= low {
+		return 0, 1
+	}
+	return float64(low) / 255, float64(high) / 255
+}
+
+func clampUint8(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, v+0.5)))
+}
@@ -0,0 +1,130 @@
+package composite
+
+import (
+	"image"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestAdjustmentsValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		a    Adjustments
+		err  string
+	}{
+		{"zero", Adjustments{}, ""},
+		{"limits", Adjustments{Brightness: -100, Contrast: 100, Saturation: 100, Gamma: 2.2, LevelsLow: 10, LevelsHigh: 11}, ""},
+		{"brightness", Adjustments{Brightness: 101}, "brightness must be between -100 and 100, 101 invalid value"},
+		{"contrast", Adjustments{Contrast: -100.5}, "contrast must be between -100 and 100, -100.5 invalid value"},
+		{"saturation", Adjustments{Saturation: 200}, "saturation must be between -100 and 100, 200 invalid value"},
+		{"gamma", Adjustments{Gamma: -1}, "gamma must not be negative, -1 invalid value"},
+		{"levels", Adjustments{LevelsLow: 100, LevelsHigh: 100}, "levels high must be greater than levels low"},
+		{"grayscale and sepia", Adjustments{Grayscale: true, Sepia: true}, "grayscale and sepia cannot both be used"},
+		{"auto levels and normalize", Adjustments{AutoLevels: true, Normalize: true}, "auto levels and normalize cannot both be used"},
+	}
+	for _, tt := range tests {
+		err := tt.a.Validate()
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %s", tt.name, err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	if !(Adjustments{}).IsZero() || (Adjustments{Sepia: true}).IsZero() {
+		t.Errorf("only the zero value should be zero")
+	}
+}
+
+func TestHistogramLevels(t *testing.T) {
+	var hist [256]int
+	hist[50] = 500
+	hist[200] = 500
+	// Fewer than half a percent of the pixels, so they are clipped
+	hist[0] = 2
+	hist[255] = 2
+	low, high := histogramLevels(hist)
+	if low != 50.0/255 || high != 200.0/255 {
+		t.Errorf("got %g-%g, want 50-200", low*255, high*255)
+	}
+
+	// A flat frame can't be stretched
+	var flat [256]int
+	flat[128] = 100
+	if low, high := histogramLevels(flat); low != 0 || high != 1 {
+		t.Errorf("flat frame: got %g-%g, want 0-1", low, high)
+	}
+}
+
+func TestAdjustFrames(t *testing.T) {
+	// Two frames, half of each one grey and half another. Normalizing stretches both by the
+	// range of the whole clip, auto levels stretches each frame to the full range
+	greys := [][2]uint8{{50, 100}, {100, 200}}
+	tests := []struct {
+		name   string
+		adjust Adjustments
+		want   [][2]uint8
+	}{
+		{"normalize", Adjustments{Normalize: true}, [][2]uint8{{0, 85}, {85, 255}}},
+		{"auto levels", Adjustments{AutoLevels: true}, [][2]uint8{{0, 255}, {0, 255}}},
+		{"levels", Adjustments{LevelsLow: 50, LevelsHigh: 100}, [][2]uint8{{0, 255}, {255, 255}}},
+		{"grayscale", Adjustments{Grayscale: true}, [][2]uint8{{50, 100}, {100, 200}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "adjust")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			var frames []os.FileInfo
+			for i, g := range greys {
+				img := image.NewGray(image.Rect(0, 0, 20, 10))
+				for j := range img.Pix {
+					img.Pix[j] = g[0]
+					if j%20 >= 10 {
+						img.Pix[j] = g[1]
+					}
+				}
+				p := path.Join(dir, []string{"a.png", "b.png"}[i])
+				if err := imaging.Save(img, p); err != nil {
+					t.Fatal(err)
+				}
+				info, err := os.Stat(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Each frame is held for two pages, but must only be adjusted once
+				frames = append(frames, info, info)
+			}
+
+			err = adjustFrames(Options{InputDir: dir, Adjust: tt.adjust, VerLog: log.New(ioutil.Discard, "", 0)}, frames)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, name := range []string{"a.png", "b.png"} {
+				img, err := imaging.Open(path.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				n := imaging.Clone(img)
+				left, right := n.Pix[n.PixOffset(0, 0)], n.Pix[n.PixOffset(19, 0)]
+				if left != tt.want[i][0] || right != tt.want[i][1] {
+					t.Errorf("%s: got %d and %d, want %d and %d", name, left, right, tt.want[i][0], tt.want[i][1])
+				}
+			}
+		})
+	}
+
+	err := adjustFrames(Options{Adjust: Adjustments{Gamma: -1}}, nil)
+	if err == nil || err.Error() != "gamma must not be negative, -1 invalid value" {
+		t.Errorf("got error %v, want the adjustments to be validated", err)
+	}
+}
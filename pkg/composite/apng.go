@@ -0,0 +1,150 @@
+package composite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"math"
+)
+
+// pngSignature the first 8 bytes of every PNG
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// encodeAPNG writes an animated PNG that loops forever, each frame is shown for delayNum /
+// delayDen seconds. Frames are read one at a time from next, which returns nil once there are
+// no more. Each frame is encoded with image/png and its compressed data is moved into the
+// animation, so every frame must encode to the same colour type, which opaque RGBA frames do
+func encodeAPNG(w io.Writer, nFrames int, delayNum, delayDen uint16, next func() (image.Image, error)) error {
+	if nFrames < 1 {
+		return fmt.Errorf("an apng needs at least one frame")
+	}
+
+	var out bytes.Buffer
+	out.WriteString(pngSignature)
+
+	var ihdr []byte
+	seq := uint32(0)
+	for i := 0; i < nFrames; i++ {
+		img, err := next()
+		if err != nil {
+			return err
+		}
+		if img == nil {
+			return fmt.Errorf("expected %d apng frames, only found %d", nFrames, i)
+		}
+
+		var b bytes.Buffer
+		err = png.Encode(&b, img)
+		if err != nil {
+			return fmt.Errorf("failed to encode apng frame: %s", err)
+		}
+		chunks, err := pngChunks(b.Bytes())
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			ihdr = chunks["IHDR"][0]
+			writePNGChunk(&out, "IHDR", ihdr)
+			actl := make([]byte, 8)
+			binary.BigEndian.PutUint32(actl[0:], uint32(nFrames))
+			// 0 plays forever
+			binary.BigEndian.PutUint32(actl[4:], 0)
+			writePNGChunk(&out, "acTL", actl)
+		} else if !bytes.Equal(chunks["IHDR"][0], ihdr) {
+			return fmt.Errorf("apng frame %d does not match the size and colour type of the first frame", i)
+		}
+
+		width := binary.BigEndian.Uint32(ihdr[0:])
+		height := binary.BigEndian.Uint32(ihdr[4:])
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], seq)
+		binary.BigEndian.PutUint32(fctl[4:], width)
+		binary.BigEndian.PutUint32(fctl[8:], height)
+		// The x and y offsets are 0
+		binary.BigEndian.PutUint16(fctl[20:], delayNum)
+		binary.BigEndian.PutUint16(fctl[22:], delayDen)
+		// Dispose op none, blend op source
+		fctl[24], fctl[25] = 0, 0
+		writePNGChunk(&out, "fcTL", fctl)
+		seq++
+
+		// The first frame is the default image, stored as IDAT so viewers without APNG
+		// support still show it, the others are stored as numbered fdAT chunks
+		for _, data := range chunks["IDAT"] {
+			if i == 0 {
+				writePNGChunk(&out, "IDAT", data)
+				continue
+			}
+			fdat := make([]byte, 4, 4+len(data))
+			binary.BigEndian.PutUint32(fdat, seq)
+			writePNGChunk(&out, "fdAT", append(fdat, data...))
+			seq++
+		}
+	}
+	writePNGChunk(&out, "IEND", nil)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// pngChunks returns the payloads of the chunks of a PNG by type, in order
+func pngChunks(b []byte) (map[string][][]byte, error) {
+	if !bytes.HasPrefix(b, []byte(pngSignature)) {
+		return nil, fmt.Errorf("invalid png signature")
+	}
+	chunks := make(map[string][][]byte)
+	b = b[len(pngSignature):]
+	for len(b) >= 12 {
+		n := int(binary.BigEndian.Uint32(b))
+		if 12+n > len(b) {
+			return nil, fmt.Errorf("truncated png chunk")
+		}
+		kind := string(b[4:8])
+		chunks[kind] = append(chunks[kind], b[8:8+n])
+		b = b[12+n:]
+	}
+	if len(chunks["IHDR"]) != 1 || len(chunks["IDAT"]) == 0 {
+		return nil, fmt.Errorf("invalid png, missing IHDR or IDAT")
+	}
+	return chunks, nil
+}
+
+func writePNGChunk(w *bytes.Buffer, kind string, data []byte) {
+	binary.Write(w, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	io.WriteString(crc, kind)
+	crc.Write(data)
+	w.WriteString(kind)
+	w.Write(data)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+// apngDelay returns the delay of a frame at fps as the fraction num/den seconds closest to
+// 1/fps, both of which must fit in 16 bits. Rates that are a ratio of whole numbers, such as
+// 12.5 or 29.97, are exact
+func apngDelay(fps float64) (uint16, uint16) {
+	bestNum, bestDen, bestErr := 1, 1, math.Inf(1)
+	for num := 1; num <= math.MaxUint16; num++ {
+		den := math.Round(float64(num) * fps)
+		if den > math.MaxUint16 {
+			break
+		}
+		if den < 1 {
+			continue
+		}
+		e := math.Abs(float64(num)/den - 1/fps)
+		if e < bestErr {
+			bestNum, bestDen, bestErr = num, int(den), e
+		}
+		if e == 0 {
+			break
+		}
+	}
+	// The smallest num with the least error is kept, so the fraction is in its lowest terms
+	return uint16(bestNum), uint16(bestDen)
+}
@@ -0,0 +1,69 @@
+package composite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math"
+	"testing"
+)
+
+func TestAPNGDelay(t *testing.T) {
+	tests := []struct {
+		fps      float64
+		num, den uint16
+	}{
+		{1, 1, 1},
+		{0.5, 2, 1},
+		{12, 1, 12},
+		{12.5, 2, 25},
+		{29.97, 100, 2997},
+		{30000.0 / 1001, 1001, 30000},
+		// Above 65.535fps the delay no longer fits in thousandths of a second
+		{66, 1, 66},
+		{75.5, 2, 151},
+		{100, 1, 100},
+	}
+	for _, tt := range tests {
+		num, den := apngDelay(tt.fps)
+		if num != tt.num || den != tt.den {
+			t.Errorf("%gfps: got %d/%d, want %d/%d", tt.fps, num, den, tt.num, tt.den)
+		}
+	}
+
+	// Rates that aren't a ratio of small numbers are as close as 16 bits allow
+	for _, fps := range []float64{math.Pi, math.Sqrt2 * 50, 0.01} {
+		num, den := apngDelay(fps)
+		if got := float64(den) / float64(num); math.Abs(got-fps)/fps > 1e-6 {
+			t.Errorf("%gfps: got %d/%d, %g fps", fps, num, den, got)
+		}
+	}
+}
+
+func TestEncodeAPNGDelay(t *testing.T) {
+	frame := testSheet(8, 6)
+	n := 0
+	next := func() (image.Image, error) {
+		if n == 2 {
+			return nil, nil
+		}
+		n++
+		return frame, nil
+	}
+
+	var b bytes.Buffer
+	num, den := apngDelay(75)
+	if err := encodeAPNG(&b, 2, num, den, next); err != nil {
+		t.Fatal(err)
+	}
+	_, chunks := pngChunkTypes(b.Bytes())
+	if len(chunks["fcTL"]) != 2 {
+		t.Fatalf("got %d frame controls, want 2", len(chunks["fcTL"]))
+	}
+	for _, fctl := range chunks["fcTL"] {
+		gotNum, gotDen := binary.BigEndian.Uint16(fctl[20:]), binary.BigEndian.Uint16(fctl[22:])
+		if gotNum != 1 || gotDen != 75 {
+			t.Errorf("got a delay of %d/%d, want 1/75", gotNum, gotDen)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package composite
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/draw"
+)
+
+// Values of Binding.Side
+const (
+	BindingLeft  = "left"
+	BindingRight = "right"
+	BindingTop   = "top"
+)
+
+// Values of Binding.Style
+const (
+	BindingSolid   = "solid"
+	BindingPattern = "pattern"
+	BindingImage   = "image"
+	BindingNone    = "none"
+)
+
+// Values of Options.Orientation
+const (
+	OrientationSide = "side"
+	OrientationTop  = "top"
+)
+
+// DefaultBindingWidth the width of the binding strip in inches when none is specified, 100
+// pixels at 300 DPI
+const DefaultBindingWidth = 1.0 / 3
+
+// Binding describes the strip along one edge of each frame that is held by the clip, staple or
+// glue once the stacks are assembled. Frames are scaled to fit beside the strip, so it never
+// covers any of the picture
+type Binding struct {
+	// Width of the strip in inches, defaults to DefaultBindingWidth
+	Width float32
+
+	// Side the edge of the frame the strip is on, left|right|top, defaults to left. Use top for
+	// books that are flipped from the bottom edge
+	Side string
+
+	// Style how the strip is filled, solid|pattern|image|none, defaults to solid. none leaves
+	// the strip blank but still keeps the frame clear of it
+	Style string
+
+	// Color the colour of a solid strip or the stripes of a pattern as #rrggbb or #rrggbbaa,
+	// defaults to black
+	Color string
+
+	// Image the image used by the image style, scaled and cropped to fill the strip
+	Image image.Image
+}
+
+// Validate returns an error if any of the binding values are invalid
+func (b Binding) Validate() error {
+	if b.Width < 0 {
+		return fmt.Errorf("binding width must not be negative, %g invalid value", b.Width)
+	}
+	switch b.Side {
+	case "", BindingLeft, BindingRight, BindingTop:
+	default:
+		return fmt.Errorf("invalid binding side: %s, must be left|right|top", b.Side)
+	}
+	switch b.Style {
+	case "", BindingSolid, BindingPattern, BindingNone:
+	case BindingImage:
+		if b.Image == nil {
+			return fmt.Errorf("the image binding style needs an image")
+		}
+	default:
+		return fmt.Errorf("invalid binding style: %s, must be solid|pattern|image|none", b.Style)
+	}
+	if _, err := parseColor(b.Color); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateOrientation returns an error if the orientation is invalid, or if the binding is on
+// a side that doesn't work with it
+func validateOrientation(opts Options) error {
+	switch opts.Orientation {
+	case "", OrientationSide:
+	case OrientationTop:
+		if opts.Binding.Side != "" && opts.Binding.Side != BindingTop {
+			return fmt.Errorf("top bound books are bound along the top edge, binding side %s invalid", opts.Binding.Side)
+		}
+	default:
+		return fmt.Errorf("invalid orientation: %s, must be side|top", opts.Orientation)
+	}
+	return nil
+}
+
+// split divides the bounds of a frame into the binding strip and the area left for the picture
+func (b Binding) split(bounds image.Rectangle, dpi int) (strip, area image.Rectangle) {
+	width := b.Width
+	if width == 0 {
+		width = DefaultBindingWidth
+	}
+	size := int(math.Round(float64(width) * float64(dpi)))
+
+	strip, area = bounds, bounds
+	switch b.Side {
+	case BindingRight:
+		strip.Min.X = bounds.Max.X - size
+		area.Max.X = strip.Min.X
+	case BindingTop:
+		strip.Max.Y = bounds.Min.Y + size
+		area.Min.Y = strip.Max.Y
+	default:
+		strip.Max.X = bounds.Min.X + size
+		area.Min.X = strip.Max.X
+	}
+	return strip, area
+}
+
+// drawBinding fills the strip in the binding's style
+func drawBinding(img *image.RGBA, strip image.Rectangle, b Binding) {
+	fg, _ := parseColor(b.Color)
+	if fg == nil {
+		fg = color.Black
+	}
+
+	switch b.Style {
+	case BindingNone:
+	case BindingImage:
+		filled := imaging.Fill(b.Image, strip.Dx(), strip.Dy(), imaging.Center, imaging.Lanczos)
+		draw.Draw(img, strip, filled, image.Point{}, draw.Over)
+	case BindingPattern:
+		// Diagonal stripes, spaced so a strip of the default width has about six of them
+		period := strip.Dx()
+		if b.Side == BindingTop {
+			period = strip.Dy()
+		}
+		period = int(math.Max(4, float64(period)/6))
+		mask := image.NewAlpha(strip)
+		for y := strip.Min.Y; y < strip.Max.Y; y++ {
+			for x := strip.Min.X; x < strip.Max.X; x++ {
+				if (x+y)%period < period/2 {
+					mask.SetAlpha(x, y, color.Alpha{A: 0xff})
+				}
+			}
+		}
+		draw.DrawMask(img, strip, image.NewUniform(fg), image.Point{}, mask, strip.Min, draw.Over)
+	default:
+		draw.Draw(img, strip, image.NewUniform(fg), image.Point{}, draw.Over)
+	}
+}
@@ -0,0 +1,139 @@
+package composite
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/markdaws/go-flipbook/pkg/captions"
+	"github.com/markdaws/go-flipbook/pkg/typeset"
+)
+
+// Values of CaptionStyle.Position
+const (
+	CaptionTop    = "top"
+	CaptionBottom = "bottom"
+)
+
+// CaptionStyle describes how captions are drawn on the frames
+type CaptionStyle struct {
+	// Fonts the fonts used for captions, before the font from the options and the fallback
+	// fonts. If nil the font from the options is used
+	Fonts typeset.Fonts
+
+	// Size the font size in points, defaults to 9
+	Size float64
+
+	// Color the colour of the text as #rrggbb or #rrggbbaa, defaults to white
+	Color string
+
+	// Outline the colour of the outline drawn around the text so it can be read on any frame,
+	// defaults to black. Use "none" for no outline
+	Outline string
+
+	// Position where the caption is drawn on the frame, top|bottom, defaults to bottom
+	Position string
+
+	// MaxLines the most lines a caption can wrap onto, text is shrunk to fit and then any
+	// lines after this are dropped. Defaults to 2
+	MaxLines int
+}
+
+// Validate returns an error if any of the style values are invalid
+func (s CaptionStyle) Validate() error {
+	if s.Size < 0 {
+		return fmt.Errorf("caption size must not be negative, %g invalid value", s.Size)
+	}
+	if s.MaxLines < 0 {
+		return fmt.Errorf("caption max lines must not be negative, %d invalid value", s.MaxLines)
+	}
+	switch s.Position {
+	case "", CaptionTop, CaptionBottom:
+	default:
+		return fmt.Errorf("invalid caption position: %s, must be top|bottom", s.Position)
+	}
+	if _, err := parseColor(s.Color); err != nil {
+		return err
+	}
+	if s.Outline != "none" {
+		if _, err := parseColor(s.Outline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drawCaption draws the caption active at the time of the frame in the cell, area is the
+// part of the cell showing the frame
+func drawCaption(img *image.RGBA, area image.Rectangle, track captions.Track, seconds float64, style CaptionStyle, fonts typeset.Fonts, dpi int) error {
+	text := track.At(seconds)
+	if text == "" {
+		return nil
+	}
+
+	dst, ok := img.SubImage(area).(*image.RGBA)
+	if !ok {
+		return fmt.Errorf("failed to clip caption to the frame")
+	}
+
+	size := style.Size
+	if size == 0 {
+		size = 9
+	}
+	maxLines := style.MaxLines
+	if maxLines == 0 {
+		maxLines = 2
+	}
+	fg, _ := parseColor(style.Color)
+	if fg == nil {
+		fg = color.White
+	}
+	var outline color.Color = color.Black
+	if style.Outline == "none" {
+		outline = nil
+	} else if c, _ := parseColor(style.Outline); c != nil {
+		outline = c
+	}
+
+	chain := append(append(typeset.Fonts{}, style.Fonts...), fonts...)
+
+	// Captions sit inside a margin so they aren't lost when the pages are cut
+	margin := area.Dy() / 20
+	box := image.Rect(area.Min.X+margin, area.Min.Y+margin, area.Max.X-margin, area.Max.Y-margin)
+
+	px := float64(dpi) / 72
+	var t *typeset.Text
+	for size *= px; ; size *= 0.9 {
+		var err error
+		t, err = typeset.Layout(text, chain, size, box.Dx())
+		if err != nil {
+			return err
+		}
+		if t.Lines() <= maxLines || size <= minFontSize*px {
+			break
+		}
+	}
+	t.Truncate(maxLines)
+
+	valign := typeset.VAlignBottom
+	if style.Position == CaptionTop {
+		valign = typeset.VAlignTop
+	}
+
+	if outline != nil {
+		// The outline is drawn by drawing the text offset in a ring around where it goes
+		width := float64(t.LineHeight()) / 16
+		if width < 1 {
+			width = 1
+		}
+		src := image.NewUniform(outline)
+		for a := 0; a < 16; a++ {
+			angle := float64(a) * math.Pi / 8
+			offset := image.Pt(int(math.Round(width*math.Cos(angle))), int(math.Round(width*math.Sin(angle))))
+			t.Draw(dst, box.Add(offset), typeset.AlignCenter, valign, src)
+		}
+	}
+	t.Draw(dst, box, typeset.AlignCenter, valign, image.NewUniform(fg))
+	return nil
+}
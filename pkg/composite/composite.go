@@ -3,48 +3,46 @@ package composite
 import (
 	"fmt"
 	"image"
-	"image/jpeg"
 	"image/png"
 	"io/ioutil"
 	"log"
-	"math"
 	"os"
 	"path"
-	"strconv"
+	"strings"
 
 	"golang.org/x/image/draw"
-	"golang.org/x/image/font"
-	"golang.org/x/image/font/basicfont"
-	"golang.org/x/image/math/fixed"
 
 	"github.com/disintegration/imaging"
-	"github.com/golang/freetype"
 	"github.com/markdaws/go-effects/pkg/effects"
+	"github.com/markdaws/go-flipbook/pkg/captions"
+	"github.com/markdaws/go-flipbook/pkg/icc"
+	"github.com/markdaws/go-flipbook/pkg/naming"
+	"github.com/markdaws/go-flipbook/pkg/typeset"
 )
 
 // Page defines all of the parameters of a single page, that can hold one
 // or more frames
 type Page struct {
 	// Width of the page in inches
-	Width float32
+	Width float32 `json:"width"`
 
 	// Height of the page in inches
-	Height float32
+	Height float32 `json:"height"`
 
 	// MarginTop size of top margin in inches
-	MarginTop float32
+	MarginTop float32 `json:"marginTop"`
 
 	// MarginRight size of right margin in inches
-	MarginRight float32
+	MarginRight float32 `json:"marginRight"`
 
 	// MarginBottom size of bottom margin in inches
-	MarginBottom float32
+	MarginBottom float32 `json:"marginBottom"`
 
 	// MarginLeft size of left margin in inches
-	MarginLeft float32
+	MarginLeft float32 `json:"marginLeft"`
 
 	// DPI number of dots per inch e.g. 300
-	DPI int
+	DPI int `json:"dpi"`
 }
 
 // Options allows callers to define all of the composition options
@@ -65,6 +63,10 @@ type Options struct {
 	// but frame images are in this directory
 	InputDir string
 
+	// Frames the frames in InputDir to composite, in order. A frame may appear more than once
+	// to hold it for several pages. If nil, every file in InputDir is used
+	Frames []os.FileInfo
+
 	// OutputDir the directory where the final composite images will be written to
 	OutputDir string
 
@@ -80,6 +82,10 @@ type Options struct {
 	// FontBytes bytes read in from a ttf file for the font to use on the front cover
 	FontBytes []byte
 
+	// FallbackFonts fonts used for characters FontBytes doesn't have, in order, e.g. CJK or
+	// emoji fonts
+	FallbackFonts typeset.Fonts
+
 	// ReversePages if true we print the last page first, useful if you are printing in order,
 	// so you don't have to manually reverse the pages before cutting them
 	ReversePages bool
@@ -91,15 +97,120 @@ type Options struct {
 	// Cover if true a cover image is rendered
 	Cover bool
 
-	// GIF if true an animated GIF is generated from the individual frames
+	// CoverTemplate describes the background and text of the cover, if nil DefaultCoverTemplate is used
+	CoverTemplate *CoverTemplate
+
+	// BackCover if true a back cover is rendered as the last frame of the book
+	BackCover bool
+
+	// BackCoverTemplate describes the back cover, if nil DefaultBackCoverTemplate is used
+	BackCoverTemplate *CoverTemplate
+
+	// Pages blank or credit pages added to the end of the book, before the back cover. Use
+	// BlankPageTemplate for a blank page
+	Pages []*CoverTemplate
+
+	// Captions if not nil, the caption active at the time of each frame is drawn on it
+	Captions captions.Track
+
+	// CaptionStyle describes how captions are drawn
+	CaptionStyle CaptionStyle
+
+	// FrameTimes the time in seconds in the video of each frame, by file name. Frames without
+	// a time don't get a caption and have an empty timecode in their label
+	FrameTimes map[string]float64
+
+	// Labels describes the label printed on the binding strip of each frame
+	Labels LabelStyle
+
+	// Markers describes the markers printed along the flip edge of each frame
+	Markers MarkerStyle
+
+	// Chapters the frames that start a new chapter, by file name, used by tab markers
+	Chapters map[string]bool
+
+	// Binding describes the strip on each frame that is held once the book is assembled
+	Binding Binding
+
+	// Orientation how the book is bound, side|top, defaults to side. Top bound books are bound
+	// along the top edge like a calendar and flipped from the bottom, each frame is drawn turned
+	// a quarter turn anticlockwise in its cell so the binding strip is on the same edge of the cell
+	// as for a side bound book and the sheets are cut and stacked the same way
+	Orientation string
+
+	// Duplex the edge the printer turns the sheets over on to print both sides, long|short. If
+	// empty the sheets are printed on one side. The fronts and backs of each sheet are written
+	// one after the other, front first, and the frames on the back are placed behind the same
+	// cells as on the front, so the sheets are cut and stacked as usual
+	Duplex string
+
+	// DuplexPDF if true the fronts and backs are written to a single PDF, front first, instead
+	// of a file for each side. The sheet writer must be a PDFWriter
+	DuplexPDF bool
+
+	// GIF if true an animated GIF preview is generated, the same as listing gif in Previews
 	GIF bool
 
-	// SmallFrames if true half size versions of each frame are created in the output dir
-	SmallFrames bool
+	// Previews the formats of animated previews of the book written to the output dir,
+	// gif|apng|webp|mp4. They are made from the finished frames, with their effects, labels and
+	// captions, in the order they are flipped. mp4 needs ffmpeg to be installed
+	Previews []string
+
+	// PreviewFPS the frame rate of the previews, normally the fps the frames were extracted at,
+	// defaults to DefaultPreviewFPS
+	PreviewFPS float64
+
+	// PreviewWidth the width of the previews in pixels, defaults to DefaultPreviewWidth
+	PreviewWidth int
+
+	// Simulator if true an HTML page is written to the output dir that flips through the book
+	// page by page, made from the same frames as the previews at the preview size and fps
+	Simulator bool
+
+	// Proof if true contact sheets are written to the output dir showing every finished frame
+	// in the order the book is flipped, with its index, timecode, stack and sheet
+	Proof bool
+
+	// ProofColumns the number of thumbnails across each contact sheet, defaults to
+	// DefaultProofColumns
+	ProofColumns int
 
-	// Effect is the name of an image processing effect to apply to each frame, values are 'oil'
+	// ProofRows the number of rows of thumbnails on each contact sheet, more frames go on
+	// another sheet. Defaults to DefaultProofRows
+	ProofRows int
+
+	// Effect is the name of an image processing effect to apply to each frame, values are
+	// 'oil|pixelate|edge|cartoon|pencil|lut', lut applies the LUT as the effect
 	Effect string
 
+	// LUT if not nil, a colour grading lookup table applied to each frame before the adjustments,
+	// or after them and the cover when Effect is lut
+	LUT *LUT
+
+	// LUTInterpolation how colours between 3D LUT entries are computed, trilinear|tetrahedral,
+	// defaults to tetrahedral
+	LUTInterpolation string
+
+	// Adjust colour and tone adjustments applied to each frame before the cover and effect
+	Adjust Adjustments
+
+	// SheetWriter encodes the composite sheets, if nil sheets are written as JPEGs at quality 90
+	SheetWriter SheetWriter
+
+	// Naming the templates used to name the sheets and the cover, the zero value uses the defaults
+	Naming naming.Scheme
+
+	// ColorProfile the builtin RGB profile embedded in the sheets, srgb|adobergb. Frames are
+	// sRGB, so for adobergb the sheets are converted. If empty no profile is embedded
+	ColorProfile string
+
+	// CMYK if true the sheets are converted to CMYK, the sheet writer must support CMYK
+	CMYK bool
+
+	// OutputProfile the ICC profile of the printer, used to convert the sheets to CMYK and embedded
+	// in them. If nil a simple conversion is used, which will not be colour accurate
+	OutputProfile *icc.OutputProfile
+
 	// VerLog a logger that will receive verbose information
 	VerLog *log.Logger
 }
@@ -111,6 +222,45 @@ type RenderInfo struct {
 
 	// FrameAR the aspect ratio of the final frames
 	FrameAR float64
+
+	// Sheets the sides of the sheets in the order they were rendered, with the frames on each
+	Sheets []SheetInfo
+
+	// SheetWidth, SheetHeight the size of the sheets in pixels
+	SheetWidth  int
+	SheetHeight int
+
+	// ReversePages, ReverseFrames and Duplex the options the sheets were rendered with, which
+	// change how they are assembled into the book
+	ReversePages  bool
+	ReverseFrames bool
+	Duplex        string
+
+	// Trimmed the frames left out of the book so the last sheet has no empty cells
+	Trimmed []string
+
+	// Outputs the names of the files written to the output directory, in the order they were
+	// written
+	Outputs []string
+}
+
+// OutputFiles returns the files the options write to the output directory for a book of n
+// frames, including the added pages, printed framesPerPage to a side. The frames and the
+// assembled book aren't written while compositing, so they are left for the caller to add
+func OutputFiles(opts Options, framesPerPage, n int) naming.Files {
+	files := naming.Files{
+		Sheets:    n / framesPerPage,
+		SheetExt:  sheetWriter(opts).Ext(),
+		Pages:     len(opts.Pages),
+		Simulator: opts.Simulator,
+	}
+	for _, p := range previews(opts) {
+		files.PreviewExts = append(files.PreviewExts, previewExt[p])
+	}
+	if opts.Proof {
+		files.Proofs = proofPages(opts, n)
+	}
+	return files
 }
 
 type rect struct {
@@ -121,15 +271,33 @@ type rect struct {
 }
 
 type frame struct {
-	path         string
-	index        int
-	label        string
-	info         os.FileInfo
-	isFrontCover bool
-	bounds       rect
+	path   string
+	index  int
+	label  string
+	info   os.FileInfo
+	design *CoverTemplate
+	bounds rect
+
+	// sheet the index of the sheet the frame is printed on, as used in its file name
+	sheet int
+
+	// stack the position of the frame on the sheet, which is the stack it ends up in once the
+	// sheets are cut
+	stack int
+
+	// back true if the frame is printed on the back of a duplex sheet
+	back bool
+
+	// progress the position of the frame in the book, from 0 at the start to 1 at the end
+	progress float64
+
+	// chapter the index of the chapter the frame is in
+	chapter int
 }
 
-type layoutFunc func(pageIndex, nPages, frontCoverIndex int, renderBounds rect, opts Options, frames []os.FileInfo) []frame
+// layoutFunc returns the frames printed on a page, designs maps the index of each designed
+// frame, the covers and added pages, to its template
+type layoutFunc func(pageIndex, nPages int, designs map[int]*CoverTemplate, renderBounds rect, opts Options, frames []os.FileInfo) []frame
 
 // To4x6x3 composites the source images to a 6x4 format, with 3 frames per image. Each frame will
 // get 4x2 in dimension within the 6x4 image.
@@ -155,7 +323,7 @@ func To4x6x3(opts Options) (RenderInfo, error) {
 	opts.Rows = 3
 	opts.Cols = 1
 
-	layoutPage := func(pageIndex, nPages, frontCoverIndex int, renderBounds rect, opts Options, frames []os.FileInfo) []frame {
+	layoutPage := func(pageIndex, nPages int, designs map[int]*CoverTemplate, renderBounds rect, opts Options, frames []os.FileInfo) []frame {
 		var pageLayout []frame
 		nFrames := len(frames)
 
@@ -176,9 +344,10 @@ func To4x6x3(opts Options) (RenderInfo, error) {
 					width:  renderBounds.width,
 					height: frameHeight,
 				},
-				index:        fi,
-				isFrontCover: fi == frontCoverIndex,
-				label:        opts.Identifier,
+				index:  fi,
+				design: designs[fi],
+				label:  opts.Identifier,
+				stack:  ri,
 			}
 			pageLayout = append(pageLayout, f)
 		}
@@ -193,7 +362,7 @@ func ToLetter(opts Options) (RenderInfo, error) {
 	opts.Rows = 5
 	opts.Cols = 2
 
-	layoutPage := func(pageIndex, nPages, frontCoverIndex int, renderBounds rect, opts Options, frames []os.FileInfo) []frame {
+	layoutPage := func(pageIndex, nPages int, designs map[int]*CoverTemplate, renderBounds rect, opts Options, frames []os.FileInfo) []frame {
 		var pageLayout []frame
 		nFrames := len(frames)
 		//framesPerPage := opts.Cols * opts.Rows
@@ -219,9 +388,10 @@ func ToLetter(opts Options) (RenderInfo, error) {
 						width:  frameWidth,
 						height: frameHeight,
 					},
-					index:        fi,
-					isFrontCover: fi == frontCoverIndex,
-					label:        opts.Identifier,
+					index:  fi,
+					design: designs[fi],
+					label:  opts.Identifier,
+					stack:  ci*opts.Rows + ri,
 				}
 				pageLayout = append(pageLayout, f)
 			}
@@ -237,49 +407,197 @@ func renderPages(opts Options, layout layoutFunc) (RenderInfo, error) {
 		return RenderInfo{}, fmt.Errorf("VerLog cannot be nil")
 	}
 
-	frames, err := ioutil.ReadDir(opts.InputDir)
+	err := validateSheetOptions(opts)
+	if err != nil {
+		return RenderInfo{}, err
+	}
+
+	err = opts.CaptionStyle.Validate()
+	if err != nil {
+		return RenderInfo{}, err
+	}
+
+	err = opts.Labels.Validate()
+	if err != nil {
+		return RenderInfo{}, err
+	}
+
+	err = opts.Binding.Validate()
+	if err != nil {
+		return RenderInfo{}, err
+	}
+
+	err = opts.Markers.Validate()
+	if err != nil {
+		return RenderInfo{}, err
+	}
+
+	err = validateOrientation(opts)
+	if err != nil {
+		return RenderInfo{}, err
+	}
+
+	err = validateDuplex(opts)
+	if err != nil {
+		return RenderInfo{}, err
+	}
+
+	err = validatePreviews(opts)
+	if err != nil {
+		return RenderInfo{}, err
+	}
+
+	err = validateProof(opts)
 	if err != nil {
-		return RenderInfo{}, fmt.Errorf("failed to read input images: %s", err)
+		return RenderInfo{}, err
 	}
 
-	// Trim the number of frames so we never end up with any empty spaces on the pages
+	// Parsed once, since every designed or captioned frame draws text
+	fonts, err := typeset.Parse(opts.FontBytes)
+	if err != nil {
+		return RenderInfo{}, err
+	}
+	fonts = append(fonts, opts.FallbackFonts...)
+
+	var frames []os.FileInfo
+	if opts.Frames != nil {
+		// Copy since the cover replaces one of the frames and pages are added below
+		frames = append(frames, opts.Frames...)
+	} else {
+		frames, err = ioutil.ReadDir(opts.InputDir)
+		if err != nil {
+			return RenderInfo{}, fmt.Errorf("failed to read input images: %s", err)
+		}
+	}
+
+	// The added pages and back cover take the place of frames, so they are counted when trimming
+	var extras []*CoverTemplate
+	extras = append(extras, opts.Pages...)
+	if opts.BackCover {
+		extras = append(extras, backCoverTemplate(opts))
+	}
+
+	// Trim the number of frames so we never end up with any empty spaces on the pages, duplex
+	// sheets have frames on both sides
 	nCols := opts.Cols
 	nRows := opts.Rows
 	framesPerPage := nCols * nRows
-	frames = frames[:framesPerPage*(len(frames)/framesPerPage)]
+	nSides := 1
+	if opts.Duplex != "" {
+		nSides = 2
+	}
+	framesPerSheet := framesPerPage * nSides
+	keep := framesPerSheet*((len(frames)+len(extras))/framesPerSheet) - len(extras)
+	if keep < 1 {
+		return RenderInfo{}, fmt.Errorf("not enough frames to fill a page, %d frames and %d added pages", len(frames), len(extras))
+	}
+	var trimmed []string
+	for _, f := range frames[keep:] {
+		trimmed = append(trimmed, f.Name())
+	}
+	frames = frames[:keep]
 
-	var coverImgIndex int
-	if opts.Cover {
-		coverFrame := frames[0]
-		coverFramePath := path.Join(opts.InputDir, coverFrame.Name())
-		coverImg, err := renderFrontCover(coverFramePath)
+	err = opts.Naming.Validate(opts.Identifier, OutputFiles(opts, framesPerPage, len(frames)+len(extras)))
+	if err != nil {
+		return RenderInfo{}, fmt.Errorf("invalid naming: %s", err)
+	}
+
+	if opts.Effect == EffectLUT && opts.LUT == nil {
+		return RenderInfo{}, fmt.Errorf("the lut effect needs a LUT")
+	}
+	if opts.LUT != nil && opts.Effect != EffectLUT {
+		err = lutFrames(opts, frames)
 		if err != nil {
-			return RenderInfo{}, fmt.Errorf("failed to generate cover image: %s", err)
+			return RenderInfo{}, fmt.Errorf("failed to apply lut: %s", err)
 		}
+	}
 
-		coverImgOutPath := path.Join(opts.OutputDir, "cover.png")
-		err = imaging.Save(coverImg, coverImgOutPath)
+	if !opts.Adjust.IsZero() {
+		err = adjustFrames(opts, frames)
 		if err != nil {
-			return RenderInfo{}, fmt.Errorf("failed to save cover image: %s", err)
+			return RenderInfo{}, fmt.Errorf("failed to adjust frames: %s", err)
 		}
+	}
 
-		coverImgInfo, err := os.Stat(coverImgOutPath)
-		if err != nil {
-			return RenderInfo{}, fmt.Errorf("failed to stat cover image: %s", err)
+	// Backgrounds pick their frame in book order, which is reversed when the frames are
+	// printed in reverse
+	names := make([]string, len(frames))
+	for i, f := range frames {
+		names[i] = f.Name()
+	}
+	if opts.ReverseFrames {
+		for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+			names[i], names[j] = names[j], names[i]
 		}
+	}
 
+	var outputs []string
+	if opts.Cover {
+		coverImgInfo, err := saveBackground(coverTemplate(opts), opts, names, opts.Naming.CoverName(opts.Identifier))
+		if err != nil {
+			return RenderInfo{}, fmt.Errorf("failed to generate cover image: %s", err)
+		}
+		outputs = append(outputs, coverImgInfo.Name())
 		if opts.ReverseFrames {
-			coverImgIndex = len(frames) - 1
+			frames[len(frames)-1] = coverImgInfo
 		} else {
-			coverImgIndex = 0
+			frames[0] = coverImgInfo
+		}
+	}
+
+	var extraInfos []os.FileInfo
+	for i, t := range extras {
+		name := opts.Naming.BackCoverName(opts.Identifier)
+		if i < len(opts.Pages) {
+			name = opts.Naming.PageName(opts.Identifier, i, len(opts.Pages))
+		}
+		info, err := saveBackground(t, opts, names, name)
+		if err != nil {
+			return RenderInfo{}, fmt.Errorf("failed to generate page: %s, %s", name, err)
+		}
+		extraInfos = append(extraInfos, info)
+		outputs = append(outputs, info.Name())
+	}
+
+	// The extras follow the last frame of the book. When the frames are reversed the layouts
+	// print the end of the slice first, so the extras go at the front in reverse order
+	designs := make(map[int]*CoverTemplate)
+	if opts.ReverseFrames {
+		var reordered []os.FileInfo
+		for i := len(extras) - 1; i >= 0; i-- {
+			designs[len(reordered)] = extras[i]
+			reordered = append(reordered, extraInfos[i])
+		}
+		frames = append(reordered, frames...)
+		if opts.Cover {
+			designs[len(frames)-1] = coverTemplate(opts)
+		}
+	} else {
+		if opts.Cover {
+			designs[0] = coverTemplate(opts)
+		}
+		for i, info := range extraInfos {
+			designs[len(frames)] = extras[i]
+			frames = append(frames, info)
 		}
-		frames[coverImgIndex] = coverImgInfo
 	}
 
 	//TODO: More efficient - should resize input frames first before applying
 	//an effect
-	if opts.Effect != "" {
+	if opts.Effect == EffectLUT {
+		err = lutFrames(opts, frames)
+		if err != nil {
+			return RenderInfo{}, fmt.Errorf("failed to apply lut effect: %s", err)
+		}
+	} else if opts.Effect != "" {
+		applied := make(map[string]bool)
 		for _, f := range frames {
+			// Held frames appear more than once, but the effect must only be applied once
+			if applied[f.Name()] {
+				continue
+			}
+			applied[f.Name()] = true
+
 			p := path.Join(opts.InputDir, f.Name())
 			var outImg *effects.Image
 
@@ -335,18 +653,39 @@ func renderPages(opts Options, layout layoutFunc) (RenderInfo, error) {
 		}
 	}
 
-	/*
-		if opts.GIF {
-			renderGIF(frames, opts.InputDir, opts.OutputDir)
-		}*/
-
 	nFrames := len(frames)
-	nPages := nFrames / framesPerPage
+	nPages := nFrames / framesPerSheet
 
 	opts.VerLog.Println("reading input frames from:", opts.InputDir)
 	opts.VerLog.Println(nFrames, "found for processing")
 	opts.VerLog.Println(nPages, "pages to be generated")
 
+	bookNames := make([]string, nFrames)
+	for i, f := range frames {
+		bookNames[i] = f.Name()
+	}
+	chapters := chapterStarts(bookNames, opts.Chapters)
+
+	sides := []bookSide{singleSide(frames, designs)}
+	if opts.Duplex != "" {
+		fronts, backs := splitSides(frames, designs, opts.ReverseFrames)
+		sides = []bookSide{fronts, backs}
+	}
+
+	var doc *pdfDocument
+	if opts.DuplexPDF {
+		doc = newPDFDocument(nPages*nSides, opts.Page, sheetProfile(opts))
+	}
+
+	var preview *previewFrames
+	if len(previews(opts)) > 0 || opts.Simulator || opts.Proof {
+		preview, err = newPreviewFrames(nFrames, opts)
+		if err != nil {
+			return RenderInfo{}, err
+		}
+		defer preview.close()
+	}
+
 	compWidth := int(opts.Page.Width * float32(opts.Page.DPI))
 	compHeight := int(opts.Page.Height * float32(opts.Page.DPI))
 	compImg := image.NewRGBA(image.Rectangle{
@@ -355,9 +694,8 @@ func renderPages(opts Options, layout layoutFunc) (RenderInfo, error) {
 	})
 
 	frameAR := 0.0
+	var sheets []SheetInfo
 	for pi := 0; pi < nPages; pi++ {
-		draw.Draw(compImg, compImg.Bounds(), image.White, image.ZP, draw.Src)
-
 		renderBounds := rect{
 			left:   int(opts.Page.MarginLeft * float32(opts.Page.DPI)),
 			top:    int(opts.Page.MarginTop * float32(opts.Page.DPI)),
@@ -365,19 +703,6 @@ func renderPages(opts Options, layout layoutFunc) (RenderInfo, error) {
 			height: int((opts.Page.Height - (opts.Page.MarginTop + opts.Page.MarginBottom)) * float32(opts.Page.DPI)),
 		}
 
-		pageLayout := layout(pi, nPages, coverImgIndex, renderBounds, opts, frames)
-
-		if frameAR == 0.0 {
-			frameAR = float64(pageLayout[0].bounds.width) / float64(pageLayout[0].bounds.height)
-		}
-
-		for fi := range pageLayout {
-			err := compFrame(compImg, pageLayout[fi], opts.Line1Text, opts.Line2Text, opts.FontBytes, opts.VerLog)
-			if err != nil {
-				return RenderInfo{}, err
-			}
-		}
-
 		// When you print pictures, maybe the service orders them by filename e.g. comp001, comp002 etc so the last
 		// frames are printed on the top of the stack so you have to reverse them for assembly, this flag flips the
 		// numbering so that you don't need to do this after printing
@@ -387,78 +712,220 @@ func renderPages(opts Options, layout layoutFunc) (RenderInfo, error) {
 		} else {
 			compIndex = pi
 		}
-		err = writeJPG(compImg, opts.OutputDir, opts.Identifier, compIndex, opts.VerLog)
+
+		for side := 0; side < nSides; side++ {
+			draw.Draw(compImg, compImg.Bounds(), image.White, image.ZP, draw.Src)
+
+			// The backs are laid out like the fronts, then moved behind them
+			s := sides[side]
+			pageLayout := layout(pi, nPages, s.designs, renderBounds, opts, s.frames)
+			for fi, f := range pageLayout {
+				f.index = s.index[f.index]
+				if side == 1 {
+					f = backFrame(f, opts.Duplex, compWidth, compHeight)
+				}
+				pageLayout[fi] = f
+			}
+
+			if frameAR == 0.0 {
+				frameAR = float64(pageLayout[0].bounds.width) / float64(pageLayout[0].bounds.height)
+				if opts.Orientation == OrientationTop {
+					frameAR = 1 / frameAR
+				}
+			}
+
+			// The sides of a sheet are written one after the other
+			sheetIndex := compIndex*nSides + side
+			sheet := SheetInfo{
+				Index: sheetIndex,
+				Sheet: compIndex,
+				Back:  side == 1,
+			}
+			if doc == nil {
+				sheet.Name = opts.Naming.SheetName(opts.Identifier, sheetIndex, nPages*nSides, sheetWriter(opts).Ext())
+			}
+
+			for fi := range pageLayout {
+				f := &pageLayout[fi]
+				f.sheet = compIndex
+				f.chapter = chapters[f.index]
+				if nFrames > 1 {
+					f.progress = float64(f.index) / float64(nFrames-1)
+				}
+				if opts.ReverseFrames {
+					f.progress = 1 - f.progress
+				}
+				upright, err := compFrame(compImg, *f, opts, fonts)
+				if err != nil {
+					return RenderInfo{}, err
+				}
+				if preview != nil {
+					err = preview.add(*f, upright)
+					if err != nil {
+						return RenderInfo{}, err
+					}
+				}
+
+				_, turn := frameTurn(*f, opts)
+				cell := CellInfo{
+					Frame:  f.index,
+					Source: f.info.Name(),
+					Stack:  f.stack,
+					Left:   f.bounds.left,
+					Top:    f.bounds.top,
+					Width:  f.bounds.width,
+					Height: f.bounds.height,
+					Turn:   turn,
+				}
+				if seconds, ok := opts.FrameTimes[cell.Source]; ok {
+					cell.Time = &seconds
+				}
+				sheet.Cells = append(sheet.Cells, cell)
+			}
+			sheets = append(sheets, sheet)
+
+			if doc != nil {
+				doc.setPage(sheetIndex, printImage(compImg, opts))
+				continue
+			}
+			err = writeSheet(compImg, opts, sheetIndex, nPages*nSides)
+			if err != nil {
+				return RenderInfo{}, err
+			}
+			outputs = append(outputs, sheet.Name)
+		}
+	}
+
+	if doc != nil {
+		err = writeDocument(doc, opts)
+		if err != nil {
+			return RenderInfo{}, err
+		}
+		outputs = append(outputs, opts.Naming.DocumentName(opts.Identifier, "pdf"))
+	}
+
+	if preview != nil {
+		err = writePreviews(preview, opts)
+		if err != nil {
+			return RenderInfo{}, err
+		}
+		for _, format := range previews(opts) {
+			outputs = append(outputs, opts.Naming.PreviewName(opts.Identifier, previewExt[format]))
+		}
+	}
+
+	if opts.Simulator {
+		err = writeSimulator(preview, opts)
+		if err != nil {
+			return RenderInfo{}, err
+		}
+		outputs = append(outputs, opts.Naming.SimulatorName(opts.Identifier))
+	}
+
+	if opts.Proof {
+		written, err := writeProof(preview, opts, fonts)
 		if err != nil {
 			return RenderInfo{}, err
 		}
+		outputs = append(outputs, written...)
 	}
 
 	return RenderInfo{
-		NFrames: nFrames,
-		FrameAR: frameAR,
+		NFrames:       nFrames,
+		FrameAR:       frameAR,
+		Sheets:        sheets,
+		SheetWidth:    compWidth,
+		SheetHeight:   compHeight,
+		ReversePages:  opts.ReversePages,
+		ReverseFrames: opts.ReverseFrames,
+		Duplex:        opts.Duplex,
+		Trimmed:       trimmed,
+		Outputs:       outputs,
 	}, nil
 }
 
-func renderFrontCover(framePath string) (image.Image, error) {
-	src, err := imaging.Open(framePath)
-	if err != nil {
-		return nil, err
+// coverTemplate returns the cover template from the options, or the default
+func coverTemplate(opts Options) *CoverTemplate {
+	if opts.CoverTemplate == nil {
+		return DefaultCoverTemplate()
 	}
+	return opts.CoverTemplate
+}
 
-	dst := imaging.Blur(src, 12.5)
-	return dst, nil
+// backCoverTemplate returns the back cover template from the options, or the default
+func backCoverTemplate(opts Options) *CoverTemplate {
+	if opts.BackCoverTemplate == nil {
+		return DefaultBackCoverTemplate()
+	}
+	return opts.BackCoverTemplate
 }
 
-func annotateFrontCover(img *image.RGBA, dstRect image.Rectangle, labelLine1, labelLine2 string, fontBytes []byte) error {
-	f, err := freetype.ParseFont(fontBytes)
+// saveBackground renders the background of a designed frame and saves it to the output
+// directory, names are the frames in book order
+func saveBackground(t *CoverTemplate, opts Options, names []string, name string) (os.FileInfo, error) {
+	img, err := renderCoverBackground(t, opts.InputDir, names)
 	if err != nil {
-		return fmt.Errorf("failed to parse font file: %s", err)
+		return nil, err
 	}
 
-	c := freetype.NewContext()
-	c.SetDPI(70)
-	c.SetFont(f)
-	c.SetClip(dstRect)
-	c.SetDst(img)
-	c.SetHinting(font.HintingFull)
-
-	var renderText = func(x, y int, size float64, fg *image.Uniform, label string) error {
-		c.SetFontSize(size)
-		c.SetSrc(fg)
-
-		pt := freetype.Pt(x, y+int(c.PointToFixed(size)>>6))
-		_, err := c.DrawString(label, pt)
-		return err
+	p := path.Join(opts.OutputDir, name)
+	err = imaging.Save(img, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save image: %s", err)
 	}
 
-	line1FontSize := 80.0
-	line2FontSize := 45.0
-	line2YOffset := 100
-	x := dstRect.Min.X + 100
-	y := dstRect.Min.Y + 30
-
-	if err = renderText(x, y, line1FontSize, image.Black, labelLine1); err != nil {
-		return err
-	}
-	if err = renderText(x-4, y-4, line1FontSize, image.Black, labelLine1); err != nil {
-		return err
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat image: %s", err)
 	}
+	return info, nil
+}
 
-	if err = renderText(x-2, y-2, line1FontSize, image.White, labelLine1); err != nil {
-		return err
+// compFrame draws the frame into its cell on the page and returns the frame as it is seen
+// once the book is assembled, upright before it is turned into the cell. The returned image
+// is only valid until the page is cleared
+func compFrame(compImg *image.RGBA, f frame, opts Options, fonts typeset.Fonts) (image.Image, error) {
+	bounds := image.Rect(f.bounds.left, f.bounds.top, f.bounds.left+f.bounds.width, f.bounds.top+f.bounds.height)
+	side, turn := frameTurn(f, opts)
+	opts.Binding.Side = side
+	if turn == 0 {
+		err := drawFrame(compImg, bounds, f, opts, fonts)
+		if err != nil {
+			return nil, err
+		}
+		return compImg.SubImage(bounds), nil
 	}
 
-	if err = renderText(x, y+line2YOffset, line2FontSize, image.Black, labelLine2); err != nil {
-		return err
+	// Turned frames are drawn upright and then turned into the cell, e.g. top bound frames are
+	// drawn with the binding along the top and turned so the top is on the left edge of the
+	// cell. Once cut, the stacks are turned back to read them
+	size := bounds.Size()
+	if turn != 180 {
+		size.X, size.Y = size.Y, size.X
 	}
-	if err = renderText(x-2, y-2+line2YOffset, line2FontSize, image.White, labelLine2); err != nil {
-		return err
+	cell := image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(cell, cell.Bounds(), image.White, image.Point{}, draw.Src)
+	err := drawFrame(cell, cell.Bounds(), f, opts, fonts)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	var turned *image.NRGBA
+	switch turn {
+	case 90:
+		turned = imaging.Rotate90(cell)
+	case 180:
+		turned = imaging.Rotate180(cell)
+	default:
+		turned = imaging.Rotate270(cell)
+	}
+	draw.Draw(compImg, bounds, turned, image.Point{}, draw.Src)
+	return cell, nil
 }
 
-func compFrame(compImg *image.RGBA, f frame, labelLine1, labelLine2 string, fontBytes []byte, verLog *log.Logger) error {
+// drawFrame draws the frame, its binding strip, label, caption and any design in the bounds
+func drawFrame(compImg *image.RGBA, bounds image.Rectangle, f frame, opts Options, fonts typeset.Fonts) error {
+	verLog := opts.VerLog
 
 	imgPath := path.Join(f.path, f.info.Name())
 	verLog.Println("reading:", imgPath)
@@ -479,8 +946,11 @@ func compFrame(compImg *image.RGBA, f frame, labelLine1, labelLine2 string, font
 	sourceWidth := srcImg.Bounds().Dx()
 	sourceHeight := srcImg.Bounds().Dy()
 
+	// The picture goes in the part of the frame beside the binding strip
+	strip, dr := opts.Binding.split(bounds, opts.Page.DPI)
+
 	// Render the image scaled to the dimensions we want
-	targetHeight := f.bounds.height
+	targetHeight := dr.Dy()
 	scaledWidth := int(float64(targetHeight) / float64(sourceHeight) * float64(sourceWidth))
 	scaledImg := image.NewRGBA(image.Rectangle{
 		Min: image.Point{X: 0, Y: 0},
@@ -488,118 +958,54 @@ func compFrame(compImg *image.RGBA, f frame, labelLine1, labelLine2 string, font
 	})
 	draw.BiLinear.Scale(scaledImg, scaledImg.Bounds(), srcImg, srcImg.Bounds(), draw.Src, nil)
 
-	// Composite into page container
-	left := int(math.Max(float64(f.bounds.left), float64(f.bounds.left+(f.bounds.width-scaledWidth))))
-	dstRect := image.Rectangle{
-		Min: image.Point{X: left, Y: f.bounds.top},
-		Max: image.Point{X: f.bounds.left + f.bounds.width, Y: f.bounds.top + f.bounds.height},
-	}
-	draw.Draw(
-		compImg,
-		dstRect,
-		scaledImg,
-		image.Point{
-			X: int(math.Max(0, float64(scaledImg.Bounds().Dx()-f.bounds.width))),
-			Y: 0,
-		},
-		draw.Src)
-
-	// Draw the left size bar
-	barWidth := 100 //int(math.Max(100.0, float64(left-f.bounds.left)))
-	draw.Draw(compImg, image.Rectangle{
-		Min: image.Point{X: f.bounds.left, Y: f.bounds.top},
-		Max: image.Point{X: f.bounds.left + barWidth, Y: f.bounds.top + f.bounds.height},
-	}, image.Black, image.ZP, draw.Src)
-
-	if !f.isFrontCover {
-		x := f.bounds.left + 20
-		y := f.bounds.top + int(float32(f.bounds.height)*0.5)
-		yOffset := 20
-		addDebugLabel(compImg, x, y, strconv.Itoa(f.index))
-		addDebugLabel(compImg, x, y+yOffset, f.label)
-	}
-
-	if f.isFrontCover {
-		dr := image.Rectangle{
-			Min: image.Point{X: f.bounds.left + barWidth, Y: f.bounds.top},
-			Max: image.Point{X: f.bounds.left + f.bounds.width, Y: f.bounds.top + f.bounds.height},
-		}
-		err := annotateFrontCover(compImg, dr, labelLine1, labelLine2, fontBytes)
-		if err != nil {
-			return err
-		}
+	// Composite into page container, the picture sits against the edge that is flipped and any
+	// extra width is cropped from the side nearest the binding
+	excess := scaledWidth - dr.Dx()
+	left, cropX := dr.Max.X-scaledWidth, excess
+	switch opts.Binding.Side {
+	case BindingRight:
+		left, cropX = dr.Min.X, 0
+	case BindingTop:
+		left, cropX = dr.Min.X-excess/2, excess/2
 	}
-
-	return nil
-}
-
-func writeJPG(compImg *image.RGBA, outputDir, identifier string, imgIndex int, verLog *log.Logger) error {
-	toImgPath := path.Join(outputDir, fmt.Sprintf("comp-%s-%03d.jpg", identifier, imgIndex))
-	toImg, err := os.Create(toImgPath)
-	if err != nil {
-		return fmt.Errorf("failed to create image: %s, %s", toImgPath, err)
+	if excess > 0 {
+		left = dr.Min.X
+	} else {
+		cropX = 0
 	}
-
-	verLog.Println("writing:", toImgPath)
-
-	err = jpeg.Encode(toImg, compImg, &jpeg.Options{Quality: 90})
-	toImg.Close()
-	if err != nil {
-		return fmt.Errorf("failed to save img: %s, %s", toImgPath, err)
+	dstRect := image.Rectangle{
+		Min: image.Point{X: left, Y: dr.Min.Y},
+		Max: dr.Max,
 	}
+	draw.Draw(compImg, dstRect, scaledImg, image.Point{X: cropX, Y: 0}, draw.Src)
 
-	verLog.Println("written file:", toImgPath)
-	return nil
-}
-
-func addDebugLabel(img *image.RGBA, x, y int, label string) {
-	point := fixed.Point26_6{fixed.Int26_6(x * 64), fixed.Int26_6(y * 64)}
-
-	d := &font.Drawer{
-		Dst:  img,
-		Src:  image.White,
-		Face: basicfont.Face7x13,
-		Dot:  point,
+	if f.design == nil && opts.Markers.Kind != "" {
+		drawMarker(compImg, dr, opts.Binding.Side, opts.Markers, f.progress, f.chapter, opts.Page.DPI)
 	}
-	d.DrawString(label)
-}
 
-/*
-func renderGIF(frames []os.FileInfo, inputDir, outputPath string) error {
-	outGif := &gif.GIF{}
-	for _, f := range frames {
+	drawBinding(compImg, strip, opts.Binding)
 
-		imgPath := path.Join(inputDir, f.Name())
-		srcReader, err := os.Open(imgPath)
+	if f.design == nil && !opts.Labels.Disabled {
+		err := drawLabel(compImg, strip, labelText(opts.Labels, f, opts), opts.Labels, fonts, opts.Page.DPI)
 		if err != nil {
-			panic("cant open")
-			return fmt.Errorf("failed to read input image: %s, %s", imgPath, err)
+			return err
 		}
+	}
 
-		srcImg, err := png.Decode(srcReader)
-		srcReader.Close()
+	if seconds, ok := opts.FrameTimes[f.info.Name()]; ok && opts.Captions != nil && f.design == nil {
+		err := drawCaption(compImg, dr, opts.Captions, seconds, opts.CaptionStyle, fonts, opts.Page.DPI)
 		if err != nil {
-			panic("cant decode")
-			return fmt.Errorf("failed to decode image on load: %s, %s", imgPath, err)
+			return err
 		}
-
-		fmt.Println("generate palette:", f.Name())
-		pm := image.NewPaletted(srcImg.Bounds(), nil)
-		q := &gogif.MedianCutQuantizer{NumColor: 256}
-		q.Quantize(pm, srcImg.Bounds(), srcImg, image.ZP)
-		//f, _ := os.Open(name)
-		//inGif, _ := gif.Decode(f)
-		//f.Close()
-
-		outGif.Image = append(outGif.Image, pm)
-		outGif.Delay = append(outGif.Delay, 0)
 	}
 
-	fmt.Println("writing gif to", outputPath)
-	ff, _ := os.OpenFile(path.Join(outputPath, "out.gif"), os.O_WRONLY|os.O_CREATE, 0600)
-	defer ff.Close()
-	gif.EncodeAll(ff, outGif)
+	if f.design != nil {
+		vars := strings.NewReplacer("{line1}", opts.Line1Text, "{line2}", opts.Line2Text, "{identifier}", opts.Identifier)
+		err := drawDesign(compImg, dr, f.design, vars, fonts, opts.Page.DPI)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
-*/
@@ -0,0 +1,591 @@
+package composite
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/markdaws/go-flipbook/pkg/typeset"
+	"golang.org/x/image/draw"
+	"rsc.io/qr"
+)
+
+// Values of CoverTemplate.Source
+const (
+	CoverSourceFrame = "frame"
+	CoverSourceImage = "image"
+	CoverSourceColor = "color"
+)
+
+// Values of CoverTemplate.Effect
+const (
+	CoverEffectBlur   = "blur"
+	CoverEffectDarken = "darken"
+	CoverEffectNone   = "none"
+)
+
+// minFontSize text is never shrunk below this size in points when fitting it to its box
+const minFontSize = 4
+
+// CoverTemplate describes how a designed page is rendered, the background and the text,
+// images and QR codes drawn on top of it. It is used for the front and back covers and for
+// credit pages
+type CoverTemplate struct {
+	// Source where the background comes from, frame|image|color, defaults to frame
+	Source string `json:"source,omitempty"`
+
+	// Frame the index of the frame used as the background when Source is frame, in book
+	// order. Negative values count back from the end, -1 is the last frame
+	Frame int `json:"frame,omitempty"`
+
+	// Background the colour of the background when Source is color, as #rrggbb, defaults to white
+	Background string `json:"background,omitempty"`
+
+	// Image the path of the image used as the background when Source is image, relative
+	// paths are relative to the template file. The image is cropped to the frame shape
+	Image string `json:"image,omitempty"`
+
+	// Effect applied to the background so the text stands out, blur|darken|none, defaults to blur
+	Effect string `json:"effect,omitempty"`
+
+	// Strength of the effect, the blur radius in pixels or the fraction the background is
+	// darkened by. Defaults to 12.5 for blur and 0.5 for darken
+	Strength float64 `json:"strength,omitempty"`
+
+	// Blocks the text drawn on the page
+	Blocks []TextBlock `json:"blocks"`
+
+	// Images logos or other images drawn on the page, below the text
+	Images []ImageBlock `json:"images,omitempty"`
+
+	// QRCodes QR codes drawn on the page, below the text e.g. linking to the full video
+	QRCodes []QRBlock `json:"qrCodes,omitempty"`
+}
+
+// Box is an area of a frame, given as fractions of the frame so the same template works
+// for every layout
+type Box struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Validate returns an error if the box doesn't fit within the frame
+func (b Box) Validate() error {
+	if b.X < 0 || b.Y < 0 || b.Width <= 0 || b.Height <= 0 || b.X+b.Width > 1 || b.Y+b.Height > 1 {
+		return fmt.Errorf("the box must fit within the frame, x, y, width and height are fractions of the frame")
+	}
+	return nil
+}
+
+// rect returns the area of the box within the cell
+func (b Box) rect(cell image.Rectangle) image.Rectangle {
+	return image.Rect(
+		cell.Min.X+int(b.X*float64(cell.Dx())),
+		cell.Min.Y+int(b.Y*float64(cell.Dy())),
+		cell.Min.X+int((b.X+b.Width)*float64(cell.Dx())),
+		cell.Min.Y+int((b.Y+b.Height)*float64(cell.Dy())),
+	)
+}
+
+// ImageBlock is an image scaled to fit in a box, keeping its aspect ratio
+type ImageBlock struct {
+	// Image path to the image, relative paths are relative to the template file
+	Image string `json:"image"`
+
+	Box
+}
+
+// QRBlock is a QR code drawn as large as fits in a box
+type QRBlock struct {
+	// Text the text encoded in the code, usually a URL
+	Text string `json:"text"`
+
+	// Color the colour of the code as #rrggbb, defaults to black
+	Color string `json:"color,omitempty"`
+
+	// Background the colour behind the code as #rrggbb, defaults to white. Most readers need
+	// a light background
+	Background string `json:"background,omitempty"`
+
+	Box
+}
+
+// TextBlock is text drawn in a box on a frame
+type TextBlock struct {
+	// Text the text to draw, new lines start a new line. {line1}, {line2} and {identifier}
+	// are replaced with the values from the options
+	Text string `json:"text"`
+
+	// Font path to a ttf or otf file, or a directory of fonts used in order, relative paths are
+	// relative to the template file. The font from the options and the fallback fonts are used
+	// for any characters it doesn't have
+	Font string `json:"font,omitempty"`
+
+	// Size the font size in points, text is wrapped to the width of the box and shrunk until
+	// it fits
+	Size float64 `json:"size"`
+
+	// Color the colour of the text as #rrggbb or #rrggbbaa, defaults to white
+	Color string `json:"color,omitempty"`
+
+	// Shadow if set, the colour of a drop shadow drawn behind the text
+	Shadow string `json:"shadow,omitempty"`
+
+	// Align horizontal alignment of each line within the box, left|center|right, defaults to the
+	// side the line starts on, which is the right for Arabic and Hebrew
+	Align string `json:"align,omitempty"`
+
+	// VAlign vertical alignment of the text within the box, top|middle|bottom, defaults to top
+	VAlign string `json:"valign,omitempty"`
+
+	Box
+
+	fonts typeset.Fonts
+}
+
+// DefaultCoverTemplate returns the template used when none is specified, the first frame
+// blurred with the two title lines in the top left
+func DefaultCoverTemplate() *CoverTemplate {
+	return &CoverTemplate{
+		Source: CoverSourceFrame,
+		Effect: CoverEffectBlur,
+		Blocks: []TextBlock{
+			{Text: "{line1}", Size: 19, Color: "#ffffff", Shadow: "#000000", Box: Box{X: 0.08, Y: 0.05, Width: 0.88, Height: 0.2}},
+			{Text: "{line2}", Size: 11, Color: "#ffffff", Shadow: "#000000", Box: Box{X: 0.08, Y: 0.22, Width: 0.88, Height: 0.1}},
+		},
+	}
+}
+
+// DefaultBackCoverTemplate returns the back cover used when none is specified, the last
+// frame blurred with "The End" in the middle
+func DefaultBackCoverTemplate() *CoverTemplate {
+	return &CoverTemplate{
+		Source: CoverSourceFrame,
+		Frame:  -1,
+		Effect: CoverEffectBlur,
+		Blocks: []TextBlock{
+			{Text: "The End", Size: 24, Color: "#ffffff", Shadow: "#000000", Align: "center", VAlign: "middle", Box: Box{X: 0.1, Y: 0.25, Width: 0.8, Height: 0.5}},
+		},
+	}
+}
+
+// BlankPageTemplate returns a template for a blank white page
+func BlankPageTemplate() *CoverTemplate {
+	return &CoverTemplate{Source: CoverSourceColor, Effect: CoverEffectNone}
+}
+
+// LoadCoverTemplate reads a cover template from a JSON file, along with any fonts it uses
+func LoadCoverTemplate(p string) (*CoverTemplate, error) {
+	var t CoverTemplate
+	err := decodeTemplateFile(p, &t)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.resolve(filepath.Dir(p))
+	if err != nil {
+		return nil, fmt.Errorf("invalid cover template: %s, %s", p, err)
+	}
+	return &t, nil
+}
+
+// LoadPageTemplates reads a JSON file containing an array of templates, one per page, used
+// for credit pages
+func LoadPageTemplates(p string) ([]*CoverTemplate, error) {
+	var ts []*CoverTemplate
+	err := decodeTemplateFile(p, &ts)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, t := range ts {
+		if t == nil {
+			return nil, fmt.Errorf("invalid page templates: %s, page %d is null", p, i)
+		}
+		err = t.resolve(filepath.Dir(p))
+		if err != nil {
+			return nil, fmt.Errorf("invalid page templates: %s, page %d: %s", p, i, err)
+		}
+	}
+	return ts, nil
+}
+
+func decodeTemplateFile(p string, v interface{}) error {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return fmt.Errorf("failed to read template: %s", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %s, %s", p, err)
+	}
+	return nil
+}
+
+// resolve makes the paths in the template relative to dir, loads the fonts and validates it
+func (t *CoverTemplate) resolve(dir string) error {
+	abs := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	t.Image = abs(t.Image)
+	for i := range t.Images {
+		t.Images[i].Image = abs(t.Images[i].Image)
+	}
+	err := loadBlockFonts(t.Blocks, dir)
+	if err != nil {
+		return err
+	}
+	return t.Validate()
+}
+
+// loadBlockFonts reads the font file of each block, relative paths are relative to dir. A
+// directory loads every font in it as a fallback chain
+func loadBlockFonts(blocks []TextBlock, dir string) error {
+	for i := range blocks {
+		if blocks[i].Font == "" {
+			continue
+		}
+		fontPath := blocks[i].Font
+		if !filepath.IsAbs(fontPath) {
+			fontPath = filepath.Join(dir, fontPath)
+		}
+		fonts, err := typeset.Load(fontPath)
+		if err != nil {
+			return err
+		}
+		blocks[i].fonts = fonts
+	}
+	return nil
+}
+
+// Validate returns an error if any of the template values are invalid
+func (t *CoverTemplate) Validate() error {
+	switch t.Source {
+	case "", CoverSourceFrame:
+	case CoverSourceImage:
+		if t.Image == "" {
+			return fmt.Errorf("an image is required when the source is image")
+		}
+	case CoverSourceColor:
+		if _, err := parseColor(t.Background); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid source: %s, must be frame|image|color", t.Source)
+	}
+
+	switch t.Effect {
+	case "", CoverEffectBlur, CoverEffectNone:
+	case CoverEffectDarken:
+		if t.Strength > 1 {
+			return fmt.Errorf("darken strength must be between 0 and 1, %g invalid value", t.Strength)
+		}
+	default:
+		return fmt.Errorf("invalid effect: %s, must be blur|darken|none", t.Effect)
+	}
+	if t.Strength < 0 {
+		return fmt.Errorf("strength cannot be negative, %g invalid value", t.Strength)
+	}
+
+	for i, b := range t.Blocks {
+		if err := b.Validate(); err != nil {
+			return fmt.Errorf("block %d: %s", i, err)
+		}
+	}
+	for i, img := range t.Images {
+		if img.Image == "" {
+			return fmt.Errorf("image %d: a path to the image is required", i)
+		}
+		if err := img.Box.Validate(); err != nil {
+			return fmt.Errorf("image %d: %s", i, err)
+		}
+	}
+	for i, q := range t.QRCodes {
+		if q.Text == "" {
+			return fmt.Errorf("qr code %d: text is required", i)
+		}
+		if _, err := qr.Encode(q.Text, qr.M); err != nil {
+			return fmt.Errorf("qr code %d: %s", i, err)
+		}
+		if err := q.Box.Validate(); err != nil {
+			return fmt.Errorf("qr code %d: %s", i, err)
+		}
+		if _, err := parseColor(q.Color); err != nil {
+			return fmt.Errorf("qr code %d: %s", i, err)
+		}
+		if _, err := parseColor(q.Background); err != nil {
+			return fmt.Errorf("qr code %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// Validate returns an error if any of the block values are invalid
+func (b TextBlock) Validate() error {
+	if b.Size <= 0 {
+		return fmt.Errorf("size must be greater than zero")
+	}
+	if err := b.Box.Validate(); err != nil {
+		return err
+	}
+	switch b.Align {
+	case "", "left", "center", "right":
+	default:
+		return fmt.Errorf("invalid align: %s, must be left|center|right", b.Align)
+	}
+	switch b.VAlign {
+	case "", "top", "middle", "bottom":
+	default:
+		return fmt.Errorf("invalid valign: %s, must be top|middle|bottom", b.VAlign)
+	}
+	if _, err := parseColor(b.Color); err != nil {
+		return err
+	}
+	if _, err := parseColor(b.Shadow); err != nil {
+		return err
+	}
+	return nil
+}
+
+// renderCoverBackground returns the background of a designed page, made from the source in
+// the template with the effect applied. frames are the frames of the book in book order, the
+// background is the same size as the frames
+func renderCoverBackground(t *CoverTemplate, inputDir string, frames []string) (image.Image, error) {
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("there are no frames")
+	}
+
+	var src image.Image
+	switch t.Source {
+	case CoverSourceImage, CoverSourceColor:
+		cfg, err := decodeConfig(filepath.Join(inputDir, frames[0]))
+		if err != nil {
+			return nil, err
+		}
+
+		if t.Source == CoverSourceColor {
+			bg, _ := parseColor(t.Background)
+			if bg == nil {
+				bg = color.White
+			}
+			return imaging.New(cfg.Width, cfg.Height, bg), nil
+		}
+
+		img, err := imaging.Open(t.Image)
+		if err != nil {
+			return nil, err
+		}
+		// Cropped to the same shape as the frames, so it fills the cell like every other frame
+		src = imaging.Fill(img, cfg.Width, cfg.Height, imaging.Center, imaging.Lanczos)
+	default:
+		index := t.Frame
+		if index < 0 {
+			index += len(frames)
+		}
+		if index < 0 || index >= len(frames) {
+			return nil, fmt.Errorf("frame %d is out of range, there are %d frames", t.Frame, len(frames))
+		}
+
+		var err error
+		src, err = imaging.Open(filepath.Join(inputDir, frames[index]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch t.Effect {
+	case "", CoverEffectBlur:
+		sigma := t.Strength
+		if sigma == 0 {
+			sigma = 12.5
+		}
+		return imaging.Blur(src, sigma), nil
+	case CoverEffectDarken:
+		amount := t.Strength
+		if amount == 0 {
+			amount = 0.5
+		}
+		return imaging.AdjustFunc(src, func(c color.NRGBA) color.NRGBA {
+			f := 1 - amount
+			return color.NRGBA{
+				R: uint8(float64(c.R) * f),
+				G: uint8(float64(c.G) * f),
+				B: uint8(float64(c.B) * f),
+				A: c.A,
+			}
+		}), nil
+	default:
+		return src, nil
+	}
+}
+
+func decodeConfig(p string) (image.Config, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return image.Config{}, fmt.Errorf("failed to decode image: %s, %s", p, err)
+	}
+	return cfg, nil
+}
+
+// drawDesign draws the images, QR codes and text of the template in the cell
+func drawDesign(img *image.RGBA, cell image.Rectangle, t *CoverTemplate, vars *strings.Replacer, fonts typeset.Fonts, dpi int) error {
+	for _, b := range t.Images {
+		src, err := imaging.Open(b.Image)
+		if err != nil {
+			return fmt.Errorf("failed to open image: %s", err)
+		}
+
+		box := b.rect(cell)
+		fitted := imaging.Fit(src, box.Dx(), box.Dy(), imaging.Lanczos)
+		fb := fitted.Bounds()
+		at := image.Pt(box.Min.X+(box.Dx()-fb.Dx())/2, box.Min.Y+(box.Dy()-fb.Dy())/2)
+		draw.Draw(img, fb.Add(at).Intersect(cell), fitted, fb.Min, draw.Over)
+	}
+
+	for _, q := range t.QRCodes {
+		err := drawQRCode(img, q.rect(cell).Intersect(cell), q)
+		if err != nil {
+			return err
+		}
+	}
+
+	return drawTextBlocks(img, cell, t.Blocks, vars, fonts, dpi)
+}
+
+// drawQRCode draws the code centred in the box, with the quiet zone around it that readers need
+func drawQRCode(img *image.RGBA, box image.Rectangle, q QRBlock) error {
+	code, err := qr.Encode(q.Text, qr.M)
+	if err != nil {
+		return fmt.Errorf("failed to encode qr code: %s", err)
+	}
+
+	fg, _ := parseColor(q.Color)
+	if fg == nil {
+		fg = color.Black
+	}
+	bg, _ := parseColor(q.Background)
+	if bg == nil {
+		bg = color.White
+	}
+
+	const quietZone = 4
+	side := box.Dx()
+	if box.Dy() < side {
+		side = box.Dy()
+	}
+	modules := code.Size + 2*quietZone
+	scale := side / modules
+	if scale < 1 {
+		return fmt.Errorf("the box is too small for the qr code, it needs at least %d pixels", modules)
+	}
+
+	size := scale * modules
+	min := image.Pt(box.Min.X+(box.Dx()-size)/2, box.Min.Y+(box.Dy()-size)/2)
+	draw.Draw(img, image.Rectangle{Min: min, Max: min.Add(image.Pt(size, size))}, image.NewUniform(bg), image.ZP, draw.Src)
+
+	fgImg := image.NewUniform(fg)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if !code.Black(x, y) {
+				continue
+			}
+			px := min.Add(image.Pt((x+quietZone)*scale, (y+quietZone)*scale))
+			draw.Draw(img, image.Rectangle{Min: px, Max: px.Add(image.Pt(scale, scale))}, fgImg, image.ZP, draw.Src)
+		}
+	}
+	return nil
+}
+
+// drawTextBlocks draws the blocks in the cell, vars are replaced in the text of each block
+// and fonts is the fallback chain used after each block's own font
+func drawTextBlocks(img *image.RGBA, cell image.Rectangle, blocks []TextBlock, vars *strings.Replacer, fonts typeset.Fonts, dpi int) error {
+	dst, ok := img.SubImage(cell).(*image.RGBA)
+	if !ok {
+		return fmt.Errorf("failed to clip text to the frame")
+	}
+
+	for _, b := range blocks {
+		text := strings.TrimSpace(vars.Replace(b.Text))
+		if text == "" {
+			continue
+		}
+
+		// The block's own font comes first, the default font and fallbacks are used for any
+		// characters it doesn't have
+		chain := append(append(typeset.Fonts{}, b.fonts...), fonts...)
+
+		fg, err := parseColor(b.Color)
+		if err != nil {
+			return err
+		}
+		if fg == nil {
+			fg = color.White
+		}
+		shadow, err := parseColor(b.Shadow)
+		if err != nil {
+			return err
+		}
+
+		// Sizes are in points
+		px := float64(dpi) / 72
+		box := b.rect(cell)
+		t, err := typeset.Fit(text, chain, b.Size*px, minFontSize*px, box.Size())
+		if err != nil {
+			return err
+		}
+
+		if shadow != nil {
+			// The shadow is offset by a small fraction of the text size
+			offset := t.LineHeight() / 25
+			if offset < 1 {
+				offset = 1
+			}
+			t.Draw(dst, box.Add(image.Pt(offset, offset)), typeset.Align(b.Align), typeset.VAlign(b.VAlign), image.NewUniform(shadow))
+		}
+		t.Draw(dst, box, typeset.Align(b.Align), typeset.VAlign(b.VAlign), image.NewUniform(fg))
+	}
+	return nil
+}
+
+// parseColor parses a #rrggbb or #rrggbbaa colour, an empty string returns nil
+func parseColor(s string) (color.Color, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 && len(hex) != 8 || hex == s {
+		return nil, fmt.Errorf("invalid color: %s, must be #rrggbb or #rrggbbaa", s)
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid color: %s, must be #rrggbb or #rrggbbaa", s)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
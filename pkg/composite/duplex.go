@@ -0,0 +1,129 @@
+package composite
+
+import (
+	"fmt"
+	"os"
+)
+
+// Values of Options.Duplex, the edge of the sheet the printer turns it over on
+const (
+	DuplexLong  = "long"
+	DuplexShort = "short"
+)
+
+// validateDuplex returns an error if the duplex options are invalid
+func validateDuplex(opts Options) error {
+	switch opts.Duplex {
+	case "", DuplexLong, DuplexShort:
+	default:
+		return fmt.Errorf("invalid duplex: %s, must be long|short", opts.Duplex)
+	}
+	if opts.DuplexPDF {
+		if opts.Duplex == "" {
+			return fmt.Errorf("an interleaved pdf needs a duplex edge")
+		}
+		if _, ok := sheetWriter(opts).(PDFWriter); !ok {
+			return fmt.Errorf("an interleaved pdf needs pdf sheets, not %s", sheetWriter(opts).Ext())
+		}
+	}
+	return nil
+}
+
+// bookSide the frames printed on one side of the sheets, in the order the layouts pick them
+// from, with their designs by position. index maps each position to the frame's place in the
+// book, since the backs are printed in a different order to the book
+type bookSide struct {
+	frames  []os.FileInfo
+	designs map[int]*CoverTemplate
+	index   []int
+}
+
+// singleSide returns the side for sheets printed on one side, which holds the whole book
+func singleSide(frames []os.FileInfo, designs map[int]*CoverTemplate) bookSide {
+	s := bookSide{frames: frames, designs: designs, index: make([]int, len(frames))}
+	for i := range s.index {
+		s.index[i] = i
+	}
+	return s
+}
+
+// splitSides divides the frames of a duplex book between the fronts and the backs of the
+// leaves. The fronts carry the first half of the book and the backs the second half, so the
+// leaf with frame i on its front has frame n-1-i on its back. Once the first half has been
+// flipped through, the book is turned over and the second half is flipped through in the same
+// way. When the frames are reversed the book is flipped from the back, so the halves swap
+// sides and the first half is on the backs
+func splitSides(frames []os.FileInfo, designs map[int]*CoverTemplate, reverse bool) (fronts, backs bookSide) {
+	n := len(frames)
+	half := n / 2
+	fronts.designs = make(map[int]*CoverTemplate)
+	backs.designs = make(map[int]*CoverTemplate)
+
+	// The layouts pick the frame for the leaf at position i from the start of a side, or from
+	// the end when the frames are reversed. Either way leaf i gets frame i on its front and
+	// frame n-1-i on its back, counting leaves from the back of the book when reversed
+	for i := 0; i < half; i++ {
+		front, back := i, n-1-i
+		if reverse {
+			front, back = half+i, half-1-i
+		}
+		fronts.frames = append(fronts.frames, frames[front])
+		fronts.index = append(fronts.index, front)
+		if t, ok := designs[front]; ok {
+			fronts.designs[i] = t
+		}
+		backs.frames = append(backs.frames, frames[back])
+		backs.index = append(backs.index, back)
+		if t, ok := designs[back]; ok {
+			backs.designs[i] = t
+		}
+	}
+	return fronts, backs
+}
+
+// backFrame moves a frame laid out on the back of a sheet to the place behind the same cell on
+// the front, and marks it so it is turned to put its binding strip behind the strip on the
+// front. pageWidth and pageHeight are the size of the sheet in pixels
+func backFrame(f frame, duplex string, pageWidth, pageHeight int) frame {
+	if duplex == DuplexShort {
+		f.bounds.top = pageHeight - f.bounds.top - f.bounds.height
+	} else {
+		f.bounds.left = pageWidth - f.bounds.left - f.bounds.width
+	}
+	f.back = true
+	return f
+}
+
+// frameTurn returns the side of the binding strip for the frame when it is drawn upright, and
+// the angle in degrees the upright frame is turned anticlockwise to draw it in its cell
+func frameTurn(f frame, opts Options) (string, int) {
+	side, turn := opts.Binding.Side, 0
+	if side == "" {
+		side = BindingLeft
+	}
+	if opts.Orientation == OrientationTop {
+		side, turn = BindingTop, 90
+	}
+	if !f.back {
+		return side, turn
+	}
+
+	// Turning a sheet over on its long edge swaps its left and right, on its short edge its
+	// top and bottom. Frames on the back have their strip on the swapped edge so that they
+	// still face the right way when the strip is held at the top or on the left
+	if opts.Duplex == DuplexShort {
+		if side == BindingTop && turn == 0 {
+			turn = 180
+		}
+		return side, turn
+	}
+	switch {
+	case side == BindingLeft:
+		side = BindingRight
+	case side == BindingRight:
+		side = BindingLeft
+	case turn == 90:
+		turn = 270
+	}
+	return side, turn
+}
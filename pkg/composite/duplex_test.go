@@ -0,0 +1,203 @@
+package composite
+
+import (
+	"image"
+	"os"
+	"testing"
+)
+
+// namedFile a file info with just a name, for laying out frames without files
+type namedFile struct {
+	os.FileInfo
+	name string
+}
+
+func (f namedFile) Name() string {
+	return f.name
+}
+
+func namedFrames(n int) []os.FileInfo {
+	frames := make([]os.FileInfo, n)
+	for i := range frames {
+		frames[i] = namedFile{name: string(rune('A' + i))}
+	}
+	return frames
+}
+
+func TestSplitSides(t *testing.T) {
+	cover := &CoverTemplate{}
+	back := &CoverTemplate{}
+	designs := map[int]*CoverTemplate{0: cover, 7: back}
+
+	tests := []struct {
+		name    string
+		reverse bool
+		fronts  []int
+		backs   []int
+	}{
+		// Leaf i has frame i on its front and frame n-1-i on its back
+		{"forward", false, []int{0, 1, 2, 3}, []int{7, 6, 5, 4}},
+		// Flipped from the back, the halves swap sides. The layouts pick from the end of each
+		// side, so the last leaf picked has frame 0 on its back and frame 7 on its front
+		{"reversed", true, []int{4, 5, 6, 7}, []int{3, 2, 1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fronts, backs := splitSides(namedFrames(8), designs, tt.reverse)
+			for i := range tt.fronts {
+				if fronts.index[i] != tt.fronts[i] || fronts.frames[i].Name() != string(rune('A'+tt.fronts[i])) {
+					t.Errorf("leaf %d: got front frame %d, want %d", i, fronts.index[i], tt.fronts[i])
+				}
+				if backs.index[i] != tt.backs[i] || backs.frames[i].Name() != string(rune('A'+tt.backs[i])) {
+					t.Errorf("leaf %d: got back frame %d, want %d", i, backs.index[i], tt.backs[i])
+				}
+			}
+			if len(fronts.frames) != 4 || len(backs.frames) != 4 {
+				t.Fatalf("got %d fronts and %d backs, want 4 of each", len(fronts.frames), len(backs.frames))
+			}
+
+			// The designs follow their frames to whichever side they are printed on
+			for _, side := range []bookSide{fronts, backs} {
+				for i, index := range side.index {
+					if side.designs[i] != designs[index] {
+						t.Errorf("frame %d at %d has the wrong design", index, i)
+					}
+				}
+			}
+		})
+	}
+
+	// An odd frame can't be printed on a leaf of its own
+	fronts, backs := splitSides(namedFrames(7), nil, false)
+	if len(fronts.frames) != 3 || len(backs.frames) != 3 {
+		t.Fatalf("got %d fronts and %d backs from 7 frames, want 3 of each", len(fronts.frames), len(backs.frames))
+	}
+}
+
+func TestBackFrame(t *testing.T) {
+	// A 100x60 sheet, the cell is 10 in from the left and 5 down from the top
+	f := frame{bounds: rect{left: 10, top: 5, width: 30, height: 20}}
+
+	long := backFrame(f, DuplexLong, 100, 60)
+	if want := (rect{left: 60, top: 5, width: 30, height: 20}); long.bounds != want || !long.back {
+		t.Errorf("long edge: got %+v, back %t, want %+v", long.bounds, long.back, want)
+	}
+	short := backFrame(f, DuplexShort, 100, 60)
+	if want := (rect{left: 10, top: 35, width: 30, height: 20}); short.bounds != want || !short.back {
+		t.Errorf("short edge: got %+v, back %t, want %+v", short.bounds, short.back, want)
+	}
+	if f.back {
+		t.Errorf("the front frame was changed")
+	}
+}
+
+func TestTurnOver(t *testing.T) {
+	// Turning a sheet over undoes moving a frame behind its front cell
+	for _, duplex := range []string{DuplexLong, DuplexShort} {
+		for _, r := range []rect{
+			{left: 0, top: 0, width: 100, height: 20},
+			{left: 10, top: 5, width: 30, height: 20},
+			{left: 70, top: 40, width: 30, height: 20},
+		} {
+			b := backFrame(frame{bounds: r}, duplex, 100, 60).bounds
+			got := turnOver(image.Rect(b.left, b.top, b.left+b.width, b.top+b.height), duplex, 100, 60)
+			if want := image.Rect(r.left, r.top, r.left+r.width, r.top+r.height); got != want {
+				t.Errorf("%s edge: %+v printed at %+v is behind %v, want %v", duplex, r, b, got, want)
+			}
+		}
+	}
+}
+
+func TestFrameTurn(t *testing.T) {
+	// The binding strip of a frame on the back is behind the strip on the front, and the frame
+	// reads the right way up once the sheet is turned over
+	tests := []struct {
+		orientation string
+		side        string
+		duplex      string
+		back        bool
+		wantSide    string
+		wantTurn    int
+	}{
+		{OrientationSide, "", DuplexLong, false, BindingLeft, 0},
+		{OrientationSide, "", DuplexLong, true, BindingRight, 0},
+		{OrientationSide, "", DuplexShort, true, BindingLeft, 0},
+		{OrientationSide, BindingRight, DuplexLong, false, BindingRight, 0},
+		{OrientationSide, BindingRight, DuplexLong, true, BindingLeft, 0},
+		{OrientationSide, BindingRight, DuplexShort, true, BindingRight, 0},
+		{OrientationSide, BindingTop, DuplexLong, false, BindingTop, 0},
+		{OrientationSide, BindingTop, DuplexLong, true, BindingTop, 0},
+		{OrientationSide, BindingTop, DuplexShort, true, BindingTop, 180},
+		// Top bound frames are turned a quarter turn with the strip on the left of the cell,
+		// which moves to the right when the sheet is turned over on its long edge
+		{OrientationTop, "", "", false, BindingTop, 90},
+		{OrientationTop, "", DuplexLong, true, BindingTop, 270},
+		{OrientationTop, "", DuplexShort, true, BindingTop, 90},
+	}
+	for _, tt := range tests {
+		opts := Options{Orientation: tt.orientation, Duplex: tt.duplex, Binding: Binding{Side: tt.side}}
+		side, turn := frameTurn(frame{back: tt.back}, opts)
+		if side != tt.wantSide || turn != tt.wantTurn {
+			t.Errorf("%s bound, %q strip, %s edge, back %t: got %s %d, want %s %d",
+				tt.orientation, tt.side, tt.duplex, tt.back, side, turn, tt.wantSide, tt.wantTurn)
+		}
+	}
+}
+
+func TestDuplexLeaves(t *testing.T) {
+	// 12 frames on two 4x6 sheets printed on both sides. Each leaf has its front frame and its
+	// back frame, the sheet and stack it is cut from
+	leaves := []struct {
+		front, back  int
+		sheet, stack int
+	}{
+		{0, 11, 0, 0},
+		{1, 10, 1, 0},
+		{2, 9, 0, 1},
+		{3, 8, 1, 1},
+		{4, 7, 0, 2},
+		{5, 6, 1, 2},
+	}
+
+	for _, duplex := range []string{DuplexLong, DuplexShort} {
+		info := renderBook(t, testBook{layout: "4x6x3", frames: 12, duplex: duplex})
+		if len(info.Sheets) != 4 {
+			t.Fatalf("%s edge: got %d sides, want 4", duplex, len(info.Sheets))
+		}
+		find := func(frame int) (SheetInfo, CellInfo) {
+			for _, s := range info.Sheets {
+				for _, c := range s.Cells {
+					if c.Frame == frame {
+						return s, c
+					}
+				}
+			}
+			t.Fatalf("%s edge: frame %d is not printed", duplex, frame)
+			return SheetInfo{}, CellInfo{}
+		}
+
+		for _, l := range leaves {
+			fs, fc := find(l.front)
+			bs, bc := find(l.back)
+			if fs.Back || fs.Sheet != l.sheet || fc.Stack != l.stack || fc.Turn != 0 {
+				t.Errorf("%s edge: frame %d is on sheet %d back %t stack %s turned %d, want the front of sheet %d stack %s",
+					duplex, l.front, fs.Sheet, fs.Back, stackName(fc.Stack), fc.Turn, l.sheet, stackName(l.stack))
+			}
+			if !bs.Back || bs.Sheet != l.sheet || bc.Stack != l.stack || bc.Turn != 0 {
+				t.Errorf("%s edge: frame %d is on sheet %d back %t stack %s turned %d, want the back of sheet %d stack %s",
+					duplex, l.back, bs.Sheet, bs.Back, stackName(bc.Stack), bc.Turn, l.sheet, stackName(l.stack))
+			}
+
+			// The back cell is the front cell mirrored across the edge the sheet turns on
+			want := fc
+			if duplex == DuplexLong {
+				want.Left = info.SheetWidth - fc.Left - fc.Width
+			} else {
+				want.Top = info.SheetHeight - fc.Top - fc.Height
+			}
+			if bc.Left != want.Left || bc.Top != want.Top || bc.Width != want.Width || bc.Height != want.Height {
+				t.Errorf("%s edge: frame %d is at %d,%d, want %d,%d behind frame %d", duplex, l.back, bc.Left, bc.Top, want.Left, want.Top, l.front)
+			}
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package composite
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// Chroma subsampling modes supported by encodeJPEG
+const (
+	Subsample444 = "444"
+	Subsample422 = "422"
+	Subsample420 = "420"
+)
+
+// quantLuma and quantChroma are the example quantization tables from Annex K of the JPEG
+// spec, in natural order, they are scaled by the quality setting
+var quantLuma = [64]int{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+var quantChroma = [64]int{
+	17, 18, 24, 47, 99, 99, 99, 99,
+	18, 21, 26, 66, 99, 99, 99, 99,
+	24, 26, 56, 99, 99, 99, 99, 99,
+	47, 66, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+}
+
+// zigzag maps the zig-zag index to the natural order index of a block
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// huffSpec is a huffman table in the form stored in a DHT segment, the number of codes of
+// each length 1-16 followed by the values in order of increasing code length
+type huffSpec struct {
+	counts [16]byte
+	values []byte
+}
+
+// The typical huffman tables from Annex K of the JPEG spec
+var (
+	huffDCLuma = huffSpec{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	}
+	huffDCChroma = huffSpec{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	}
+	huffACLuma = huffSpec{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12, 0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08, 0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	}
+	huffACChroma = huffSpec{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21, 0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91, 0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34, 0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	}
+)
+
+// dctCos[x][u] is cos((2x+1)uπ/16) scaled by C(u)/2, so a 1D DCT is a single sum
+var dctCos = func() [8][8]float64 {
+	var t [8][8]float64
+	for x := 0; x < 8; x++ {
+		for u := 0; u < 8; u++ {
+			c := 1.0
+			if u == 0 {
+				c = 1 / math.Sqrt2
+			}
+			t[x][u] = c / 2 * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return t
+}()
+
+// huffCode is a code and its length in bits
+type huffCode struct {
+	code uint16
+	size uint8
+}
+
+func (s huffSpec) codes() [256]huffCode {
+	var codes [256]huffCode
+	code, k := uint16(0), 0
+	for i, n := range s.counts {
+		for j := 0; j < int(n); j++ {
+			codes[s.values[k]] = huffCode{code: code, size: uint8(i + 1)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// jpegBitWriter writes huffman coded data, stuffing a zero byte after any 0xFF byte
+type jpegBitWriter struct {
+	w     *bufio.Writer
+	bits  uint32
+	nBits uint
+}
+
+func (b *jpegBitWriter) write(bits uint32, n uint) {
+	b.bits = b.bits<<n | bits&(1<<n-1)
+	b.nBits += n
+	for b.nBits >= 8 {
+		c := byte(b.bits >> (b.nBits - 8))
+		b.w.WriteByte(c)
+		if c == 0xFF {
+			b.w.WriteByte(0)
+		}
+		b.nBits -= 8
+	}
+}
+
+func (b *jpegBitWriter) flush() {
+	// Pad the final byte with 1 bits
+	if b.nBits > 0 {
+		b.write(0x7F, 8-b.nBits)
+	}
+}
+
+// encodeJPEG writes a baseline JPEG. Unlike image/jpeg this allows the chroma subsampling
+// to be chosen and writes the dpi to the JFIF header, so printers print at the intended size
+func encodeJPEG(w io.Writer, img image.Image, quality int, subsampling string, dpi int) error {
+	if quality < 1 || quality > 100 {
+		return fmt.Errorf("jpeg quality must be between 1 and 100, %d invalid value", quality)
+	}
+
+	// Horizontal and vertical sampling factors of the luma component
+	var h, v int
+	switch subsampling {
+	case Subsample444:
+		h, v = 1, 1
+	case Subsample422:
+		h, v = 2, 1
+	case "", Subsample420:
+		h, v = 2, 2
+	default:
+		return fmt.Errorf("invalid chroma subsampling: %s, must be 444|422|420", subsampling)
+	}
+	if dpi < 0 || dpi > 0xFFFF {
+		return fmt.Errorf("dpi must be between 0 and 65535, %d invalid value", dpi)
+	}
+
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width > 0xFFFF || height > 0xFFFF {
+		return fmt.Errorf("image is too large for a jpeg: %dx%d", width, height)
+	}
+
+	// Scale the quantization tables the same way as libjpeg
+	scale := 200 - quality*2
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	var qLuma, qChroma [64]int
+	for i := range qLuma {
+		qLuma[i] = clampInt((quantLuma[i]*scale+50)/100, 1, 255)
+		qChroma[i] = clampInt((quantChroma[i]*scale+50)/100, 1, 255)
+	}
+
+	bw := bufio.NewWriter(w)
+	marker := func(m byte, data []byte) {
+		bw.Write([]byte{0xFF, m})
+		binary.Write(bw, binary.BigEndian, uint16(len(data)+2))
+		bw.Write(data)
+	}
+
+	bw.Write([]byte{0xFF, 0xD8})
+
+	// JFIF 1.01, density in dots per inch, or aspect ratio only if the dpi is unknown
+	units := byte(1)
+	if dpi == 0 {
+		units, dpi = 0, 1
+	}
+	marker(0xE0, []byte{'J', 'F', 'I', 'F', 0, 1, 1, units,
+		byte(dpi >> 8), byte(dpi), byte(dpi >> 8), byte(dpi), 0, 0})
+
+	dqt := []byte{0}
+	for _, zi := range zigzag {
+		dqt = append(dqt, byte(qLuma[zi]))
+	}
+	dqt = append(dqt, 1)
+	for _, zi := range zigzag {
+		dqt = append(dqt, byte(qChroma[zi]))
+	}
+	marker(0xDB, dqt)
+
+	marker(0xC0, []byte{8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), 3,
+		1, byte(h<<4 | v), 0,
+		2, 0x11, 1,
+		3, 0x11, 1})
+
+	var dht []byte
+	for i, spec := range []huffSpec{huffDCLuma, huffACLuma, huffDCChroma, huffACChroma} {
+		// Table class in the high nibble, 0 for DC and 1 for AC, table id in the low nibble
+		dht = append(dht, byte((i%2)<<4|i/2))
+		dht = append(dht, spec.counts[:]...)
+		dht = append(dht, spec.values...)
+	}
+	marker(0xC4, dht)
+
+	marker(0xDA, []byte{3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 63, 0})
+
+	// Convert to YCbCr planes padded to a whole number of MCUs by repeating the edge pixels
+	mcuW, mcuH := 8*h, 8*v
+	pw := (width + mcuW - 1) / mcuW * mcuW
+	ph := (height + mcuH - 1) / mcuH * mcuH
+	yp := make([]float64, pw*ph)
+	cbp := make([]float64, pw*ph)
+	crp := make([]float64, pw*ph)
+	for y := 0; y < ph; y++ {
+		sy := b.Min.Y + minInt(y, height-1)
+		for x := 0; x < pw; x++ {
+			sx := b.Min.X + minInt(x, width-1)
+			r, g, bl, _ := img.At(sx, sy).RGBA()
+			rf, gf, bf := float64(r>>8), float64(g>>8), float64(bl>>8)
+			i := y*pw + x
+			yp[i] = 0.299*rf + 0.587*gf + 0.114*bf
+			cbp[i] = -0.168736*rf - 0.331264*gf + 0.5*bf + 128
+			crp[i] = 0.5*rf - 0.418688*gf - 0.081312*bf + 128
+		}
+	}
+
+	dcLuma, acLuma := huffDCLuma.codes(), huffACLuma.codes()
+	dcChroma, acChroma := huffDCChroma.codes(), huffACChroma.codes()
+	bits := &jpegBitWriter{w: bw}
+	var prevDC [3]int
+
+	var block [64]float64
+	for my := 0; my < ph; my += mcuH {
+		for mx := 0; mx < pw; mx += mcuW {
+			for by := 0; by < v; by++ {
+				for bx := 0; bx < h; bx++ {
+					for y := 0; y < 8; y++ {
+						for x := 0; x < 8; x++ {
+							block[y*8+x] = yp[(my+by*8+y)*pw+mx+bx*8+x]
+						}
+					}
+					prevDC[0] = encodeBlock(bits, &block, &qLuma, prevDC[0], &dcLuma, &acLuma)
+				}
+			}
+
+			for c, plane := range [][]float64{cbp, crp} {
+				// Average the chroma over the area of the MCU covered by one chroma block
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						var sum float64
+						for sy := 0; sy < v; sy++ {
+							for sx := 0; sx < h; sx++ {
+								sum += plane[(my+y*v+sy)*pw+mx+x*h+sx]
+							}
+						}
+						block[y*8+x] = sum / float64(h*v)
+					}
+				}
+				prevDC[c+1] = encodeBlock(bits, &block, &qChroma, prevDC[c+1], &dcChroma, &acChroma)
+			}
+		}
+	}
+	bits.flush()
+
+	bw.Write([]byte{0xFF, 0xD9})
+	return bw.Flush()
+}
+
+// encodeBlock transforms, quantizes and huffman codes a block of samples, returning the
+// quantized DC value which the next block of the same component is coded relative to
+func encodeBlock(bits *jpegBitWriter, block *[64]float64, quant *[64]int, prevDC int, dc, ac *[256]huffCode) int {
+	// Separable 2D DCT of the level shifted samples
+	var tmp, coef [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < 8; x++ {
+				sum += (block[y*8+x] - 128) * dctCos[x][u]
+			}
+			tmp[y*8+u] = sum
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for vv := 0; vv < 8; vv++ {
+			var sum float64
+			for y := 0; y < 8; y++ {
+				sum += tmp[y*8+u] * dctCos[y][vv]
+			}
+			coef[vv*8+u] = sum
+		}
+	}
+
+	var q [64]int
+	for i, zi := range zigzag {
+		q[i] = int(math.Floor(coef[zi]/float64(quant[zi]) + 0.5))
+	}
+
+	emit := func(code huffCode) {
+		bits.write(uint32(code.code), uint(code.size))
+	}
+	emitValue := func(value, size int) {
+		if value < 0 {
+			value += 1<<uint(size) - 1
+		}
+		bits.write(uint32(value), uint(size))
+	}
+
+	diff := q[0] - prevDC
+	size := bitSize(diff)
+	emit(dc[size])
+	emitValue(diff, size)
+
+	run := 0
+	for i := 1; i < 64; i++ {
+		if q[i] == 0 {
+			run++
+			continue
+		}
+		for run > 15 {
+			// ZRL, a run of 16 zeros
+			emit(ac[0xF0])
+			run -= 16
+		}
+		size := bitSize(q[i])
+		emit(ac[run<<4|size])
+		emitValue(q[i], size)
+		run = 0
+	}
+	if run > 0 {
+		// EOB
+		emit(ac[0x00])
+	}
+	return q[0]
+}
+
+// bitSize returns the number of bits needed to store the magnitude of v
+func bitSize(v int) int {
+	if v < 0 {
+		v = -v
+	}
+	n := 0
+	for v > 0 {
+		n++
+		v >>= 1
+	}
+	return n
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
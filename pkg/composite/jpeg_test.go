@@ -0,0 +1,189 @@
+package composite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// testSheet returns a smooth image with some hard edges, an odd size so the encoders have to
+// handle partial blocks and tiles
+func testSheet(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{
+				R: uint8(x * 255 / width),
+				G: uint8(y * 255 / height),
+				B: uint8(128 + 100*math.Sin(float64(x+y)/9)),
+				A: 255,
+			}
+			if x > width/2 && y > height/2 {
+				c = color.RGBA{200, 30, 40, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// meanError returns the mean absolute difference of the RGB channels of two images
+func meanError(a, b image.Image) float64 {
+	bounds := a.Bounds()
+	total := 0.0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r1, g1, b1, _ := a.At(x, y).RGBA()
+			r2, g2, b2, _ := b.At(x, y).RGBA()
+			total += math.Abs(float64(r1>>8)-float64(r2>>8)) +
+				math.Abs(float64(g1>>8)-float64(g2>>8)) +
+				math.Abs(float64(b1>>8)-float64(b2>>8))
+		}
+	}
+	return total / float64(3*bounds.Dx()*bounds.Dy())
+}
+
+func TestEncodeJPEG(t *testing.T) {
+	ratios := map[string]image.YCbCrSubsampleRatio{
+		Subsample444: image.YCbCrSubsampleRatio444,
+		Subsample422: image.YCbCrSubsampleRatio422,
+		Subsample420: image.YCbCrSubsampleRatio420,
+		"":           image.YCbCrSubsampleRatio420,
+	}
+
+	for _, size := range []image.Point{{1, 1}, {37, 23}, {64, 48}} {
+		img := testSheet(size.X, size.Y)
+		for _, quality := range []int{1, 25, 50, 90, 100} {
+			// image/jpeg always subsamples 4:2:0 and scales its tables the same way, so it
+			// sets the error to expect
+			var ref bytes.Buffer
+			if err := jpeg.Encode(&ref, img, &jpeg.Options{Quality: quality}); err != nil {
+				t.Fatal(err)
+			}
+			refOut, err := jpeg.Decode(&ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			refError := meanError(img, refOut)
+
+			errs := make(map[string]float64)
+			for sub, ratio := range ratios {
+				var b bytes.Buffer
+				if err := encodeJPEG(&b, img, quality, sub, 300); err != nil {
+					t.Fatal(err)
+				}
+				out, err := jpeg.Decode(&b)
+				if err != nil {
+					t.Fatalf("%v, %q, quality %d: failed to decode: %s", size, sub, quality, err)
+				}
+				if out.Bounds() != img.Bounds() {
+					t.Fatalf("%v, %q, quality %d: got bounds %v", size, sub, quality, out.Bounds())
+				}
+				ycc, ok := out.(*image.YCbCr)
+				if !ok || ycc.SubsampleRatio != ratio {
+					t.Fatalf("%v, %q, quality %d: got %T, want subsampling %v", size, sub, quality, out, ratio)
+				}
+				errs[sub] = meanError(img, out)
+			}
+
+			if errs[Subsample420] > refError*1.05+0.1 {
+				t.Errorf("%v, quality %d: 420 mean error %.2f, image/jpeg %.2f", size, quality, errs[Subsample420], refError)
+			}
+			// Less subsampling keeps more colour detail, at the lowest quality the blocks are
+			// too coarse for that to show
+			if quality >= 25 && (errs[Subsample444] > errs[Subsample422]+0.05 || errs[Subsample422] > errs[Subsample420]+0.05) {
+				t.Errorf("%v, quality %d: mean errors 444 %.2f, 422 %.2f, 420 %.2f", size, quality,
+					errs[Subsample444], errs[Subsample422], errs[Subsample420])
+			}
+			if quality >= 90 && errs[Subsample444] > 1.5 {
+				t.Errorf("%v, quality %d: 444 mean error %.2f, want at most 1.5", size, quality, errs[Subsample444])
+			}
+		}
+	}
+}
+
+func TestEncodeJPEGQuality(t *testing.T) {
+	// Higher quality keeps more detail and makes larger files
+	img := testSheet(64, 48)
+	lastSize, lastError := 0, math.MaxFloat64
+	for _, quality := range []int{10, 50, 75, 90, 100} {
+		var b bytes.Buffer
+		if err := encodeJPEG(&b, img, quality, Subsample444, 0); err != nil {
+			t.Fatal(err)
+		}
+		size := b.Len()
+		out, err := jpeg.Decode(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := meanError(img, out)
+		if size <= lastSize || e >= lastError {
+			t.Fatalf("quality %d: got %d bytes and mean error %.2f, after %d bytes and %.2f", quality, size, e, lastSize, lastError)
+		}
+		lastSize, lastError = size, e
+	}
+}
+
+func TestEncodeJPEGDensity(t *testing.T) {
+	tests := []struct {
+		dpi     int
+		units   byte
+		density uint16
+	}{
+		{0, 0, 1},
+		{72, 1, 72},
+		{300, 1, 300},
+		{1200, 1, 1200},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		if err := encodeJPEG(&b, testSheet(8, 8), 90, "", tt.dpi); err != nil {
+			t.Fatal(err)
+		}
+		data := b.Bytes()
+
+		// The JFIF segment directly follows the start of image marker
+		if !bytes.Equal(data[:4], []byte{0xFF, 0xD8, 0xFF, 0xE0}) || string(data[6:11]) != "JFIF\x00" {
+			t.Fatalf("dpi %d: no JFIF segment at the start, got % x", tt.dpi, data[:11])
+		}
+		app0 := data[11:]
+		if app0[0] != 1 || app0[1] != 1 {
+			t.Fatalf("dpi %d: got JFIF version %d.%d, want 1.1", tt.dpi, app0[0], app0[1])
+		}
+		x, y := binary.BigEndian.Uint16(app0[3:]), binary.BigEndian.Uint16(app0[5:])
+		if app0[2] != tt.units || x != tt.density || y != tt.density {
+			t.Fatalf("dpi %d: got units %d and density %dx%d, want %d and %d", tt.dpi, app0[2], x, y, tt.units, tt.density)
+		}
+	}
+}
+
+func TestEncodeJPEGErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		img         image.Image
+		quality     int
+		subsampling string
+		dpi         int
+		err         string
+	}{
+		{"quality 0", testSheet(8, 8), 0, "", 300, "jpeg quality must be between 1 and 100, 0 invalid value"},
+		{"quality 101", testSheet(8, 8), 101, "", 300, "jpeg quality must be between 1 and 100, 101 invalid value"},
+		{"subsampling", testSheet(8, 8), 90, "411", 300, "invalid chroma subsampling: 411, must be 444|422|420"},
+		{"negative dpi", testSheet(8, 8), 90, "", -1, "dpi must be between 0 and 65535, -1 invalid value"},
+		{"large dpi", testSheet(8, 8), 90, "", 70000, "dpi must be between 0 and 65535, 70000 invalid value"},
+		{"large image", image.NewRGBA(image.Rect(0, 0, 70000, 1)), 90, "", 300, "image is too large for a jpeg: 70000x1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			err := encodeJPEG(&b, tt.img, tt.quality, tt.subsampling, tt.dpi)
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package composite
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/markdaws/go-flipbook/pkg/typeset"
+	"golang.org/x/image/draw"
+)
+
+// DefaultLabel the label printed on the binding strip of each frame when none is specified
+const DefaultLabel = "{index} {identifier}"
+
+// LabelStyle describes the label printed on the binding strip of each frame, which helps to
+// put the frames back in order if the stacks get mixed up
+type LabelStyle struct {
+	// Disabled if true no labels are printed
+	Disabled bool
+
+	// Template the text of the label, defaults to DefaultLabel. The fields {index} the index of
+	// the frame, {timecode} the time of the frame in the video, {sheet} the index of the sheet
+	// as used in its file name, {stack} the letter of the stack the frame is in once the sheets
+	// are cut, and {identifier} are replaced, any other text is printed as is
+	Template string
+
+	// Fonts the fonts used for labels, before the font from the options and the fallback fonts.
+	// If nil the font from the options is used
+	Fonts typeset.Fonts
+
+	// Size the font size in points, labels are shrunk to fit the strip. Defaults to 7
+	Size float64
+
+	// Color the colour of the text as #rrggbb or #rrggbbaa, defaults to white
+	Color string
+
+	// Rotation the angle in degrees the label is turned anticlockwise, 0|90|180|270. At 90 or
+	// 270 the label runs along the strip, which leaves room for longer labels
+	Rotation int
+}
+
+// Validate returns an error if any of the style values are invalid
+func (s LabelStyle) Validate() error {
+	if s.Size < 0 {
+		return fmt.Errorf("label size must not be negative, %g invalid value", s.Size)
+	}
+	switch s.Rotation {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("invalid label rotation: %d, must be 0|90|180|270", s.Rotation)
+	}
+	if _, err := parseColor(s.Color); err != nil {
+		return err
+	}
+	return nil
+}
+
+// labelText returns the text of the label for the frame
+func labelText(style LabelStyle, f frame, opts Options) string {
+	tmpl := style.Template
+	if tmpl == "" {
+		tmpl = DefaultLabel
+	}
+
+	timecode := ""
+	if seconds, ok := opts.FrameTimes[f.info.Name()]; ok {
+		timecode = formatTimecode(seconds)
+	}
+
+	return strings.NewReplacer(
+		"{index}", strconv.Itoa(f.index),
+		"{timecode}", timecode,
+		"{sheet}", strconv.Itoa(f.sheet),
+		"{stack}", stackName(f.stack),
+		"{identifier}", f.label,
+	).Replace(tmpl)
+}
+
+// stackName returns the letter of a stack, a to z then aa, ab and so on
+func stackName(stack int) string {
+	name := string(rune('a' + stack%26))
+	for stack /= 26; stack > 0; stack = stack/26 - 1 {
+		name = string(rune('a'+(stack-1)%26)) + name
+	}
+	return name
+}
+
+// formatTimecode formats seconds as m:ss.cc, or h:mm:ss.cc for an hour or more
+func formatTimecode(seconds float64) string {
+	cs := int(math.Round(seconds * 100))
+	h, m, s := cs/360000, cs/6000%60, cs/100%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d.%02d", h, m, s, cs%100)
+	}
+	return fmt.Sprintf("%d:%02d.%02d", m, s, cs%100)
+}
+
+// drawLabel draws the text centred in the strip, turned by the rotation in the style
+func drawLabel(img *image.RGBA, strip image.Rectangle, text string, style LabelStyle, fonts typeset.Fonts, dpi int) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+
+	size := style.Size
+	if size == 0 {
+		size = 7
+	}
+	fg, _ := parseColor(style.Color)
+	if fg == nil {
+		fg = color.White
+	}
+	chain := append(append(typeset.Fonts{}, style.Fonts...), fonts...)
+
+	// The label is drawn upright on its own image and then turned, so at 90 and 270 it is laid
+	// out along the length of the strip
+	w, h := strip.Dx(), strip.Dy()
+	if style.Rotation == 90 || style.Rotation == 270 {
+		w, h = h, w
+	}
+	margin := int(math.Min(float64(w), float64(h)) / 10)
+	box := image.Rect(margin, margin, w-margin, h-margin)
+
+	px := float64(dpi) / 72
+	t, err := typeset.Fit(text, chain, size*px, minFontSize*px, box.Size())
+	if err != nil {
+		return err
+	}
+
+	label := image.NewNRGBA(image.Rect(0, 0, w, h))
+	t.Draw(label, box, typeset.AlignCenter, typeset.VAlignMiddle, image.NewUniform(fg))
+
+	var turned *image.NRGBA
+	switch style.Rotation {
+	case 90:
+		turned = imaging.Rotate90(label)
+	case 180:
+		turned = imaging.Rotate180(label)
+	case 270:
+		turned = imaging.Rotate270(label)
+	default:
+		turned = label
+	}
+	draw.Draw(img, strip, turned, image.Point{}, draw.Over)
+	return nil
+}
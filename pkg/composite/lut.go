@@ -0,0 +1,296 @@
+package composite
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// EffectLUT the effect that applies the LUT in the effect pipeline, after the adjustments and
+// the cover rather than before them, so the cover and designed pages are graded too
+const EffectLUT = "lut"
+
+// LUT is a colour lookup table loaded from an Adobe .cube file, either a 1D table applied
+// to each channel separately or a 3D table mapping every input colour to an output colour
+type LUT struct {
+	// Title the optional title from the file
+	Title string
+
+	// Size the number of entries per channel
+	Size int
+
+	// Is3D true if the table is a 3D table
+	Is3D bool
+
+	// DomainMin the input value mapped to the first entry of each channel, usually 0
+	DomainMin [3]float64
+
+	// DomainMax the input value mapped to the last entry of each channel, usually 1
+	DomainMax [3]float64
+
+	// Table the output values. For a 1D table entry i is table[i], for a 3D table the entry
+	// for red index r, green index g and blue index b is table[r + g*Size + b*Size*Size]
+	Table [][3]float64
+}
+
+// LoadCube loads a LUT from the .cube file at path
+func LoadCube(p string) (*LUT, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lut: %s", err)
+	}
+	defer f.Close()
+
+	lut, err := ParseCube(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse lut: %s, %s", p, err)
+	}
+	return lut, nil
+}
+
+// ParseCube parses a LUT in the Adobe .cube format
+func ParseCube(r io.Reader) (*LUT, error) {
+	lut := &LUT{DomainMax: [3]float64{1, 1, 1}}
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "TITLE":
+			lut.Title = strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "TITLE")), `"`)
+		case "LUT_1D_SIZE", "LUT_3D_SIZE":
+			if lut.Size != 0 {
+				return nil, fmt.Errorf("line %d: size specified more than once", lineNum)
+			}
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: invalid size", lineNum)
+			}
+			size, err := strconv.Atoi(fields[1])
+			if err != nil || size < 2 {
+				return nil, fmt.Errorf("line %d: invalid size: %s", lineNum, fields[1])
+			}
+			lut.Size = size
+			lut.Is3D = fields[0] == "LUT_3D_SIZE"
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			v, err := parseTriple(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNum, err)
+			}
+			if fields[0] == "DOMAIN_MIN" {
+				lut.DomainMin = v
+			} else {
+				lut.DomainMax = v
+			}
+		default:
+			v, err := parseTriple(fields)
+			if err != nil {
+				// Unknown keywords are allowed by the spec and can be ignored
+				if _, numErr := strconv.ParseFloat(fields[0], 64); numErr != nil {
+					continue
+				}
+				return nil, fmt.Errorf("line %d: %s", lineNum, err)
+			}
+			lut.Table = append(lut.Table, v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if lut.Size == 0 {
+		return nil, fmt.Errorf("missing LUT_1D_SIZE or LUT_3D_SIZE")
+	}
+
+	expected := lut.Size
+	if lut.Is3D {
+		expected = lut.Size * lut.Size * lut.Size
+	}
+	if len(lut.Table) != expected {
+		return nil, fmt.Errorf("expected %d entries, found %d", expected, len(lut.Table))
+	}
+
+	for c := 0; c < 3; c++ {
+		if lut.DomainMax[c] <= lut.DomainMin[c] {
+			return nil, fmt.Errorf("domain max must be greater than domain min")
+		}
+	}
+	return lut, nil
+}
+
+func parseTriple(fields []string) ([3]float64, error) {
+	var v [3]float64
+	if len(fields) != 3 {
+		return v, fmt.Errorf("expected 3 values, found %d", len(fields))
+	}
+	for i, f := range fields {
+		n, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return v, fmt.Errorf("invalid value: %s", f)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// Apply returns a copy of the image with the LUT applied. interpolation is used for 3D
+// tables and can be trilinear or tetrahedral, tetrahedral is used if it is empty
+func (l *LUT) Apply(img image.Image, interpolation string) (*image.NRGBA, error) {
+	tetrahedral := true
+	switch interpolation {
+	case "", "tetrahedral":
+	case "trilinear":
+		tetrahedral = false
+	default:
+		return nil, fmt.Errorf("invalid lut interpolation: %s, must be trilinear|tetrahedral", interpolation)
+	}
+
+	return imaging.AdjustFunc(img, func(c color.NRGBA) color.NRGBA {
+		in := [3]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255}
+
+		// Map the input into table coordinates
+		var pos [3]float64
+		for i := range in {
+			p := (in[i] - l.DomainMin[i]) / (l.DomainMax[i] - l.DomainMin[i]) * float64(l.Size-1)
+			pos[i] = math.Max(0, math.Min(float64(l.Size-1), p))
+		}
+
+		var out [3]float64
+		switch {
+		case !l.Is3D:
+			out = l.lookup1D(pos)
+		case tetrahedral:
+			out = l.tetrahedral(pos)
+		default:
+			out = l.trilinear(pos)
+		}
+
+		return color.NRGBA{
+			R: clampUint8(out[0] * 255),
+			G: clampUint8(out[1] * 255),
+			B: clampUint8(out[2] * 255),
+			A: c.A,
+		}
+	}), nil
+}
+
+func (l *LUT) lookup1D(pos [3]float64) [3]float64 {
+	var out [3]float64
+	for c := range pos {
+		i0, i1, f := l.split(pos[c])
+		out[c] = l.Table[i0][c]*(1-f) + l.Table[i1][c]*f
+	}
+	return out
+}
+
+func (l *LUT) at(r, g, b int) [3]float64 {
+	return l.Table[r+g*l.Size+b*l.Size*l.Size]
+}
+
+func (l *LUT) trilinear(pos [3]float64) [3]float64 {
+	r0, r1, fr := l.split(pos[0])
+	g0, g1, fg := l.split(pos[1])
+	b0, b1, fb := l.split(pos[2])
+
+	var out [3]float64
+	for c := 0; c < 3; c++ {
+		c00 := l.at(r0, g0, b0)[c]*(1-fr) + l.at(r1, g0, b0)[c]*fr
+		c10 := l.at(r0, g1, b0)[c]*(1-fr) + l.at(r1, g1, b0)[c]*fr
+		c01 := l.at(r0, g0, b1)[c]*(1-fr) + l.at(r1, g0, b1)[c]*fr
+		c11 := l.at(r0, g1, b1)[c]*(1-fr) + l.at(r1, g1, b1)[c]*fr
+		c0 := c00*(1-fg) + c10*fg
+		c1 := c01*(1-fg) + c11*fg
+		out[c] = c0*(1-fb) + c1*fb
+	}
+	return out
+}
+
+// tetrahedral splits the cube surrounding the position in to 6 tetrahedra and interpolates
+// within the one containing the position, which preserves neutral greys better than trilinear
+func (l *LUT) tetrahedral(pos [3]float64) [3]float64 {
+	r0, r1, fr := l.split(pos[0])
+	g0, g1, fg := l.split(pos[1])
+	b0, b1, fb := l.split(pos[2])
+
+	c000 := l.at(r0, g0, b0)
+	c111 := l.at(r1, g1, b1)
+
+	var out [3]float64
+	for c := 0; c < 3; c++ {
+		switch {
+		case fr >= fg && fg >= fb:
+			c100, c110 := l.at(r1, g0, b0)[c], l.at(r1, g1, b0)[c]
+			out[c] = (1-fr)*c000[c] + (fr-fg)*c100 + (fg-fb)*c110 + fb*c111[c]
+		case fr >= fb && fb >= fg:
+			c100, c101 := l.at(r1, g0, b0)[c], l.at(r1, g0, b1)[c]
+			out[c] = (1-fr)*c000[c] + (fr-fb)*c100 + (fb-fg)*c101 + fg*c111[c]
+		case fb >= fr && fr >= fg:
+			c001, c101 := l.at(r0, g0, b1)[c], l.at(r1, g0, b1)[c]
+			out[c] = (1-fb)*c000[c] + (fb-fr)*c001 + (fr-fg)*c101 + fg*c111[c]
+		case fg >= fr && fr >= fb:
+			c010, c110 := l.at(r0, g1, b0)[c], l.at(r1, g1, b0)[c]
+			out[c] = (1-fg)*c000[c] + (fg-fr)*c010 + (fr-fb)*c110 + fb*c111[c]
+		case fg >= fb && fb >= fr:
+			c010, c011 := l.at(r0, g1, b0)[c], l.at(r0, g1, b1)[c]
+			out[c] = (1-fg)*c000[c] + (fg-fb)*c010 + (fb-fr)*c011 + fr*c111[c]
+		default:
+			c001, c011 := l.at(r0, g0, b1)[c], l.at(r0, g1, b1)[c]
+			out[c] = (1-fb)*c000[c] + (fb-fg)*c001 + (fg-fr)*c011 + fr*c111[c]
+		}
+	}
+	return out
+}
+
+// split returns the indices of the table entries either side of p, and how far between them p is
+func (l *LUT) split(p float64) (int, int, float64) {
+	i0 := int(math.Floor(p))
+	if i0 >= l.Size-1 {
+		return l.Size - 1, l.Size - 1, 0
+	}
+	return i0, i0 + 1, p - float64(i0)
+}
+
+// lutFrames applies the LUT in the options to each of the frames, overwriting the frame files
+func lutFrames(opts Options, frames []os.FileInfo) error {
+	applied := make(map[string]bool)
+	for _, f := range frames {
+		// Held frames appear more than once, but the LUT must only be applied once
+		if applied[f.Name()] {
+			continue
+		}
+		applied[f.Name()] = true
+
+		p := path.Join(opts.InputDir, f.Name())
+		opts.VerLog.Println("Applying lut to:", p)
+		img, err := imaging.Open(p)
+		if err != nil {
+			return fmt.Errorf("failed to load frame: %s, %s", p, err)
+		}
+
+		dst, err := opts.LUT.Apply(img, opts.LUTInterpolation)
+		if err != nil {
+			return err
+		}
+
+		err = imaging.Save(dst, p)
+		if err != nil {
+			return fmt.Errorf("failed to save frame with lut: %s, %s", p, err)
+		}
+	}
+	return nil
+}
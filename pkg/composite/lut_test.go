@@ -0,0 +1,270 @@
+package composite
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// identityCube returns a .cube file of a 3D table of the given size that leaves colours unchanged
+func identityCube(size int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "TITLE \"identity\"\nLUT_3D_SIZE %d\n", size)
+	n := float64(size - 1)
+	for bl := 0; bl < size; bl++ {
+		for g := 0; g < size; g++ {
+			for r := 0; r < size; r++ {
+				fmt.Fprintf(&b, "%g %g %g\n", float64(r)/n, float64(g)/n, float64(bl)/n)
+			}
+		}
+	}
+	return b.String()
+}
+
+func TestParseCubeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cube string
+		err  string
+	}{
+		{"no size", "0 0 0\n1 1 1\n", "missing LUT_1D_SIZE or LUT_3D_SIZE"},
+		{"size too small", "LUT_1D_SIZE 1\n0 0 0\n", "line 1: invalid size: 1"},
+		{"size not a number", "LUT_3D_SIZE two\n", "line 1: invalid size: two"},
+		{"size missing value", "LUT_3D_SIZE\n", "line 1: invalid size"},
+		{"size twice", "LUT_1D_SIZE 2\nLUT_1D_SIZE 2\n", "line 2: size specified more than once"},
+		{"too few 1D entries", "LUT_1D_SIZE 3\n0 0 0\n1 1 1\n", "expected 3 entries, found 2"},
+		{"too many 3D entries", identityCube(2) + "1 1 1\n", "expected 8 entries, found 9"},
+		{"too few 3D entries", "LUT_3D_SIZE 2\n0 0 0\n1 1 1\n", "expected 8 entries, found 2"},
+		{"short entry", "LUT_1D_SIZE 2\n0 0\n1 1 1\n", "line 2: expected 3 values, found 2"},
+		{"bad entry", "LUT_1D_SIZE 2\n0 0 x\n1 1 1\n", "line 2: invalid value: x"},
+		{"short domain", "DOMAIN_MIN 0 0\nLUT_1D_SIZE 2\n0 0 0\n1 1 1\n", "line 1: expected 3 values, found 2"},
+		{"bad domain", "DOMAIN_MAX 1 1 one\nLUT_1D_SIZE 2\n0 0 0\n1 1 1\n", "line 1: invalid value: one"},
+		{"empty domain", "DOMAIN_MIN 0 0.5 0\nDOMAIN_MAX 1 0.5 1\nLUT_1D_SIZE 2\n0 0 0\n1 1 1\n", "domain max must be greater than domain min"},
+		{"reversed domain", "DOMAIN_MIN 1 1 1\nDOMAIN_MAX 0 0 0\nLUT_1D_SIZE 2\n0 0 0\n1 1 1\n", "domain max must be greater than domain min"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCube(strings.NewReader(tt.cube))
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseCube(t *testing.T) {
+	cube := `# A comment
+TITLE "Warm"
+LUT_IN_VIDEO_RANGE
+DOMAIN_MIN 0 0 0
+DOMAIN_MAX 2 2 2
+LUT_1D_SIZE 2
+
+0 0 0
+1 0.5 0.25
+`
+	lut, err := ParseCube(strings.NewReader(cube))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lut.Title != "Warm" || lut.Size != 2 || lut.Is3D {
+		t.Fatalf("got title %q, size %d, 3D %t", lut.Title, lut.Size, lut.Is3D)
+	}
+	if lut.DomainMin != [3]float64{0, 0, 0} || lut.DomainMax != [3]float64{2, 2, 2} {
+		t.Fatalf("got domain %v to %v", lut.DomainMin, lut.DomainMax)
+	}
+	if len(lut.Table) != 2 || lut.Table[1] != [3]float64{1, 0.5, 0.25} {
+		t.Fatalf("got table %v", lut.Table)
+	}
+}
+
+// testImage returns an image with a pixel of every 17th level of each channel, and varying alpha
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 16*16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16*16; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(x%16) * 17,
+				G: uint8(x/16) * 17,
+				B: uint8(y) * 17,
+				A: uint8(255 - y),
+			})
+		}
+	}
+	return img
+}
+
+func TestApplyIdentity(t *testing.T) {
+	img := testImage()
+	for _, size := range []int{2, 17, 33} {
+		lut, err := ParseCube(strings.NewReader(identityCube(size)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, interp := range []string{"trilinear", "tetrahedral"} {
+			got, err := lut.Apply(img, interp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range img.Pix {
+				if got.Pix[i] != img.Pix[i] {
+					t.Fatalf("size %d, %s: byte %d got %d, want %d", size, interp, i, got.Pix[i], img.Pix[i])
+				}
+			}
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	// Every corner is black except white, so trilinear blends in all eight corners while
+	// tetrahedral follows the grey diagonal
+	corner := "LUT_3D_SIZE 2\n" + strings.Repeat("0 0 0\n", 7) + "1 1 1\n"
+
+	// Red and blue are swapped, which is linear so both interpolations are exact
+	swap := "LUT_3D_SIZE 2\n" +
+		"0 0 0\n0 0 1\n0 1 0\n0 1 1\n" +
+		"1 0 0\n1 0 1\n1 1 0\n1 1 1\n"
+
+	tests := []struct {
+		name   string
+		cube   string
+		interp string
+		in     color.NRGBA
+		want   color.NRGBA
+	}{
+		{"corner trilinear grey", corner, "trilinear", color.NRGBA{51, 51, 51, 255}, color.NRGBA{2, 2, 2, 255}},
+		{"corner tetrahedral grey", corner, "tetrahedral", color.NRGBA{51, 51, 51, 255}, color.NRGBA{51, 51, 51, 255}},
+		{"corner trilinear red", corner, "trilinear", color.NRGBA{255, 102, 51, 255}, color.NRGBA{20, 20, 20, 255}},
+		{"corner tetrahedral red", corner, "tetrahedral", color.NRGBA{255, 102, 51, 255}, color.NRGBA{51, 51, 51, 255}},
+		{"corner white", corner, "tetrahedral", color.NRGBA{255, 255, 255, 128}, color.NRGBA{255, 255, 255, 128}},
+		{"swap trilinear", swap, "trilinear", color.NRGBA{200, 100, 10, 255}, color.NRGBA{10, 100, 200, 255}},
+		{"swap tetrahedral", swap, "tetrahedral", color.NRGBA{200, 100, 10, 255}, color.NRGBA{10, 100, 200, 255}},
+		{"1D invert", "LUT_1D_SIZE 2\n1 1 1\n0 0 0\n", "", color.NRGBA{200, 100, 0, 255}, color.NRGBA{55, 155, 255, 255}},
+		{"1D curve", "LUT_1D_SIZE 3\n0 0 0\n0.25 0.5 0.75\n1 1 1\n", "", color.NRGBA{51, 51, 51, 255}, color.NRGBA{26, 51, 77, 255}},
+		{"domain", "DOMAIN_MAX 2 2 2\nLUT_1D_SIZE 2\n0 0 0\n1 1 1\n", "", color.NRGBA{255, 0, 102, 255}, color.NRGBA{128, 0, 51, 255}},
+		{"domain clamps", "DOMAIN_MIN 0.2 0.2 0.2\nDOMAIN_MAX 0.6 0.6 0.6\nLUT_1D_SIZE 2\n0 0 0\n1 1 1\n", "", color.NRGBA{0, 255, 102, 255}, color.NRGBA{0, 255, 128, 255}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lut, err := ParseCube(strings.NewReader(tt.cube))
+			if err != nil {
+				t.Fatal(err)
+			}
+			img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+			img.SetNRGBA(0, 0, tt.in)
+			out, err := lut.Apply(img, tt.interp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := out.NRGBAAt(0, 0); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyInvalidInterpolation(t *testing.T) {
+	lut, err := ParseCube(strings.NewReader(identityCube(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = lut.Apply(testImage(), "cubic")
+	if err == nil {
+		t.Fatal("expected an error for an unknown interpolation")
+	}
+}
+
+func TestLUTEffect(t *testing.T) {
+	// The LUT makes every colour mid grey, so it is the last step if the frames come out grey
+	// despite being darkened, and the first if they are darker than grey
+	flat, err := ParseCube(strings.NewReader("LUT_1D_SIZE 2\n0.5 0.5 0.5\n0.5 0.5 0.5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		effect string
+		lut    *LUT
+		grey   bool
+		err    string
+	}{
+		{"before the adjustments", "", flat, false, ""},
+		{"as the effect", EffectLUT, flat, true, ""},
+		{"effect without a lut", EffectLUT, nil, false, "the lut effect needs a LUT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "luteffect")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			var frames []os.FileInfo
+			for i := 0; i < 12; i++ {
+				p := path.Join(dir, fmt.Sprintf("frame-%03d.png", i))
+				err = imaging.Save(testSheet(16, 9), p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				info, err := os.Stat(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				frames = append(frames, info)
+			}
+
+			_, err = To4x6x3(Options{
+				Page:        Page{Width: 4, Height: 6, DPI: 20},
+				BGColor:     "white",
+				InputDir:    dir,
+				OutputDir:   dir,
+				Frames:      frames,
+				FontBytes:   goregular.TTF,
+				Labels:      LabelStyle{Disabled: true},
+				Effect:      tt.effect,
+				LUT:         tt.lut,
+				Adjust:      Adjustments{Brightness: -50},
+				SheetWriter: PNGWriter{},
+				VerLog:      log.New(ioutil.Discard, "", 0),
+			})
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(path.Join(dir, "frame-000.png"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			img, err := png.Decode(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := color.NRGBAModel.Convert(img.At(0, 0)).(color.NRGBA)
+			if tt.grey && got.R != 128 {
+				t.Errorf("got %v, want mid grey", got)
+			}
+			if !tt.grey && got.R >= 128 {
+				t.Errorf("got %v, want darker than mid grey", got)
+			}
+		})
+	}
+}
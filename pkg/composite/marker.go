@@ -0,0 +1,145 @@
+package composite
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Values of MarkerStyle.Kind
+const (
+	MarkerProgress = "progress"
+	MarkerThumb    = "thumb"
+	MarkerTabs     = "tabs"
+)
+
+// DefaultMarkerWidth the width of the markers in inches when none is specified
+const DefaultMarkerWidth = 0.08
+
+// DefaultTabColors the colours of chapter tabs when none are specified
+var DefaultTabColors = []string{"#e6194b", "#3cb44b", "#4363d8", "#f58231", "#911eb4", "#42d4f4"}
+
+// MarkerStyle describes the markers printed along the flip edge of each frame. The markers on
+// every frame line up once the stacks are bound, forming a gauge on the edge of the book that
+// shows where in the sequence each page is
+type MarkerStyle struct {
+	// Kind the kind of marker, progress|thumb|tabs, if empty no markers are printed. progress
+	// is a bar along the edge that grows from the start to the end of the book, thumb is a
+	// short mark that moves along the edge, and tabs are a mark for each chapter, moving along
+	// the edge one tab at a time and starting again from the top after Slots chapters
+	Kind string
+
+	// Width of the markers in inches, measured in from the flip edge, defaults to
+	// DefaultMarkerWidth
+	Width float32
+
+	// Color the colour of progress and thumb markers as #rrggbb or #rrggbbaa, defaults to black
+	Color string
+
+	// Colors the colours of tabs, used in turn for each chapter, defaults to DefaultTabColors
+	Colors []string
+
+	// Slots the number of tab positions along the edge, defaults to the number of colours
+	Slots int
+}
+
+// Validate returns an error if any of the style values are invalid
+func (s MarkerStyle) Validate() error {
+	switch s.Kind {
+	case "", MarkerProgress, MarkerThumb, MarkerTabs:
+	default:
+		return fmt.Errorf("invalid marker kind: %s, must be progress|thumb|tabs", s.Kind)
+	}
+	if s.Width < 0 {
+		return fmt.Errorf("marker width must not be negative, %g invalid value", s.Width)
+	}
+	if s.Slots < 0 {
+		return fmt.Errorf("marker slots must not be negative, %d invalid value", s.Slots)
+	}
+	if _, err := parseColor(s.Color); err != nil {
+		return err
+	}
+	for _, c := range s.Colors {
+		if _, err := parseColor(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chapterStarts returns the chapter of each frame in the list, a new chapter starts at each
+// frame named in starts. Frames before the first start are in chapter 0
+func chapterStarts(frames []string, starts map[string]bool) []int {
+	chapters := make([]int, len(frames))
+	chapter := 0
+	for i, name := range frames {
+		if starts[name] && i > 0 {
+			chapter++
+		}
+		chapters[i] = chapter
+	}
+	return chapters
+}
+
+// drawMarker draws the marker for the frame along the flip edge of the area, which is the edge
+// opposite the binding strip. progress is the position of the frame in the book from 0 to 1
+func drawMarker(img *image.RGBA, area image.Rectangle, side string, style MarkerStyle, progress float64, chapter, dpi int) {
+	width := style.Width
+	if width == 0 {
+		width = DefaultMarkerWidth
+	}
+	size := int(math.Max(1, math.Round(float64(width)*float64(dpi))))
+
+	// The marker is computed as a span along the edge, from 0 to length, then placed on the edge
+	length := area.Dy()
+	if side == BindingTop {
+		length = area.Dx()
+	}
+
+	fg, _ := parseColor(style.Color)
+	if fg == nil {
+		fg = color.Black
+	}
+
+	var start, end int
+	switch style.Kind {
+	case MarkerProgress:
+		end = int(math.Round(progress * float64(length)))
+	case MarkerThumb:
+		mark := length / 8
+		start = int(math.Round(progress * float64(length-mark)))
+		end = start + mark
+	case MarkerTabs:
+		colors := style.Colors
+		if len(colors) == 0 {
+			colors = DefaultTabColors
+		}
+		slots := style.Slots
+		if slots == 0 {
+			slots = len(colors)
+		}
+		tab := length / slots
+		start = chapter % slots * tab
+		end = start + tab
+		fg, _ = parseColor(colors[chapter%len(colors)])
+	default:
+		return
+	}
+	if end <= start {
+		return
+	}
+
+	var r image.Rectangle
+	switch side {
+	case BindingTop:
+		r = image.Rect(area.Min.X+start, area.Max.Y-size, area.Min.X+end, area.Max.Y)
+	case BindingRight:
+		r = image.Rect(area.Min.X, area.Min.Y+start, area.Min.X+size, area.Min.Y+end)
+	default:
+		r = image.Rect(area.Max.X-size, area.Min.Y+start, area.Max.X, area.Min.Y+end)
+	}
+	draw.Draw(img, r, image.NewUniform(fg), image.Point{}, draw.Over)
+}
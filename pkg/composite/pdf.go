@@ -0,0 +1,163 @@
+package composite
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"io"
+)
+
+// encodePDF writes a single page PDF, sized to the physical page, containing the image. CMYK
+// images are stored in the DeviceCMYK colour space, RGB images in DeviceRGB, if a profile is
+// specified the colour space is ICCBased using the profile
+func encodePDF(w io.Writer, img image.Image, page Page, profile []byte) error {
+	doc := newPDFDocument(1, page, profile)
+	doc.setPage(0, img)
+	return doc.write(w)
+}
+
+// pdfDocument builds a PDF with one image on each page, every page is sized to the physical
+// page. Pages are compressed as they are set so the whole book isn't held in memory, and can
+// be set in any order. The images must either all be CMYK or all be RGB
+type pdfDocument struct {
+	page    Page
+	profile []byte
+	pages   []pdfPage
+}
+
+type pdfPage struct {
+	width, height int
+	components    int
+	pix           []byte
+}
+
+func newPDFDocument(nPages int, page Page, profile []byte) *pdfDocument {
+	return &pdfDocument{
+		page:    page,
+		profile: profile,
+		pages:   make([]pdfPage, nPages),
+	}
+}
+
+// setPage compresses the image and stores it as page i
+func (d *pdfDocument) setPage(i int, img image.Image) {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	var pix []byte
+	components := 3
+	switch src := img.(type) {
+	case *image.CMYK:
+		components = 4
+		pix = make([]byte, 0, width*height*4)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			i := src.PixOffset(b.Min.X, y)
+			pix = append(pix, src.Pix[i:i+width*4]...)
+		}
+	default:
+		pix = make([]byte, 0, width*height*3)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, g, bl, _ := img.At(x, y).RGBA()
+				pix = append(pix, uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+			}
+		}
+	}
+
+	d.pages[i] = pdfPage{
+		width:      width,
+		height:     height,
+		components: components,
+		pix:        deflate(pix),
+	}
+}
+
+// write writes the document, every page must have been set
+func (d *pdfDocument) write(w io.Writer) error {
+	if len(d.pages) == 0 {
+		return fmt.Errorf("a pdf must have at least one page")
+	}
+	for i, p := range d.pages {
+		if p.pix == nil {
+			return fmt.Errorf("pdf page %d was not set", i)
+		}
+	}
+
+	var objects [][]byte
+	addObject := func(obj string, stream []byte) int {
+		var o bytes.Buffer
+		o.WriteString(obj)
+		if stream != nil {
+			o.WriteString("\nstream\n")
+			o.Write(stream)
+			o.WriteString("\nendstream")
+		}
+		objects = append(objects, o.Bytes())
+		return len(objects)
+	}
+
+	// Points are 1/72 of an inch
+	pageWidth := float64(d.page.Width) * 72
+	pageHeight := float64(d.page.Height) * 72
+
+	// The catalog and page tree are objects 1 and 2, the page tree is filled in once the page
+	// objects are numbered
+	addObject("<< /Type /Catalog /Pages 2 0 R >>", nil)
+	addObject("", nil)
+
+	components := d.pages[0].components
+	colorSpace := "/DeviceRGB"
+	if components == 4 {
+		colorSpace = "/DeviceCMYK"
+	}
+	if d.profile != nil {
+		profileData := deflate(d.profile)
+		n := addObject(fmt.Sprintf("<< /N %d /Alternate %s /Filter /FlateDecode /Length %d >>",
+			components, colorSpace, len(profileData)), profileData)
+		colorSpace = fmt.Sprintf("[/ICCBased %d 0 R]", n)
+	}
+
+	var kids bytes.Buffer
+	for _, p := range d.pages {
+		// Each page is followed by its image and contents
+		n := len(objects) + 1
+		addObject(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, n+1, n+2), nil)
+		addObject(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>",
+			p.width, p.height, colorSpace, len(p.pix)), p.pix)
+		contents := []byte(fmt.Sprintf("q %.2f 0 0 %.2f 0 0 cm /Im0 Do Q", pageWidth, pageHeight))
+		addObject(fmt.Sprintf("<< /Length %d >>", len(contents)), contents)
+		fmt.Fprintf(&kids, "%d 0 R ", n)
+	}
+	objects[1] = []byte(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", bytes.TrimSpace(kids.Bytes()), len(d.pages)))
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n", i+1)
+		out.Write(o)
+		out.WriteString("\nendobj\n")
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n", len(objects)+1)
+	out.WriteString("0000000000 65535 f \n")
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func deflate(data []byte) []byte {
+	var d bytes.Buffer
+	zw := zlib.NewWriter(&d)
+	zw.Write(data)
+	zw.Close()
+	return d.Bytes()
+}
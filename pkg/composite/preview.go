@@ -0,0 +1,244 @@
+package composite
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+
+	"golang.org/x/image/draw"
+
+	"github.com/disintegration/imaging"
+	"github.com/markdaws/go-flipbook/pkg/ffmpeg"
+)
+
+// Values of Options.Previews, the formats of the animated previews
+const (
+	PreviewGIF  = "gif"
+	PreviewAPNG = "apng"
+	PreviewWebP = "webp"
+	PreviewMP4  = "mp4"
+)
+
+// DefaultPreviewWidth the width in pixels of the previews when none is specified
+const DefaultPreviewWidth = 480
+
+// DefaultPreviewFPS the frame rate of the previews when none is specified
+const DefaultPreviewFPS = 15
+
+// previewFrameName the printf pattern of the preview frames, numbered by their place in the book
+const previewFrameName = "preview-%05d.png"
+
+// previewExt the extension of the file written for each preview format
+var previewExt = map[string]string{
+	PreviewGIF:  "gif",
+	PreviewAPNG: "png",
+	PreviewWebP: "webp",
+	PreviewMP4:  "mp4",
+}
+
+// previews returns the preview formats in the options, GIF adds a gif preview if it isn't
+// already listed
+func previews(opts Options) []string {
+	formats := opts.Previews
+	if opts.GIF {
+		for _, p := range formats {
+			if p == PreviewGIF {
+				return formats
+			}
+		}
+		formats = append([]string{PreviewGIF}, formats...)
+	}
+	return formats
+}
+
+// validatePreviews returns an error if the preview options are invalid
+func validatePreviews(opts Options) error {
+	seen := make(map[string]bool)
+	for _, p := range opts.Previews {
+		if _, ok := previewExt[p]; !ok {
+			return fmt.Errorf("invalid preview: %s, must be gif|apng|webp|mp4", p)
+		}
+		if seen[p] {
+			return fmt.Errorf("preview %s is listed more than once", p)
+		}
+		seen[p] = true
+	}
+	if opts.PreviewFPS < 0 || opts.PreviewFPS > 100 {
+		return fmt.Errorf("preview fps must be between 0 and 100, %g invalid value", opts.PreviewFPS)
+	}
+	if opts.PreviewWidth < 0 {
+		return fmt.Errorf("preview width must not be negative, %d invalid value", opts.PreviewWidth)
+	}
+	return nil
+}
+
+// previewFrames collects the finished frames of the book, after the effects, labels and
+// captions are drawn, to animate them once every sheet has been rendered. Frames are drawn
+// in sheet order rather than book order, so each is scaled down and saved to a temporary
+// directory under its place in the book
+type previewFrames struct {
+	dir   string
+	n     int
+	width int
+	size  image.Point
+
+	// info where each frame came from and where it is printed, by its place in the book
+	info []previewInfo
+}
+
+type previewInfo struct {
+	name  string
+	sheet int
+	stack int
+}
+
+func newPreviewFrames(nFrames int, opts Options) (*previewFrames, error) {
+	dir, err := ioutil.TempDir("", "flipbook-preview")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create preview dir: %s", err)
+	}
+	width := opts.PreviewWidth
+	if width == 0 {
+		width = DefaultPreviewWidth
+	}
+	return &previewFrames{dir: dir, n: nFrames, width: width, info: make([]previewInfo, nFrames)}, nil
+}
+
+// add saves the upright image of the frame under its place in the book. Every frame is scaled
+// to the size of the first one added, so they all line up in the animation
+func (p *previewFrames) add(f frame, img image.Image) error {
+	p.info[f.index] = previewInfo{name: f.info.Name(), sheet: f.sheet, stack: f.stack}
+	return p.save(f.index, img)
+}
+
+// save scales the image and saves it as the frame at position i
+func (p *previewFrames) save(i int, img image.Image) error {
+	if p.size == (image.Point{}) {
+		b := img.Bounds()
+		height := int(math.Max(1, math.Round(float64(p.width)*float64(b.Dy())/float64(b.Dx()))))
+		p.size = image.Pt(p.width, height)
+	}
+
+	dst := image.NewRGBA(image.Rectangle{Max: p.size})
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	name := path.Join(p.dir, fmt.Sprintf(previewFrameName, i))
+	err := imaging.Save(dst, name)
+	if err != nil {
+		return fmt.Errorf("failed to save preview frame: %s, %s", name, err)
+	}
+	return nil
+}
+
+// next returns a function that loads the frames in book order, returning nil after the last
+func (p *previewFrames) next() func() (image.Image, error) {
+	i := 0
+	return func() (image.Image, error) {
+		if i == p.n {
+			return nil, nil
+		}
+		name := path.Join(p.dir, fmt.Sprintf(previewFrameName, i))
+		i++
+		img, err := imaging.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load preview frame: %s, %s", name, err)
+		}
+		// Decoded PNGs are NRGBA, the encoders expect every frame to be the same opaque type
+		dst := image.NewRGBA(img.Bounds())
+		draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+		return dst, nil
+	}
+}
+
+func (p *previewFrames) close() {
+	os.RemoveAll(p.dir)
+}
+
+// frameDurations returns how long each of n frames is shown for at fps, in units of 1/scale
+// of a second. The times are rounded from the start of the animation rather than frame by
+// frame, so the rounding errors don't add up and the animation keeps to time
+func frameDurations(n int, fps float64, scale int) []int {
+	durations := make([]int, n)
+	prev := 0
+	for i := range durations {
+		end := int(math.Round(float64(i+1) * float64(scale) / fps))
+		durations[i] = end - prev
+		prev = end
+	}
+	return durations
+}
+
+// writePreviews writes each of the preview formats in the options to the output directory
+func writePreviews(frames *previewFrames, opts Options) error {
+	fps := opts.PreviewFPS
+	if fps == 0 {
+		fps = DefaultPreviewFPS
+	}
+
+	for _, format := range previews(opts) {
+		p := path.Join(opts.OutputDir, opts.Naming.PreviewName(opts.Identifier, previewExt[format]))
+		opts.VerLog.Println("writing:", p)
+
+		if format == PreviewMP4 {
+			err := ffmpeg.EncodeVideo(path.Join(frames.dir, previewFrameName), fps, p)
+			if err != nil {
+				return fmt.Errorf("failed to encode mp4 preview: %s, %s", p, err)
+			}
+			opts.VerLog.Println("written file:", p)
+			continue
+		}
+
+		var b bytes.Buffer
+		var err error
+		switch format {
+		case PreviewGIF:
+			err = encodeGIF(&b, frameDurations(frames.n, fps, 100), frames.next())
+		case PreviewAPNG:
+			num, den := apngDelay(fps)
+			err = encodeAPNG(&b, frames.n, num, den, frames.next())
+		case PreviewWebP:
+			err = encodeAnimatedWebP(&b, frames.size.X, frames.size.Y, frameDurations(frames.n, fps, 1000), frames.next())
+		}
+		if err != nil {
+			return fmt.Errorf("failed to encode %s preview: %s, %s", format, p, err)
+		}
+
+		err = ioutil.WriteFile(p, b.Bytes(), 0644)
+		if err != nil {
+			return fmt.Errorf("failed to save preview: %s, %s", p, err)
+		}
+		opts.VerLog.Println("written file:", p)
+	}
+	return nil
+}
+
+// encodeGIF writes an animated GIF that loops forever, durations are in 1/100 of a second.
+// Each frame gets its own palette, quantized by median cut, and is dithered to it so
+// gradients don't band
+func encodeGIF(w io.Writer, durations []int, next func() (image.Image, error)) error {
+	anim := &gif.GIF{}
+	for _, d := range durations {
+		img, err := next()
+		if err != nil {
+			return err
+		}
+		if img == nil {
+			return fmt.Errorf("expected %d gif frames, only found %d", len(durations), len(anim.Image))
+		}
+
+		palette := medianCut{n: 256}.Quantize(make(color.Palette, 0, 256), img)
+		pm := image.NewPaletted(img.Bounds(), palette)
+		draw.FloydSteinberg.Draw(pm, pm.Bounds(), img, img.Bounds().Min)
+
+		anim.Image = append(anim.Image, pm)
+		anim.Delay = append(anim.Delay, d)
+	}
+	return gif.EncodeAll(w, anim)
+}
@@ -0,0 +1,149 @@
+package composite
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"math"
+	"path"
+	"strconv"
+
+	"golang.org/x/image/draw"
+
+	"github.com/markdaws/go-flipbook/pkg/typeset"
+)
+
+// Default layout of the proof pages
+const (
+	DefaultProofColumns = 6
+	DefaultProofRows    = 8
+)
+
+// Sizes in pixels of the parts of a proof page
+const (
+	proofThumbWidth = 240
+	proofMargin     = 32
+	proofGap        = 16
+	proofTextHeight = 22
+	proofFontSize   = 14
+)
+
+// validateProof returns an error if the proof options are invalid
+func validateProof(opts Options) error {
+	if opts.ProofColumns < 0 {
+		return fmt.Errorf("proof columns must not be negative, %d invalid value", opts.ProofColumns)
+	}
+	if opts.ProofRows < 0 {
+		return fmt.Errorf("proof rows must not be negative, %d invalid value", opts.ProofRows)
+	}
+	return nil
+}
+
+// proofGrid returns the number of columns and rows of thumbnails on each proof page
+func proofGrid(opts Options) (int, int) {
+	cols := opts.ProofColumns
+	if cols == 0 {
+		cols = DefaultProofColumns
+	}
+	rows := opts.ProofRows
+	if rows == 0 {
+		rows = DefaultProofRows
+	}
+	return cols, rows
+}
+
+// proofPages returns the number of proof pages showing n frames
+func proofPages(opts Options, n int) int {
+	cols, rows := proofGrid(opts)
+	return (n + cols*rows - 1) / (cols * rows)
+}
+
+// writeProof writes contact sheets to the output directory showing every finished frame as
+// a thumbnail, in the order the book is flipped, so the sequence can be reviewed before it
+// is printed in the interlaced sheet order. Each thumbnail is captioned with the index and
+// timecode of the frame on the left, and on the right the stack and sheet it is printed on.
+// Returns the names of the pages written
+func writeProof(frames *previewFrames, opts Options, fonts typeset.Fonts) ([]string, error) {
+	cols, rows := proofGrid(opts)
+	perPage := cols * rows
+	nPages := proofPages(opts, frames.n)
+
+	thumbWidth := proofThumbWidth
+	if frames.size.X < thumbWidth {
+		thumbWidth = frames.size.X
+	}
+	thumbHeight := int(math.Max(1, math.Round(float64(thumbWidth)*float64(frames.size.Y)/float64(frames.size.X))))
+	cellWidth := thumbWidth
+	cellHeight := thumbHeight + proofTextHeight
+
+	var written []string
+	next := frames.next()
+	for pi := 0; pi < nPages; pi++ {
+		n := perPage
+		if pi == nPages-1 {
+			n = frames.n - pi*perPage
+		}
+		pageRows := (n + cols - 1) / cols
+
+		page := image.NewRGBA(image.Rect(0, 0,
+			2*proofMargin+cols*cellWidth+(cols-1)*proofGap,
+			2*proofMargin+pageRows*cellHeight+(pageRows-1)*proofGap))
+		draw.Draw(page, page.Bounds(), image.White, image.Point{}, draw.Src)
+
+		for i := 0; i < n; i++ {
+			pos := pi*perPage + i
+			img, err := next()
+			if err != nil {
+				return nil, err
+			}
+
+			left := proofMargin + i%cols*(cellWidth+proofGap)
+			top := proofMargin + i/cols*(cellHeight+proofGap)
+			thumb := image.Rect(left, top, left+thumbWidth, top+thumbHeight)
+			draw.BiLinear.Scale(page, thumb, img, img.Bounds(), draw.Src, nil)
+
+			info := frames.info[pos]
+			text := strconv.Itoa(pos)
+			if seconds, ok := opts.FrameTimes[info.name]; ok {
+				text += "  " + formatTimecode(seconds)
+			}
+			caption := image.Rect(left, thumb.Max.Y, left+cellWidth, thumb.Max.Y+proofTextHeight)
+			err = drawProofText(page, caption, text, typeset.AlignLeft, fonts)
+			if err != nil {
+				return nil, err
+			}
+			err = drawProofText(page, caption, stackName(info.stack)+strconv.Itoa(info.sheet), typeset.AlignRight, fonts)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		name := opts.Naming.ProofName(opts.Identifier, pi, nPages)
+		p := path.Join(opts.OutputDir, name)
+		opts.VerLog.Println("writing:", p)
+		var b bytes.Buffer
+		err := jpeg.Encode(&b, page, &jpeg.Options{Quality: 90})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode proof: %s, %s", p, err)
+		}
+		err = ioutil.WriteFile(p, b.Bytes(), 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save proof: %s, %s", p, err)
+		}
+		opts.VerLog.Println("written file:", p)
+		written = append(written, name)
+	}
+	return written, nil
+}
+
+func drawProofText(img *image.RGBA, box image.Rectangle, text string, align typeset.Align, fonts typeset.Fonts) error {
+	t, err := typeset.Fit(text, fonts, proofFontSize, proofFontSize/2, box.Size())
+	if err != nil {
+		return err
+	}
+	t.Draw(img, box, align, typeset.VAlignMiddle, image.NewUniform(color.Gray{Y: 0x40}))
+	return nil
+}
@@ -0,0 +1,124 @@
+package composite
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// maxQuantizeSamples the most pixels sampled to build a palette, larger images are sampled
+// on a grid, which is plenty to find the main colours and keeps quantizing fast
+const maxQuantizeSamples = 1 << 16
+
+// medianCut is a draw.Quantizer that builds a palette by median cut. The colours of the image
+// start in one box, then the box with the widest range in any channel is split at the median
+// of that channel until there are enough boxes. Each box gives the average of its colours
+type medianCut struct {
+	// n the number of colours in the palette, at most 256
+	n int
+}
+
+type colorBox []color.RGBA
+
+// Quantize implements draw.Quantizer, colours are appended to p until it has n colours
+func (q medianCut) Quantize(p color.Palette, m image.Image) color.Palette {
+	n := q.n - len(p)
+	if n <= 0 {
+		return p
+	}
+
+	b := m.Bounds()
+	step := 1
+	for b.Dx()/step*(b.Dy()/step) > maxQuantizeSamples {
+		step++
+	}
+
+	var all colorBox
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			r, g, bl, _ := m.At(x, y).RGBA()
+			all = append(all, color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(bl >> 8), A: 0xff})
+		}
+	}
+	if len(all) == 0 {
+		return p
+	}
+
+	boxes := []colorBox{all}
+	for len(boxes) < n {
+		// Split the box with the widest channel, boxes of a single colour can't be split
+		best, bestRange, bestChannel := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			channel, r := box.widest()
+			if r > bestRange {
+				best, bestRange, bestChannel = i, r, channel
+			}
+		}
+		if best == -1 {
+			break
+		}
+
+		box := boxes[best]
+		sort.Slice(box, func(i, j int) bool {
+			return channelOf(box[i], bestChannel) < channelOf(box[j], bestChannel)
+		})
+		mid := len(box) / 2
+		boxes[best] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+
+	for _, box := range boxes {
+		p = append(p, box.average())
+	}
+	return p
+}
+
+// widest returns the channel with the widest range of values in the box, and the range
+func (c colorBox) widest() (int, int) {
+	var lo, hi [3]uint8
+	lo = [3]uint8{255, 255, 255}
+	for _, col := range c {
+		for ch := 0; ch < 3; ch++ {
+			v := channelOf(col, ch)
+			if v < lo[ch] {
+				lo[ch] = v
+			}
+			if v > hi[ch] {
+				hi[ch] = v
+			}
+		}
+	}
+
+	channel, r := 0, 0
+	for ch := 0; ch < 3; ch++ {
+		if int(hi[ch])-int(lo[ch]) > r {
+			channel, r = ch, int(hi[ch])-int(lo[ch])
+		}
+	}
+	return channel, r
+}
+
+func (c colorBox) average() color.RGBA {
+	var r, g, b int
+	for _, col := range c {
+		r += int(col.R)
+		g += int(col.G)
+		b += int(col.B)
+	}
+	n := len(c)
+	return color.RGBA{R: uint8((r + n/2) / n), G: uint8((g + n/2) / n), B: uint8((b + n/2) / n), A: 0xff}
+}
+
+func channelOf(c color.RGBA, channel int) uint8 {
+	switch channel {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	default:
+		return c.B
+	}
+}
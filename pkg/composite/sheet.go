@@ -0,0 +1,329 @@
+package composite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"path"
+
+	"github.com/markdaws/go-flipbook/pkg/icc"
+)
+
+// SheetWriter encodes a composite sheet to a file format. Writers should record the dpi of
+// the page so printers print the sheet at its physical size instead of guessing from the
+// pixel count
+type SheetWriter interface {
+	// Ext the file extension of the format, without the leading dot
+	Ext() string
+
+	// SupportsCMYK true if the format can store CMYK images
+	SupportsCMYK() bool
+
+	// Write encodes the image, profile if not nil is an ICC profile to embed
+	Write(w io.Writer, img image.Image, page Page, profile []byte) error
+}
+
+// JPEGWriter writes baseline JPEG sheets
+type JPEGWriter struct {
+	// Quality 1-100, higher is better quality and a larger file, defaults to 90
+	Quality int
+
+	// Subsampling the chroma subsampling 444|422|420, defaults to 420. 444 keeps the edges of
+	// saturated colours sharp at the cost of larger files
+	Subsampling string
+}
+
+// Ext implements SheetWriter
+func (j JPEGWriter) Ext() string {
+	return "jpg"
+}
+
+// SupportsCMYK implements SheetWriter
+func (j JPEGWriter) SupportsCMYK() bool {
+	return false
+}
+
+// Write implements SheetWriter
+func (j JPEGWriter) Write(w io.Writer, img image.Image, page Page, profile []byte) error {
+	quality := j.Quality
+	if quality == 0 {
+		quality = 90
+	}
+
+	var b bytes.Buffer
+	err := encodeJPEG(&b, img, quality, j.Subsampling, page.DPI)
+	if err != nil {
+		return err
+	}
+
+	data := b.Bytes()
+	if profile != nil {
+		data, err = icc.EmbedInJPEG(data, profile)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// PNGWriter writes lossless PNG sheets
+type PNGWriter struct {
+	// BitDepth bits per channel, 8|16, defaults to 8
+	BitDepth int
+}
+
+// Ext implements SheetWriter
+func (p PNGWriter) Ext() string {
+	return "png"
+}
+
+// SupportsCMYK implements SheetWriter
+func (p PNGWriter) SupportsCMYK() bool {
+	return false
+}
+
+// Write implements SheetWriter
+func (p PNGWriter) Write(w io.Writer, img image.Image, page Page, profile []byte) error {
+	if p.BitDepth == 16 {
+		img = toRGBA64(img)
+	}
+
+	var b bytes.Buffer
+	err := png.Encode(&b, img)
+	if err != nil {
+		return err
+	}
+
+	data := b.Bytes()
+	if page.DPI > 0 {
+		// pHYs stores pixels per metre, unit 1 is metres
+		var phys bytes.Buffer
+		ppm := uint32(float64(page.DPI)/0.0254 + 0.5)
+		binary.Write(&phys, binary.BigEndian, []uint32{ppm, ppm})
+		phys.WriteByte(1)
+		data, err = icc.InsertPNGChunk(data, "pHYs", phys.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	if profile != nil {
+		data, err = icc.EmbedInPNG(data, profile, "ICC profile")
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// TIFFWriter writes uncompressed TIFF sheets
+type TIFFWriter struct {
+	// BitDepth bits per channel, 8|16, defaults to 8
+	BitDepth int
+}
+
+// Ext implements SheetWriter
+func (t TIFFWriter) Ext() string {
+	return "tiff"
+}
+
+// SupportsCMYK implements SheetWriter
+func (t TIFFWriter) SupportsCMYK() bool {
+	return true
+}
+
+// Write implements SheetWriter
+func (t TIFFWriter) Write(w io.Writer, img image.Image, page Page, profile []byte) error {
+	return encodeTIFF(w, img, page.DPI, t.BitDepth, profile)
+}
+
+// WebPWriter writes lossless WebP sheets
+type WebPWriter struct{}
+
+// Ext implements SheetWriter
+func (wp WebPWriter) Ext() string {
+	return "webp"
+}
+
+// SupportsCMYK implements SheetWriter
+func (wp WebPWriter) SupportsCMYK() bool {
+	return false
+}
+
+// Write implements SheetWriter
+func (wp WebPWriter) Write(w io.Writer, img image.Image, page Page, profile []byte) error {
+	return encodeWebP(w, img, page.DPI, profile)
+}
+
+// PDFWriter writes single page PDF sheets sized to the page
+type PDFWriter struct{}
+
+// Ext implements SheetWriter
+func (p PDFWriter) Ext() string {
+	return "pdf"
+}
+
+// SupportsCMYK implements SheetWriter
+func (p PDFWriter) SupportsCMYK() bool {
+	return true
+}
+
+// Write implements SheetWriter
+func (p PDFWriter) Write(w io.Writer, img image.Image, page Page, profile []byte) error {
+	return encodePDF(w, img, page, profile)
+}
+
+// NewSheetWriter returns a writer for the format, jpg|png|tiff|webp|pdf. quality and
+// subsampling only apply to jpg and bitDepth only to png and tiff, zero values use the
+// defaults
+func NewSheetWriter(format string, quality int, subsampling string, bitDepth int) (SheetWriter, error) {
+	switch bitDepth {
+	case 0, 8, 16:
+	default:
+		return nil, fmt.Errorf("invalid bit depth: %d, must be 8|16", bitDepth)
+	}
+	if bitDepth == 16 && format != "png" && format != "tiff" {
+		return nil, fmt.Errorf("a bit depth of 16 is only supported for png and tiff sheets")
+	}
+
+	switch format {
+	case "", "jpg":
+		if quality == 0 {
+			quality = 90
+		}
+		if quality < 1 || quality > 100 {
+			return nil, fmt.Errorf("jpeg quality must be between 1 and 100, %d invalid value", quality)
+		}
+		switch subsampling {
+		case "", Subsample444, Subsample422, Subsample420:
+		default:
+			return nil, fmt.Errorf("invalid chroma subsampling: %s, must be 444|422|420", subsampling)
+		}
+		return JPEGWriter{Quality: quality, Subsampling: subsampling}, nil
+	case "png":
+		return PNGWriter{BitDepth: bitDepth}, nil
+	case "tiff":
+		return TIFFWriter{BitDepth: bitDepth}, nil
+	case "webp":
+		return WebPWriter{}, nil
+	case "pdf":
+		return PDFWriter{}, nil
+	default:
+		return nil, fmt.Errorf("invalid sheet format: %s, must be jpg|png|tiff|webp|pdf", format)
+	}
+}
+
+// sheetWriter returns the writer from the options, defaulting to JPEG
+func sheetWriter(opts Options) SheetWriter {
+	if opts.SheetWriter == nil {
+		return JPEGWriter{Quality: 90}
+	}
+	return opts.SheetWriter
+}
+
+// validateSheetOptions returns an error if the sheet format and colour options can't be combined
+func validateSheetOptions(opts Options) error {
+	switch opts.ColorProfile {
+	case "", icc.SRGB, icc.AdobeRGB:
+	default:
+		return fmt.Errorf("invalid color profile: %s, must be srgb|adobergb", opts.ColorProfile)
+	}
+
+	if opts.CMYK {
+		if !sheetWriter(opts).SupportsCMYK() {
+			return fmt.Errorf("CMYK output is not supported for %s sheets", sheetWriter(opts).Ext())
+		}
+		if opts.ColorProfile != "" {
+			return fmt.Errorf("a color profile can't be used with CMYK output, use an output profile")
+		}
+	} else if opts.OutputProfile != nil {
+		return fmt.Errorf("an output profile can only be used with CMYK output")
+	}
+	return nil
+}
+
+// writeSheet encodes the composite image with the sheet writer from the options and writes
+// it to the output directory, named using the sheet template
+func writeSheet(compImg *image.RGBA, opts Options, imgIndex, nSheets int) error {
+	writer := sheetWriter(opts)
+	toImgPath := path.Join(opts.OutputDir, opts.Naming.SheetName(opts.Identifier, imgIndex, nSheets, writer.Ext()))
+	opts.VerLog.Println("writing:", toImgPath)
+
+	var b bytes.Buffer
+	err := writer.Write(&b, printImage(compImg, opts), opts.Page, sheetProfile(opts))
+	if err != nil {
+		return fmt.Errorf("failed to encode img: %s, %s", toImgPath, err)
+	}
+
+	err = ioutil.WriteFile(toImgPath, b.Bytes(), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to save img: %s, %s", toImgPath, err)
+	}
+
+	opts.VerLog.Println("written file:", toImgPath)
+	return nil
+}
+
+// writeDocument writes a multi page PDF to the output directory, named using the document
+// template
+func writeDocument(doc *pdfDocument, opts Options) error {
+	p := path.Join(opts.OutputDir, opts.Naming.DocumentName(opts.Identifier, "pdf"))
+	opts.VerLog.Println("writing:", p)
+
+	var b bytes.Buffer
+	err := doc.write(&b)
+	if err != nil {
+		return fmt.Errorf("failed to encode pdf: %s, %s", p, err)
+	}
+
+	err = ioutil.WriteFile(p, b.Bytes(), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to save pdf: %s, %s", p, err)
+	}
+
+	opts.VerLog.Println("written file:", p)
+	return nil
+}
+
+// printImage converts the composite image to the colour space from the options
+func printImage(compImg *image.RGBA, opts Options) image.Image {
+	if opts.CMYK {
+		if opts.OutputProfile != nil {
+			return opts.OutputProfile.ToCMYK(compImg)
+		}
+		opts.VerLog.Println("no output profile, CMYK colours will not be accurate")
+		return icc.NaiveCMYK(compImg)
+	}
+	if opts.ColorProfile == icc.AdobeRGB {
+		return icc.ToAdobeRGB(compImg)
+	}
+	return compImg
+}
+
+// sheetProfile returns the ICC profile to embed in the sheets, or nil if there is none
+func sheetProfile(opts Options) []byte {
+	if opts.CMYK {
+		if opts.OutputProfile != nil {
+			return opts.OutputProfile.Data
+		}
+		return nil
+	}
+	if opts.ColorProfile != "" {
+		profile, _ := icc.RGBProfile(opts.ColorProfile)
+		return profile
+	}
+	return nil
+}
+
+// toRGBA64 widens an image to 16 bits per channel
+func toRGBA64(img image.Image) *image.RGBA64 {
+	dst := image.NewRGBA64(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,140 @@
+package composite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// pngChunkTypes returns the types of the chunks of a PNG in order, runs of IDAT chunks are
+// listed once since image/png may split the data, and the data of the chunks by type
+func pngChunkTypes(data []byte) ([]string, map[string][][]byte) {
+	var types []string
+	chunks := make(map[string][][]byte)
+	for pos := 8; pos+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		if typ != "IDAT" || types[len(types)-1] != "IDAT" {
+			types = append(types, typ)
+		}
+		chunks[typ] = append(chunks[typ], data[pos+8:pos+8+size])
+		pos += 12 + size
+	}
+	return types, chunks
+}
+
+func TestPNGWriter(t *testing.T) {
+	img := testSheet(37, 23)
+	profile := []byte("an icc profile")
+
+	tests := []struct {
+		name     string
+		bitDepth int
+		dpi      int
+		profile  []byte
+		ppm      uint32
+		order    []string
+	}{
+		{"no dpi", 8, 0, nil, 0, []string{"IHDR", "IDAT", "IEND"}},
+		{"300 dpi", 8, 300, nil, 11811, []string{"IHDR", "pHYs", "IDAT", "IEND"}},
+		{"72 dpi", 8, 72, nil, 2835, []string{"IHDR", "pHYs", "IDAT", "IEND"}},
+		{"profile", 16, 600, profile, 23622, []string{"IHDR", "iCCP", "pHYs", "IDAT", "IEND"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			err := PNGWriter{BitDepth: tt.bitDepth}.Write(&b, img, Page{DPI: tt.dpi}, tt.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			types, chunks := pngChunkTypes(b.Bytes())
+			if len(types) != len(tt.order) {
+				t.Fatalf("got chunks %v, want %v", types, tt.order)
+			}
+			for i := range types {
+				if types[i] != tt.order[i] {
+					t.Fatalf("got chunks %v, want %v", types, tt.order)
+				}
+			}
+			if depth := chunks["IHDR"][0][8]; int(depth) != tt.bitDepth {
+				t.Fatalf("got bit depth %d, want %d", depth, tt.bitDepth)
+			}
+
+			if len(chunks["pHYs"]) > 0 {
+				phys := chunks["pHYs"][0]
+				x, y := binary.BigEndian.Uint32(phys), binary.BigEndian.Uint32(phys[4:])
+				// Unit 1 is metres
+				if len(phys) != 9 || x != tt.ppm || y != tt.ppm || phys[8] != 1 {
+					t.Fatalf("got pHYs %dx%d unit %d, want %d pixels per metre", x, y, phys[8], tt.ppm)
+				}
+			}
+
+			// Decoding checks the CRC of every chunk
+			out, err := png.Decode(&b)
+			if err != nil {
+				t.Fatalf("failed to decode: %s", err)
+			}
+			if p, ok := sameImage(img, out); !ok {
+				t.Fatalf("pixel %v differs", p)
+			}
+		})
+	}
+}
+
+func TestNewSheetWriter(t *testing.T) {
+	tests := []struct {
+		format      string
+		quality     int
+		subsampling string
+		bitDepth    int
+		want        SheetWriter
+		err         string
+	}{
+		{"", 0, "", 0, JPEGWriter{Quality: 90}, ""},
+		{"jpg", 75, Subsample444, 8, JPEGWriter{Quality: 75, Subsampling: Subsample444}, ""},
+		{"png", 0, "", 16, PNGWriter{BitDepth: 16}, ""},
+		{"tiff", 0, "", 0, TIFFWriter{}, ""},
+		{"webp", 0, "", 0, WebPWriter{}, ""},
+		{"pdf", 0, "", 8, PDFWriter{}, ""},
+		{"jpg", 101, "", 0, nil, "jpeg quality must be between 1 and 100, 101 invalid value"},
+		{"jpg", 0, "411", 0, nil, "invalid chroma subsampling: 411, must be 444|422|420"},
+		{"jpg", 0, "", 16, nil, "a bit depth of 16 is only supported for png and tiff sheets"},
+		{"png", 0, "", 12, nil, "invalid bit depth: 12, must be 8|16"},
+		{"gif", 0, "", 0, nil, "invalid sheet format: gif, must be jpg|png|tiff|webp|pdf"},
+	}
+	for _, tt := range tests {
+		w, err := NewSheetWriter(tt.format, tt.quality, tt.subsampling, tt.bitDepth)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: got error %v, want %q", tt.format, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || w != tt.want {
+			t.Errorf("%q: got %#v, %v, want %#v", tt.format, w, err, tt.want)
+		}
+	}
+}
+
+func TestJPEGWriter(t *testing.T) {
+	// The profile goes after the JFIF segment, which keeps the density
+	var b bytes.Buffer
+	err := JPEGWriter{}.Write(&b, testSheet(16, 16), Page{DPI: 300}, []byte("an icc profile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := b.Bytes()
+	if string(data[6:11]) != "JFIF\x00" || binary.BigEndian.Uint16(data[14:]) != 300 {
+		t.Fatalf("no JFIF segment with the density first, got % x", data[:20])
+	}
+	app2 := 4 + int(binary.BigEndian.Uint16(data[4:]))
+	if data[app2+1] != 0xE2 || string(data[app2+4:app2+16]) != "ICC_PROFILE\x00" {
+		t.Fatalf("no profile after the JFIF segment")
+	}
+	if _, err := jpeg.Decode(&b); err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+}
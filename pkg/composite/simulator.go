@@ -0,0 +1,262 @@
+package composite
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image/jpeg"
+	"io/ioutil"
+	"path"
+)
+
+// simulatorData the values the simulator page is built from
+type simulatorData struct {
+	Title  string
+	Width  int
+	Height int
+	FPS    float64
+
+	// Pages the frames as data URLs, in the order they are bound from the front of the book
+	Pages []string
+
+	// Reverse if true the book is flipped from the back to the front
+	Reverse bool
+
+	// Top if true the book is bound along the top, so pages turn up rather than to the side
+	Top bool
+}
+
+// writeSimulator writes a self contained HTML page to the output directory that flips through
+// the finished frames like the printed book, to get a feel for it before printing. The frames
+// are embedded in the page, so it can be opened offline or sent on as a single file
+func writeSimulator(frames *previewFrames, opts Options) error {
+	p := path.Join(opts.OutputDir, opts.Naming.SimulatorName(opts.Identifier))
+	opts.VerLog.Println("writing:", p)
+
+	fps := opts.PreviewFPS
+	if fps == 0 {
+		fps = DefaultPreviewFPS
+	}
+	title := opts.Identifier
+	if title == "" {
+		title = "flipbook"
+	}
+	data := simulatorData{
+		Title:   title,
+		Width:   frames.size.X,
+		Height:  frames.size.Y,
+		FPS:     fps,
+		Reverse: opts.ReverseFrames,
+		Top:     opts.Orientation == OrientationTop,
+	}
+
+	// The frames are in the order the scene plays, when the book is flipped from the back that
+	// is the reverse of the order they are bound in
+	next := frames.next()
+	data.Pages = make([]string, frames.n)
+	for i := 0; i < frames.n; i++ {
+		img, err := next()
+		if err != nil {
+			return err
+		}
+		var b bytes.Buffer
+		err = jpeg.Encode(&b, img, &jpeg.Options{Quality: 85})
+		if err != nil {
+			return fmt.Errorf("failed to encode simulator frame: %s", err)
+		}
+		page := i
+		if opts.ReverseFrames {
+			page = frames.n - 1 - i
+		}
+		data.Pages[page] = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(b.Bytes())
+	}
+
+	var b bytes.Buffer
+	err := simulatorTemplate.Execute(&b, data)
+	if err != nil {
+		return fmt.Errorf("failed to build simulator: %s, %s", p, err)
+	}
+
+	err = ioutil.WriteFile(p, b.Bytes(), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to save simulator: %s, %s", p, err)
+	}
+	opts.VerLog.Println("written file:", p)
+	return nil
+}
+
+// simulatorTemplate the simulator page. The page on top of the book is turned away about its
+// binding edge to show the page under it, flipping back turns the previous page in over it
+var simulatorTemplate = template.Must(template.New("simulator").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { margin: 0; padding: 24px; background: #2b2b2b; color: #eee; font: 14px sans-serif; }
+#book { position: relative; margin: 0 auto 24px; perspective: 2000px; max-width: 100%; }
+#book img { position: absolute; top: 0; left: 0; width: 100%; height: 100%; backface-visibility: hidden; box-shadow: 0 2px 12px rgba(0, 0, 0, 0.6); }
+#controls { display: flex; flex-wrap: wrap; gap: 16px; align-items: center; justify-content: center; }
+#controls label { display: flex; gap: 8px; align-items: center; }
+button { min-width: 40px; }
+</style>
+</head>
+<body>
+<div id="book"><img id="under" alt=""><img id="over" alt=""></div>
+<div id="controls">
+<button id="prev" title="Turn back a page">&#9664;</button>
+<button id="play">Play</button>
+<button id="next" title="Turn forward a page">&#9654;</button>
+<label>Direction <select id="direction"><option value="1">Front to back</option><option value="-1">Back to front</option></select></label>
+<label>Speed <input id="speed" type="range" min="1" max="60" step="1"> <span id="speedText"></span></label>
+<label><input id="loop" type="checkbox" checked> Loop</label>
+<label>Page <input id="position" type="range" min="0" step="1"> <span id="positionText"></span></label>
+</div>
+<script>
+(function() {
+	var pages = {{.Pages}};
+	var width = {{.Width}}, height = {{.Height}};
+	var topBound = {{.Top}};
+	var reverse = {{.Reverse}};
+
+	var book = document.getElementById("book");
+	var under = document.getElementById("under");
+	var over = document.getElementById("over");
+	var play = document.getElementById("play");
+	var direction = document.getElementById("direction");
+	var speed = document.getElementById("speed");
+	var speedText = document.getElementById("speedText");
+	var loop = document.getElementById("loop");
+	var position = document.getElementById("position");
+	var positionText = document.getElementById("positionText");
+
+	// The page turns about its binding edge, away from the reader
+	over.style.transformOrigin = topBound ? "center top" : "left center";
+	var turned = topBound ? "rotateX(90deg)" : "rotateY(-90deg)";
+
+	book.style.width = width + "px";
+	book.style.aspectRatio = width + " / " + height;
+	position.max = pages.length - 1;
+	speed.value = Math.min(60, Math.max(1, Math.round({{.FPS}})));
+	direction.value = reverse ? "-1" : "1";
+
+	// pos is the page on top of the book, the one being looked at
+	var pos = reverse ? pages.length - 1 : 0;
+	var timer = null;
+	var busy = false;
+
+	function show() {
+		over.style.transition = "none";
+		over.style.transform = "none";
+		over.src = pages[pos];
+		under.src = pages[pos];
+		position.value = pos;
+		positionText.textContent = (pos + 1) + " of " + pages.length;
+	}
+
+	function turnTime() {
+		return Math.min(400, 700 / speed.value);
+	}
+
+	// turn moves one page in direction d, 1 turns the top page away, -1 turns the previous
+	// page back on top. done is called once the page has turned
+	function turn(d, done) {
+		var to = pos + d;
+		if (busy || to < 0 || to >= pages.length) {
+			done(false);
+			return;
+		}
+		busy = true;
+		var ms = turnTime();
+		if (d > 0) {
+			under.src = pages[to];
+			over.src = pages[pos];
+			over.style.transition = "none";
+			over.style.transform = "none";
+		} else {
+			under.src = pages[pos];
+			over.src = pages[to];
+			over.style.transition = "none";
+			over.style.transform = turned;
+		}
+		// Force the starting transform to apply before the transition starts
+		over.getBoundingClientRect();
+		over.style.transition = "transform " + ms + "ms ease-in";
+		over.style.transform = d > 0 ? turned : "none";
+		setTimeout(function() {
+			pos = to;
+			busy = false;
+			show();
+			done(true);
+		}, ms);
+	}
+
+	function tick() {
+		var d = parseInt(direction.value, 10);
+		turn(d, function(ok) {
+			if (timer === null) {
+				return;
+			}
+			if (!ok) {
+				if (!loop.checked) {
+					stop();
+					return;
+				}
+				pos = d > 0 ? 0 : pages.length - 1;
+				show();
+			}
+			timer = setTimeout(tick, Math.max(0, 1000 / speed.value - turnTime()));
+		});
+	}
+
+	function start() {
+		play.textContent = "Pause";
+		timer = setTimeout(tick, 0);
+	}
+
+	function stop() {
+		play.textContent = "Play";
+		clearTimeout(timer);
+		timer = null;
+	}
+
+	function updateSpeed() {
+		speedText.textContent = speed.value + " pages/s";
+	}
+
+	play.onclick = function() {
+		if (timer === null) {
+			start();
+		} else {
+			stop();
+		}
+	};
+	document.getElementById("prev").onclick = function() { stop(); turn(-1, function() {}); };
+	document.getElementById("next").onclick = function() { stop(); turn(1, function() {}); };
+	speed.oninput = updateSpeed;
+	position.oninput = function() {
+		stop();
+		pos = parseInt(position.value, 10);
+		show();
+	};
+	document.onkeydown = function(e) {
+		if (e.key === "ArrowRight" || e.key === "ArrowDown") {
+			stop();
+			turn(1, function() {});
+		} else if (e.key === "ArrowLeft" || e.key === "ArrowUp") {
+			stop();
+			turn(-1, function() {});
+		} else if (e.key === " ") {
+			e.preventDefault();
+			play.onclick();
+		}
+	};
+
+	updateSpeed();
+	show();
+})();
+</script>
+</body>
+</html>
+`))
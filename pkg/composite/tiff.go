@@ -0,0 +1,196 @@
+package composite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+)
+
+// TIFF tag ids and types used by encodeTIFF
+const (
+	tiffShort     = 3
+	tiffLong      = 4
+	tiffRational  = 5
+	tiffUndefined = 7
+
+	tagImageWidth       = 256
+	tagImageLength      = 257
+	tagBitsPerSample    = 258
+	tagCompression      = 259
+	tagPhotometric      = 262
+	tagStripOffsets     = 273
+	tagSamplesPerPixel  = 277
+	tagRowsPerStrip     = 278
+	tagStripByteCounts  = 279
+	tagXResolution      = 282
+	tagYResolution      = 283
+	tagPlanarConfig     = 284
+	tagResolutionUnit   = 296
+	tagInkSet           = 332
+	tagICCProfile       = 34675
+	photometricRGB      = 2
+	photometricSeparate = 5
+)
+
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+// encodeTIFF writes an uncompressed, single strip, baseline TIFF. Unlike image/tiff this
+// supports CMYK images, resolution tags and an embedded ICC profile, which print
+// workflows rely on. bitDepth is 8 or 16 bits per sample, zero is treated as 8
+func encodeTIFF(w io.Writer, img image.Image, dpi, bitDepth int, profile []byte) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	if bitDepth == 0 {
+		bitDepth = 8
+	}
+	if bitDepth != 8 && bitDepth != 16 {
+		return fmt.Errorf("invalid bit depth: %d, must be 8|16", bitDepth)
+	}
+	bytesPerSample := bitDepth / 8
+
+	// put appends a 16 bit sample, or its high byte for 8 bit images
+	var pix []byte
+	put := func(v uint32) {
+		if bytesPerSample == 2 {
+			pix = append(pix, byte(v), byte(v>>8))
+		} else {
+			pix = append(pix, byte(v>>8))
+		}
+	}
+
+	var samples uint16
+	var photometric uint16
+	switch src := img.(type) {
+	case *image.CMYK:
+		samples = 4
+		photometric = photometricSeparate
+		pix = make([]byte, 0, width*height*4*bytesPerSample)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			i := src.PixOffset(b.Min.X, y)
+			for _, v := range src.Pix[i : i+width*4] {
+				put(uint32(v) * 0x101)
+			}
+		}
+	default:
+		samples = 3
+		photometric = photometricRGB
+		pix = make([]byte, 0, width*height*3*bytesPerSample)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, g, bl, _ := img.At(x, y).RGBA()
+				put(r)
+				put(g)
+				put(bl)
+			}
+		}
+	}
+
+	if dpi <= 0 {
+		return fmt.Errorf("dpi must be greater than zero")
+	}
+
+	short := func(v ...uint16) []byte {
+		var d bytes.Buffer
+		binary.Write(&d, binary.LittleEndian, v)
+		return d.Bytes()
+	}
+	long := func(v uint32) []byte {
+		var d bytes.Buffer
+		binary.Write(&d, binary.LittleEndian, v)
+		return d.Bytes()
+	}
+	rational := func(num, den uint32) []byte {
+		var d bytes.Buffer
+		binary.Write(&d, binary.LittleEndian, [2]uint32{num, den})
+		return d.Bytes()
+	}
+
+	bits := make([]uint16, samples)
+	for i := range bits {
+		bits[i] = uint16(bitDepth)
+	}
+
+	entries := []tiffEntry{
+		{tagImageWidth, tiffLong, 1, long(uint32(width))},
+		{tagImageLength, tiffLong, 1, long(uint32(height))},
+		{tagBitsPerSample, tiffShort, uint32(samples), short(bits...)},
+		{tagCompression, tiffShort, 1, short(1)},
+		{tagPhotometric, tiffShort, 1, short(photometric)},
+		// Patched once the position of the pixel data is known
+		{tagStripOffsets, tiffLong, 1, long(0)},
+		{tagSamplesPerPixel, tiffShort, 1, short(samples)},
+		{tagRowsPerStrip, tiffLong, 1, long(uint32(height))},
+		{tagStripByteCounts, tiffLong, 1, long(uint32(len(pix)))},
+		{tagXResolution, tiffRational, 1, rational(uint32(dpi), 1)},
+		{tagYResolution, tiffRational, 1, rational(uint32(dpi), 1)},
+		{tagPlanarConfig, tiffShort, 1, short(1)},
+		// 2 is inches
+		{tagResolutionUnit, tiffShort, 1, short(2)},
+	}
+	if samples == 4 {
+		// 1 is CMYK
+		entries = append(entries, tiffEntry{tagInkSet, tiffShort, 1, short(1)})
+	}
+	if profile != nil {
+		entries = append(entries, tiffEntry{tagICCProfile, tiffUndefined, uint32(len(profile)), profile})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	// Layout: header, IFD, values that don't fit in an entry, pixel data
+	const headerSize = 8
+	ifdSize := 2 + len(entries)*12 + 4
+	extraOffset := headerSize + ifdSize
+	var extra bytes.Buffer
+	offsets := make([]uint32, len(entries))
+	for i, e := range entries {
+		if len(e.data) > 4 {
+			offsets[i] = uint32(extraOffset + extra.Len())
+			extra.Write(e.data)
+			if extra.Len()%2 != 0 {
+				extra.WriteByte(0)
+			}
+		}
+	}
+	pixOffset := uint32(extraOffset + extra.Len())
+	for i := range entries {
+		if entries[i].tag == tagStripOffsets {
+			entries[i].data = long(pixOffset)
+		}
+	}
+
+	var out bytes.Buffer
+	out.WriteString("II")
+	binary.Write(&out, binary.LittleEndian, uint16(42))
+	binary.Write(&out, binary.LittleEndian, uint32(headerSize))
+	binary.Write(&out, binary.LittleEndian, uint16(len(entries)))
+	for i, e := range entries {
+		binary.Write(&out, binary.LittleEndian, e.tag)
+		binary.Write(&out, binary.LittleEndian, e.typ)
+		binary.Write(&out, binary.LittleEndian, e.count)
+		if len(e.data) > 4 {
+			binary.Write(&out, binary.LittleEndian, offsets[i])
+		} else {
+			var v [4]byte
+			copy(v[:], e.data)
+			out.Write(v[:])
+		}
+	}
+	// No more IFDs
+	binary.Write(&out, binary.LittleEndian, uint32(0))
+	out.Write(extra.Bytes())
+
+	if _, err := w.Write(out.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(pix)
+	return err
+}
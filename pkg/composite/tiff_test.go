@@ -0,0 +1,159 @@
+package composite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+// tiffTags returns the entries of the first IFD of a little endian TIFF by tag, each as its
+// type, count and the bytes of its value
+func tiffTags(t *testing.T, data []byte) map[uint16]tiffEntry {
+	if len(data) < 8 || string(data[:4]) != "II*\x00" {
+		t.Fatalf("not a little endian TIFF, got % x", data[:4])
+	}
+	size := map[uint16]int{tiffShort: 2, tiffLong: 4, tiffRational: 8, tiffUndefined: 1}
+
+	ifd := int(binary.LittleEndian.Uint32(data[4:]))
+	n := int(binary.LittleEndian.Uint16(data[ifd:]))
+	tags := make(map[uint16]tiffEntry)
+	for i := 0; i < n; i++ {
+		e := data[ifd+2+i*12:]
+		entry := tiffEntry{
+			tag:   binary.LittleEndian.Uint16(e),
+			typ:   binary.LittleEndian.Uint16(e[2:]),
+			count: binary.LittleEndian.Uint32(e[4:]),
+		}
+		length := size[entry.typ] * int(entry.count)
+		if length <= 4 {
+			entry.data = e[8 : 8+length]
+		} else {
+			offset := int(binary.LittleEndian.Uint32(e[8:]))
+			entry.data = data[offset : offset+length]
+		}
+		tags[entry.tag] = entry
+	}
+	return tags
+}
+
+// checkTIFFResolution checks the TIFF, or EXIF block, gives the resolution in dots per inch
+func checkTIFFResolution(t *testing.T, data []byte, dpi int) {
+	t.Helper()
+	tags := tiffTags(t, data)
+	for _, tag := range []uint16{tagXResolution, tagYResolution} {
+		e, ok := tags[tag]
+		if !ok || e.typ != tiffRational || e.count != 1 {
+			t.Fatalf("tag %d: missing or not a single rational", tag)
+		}
+		num, den := binary.LittleEndian.Uint32(e.data), binary.LittleEndian.Uint32(e.data[4:])
+		if den == 0 || float64(num)/float64(den) != float64(dpi) {
+			t.Fatalf("tag %d: got %d/%d, want %d", tag, num, den, dpi)
+		}
+	}
+	unit, ok := tags[tagResolutionUnit]
+	if !ok || binary.LittleEndian.Uint16(unit.data) != 2 {
+		t.Fatalf("resolution unit is not inches")
+	}
+}
+
+func TestEncodeTIFF(t *testing.T) {
+	rgb := testSheet(37, 23)
+	wide := image.NewRGBA64(image.Rect(0, 0, 9, 5))
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 9; x++ {
+			wide.SetRGBA64(x, y, color.RGBA64{uint16(x * 7000), uint16(y * 13000), 0x1234, 0xffff})
+		}
+	}
+
+	tests := []struct {
+		name     string
+		img      image.Image
+		bitDepth int
+	}{
+		{"8 bit", rgb, 8},
+		{"default depth", rgb, 0},
+		{"16 bit from 8", rgb, 16},
+		{"16 bit", wide, 16},
+		{"offset", rgb.SubImage(image.Rect(3, 4, 30, 20)), 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := encodeTIFF(&b, tt.img, 300, tt.bitDepth, nil); err != nil {
+				t.Fatal(err)
+			}
+			checkTIFFResolution(t, b.Bytes(), 300)
+
+			out, err := tiff.Decode(bytes.NewReader(b.Bytes()))
+			if err != nil {
+				t.Fatalf("failed to decode: %s", err)
+			}
+			if tt.bitDepth == 16 {
+				if _, ok := out.(*image.RGBA64); !ok {
+					t.Fatalf("got %T, want 16 bits per channel", out)
+				}
+				// 16 bit samples keep every bit
+				ib, ob := tt.img.Bounds(), out.Bounds()
+				for y := 0; y < ib.Dy(); y++ {
+					for x := 0; x < ib.Dx(); x++ {
+						r1, g1, b1, _ := tt.img.At(ib.Min.X+x, ib.Min.Y+y).RGBA()
+						r2, g2, b2, _ := out.At(ob.Min.X+x, ob.Min.Y+y).RGBA()
+						if r1 != r2 || g1 != g2 || b1 != b2 {
+							t.Fatalf("pixel %d,%d differs", x, y)
+						}
+					}
+				}
+			} else if p, ok := sameImage(tt.img, out); !ok {
+				t.Fatalf("pixel %v differs", p)
+			}
+		})
+	}
+}
+
+func TestEncodeTIFFCMYK(t *testing.T) {
+	img := image.NewCMYK(image.Rect(0, 0, 7, 3))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 11)
+	}
+	profile := []byte("a cmyk profile")
+
+	var b bytes.Buffer
+	if err := encodeTIFF(&b, img, 1200, 8, profile); err != nil {
+		t.Fatal(err)
+	}
+	checkTIFFResolution(t, b.Bytes(), 1200)
+
+	tags := tiffTags(t, b.Bytes())
+	if got := binary.LittleEndian.Uint16(tags[tagPhotometric].data); got != photometricSeparate {
+		t.Fatalf("got photometric %d, want separated", got)
+	}
+	if got := binary.LittleEndian.Uint16(tags[tagInkSet].data); got != 1 {
+		t.Fatalf("got ink set %d, want CMYK", got)
+	}
+	if got := tags[tagICCProfile].data; !bytes.Equal(got, profile) {
+		t.Fatalf("got profile %q", got)
+	}
+
+	// The samples are stored as given, in a single strip
+	offset := binary.LittleEndian.Uint32(tags[tagStripOffsets].data)
+	count := binary.LittleEndian.Uint32(tags[tagStripByteCounts].data)
+	if got := b.Bytes()[offset : offset+count]; !bytes.Equal(got, img.Pix) {
+		t.Fatalf("got samples % x, want % x", got, img.Pix)
+	}
+}
+
+func TestEncodeTIFFErrors(t *testing.T) {
+	var b bytes.Buffer
+	err := encodeTIFF(&b, testSheet(4, 4), 0, 8, nil)
+	if err == nil || err.Error() != "dpi must be greater than zero" {
+		t.Fatalf("got error %v", err)
+	}
+	err = encodeTIFF(&b, testSheet(4, 4), 300, 12, nil)
+	if err == nil || err.Error() != "invalid bit depth: 12, must be 8|16" {
+		t.Fatalf("got error %v", err)
+	}
+}
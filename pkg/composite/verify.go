@@ -0,0 +1,322 @@
+package composite
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"path"
+	"sort"
+
+	"github.com/disintegration/imaging"
+
+	// Registers the webp decoder so webp sheets can be reconstructed
+	_ "golang.org/x/image/webp"
+)
+
+// SheetInfo describes a side of a printed sheet and the frames printed on it
+type SheetInfo struct {
+	// Name the file name of the sheet, empty if the sheets were written to a single document
+	Name string `json:"name,omitempty"`
+
+	// Index the index of the sheet in its file name, the sides of duplex sheets are numbered
+	// one after the other
+	Index int `json:"index"`
+
+	// Sheet the index of the physical sheet of paper, the same for the front and back
+	Sheet int `json:"sheet"`
+
+	// Back true if this is the back of a duplex sheet
+	Back bool `json:"back,omitempty"`
+
+	// Cells the frames printed on the sheet
+	Cells []CellInfo `json:"cells"`
+}
+
+// CellInfo describes a frame printed on a sheet
+type CellInfo struct {
+	// Frame the position of the frame in the book, from 0 for the first frame flipped to
+	Frame int `json:"frame"`
+
+	// Source the file name of the image the frame was made from
+	Source string `json:"source"`
+
+	// Stack the stack the frame is in once the sheets are cut, 0 for a, 1 for b and so on
+	Stack int `json:"stack"`
+
+	// Left, Top, Width, Height the cell the frame is printed in, in pixels on the sheet
+	Left   int `json:"left"`
+	Top    int `json:"top"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	// Turn the angle in degrees the frame is turned anticlockwise in its cell
+	Turn int `json:"turn,omitempty"`
+
+	// Time the time of the frame in seconds from the start of the video, nil for designed
+	// pages and frames that weren't extracted from a video
+	Time *float64 `json:"time,omitempty"`
+}
+
+func (c CellInfo) bounds() image.Rectangle {
+	return image.Rect(c.Left, c.Top, c.Left+c.Width, c.Top+c.Height)
+}
+
+// bookPage a page of the assembled book, the sheet and cell it was cut from
+type bookPage struct {
+	sheet SheetInfo
+	cell  CellInfo
+}
+
+// Verify simulates assembling the printed book and returns an error if the frames don't end
+// up in order. The sheets are stacked the way they come off the printer, last printed on top
+// with ReversePages, otherwise first printed on top. The stack is cut into the cells, every
+// cell must be in the same place on each sheet. The stacks are taken from where the cells are
+// on the sheet, not from their labels, reading down each column from the left, and put
+// together a on top, b below it and so on. Every frame must be labelled with the stack it is
+// cut into, since the labels are how the stacks are told apart. Flipping through the assembled
+// book, and for duplex books turning it over and flipping through the backs, must show the
+// frames 0 to n-1, or n-1 to 0 when the frames are reversed
+func Verify(info RenderInfo) error {
+	_, err := assemble(info)
+	return err
+}
+
+// assemble returns the pages of the book in the order they are flipped through
+func assemble(info RenderInfo) ([]bookPage, error) {
+	if len(info.Sheets) == 0 {
+		return nil, fmt.Errorf("no sheets to verify")
+	}
+
+	// The sheets of paper in the printed pile, top first
+	fronts := make(map[int]SheetInfo)
+	backs := make(map[int]SheetInfo)
+	var order []int
+	for _, s := range info.Sheets {
+		sides := fronts
+		if s.Back {
+			sides = backs
+		} else {
+			order = append(order, s.Sheet)
+		}
+		if _, ok := sides[s.Sheet]; ok {
+			return nil, fmt.Errorf("sheet %d is printed on the %s of a sheet that already has one", s.Index, sideName(s.Back))
+		}
+		sides[s.Sheet] = s
+	}
+	sort.Ints(order)
+	if info.ReversePages {
+		for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+			order[i], order[j] = order[j], order[i]
+		}
+	}
+	duplex := info.Duplex != ""
+	if duplex && len(backs) != len(fronts) {
+		return nil, fmt.Errorf("duplex book has %d fronts but %d backs", len(fronts), len(backs))
+	}
+	if !duplex && len(backs) > 0 {
+		return nil, fmt.Errorf("book is not duplex but has %d backs", len(backs))
+	}
+
+	// Cutting the pile gives a pile of leaves for each cell, the cells of the first sheet set
+	// where the cuts are made
+	first := fronts[order[0]]
+	piles := make(map[image.Rectangle][]bookPage)
+	pileStack, err := cutStacks(first)
+	if err != nil {
+		return nil, err
+	}
+	var pileBacks map[image.Rectangle][]bookPage
+	if duplex {
+		pileBacks = make(map[image.Rectangle][]bookPage)
+	}
+	for _, n := range order {
+		front := fronts[n]
+		if len(front.Cells) != len(first.Cells) {
+			return nil, fmt.Errorf("sheet %d has %d frames, sheet %d has %d", front.Index, len(front.Cells), first.Index, len(first.Cells))
+		}
+
+		var behind map[image.Rectangle]CellInfo
+		if duplex {
+			back, ok := backs[n]
+			if !ok {
+				return nil, fmt.Errorf("sheet %d has no back", front.Index)
+			}
+			// Turning the sheet over moves each back cell behind a front cell
+			behind = make(map[image.Rectangle]CellInfo)
+			for _, c := range back.Cells {
+				behind[turnOver(c.bounds(), info.Duplex, info.SheetWidth, info.SheetHeight)] = c
+			}
+			if len(behind) != len(back.Cells) {
+				return nil, fmt.Errorf("sheet %d has frames printed over each other", back.Index)
+			}
+			for _, c := range front.Cells {
+				if _, ok := behind[c.bounds()]; !ok {
+					return nil, fmt.Errorf("frame %d on sheet %d has nothing printed behind it", c.Frame, front.Index)
+				}
+			}
+		}
+
+		for _, c := range front.Cells {
+			r := c.bounds()
+			stack, ok := pileStack[r]
+			if !ok {
+				return nil, fmt.Errorf("frame %d on sheet %d is not in the same place as any frame on sheet %d", c.Frame, front.Index, first.Index)
+			}
+			if c.Stack != stack {
+				return nil, fmt.Errorf("frame %d on sheet %d is labelled stack %s but is cut into stack %s", c.Frame, front.Index, stackName(c.Stack), stackName(stack))
+			}
+			piles[r] = append(piles[r], bookPage{sheet: front, cell: c})
+			if duplex {
+				b := behind[r]
+				if b.Stack != stack {
+					return nil, fmt.Errorf("frame %d on sheet %d is labelled stack %s but is cut into stack %s", b.Frame, backs[n].Index, stackName(b.Stack), stackName(stack))
+				}
+				pileBacks[r] = append(pileBacks[r], bookPage{sheet: backs[n], cell: b})
+			}
+		}
+	}
+
+	// The piles are put together in stack order
+	var cells []image.Rectangle
+	for r := range piles {
+		cells = append(cells, r)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		return pileStack[cells[i]] < pileStack[cells[j]]
+	})
+
+	// The fronts are flipped through from the top of the book, then the book is turned over
+	// and the backs are flipped through from the bottom
+	var book []bookPage
+	for _, r := range cells {
+		book = append(book, piles[r]...)
+	}
+	if duplex {
+		var leafBacks []bookPage
+		for _, r := range cells {
+			leafBacks = append(leafBacks, pileBacks[r]...)
+		}
+		for i := len(leafBacks) - 1; i >= 0; i-- {
+			book = append(book, leafBacks[i])
+		}
+	}
+
+	if len(book) != info.NFrames {
+		return nil, fmt.Errorf("the book has %d pages but there are %d frames", len(book), info.NFrames)
+	}
+	for i, p := range book {
+		want := i
+		if info.ReverseFrames {
+			want = info.NFrames - 1 - i
+		}
+		if p.cell.Frame != want {
+			return nil, fmt.Errorf("page %d of the assembled book is frame %d, expected frame %d, it is in stack %s on sheet %d", i, p.cell.Frame, want, stackName(p.cell.Stack), p.sheet.Index)
+		}
+	}
+
+	// Flipped from the back, the book is seen from its last page
+	if info.ReverseFrames {
+		for i, j := 0, len(book)-1; i < j; i, j = i+1, j-1 {
+			book[i], book[j] = book[j], book[i]
+		}
+	}
+	return book, nil
+}
+
+// cutStacks returns the stack each cell of a sheet is cut into, by its place on the sheet. The
+// cells are read down each column from the left, the first is stack a
+func cutStacks(s SheetInfo) (map[image.Rectangle]int, error) {
+	var cells []image.Rectangle
+	for _, c := range s.Cells {
+		cells = append(cells, c.bounds())
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Min.X != cells[j].Min.X {
+			return cells[i].Min.X < cells[j].Min.X
+		}
+		return cells[i].Min.Y < cells[j].Min.Y
+	})
+
+	stacks := make(map[image.Rectangle]int)
+	for i, r := range cells {
+		if _, ok := stacks[r]; ok {
+			return nil, fmt.Errorf("sheet %d has frames printed over each other", s.Index)
+		}
+		stacks[r] = i
+	}
+	return stacks, nil
+}
+
+// turnOver returns where a cell on the back of a sheet is once the sheet is turned over on
+// its long or short edge, which is the cell on the front it is printed behind
+func turnOver(r image.Rectangle, duplex string, width, height int) image.Rectangle {
+	if duplex == DuplexShort {
+		return image.Rect(r.Min.X, height-r.Max.Y, r.Max.X, height-r.Min.Y)
+	}
+	return image.Rect(width-r.Max.X, r.Min.Y, width-r.Min.X, r.Max.Y)
+}
+
+func sideName(back bool) string {
+	if back {
+		return "back"
+	}
+	return "front"
+}
+
+// Reconstruct assembles the book as Verify does, then crops every frame out of the sheets in
+// dir and writes them as an animated GIF at fps, in the order they are flipped, so the result
+// of cutting up the printed sheets can be watched. Frames are turned upright and scaled to
+// width pixels wide. The sheets must be in a format that can be decoded, not pdf or CMYK
+func Reconstruct(w io.Writer, info RenderInfo, dir string, fps float64, width int) error {
+	book, err := assemble(info)
+	if err != nil {
+		return err
+	}
+
+	frames, err := newPreviewFrames(len(book), Options{PreviewWidth: width})
+	if err != nil {
+		return err
+	}
+	defer frames.close()
+
+	// Each sheet is decoded once and all of its frames are cropped from it
+	bySheet := make(map[int][]int)
+	var sheets []SheetInfo
+	for i, p := range book {
+		if _, ok := bySheet[p.sheet.Index]; !ok {
+			sheets = append(sheets, p.sheet)
+		}
+		bySheet[p.sheet.Index] = append(bySheet[p.sheet.Index], i)
+	}
+	for _, s := range sheets {
+		if s.Name == "" {
+			return fmt.Errorf("sheet %d was not written to its own file", s.Index)
+		}
+		p := path.Join(dir, s.Name)
+		img, err := imaging.Open(p)
+		if err != nil {
+			return fmt.Errorf("failed to load sheet: %s, %s", p, err)
+		}
+		for _, i := range bySheet[s.Index] {
+			c := book[i].cell
+			var upright image.Image = imaging.Crop(img, c.bounds())
+			switch c.Turn {
+			case 90:
+				upright = imaging.Rotate270(upright)
+			case 180:
+				upright = imaging.Rotate180(upright)
+			case 270:
+				upright = imaging.Rotate90(upright)
+			}
+			err = frames.save(i, upright)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if fps == 0 {
+		fps = DefaultPreviewFPS
+	}
+	return encodeGIF(w, frameDurations(len(book), fps, 100), frames.next())
+}
@@ -0,0 +1,353 @@
+package composite
+
+import (
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// testBook describes a book rendered by renderBook
+type testBook struct {
+	layout        string
+	frames        int
+	duplex        string
+	reversePages  bool
+	reverseFrames bool
+}
+
+// renderBook renders a book of small frames at a low DPI, so the layouts can be checked
+// quickly, and returns its render info
+func renderBook(t *testing.T, b testBook) RenderInfo {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var frames []os.FileInfo
+	for i := 0; i < b.frames; i++ {
+		p := path.Join(dir, fmt.Sprintf("frame-%03d.png", i))
+		f, err := os.Create(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = png.Encode(f, testSheet(16, 9))
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, info)
+	}
+
+	opts := Options{
+		Page:          Page{Width: 4, Height: 6, DPI: 20},
+		BGColor:       "white",
+		InputDir:      dir,
+		OutputDir:     dir,
+		Frames:        frames,
+		FontBytes:     goregular.TTF,
+		Labels:        LabelStyle{Disabled: true},
+		Duplex:        b.duplex,
+		ReversePages:  b.reversePages,
+		ReverseFrames: b.reverseFrames,
+		SheetWriter:   PNGWriter{},
+		VerLog:        log.New(ioutil.Discard, "", 0),
+	}
+	render := To4x6x3
+	if b.layout == "letter" {
+		opts.Page = Page{Width: 8.5, Height: 11, MarginBottom: 1, DPI: 20}
+		render = ToLetter
+	}
+	info, err := render(opts)
+	if err != nil {
+		t.Fatalf("failed to render: %s", err)
+	}
+	return info
+}
+
+// copyInfo returns a copy of the info whose sheets can be changed without changing the original
+func copyInfo(info RenderInfo) RenderInfo {
+	sheets := make([]SheetInfo, len(info.Sheets))
+	for i, s := range info.Sheets {
+		s.Cells = append([]CellInfo(nil), s.Cells...)
+		sheets[i] = s
+	}
+	info.Sheets = sheets
+	return info
+}
+
+// testBooks every combination of layout, duplex edge and reversal
+func testBooks() []testBook {
+	var books []testBook
+	for _, layout := range []struct {
+		name   string
+		frames int
+	}{{"4x6x3", 12}, {"letter", 40}} {
+		for _, duplex := range []string{"", DuplexLong, DuplexShort} {
+			for _, reversePages := range []bool{false, true} {
+				for _, reverseFrames := range []bool{false, true} {
+					books = append(books, testBook{layout.name, layout.frames, duplex, reversePages, reverseFrames})
+				}
+			}
+		}
+	}
+	return books
+}
+
+func TestVerifyRenderedBooks(t *testing.T) {
+	for _, b := range testBooks() {
+		info := renderBook(t, b)
+		if err := Verify(info); err != nil {
+			t.Errorf("%+v: %s", b, err)
+		}
+		book, err := assemble(info)
+		if err != nil {
+			continue
+		}
+		// Whichever end the book is flipped from, the pages are seen in the order of the frames
+		if len(book) != b.frames {
+			t.Errorf("%+v: got %d pages, want %d", b, len(book), b.frames)
+		}
+		for i, p := range book {
+			if p.cell.Frame != i {
+				t.Errorf("%+v: page %d is frame %d", b, i, p.cell.Frame)
+				break
+			}
+		}
+	}
+}
+
+func TestVerifyLayout(t *testing.T) {
+	// The interlacing is stated directly, frame k of a side is on sheet k mod n, where n is
+	// the number of sheets, in stack k / n. Stacks run down each column from the left
+	tests := []struct {
+		layout string
+		frames int
+		rows   int
+	}{
+		{"4x6x3", 12, 3},
+		{"letter", 40, 5},
+	}
+	for _, tt := range tests {
+		info := renderBook(t, testBook{layout: tt.layout, frames: tt.frames})
+		nSheets := len(info.Sheets)
+		for _, s := range info.Sheets {
+			stacks, err := cutStacks(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range s.Cells {
+				stack := stacks[c.bounds()]
+				if want := s.Sheet + stack*nSheets; c.Frame != want {
+					t.Errorf("%s: sheet %d stack %s holds frame %d, want %d", tt.layout, s.Sheet, stackName(stack), c.Frame, want)
+				}
+				if c.Stack != stack {
+					t.Errorf("%s: frame %d is labelled stack %s, it is cut into stack %s", tt.layout, c.Frame, stackName(c.Stack), stackName(stack))
+				}
+				col, row := stack/tt.rows, stack%tt.rows
+				if c.Left != info.Sheets[0].Cells[col*tt.rows].Left || c.Top != info.Sheets[0].Cells[row].Top {
+					t.Errorf("%s: stack %s is at %d,%d, not in column %d row %d", tt.layout, stackName(stack), c.Left, c.Top, col, row)
+				}
+			}
+		}
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	// Each change to a good book must be caught
+	tests := []struct {
+		name   string
+		book   testBook
+		change func(info *RenderInfo)
+		err    string
+	}{
+		{
+			"swapped cell",
+			testBook{layout: "4x6x3", frames: 12},
+			func(info *RenderInfo) {
+				c := info.Sheets[1].Cells
+				c[0].Frame, c[1].Frame = c[1].Frame, c[0].Frame
+			},
+			"page 1 of the assembled book is frame 5, expected frame 1",
+		},
+		{
+			"swapped sheets",
+			testBook{layout: "letter", frames: 40},
+			func(info *RenderInfo) {
+				info.Sheets[0].Sheet, info.Sheets[1].Sheet = info.Sheets[1].Sheet, info.Sheets[0].Sheet
+			},
+			"page 0 of the assembled book is frame 1, expected frame 0",
+		},
+		{
+			// The cells of stacks a and b change places on every sheet but keep their labels,
+			// so the labels still agree with each other but not with where the frames are cut
+			"wrong stack",
+			testBook{layout: "4x6x3", frames: 12},
+			func(info *RenderInfo) {
+				for _, s := range info.Sheets {
+					c := s.Cells
+					c[0].Top, c[1].Top = c[1].Top, c[0].Top
+				}
+			},
+			"frame 0 on sheet 0 is labelled stack a but is cut into stack b",
+		},
+		{
+			// Labels moved with the cells, the stacks are cut and labelled consistently but
+			// are in the wrong order
+			"wrong stack order",
+			testBook{layout: "letter", frames: 40},
+			func(info *RenderInfo) {
+				for _, s := range info.Sheets {
+					c := s.Cells
+					c[0].Left, c[5].Left = c[5].Left, c[0].Left
+					c[0].Stack, c[5].Stack = c[5].Stack, c[0].Stack
+				}
+			},
+			"page 0 of the assembled book is frame 20, expected frame 0",
+		},
+		{
+			"misplaced cell",
+			testBook{layout: "4x6x3", frames: 12},
+			func(info *RenderInfo) {
+				info.Sheets[2].Cells[1].Top++
+			},
+			"frame 6 on sheet 2 is not in the same place as any frame on sheet 0",
+		},
+		{
+			"overlapping cells",
+			testBook{layout: "4x6x3", frames: 12},
+			func(info *RenderInfo) {
+				info.Sheets[0].Cells[1].Top = info.Sheets[0].Cells[0].Top
+			},
+			"sheet 0 has frames printed over each other",
+		},
+		{
+			"missing frame",
+			testBook{layout: "4x6x3", frames: 12},
+			func(info *RenderInfo) {
+				info.Sheets[3].Cells = info.Sheets[3].Cells[:2]
+			},
+			"sheet 3 has 2 frames, sheet 0 has 3",
+		},
+		{
+			"missing back",
+			testBook{layout: "4x6x3", frames: 12, duplex: DuplexLong},
+			func(info *RenderInfo) {
+				info.Sheets = info.Sheets[:len(info.Sheets)-1]
+			},
+			"duplex book has 2 fronts but 1 backs",
+		},
+		{
+			"back on the wrong sheet",
+			testBook{layout: "4x6x3", frames: 12, duplex: DuplexShort},
+			func(info *RenderInfo) {
+				info.Sheets[3].Sheet = 0
+			},
+			"sheet 3 is printed on the back of a sheet that already has one",
+		},
+		{
+			"back not behind the front",
+			testBook{layout: "letter", frames: 40, duplex: DuplexLong},
+			func(info *RenderInfo) {
+				for i := range info.Sheets[1].Cells {
+					info.Sheets[1].Cells[i].Left += 3
+				}
+			},
+			"has nothing printed behind it",
+		},
+		{
+			// Turned over on the wrong edge the backs are behind the wrong cells
+			"wrong edge",
+			testBook{layout: "letter", frames: 40, duplex: DuplexLong},
+			func(info *RenderInfo) {
+				info.Duplex = DuplexShort
+			},
+			"has nothing printed behind it",
+		},
+		{
+			"swapped back",
+			testBook{layout: "4x6x3", frames: 12, duplex: DuplexLong},
+			func(info *RenderInfo) {
+				c := info.Sheets[1].Cells
+				c[1].Frame, c[2].Frame = c[2].Frame, c[1].Frame
+			},
+			"expected frame",
+		},
+		{
+			"not reversed",
+			testBook{layout: "4x6x3", frames: 12, reversePages: true},
+			func(info *RenderInfo) {
+				info.ReversePages = false
+			},
+			"page 0 of the assembled book is frame 3, expected frame 0",
+		},
+		{
+			"backs without duplex",
+			testBook{layout: "4x6x3", frames: 12, duplex: DuplexLong},
+			func(info *RenderInfo) {
+				info.Duplex = ""
+			},
+			"book is not duplex but has 2 backs",
+		},
+		{
+			"frame count",
+			testBook{layout: "4x6x3", frames: 12},
+			func(info *RenderInfo) {
+				info.NFrames = 13
+			},
+			"the book has 12 pages but there are 13 frames",
+		},
+		{
+			"no sheets",
+			testBook{layout: "4x6x3", frames: 12},
+			func(info *RenderInfo) {
+				info.Sheets = nil
+			},
+			"no sheets to verify",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := copyInfo(renderBook(t, tt.book))
+			if err := Verify(info); err != nil {
+				t.Fatalf("the book fails before it is changed: %s", err)
+			}
+			tt.change(&info)
+			err := Verify(info)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCutStacks(t *testing.T) {
+	// Two columns of two, given out of order
+	s := SheetInfo{Cells: []CellInfo{
+		{Left: 50, Top: 0, Width: 50, Height: 20},
+		{Left: 0, Top: 20, Width: 50, Height: 20},
+		{Left: 50, Top: 20, Width: 50, Height: 20},
+		{Left: 0, Top: 0, Width: 50, Height: 20},
+	}}
+	stacks, err := cutStacks(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{2, 1, 3, 0} {
+		if got := stacks[s.Cells[i].bounds()]; got != want {
+			t.Errorf("cell %d: got stack %s, want %s", i, stackName(got), stackName(want))
+		}
+	}
+}
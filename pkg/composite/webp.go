@@ -0,0 +1,704 @@
+package composite
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+)
+
+// VP8L constants, see the WebP lossless bitstream specification
+const (
+	vp8lSignature     = 0x2f
+	vp8lMaxSize       = 1 << 14
+	vp8lNumLiterals   = 256
+	vp8lNumLengths    = 24
+	vp8lNumDistances  = 40
+	vp8lMaxCodeLength = 15
+
+	// Distances up to this are coded using the 2D neighbourhood table, larger distances are
+	// offset by it
+	vp8lDistanceTableSize = 120
+
+	// Predictor tiles are 1<<vp8lPredictorBits pixels square
+	vp8lPredictorBits = 4
+
+	// Shortest back reference worth coding instead of literals
+	vp8lMinMatch = 3
+	// Longest back reference that can be coded
+	vp8lMaxMatch = 4096
+
+	transformPredictor     = 0
+	transformSubtractGreen = 2
+)
+
+// vp8lCodeLengthOrder is the order the code lengths of the code length code are written in
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// vp8lPredictors are the predictor modes tried for each tile, the mode giving the smallest
+// residuals is used
+var vp8lPredictors = []int{1, 2, 7, 12}
+
+// vp8lBitWriter writes values least significant bit first as VP8L requires
+type vp8lBitWriter struct {
+	buf   []byte
+	bits  uint64
+	nBits uint
+}
+
+func (b *vp8lBitWriter) write(v uint32, n uint) {
+	b.bits |= uint64(v&(1<<n-1)) << b.nBits
+	b.nBits += n
+	for b.nBits >= 8 {
+		b.buf = append(b.buf, byte(b.bits))
+		b.bits >>= 8
+		b.nBits -= 8
+	}
+}
+
+func (b *vp8lBitWriter) bytes() []byte {
+	if b.nBits > 0 {
+		b.buf = append(b.buf, byte(b.bits))
+		b.bits, b.nBits = 0, 0
+	}
+	return b.buf
+}
+
+// vp8lSymbol is a single coded symbol of the pixel stream, either a literal pixel or a back
+// reference to previously coded pixels
+type vp8lSymbol struct {
+	argb   uint32
+	length int
+	dist   int
+}
+
+// encodeWebP writes a lossless WebP. When dpi is greater than zero or a profile is given the
+// extended format is used so the resolution can be stored as EXIF and the profile as ICCP
+func encodeWebP(w io.Writer, img image.Image, dpi int, profile []byte) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	data, err := encodeVP8L(img)
+	if err != nil {
+		return err
+	}
+
+	var out webpChunks
+	if dpi > 0 || profile != nil {
+		var flags byte
+		if profile != nil {
+			flags |= 0x20
+		}
+		if dpi > 0 {
+			flags |= 0x08
+		}
+		out.add("VP8X", vp8xChunk(flags, width, height))
+		if profile != nil {
+			out.add("ICCP", profile)
+		}
+		out.add("VP8L", data)
+		if dpi > 0 {
+			out.add("EXIF", exifResolution(dpi))
+		}
+	} else {
+		out.add("VP8L", data)
+	}
+	return out.writeRIFF(w)
+}
+
+// encodeAnimatedWebP writes a lossless animated WebP that loops forever, each frame is shown
+// for the matching duration in milliseconds. Frames are read one at a time from next, which
+// returns nil once there are no more, so only the compressed frames are held in memory
+func encodeAnimatedWebP(w io.Writer, width, height int, durations []int, next func() (image.Image, error)) error {
+	var out webpChunks
+	// 0x02 is the animation flag
+	out.add("VP8X", vp8xChunk(0x02, width, height))
+	// Background colour, white, and a loop count of 0 which loops forever
+	out.add("ANIM", []byte{0xff, 0xff, 0xff, 0xff, 0, 0})
+
+	for _, d := range durations {
+		img, err := next()
+		if err != nil {
+			return err
+		}
+		if img == nil {
+			return fmt.Errorf("fewer frames than durations for the webp")
+		}
+		if img.Bounds().Dx() != width || img.Bounds().Dy() != height {
+			return fmt.Errorf("webp frames must all be %dx%d, not %dx%d", width, height, img.Bounds().Dx(), img.Bounds().Dy())
+		}
+
+		data, err := encodeVP8L(img)
+		if err != nil {
+			return err
+		}
+
+		// Each frame covers the whole canvas at 0,0 and replaces what was there, the flags
+		// byte sets no blending and no disposal
+		var frame webpChunks
+		frame.add("VP8L", data)
+		anmf := []byte{0, 0, 0, 0, 0, 0}
+		anmf = append(anmf, uint24(width-1)...)
+		anmf = append(anmf, uint24(height-1)...)
+		anmf = append(anmf, uint24(d)...)
+		anmf = append(anmf, 0x02)
+		out.add("ANMF", append(anmf, frame.Bytes()...))
+	}
+	return out.writeRIFF(w)
+}
+
+// encodeVP8L returns the image as a VP8L bitstream, the payload of a VP8L chunk
+func encodeVP8L(img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxSize || height > vp8lMaxSize {
+		return nil, fmt.Errorf("image is too large for a webp: %dx%d", width, height)
+	}
+
+	argb := make([]uint32, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			argb[y*width+x] = a>>8<<24 | r>>8<<16 | g>>8<<8 | bl>>8
+		}
+	}
+
+	bw := &vp8lBitWriter{}
+	bw.write(vp8lSignature, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	// alpha_is_used is only a hint, sheets are always opaque
+	bw.write(0, 1)
+	// Version
+	bw.write(0, 3)
+
+	// Subtracting green decorrelates the channels, the predictor then turns smooth areas and
+	// the blank space around frames into runs of zeros
+	bw.write(1, 1)
+	bw.write(transformSubtractGreen, 2)
+	for i, p := range argb {
+		g := p >> 8 & 0xff
+		argb[i] = p&0xff00ff00 | ((p>>16&0xff-g)&0xff)<<16 | (p&0xff-g)&0xff
+	}
+
+	bw.write(1, 1)
+	bw.write(transformPredictor, 2)
+	bw.write(vp8lPredictorBits-2, 3)
+	modes, residuals := vp8lPredict(argb, width, height)
+	tilesW := vp8lTiles(width)
+	writeVP8LImage(bw, modes, tilesW, false)
+
+	// No more transforms
+	bw.write(0, 1)
+	writeVP8LImage(bw, residuals, width, true)
+
+	return bw.bytes(), nil
+}
+
+// webpChunks accumulates RIFF chunks
+type webpChunks struct {
+	bytes.Buffer
+}
+
+func (c *webpChunks) add(fourCC string, payload []byte) {
+	c.WriteString(fourCC)
+	binary.Write(c, binary.LittleEndian, uint32(len(payload)))
+	c.Write(payload)
+	if len(payload)%2 != 0 {
+		c.WriteByte(0)
+	}
+}
+
+// writeRIFF writes the chunks wrapped in the RIFF WEBP header
+func (c *webpChunks) writeRIFF(w io.Writer) error {
+	var riff bytes.Buffer
+	riff.WriteString("RIFF")
+	binary.Write(&riff, binary.LittleEndian, uint32(4+c.Len()))
+	riff.WriteString("WEBP")
+	riff.Write(c.Bytes())
+	_, err := w.Write(riff.Bytes())
+	return err
+}
+
+// vp8xChunk returns the payload of a VP8X chunk, the canvas size is stored minus one
+func vp8xChunk(flags byte, width, height int) []byte {
+	vp8x := []byte{flags, 0, 0, 0}
+	vp8x = append(vp8x, uint24(width-1)...)
+	return append(vp8x, uint24(height-1)...)
+}
+
+// uint24 returns v as 3 little endian bytes
+func uint24(v int) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+}
+
+// exifResolution returns a minimal EXIF block holding the resolution of the image in dpi
+func exifResolution(dpi int) []byte {
+	const ifdOffset = 8
+	const nEntries = 3
+	valuesOffset := uint32(ifdOffset + 2 + nEntries*12 + 4)
+
+	var d bytes.Buffer
+	d.WriteString("II")
+	binary.Write(&d, binary.LittleEndian, uint16(42))
+	binary.Write(&d, binary.LittleEndian, uint32(ifdOffset))
+	binary.Write(&d, binary.LittleEndian, uint16(nEntries))
+	binary.Write(&d, binary.LittleEndian, []uint16{tagXResolution, tiffRational})
+	binary.Write(&d, binary.LittleEndian, []uint32{1, valuesOffset})
+	binary.Write(&d, binary.LittleEndian, []uint16{tagYResolution, tiffRational})
+	binary.Write(&d, binary.LittleEndian, []uint32{1, valuesOffset + 8})
+	// 2 is inches
+	binary.Write(&d, binary.LittleEndian, []uint16{tagResolutionUnit, tiffShort})
+	binary.Write(&d, binary.LittleEndian, []uint32{1, 2})
+	// No more IFDs
+	binary.Write(&d, binary.LittleEndian, uint32(0))
+	binary.Write(&d, binary.LittleEndian, []uint32{uint32(dpi), 1, uint32(dpi), 1})
+	return d.Bytes()
+}
+
+func vp8lTiles(size int) int {
+	return (size + 1<<vp8lPredictorBits - 1) >> vp8lPredictorBits
+}
+
+// vp8lPredict picks a predictor mode for each tile and returns the tile modes, stored in the
+// green channel as the spec requires, and the residuals of every pixel
+func vp8lPredict(argb []uint32, width, height int) ([]uint32, []uint32) {
+	tilesW, tilesH := vp8lTiles(width), vp8lTiles(height)
+	modes := make([]uint32, tilesW*tilesH)
+	residuals := make([]uint32, len(argb))
+
+	predict := func(mode, x, y int) uint32 {
+		i := y*width + x
+		switch {
+		case x == 0 && y == 0:
+			return 0xff000000
+		case y == 0:
+			return argb[i-1]
+		case x == 0:
+			return argb[i-width]
+		}
+		l, t, tl := argb[i-1], argb[i-width], argb[i-width-1]
+		switch mode {
+		case 1:
+			return l
+		case 2:
+			return t
+		case 7:
+			return vp8lAverage(l, t)
+		default:
+			return vp8lClampAddSubtract(l, t, tl)
+		}
+	}
+
+	for ty := 0; ty < tilesH; ty++ {
+		for tx := 0; tx < tilesW; tx++ {
+			x0, y0 := tx<<vp8lPredictorBits, ty<<vp8lPredictorBits
+			x1 := minInt(x0+1<<vp8lPredictorBits, width)
+			y1 := minInt(y0+1<<vp8lPredictorBits, height)
+
+			bestMode, bestCost := 0, -1
+			for _, mode := range vp8lPredictors {
+				cost := 0
+				for y := y0; y < y1; y++ {
+					for x := x0; x < x1; x++ {
+						cost += vp8lResidualCost(vp8lSub(argb[y*width+x], predict(mode, x, y)))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					bestMode, bestCost = mode, cost
+				}
+			}
+
+			modes[ty*tilesW+tx] = 0xff000000 | uint32(bestMode)<<8
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					residuals[y*width+x] = vp8lSub(argb[y*width+x], predict(bestMode, x, y))
+				}
+			}
+		}
+	}
+	return modes, residuals
+}
+
+// vp8lSub subtracts each channel of b from a, modulo 256
+func vp8lSub(a, b uint32) uint32 {
+	var r uint32
+	for s := uint(0); s < 32; s += 8 {
+		r |= ((a>>s&0xff - b>>s&0xff) & 0xff) << s
+	}
+	return r
+}
+
+// vp8lResidualCost estimates the cost of coding a residual, small values in either direction
+// are cheap
+func vp8lResidualCost(v uint32) int {
+	cost := 0
+	for s := uint(0); s < 32; s += 8 {
+		c := int(int8(v >> s))
+		if c < 0 {
+			c = -c
+		}
+		cost += c
+	}
+	return cost
+}
+
+func vp8lAverage(a, b uint32) uint32 {
+	var r uint32
+	for s := uint(0); s < 32; s += 8 {
+		r |= ((a>>s&0xff + b>>s&0xff) / 2) << s
+	}
+	return r
+}
+
+func vp8lClampAddSubtract(a, b, c uint32) uint32 {
+	var r uint32
+	for s := uint(0); s < 32; s += 8 {
+		v := clampInt(int(a>>s&0xff)+int(b>>s&0xff)-int(c>>s&0xff), 0, 255)
+		r |= uint32(v) << s
+	}
+	return r
+}
+
+// writeVP8LImage entropy codes an image using a single group of prefix codes and no color
+// cache. Only the main image has the meta prefix code flag
+func writeVP8LImage(bw *vp8lBitWriter, argb []uint32, width int, mainImage bool) {
+	symbols := vp8lBackwardRefs(argb, width)
+
+	green := make([]int, vp8lNumLiterals+vp8lNumLengths)
+	red := make([]int, vp8lNumLiterals)
+	blue := make([]int, vp8lNumLiterals)
+	alpha := make([]int, vp8lNumLiterals)
+	dist := make([]int, vp8lNumDistances)
+	for _, s := range symbols {
+		if s.length == 0 {
+			green[s.argb>>8&0xff]++
+			red[s.argb>>16&0xff]++
+			blue[s.argb&0xff]++
+			alpha[s.argb>>24]++
+			continue
+		}
+		code, _, _ := vp8lPrefix(s.length)
+		green[vp8lNumLiterals+code]++
+		code, _, _ = vp8lPrefix(s.dist)
+		dist[code]++
+	}
+
+	// No color cache
+	bw.write(0, 1)
+	if mainImage {
+		// No meta prefix codes
+		bw.write(0, 1)
+	}
+
+	var codes [5][]huffCode
+	for i, freqs := range [][]int{green, red, blue, alpha, dist} {
+		codes[i] = writeVP8LPrefixCode(bw, freqs)
+	}
+
+	put := func(c huffCode) {
+		bw.write(uint32(c.code), uint(c.size))
+	}
+	for _, s := range symbols {
+		if s.length == 0 {
+			put(codes[0][s.argb>>8&0xff])
+			put(codes[1][s.argb>>16&0xff])
+			put(codes[2][s.argb&0xff])
+			put(codes[3][s.argb>>24])
+			continue
+		}
+		code, n, extra := vp8lPrefix(s.length)
+		put(codes[0][vp8lNumLiterals+code])
+		bw.write(extra, n)
+		code, n, extra = vp8lPrefix(s.dist)
+		put(codes[4][code])
+		bw.write(extra, n)
+	}
+}
+
+// vp8lBackwardRefs greedily replaces runs of pixels that repeat the pixel to the left or the
+// pixel above with back references. The distances are returned already mapped to distance
+// codes, 1 is the pixel above and 2 the pixel to the left
+func vp8lBackwardRefs(argb []uint32, width int) []vp8lSymbol {
+	var symbols []vp8lSymbol
+	matchLen := func(i, d int) int {
+		if i < d {
+			return 0
+		}
+		n := 0
+		for i+n < len(argb) && n < vp8lMaxMatch && argb[i+n] == argb[i+n-d] {
+			n++
+		}
+		return n
+	}
+
+	for i := 0; i < len(argb); {
+		left := matchLen(i, 1)
+		up := matchLen(i, width)
+		switch {
+		case up >= vp8lMinMatch && up >= left:
+			symbols = append(symbols, vp8lSymbol{length: up, dist: 1})
+			i += up
+		case left >= vp8lMinMatch:
+			symbols = append(symbols, vp8lSymbol{length: left, dist: 2})
+			i += left
+		default:
+			symbols = append(symbols, vp8lSymbol{argb: argb[i]})
+			i++
+		}
+	}
+	return symbols
+}
+
+// vp8lPrefix returns the prefix code, number of extra bits and the extra bits for a length
+// or distance code
+func vp8lPrefix(v int) (int, uint, uint32) {
+	d := v - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+	h := uint(0)
+	for d>>(h+1) > 0 {
+		h++
+	}
+	s := d >> (h - 1) & 1
+	return int(2*h) + s, h - 1, uint32(d & (1<<(h-1) - 1))
+}
+
+// writeVP8LPrefixCode writes the prefix code for the symbol frequencies and returns the code
+// for each symbol
+func writeVP8LPrefixCode(bw *vp8lBitWriter, freqs []int) []huffCode {
+	var used []int
+	for s, f := range freqs {
+		if f > 0 {
+			used = append(used, s)
+		}
+	}
+
+	codes := make([]huffCode, len(freqs))
+	if len(used) == 0 {
+		// The symbols are never read, any single symbol code will do
+		used = []int{0}
+	}
+	if len(used) <= 2 && used[len(used)-1] < vp8lNumLiterals {
+		// Simple code, a single symbol takes no bits, two symbols take one bit each with the
+		// smaller symbol coded as 0
+		bw.write(1, 1)
+		bw.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			bw.write(uint32(used[1]), 8)
+			codes[used[0]] = huffCode{code: 0, size: 1}
+			codes[used[1]] = huffCode{code: 1, size: 1}
+		}
+		return codes
+	}
+
+	lengths := huffmanLengths(freqs, vp8lMaxCodeLength)
+	if len(used) > 1 {
+		codes = canonicalCodes(lengths)
+	}
+
+	// Run length code the code lengths, 16 repeats the previous non zero length 3-6 times,
+	// 17 repeats zero 3-10 times and 18 repeats zero 11-138 times
+	type clSymbol struct {
+		symbol int
+		extra  uint32
+	}
+	var cls []clSymbol
+	prev := 8
+	for i := 0; i < len(lengths); {
+		l := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == l {
+			run++
+		}
+		i += run
+		if l == 0 {
+			for run >= 3 {
+				if run >= 11 {
+					n := minInt(run, 138)
+					cls = append(cls, clSymbol{18, uint32(n - 11)})
+					run -= n
+				} else {
+					n := minInt(run, 10)
+					cls = append(cls, clSymbol{17, uint32(n - 3)})
+					run -= n
+				}
+			}
+		} else {
+			if l != prev {
+				cls = append(cls, clSymbol{l, 0})
+				prev = l
+				run--
+			}
+			for run >= 3 {
+				n := minInt(run, 6)
+				cls = append(cls, clSymbol{16, uint32(n - 3)})
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			cls = append(cls, clSymbol{l, 0})
+		}
+	}
+
+	clFreqs := make([]int, len(vp8lCodeLengthOrder))
+	for _, c := range cls {
+		clFreqs[c.symbol]++
+	}
+	clLengths := huffmanLengths(clFreqs, 7)
+	clCodes := canonicalCodes(clLengths)
+	nUsed := 0
+	for _, f := range clFreqs {
+		if f > 0 {
+			nUsed++
+		}
+	}
+	if nUsed == 1 {
+		// A code with a single symbol is read with no bits
+		clCodes = make([]huffCode, len(clCodes))
+	}
+
+	nCodes := 4
+	for i, s := range vp8lCodeLengthOrder {
+		if clLengths[s] > 0 && i+1 > nCodes {
+			nCodes = i + 1
+		}
+	}
+
+	bw.write(0, 1)
+	bw.write(uint32(nCodes-4), 4)
+	for _, s := range vp8lCodeLengthOrder[:nCodes] {
+		bw.write(uint32(clLengths[s]), 3)
+	}
+	// Code lengths are given for the whole alphabet
+	bw.write(0, 1)
+	for _, c := range cls {
+		code := clCodes[c.symbol]
+		bw.write(uint32(code.code), uint(code.size))
+		switch c.symbol {
+		case 16:
+			bw.write(c.extra, 2)
+		case 17:
+			bw.write(c.extra, 3)
+		case 18:
+			bw.write(c.extra, 7)
+		}
+	}
+	return codes
+}
+
+// canonicalCodes assigns canonical huffman codes to the code lengths. The codes are bit
+// reversed, since VP8L reads codes a bit at a time starting from the least significant bit
+func canonicalCodes(lengths []int) []huffCode {
+	var count [vp8lMaxCodeLength + 1]int
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [vp8lMaxCodeLength + 1]int
+	code := 0
+	for l := 1; l <= vp8lMaxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+
+	codes := make([]huffCode, len(lengths))
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		var rev uint16
+		for i := 0; i < l; i++ {
+			rev = rev<<1 | uint16(c>>uint(i)&1)
+		}
+		codes[s] = huffCode{code: rev, size: uint8(l)}
+	}
+	return codes
+}
+
+// huffmanNode is a node of the tree built by huffmanLengths
+type huffmanNode struct {
+	freq   int
+	symbol int
+	left   *huffmanNode
+	right  *huffmanNode
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].freq == h[j].freq {
+		return h[i].symbol < h[j].symbol
+	}
+	return h[i].freq < h[j].freq
+}
+func (h huffmanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x interface{}) { *h = append(*h, x.(*huffmanNode)) }
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// huffmanLengths returns the huffman code length of each symbol, no longer than maxLength.
+// If the tree is too deep the frequencies are flattened and the tree rebuilt. A lone symbol
+// is given a length of 1
+func huffmanLengths(freqs []int, maxLength int) []int {
+	f := append([]int(nil), freqs...)
+	for {
+		h := &huffmanHeap{}
+		for s, n := range f {
+			if n > 0 {
+				*h = append(*h, &huffmanNode{freq: n, symbol: s})
+			}
+		}
+		lengths := make([]int, len(f))
+		if h.Len() == 1 {
+			lengths[(*h)[0].symbol] = 1
+			return lengths
+		}
+		heap.Init(h)
+		for h.Len() > 1 {
+			a := heap.Pop(h).(*huffmanNode)
+			b := heap.Pop(h).(*huffmanNode)
+			heap.Push(h, &huffmanNode{freq: a.freq + b.freq, symbol: minInt(a.symbol, b.symbol), left: a, right: b})
+		}
+
+		maxDepth := 0
+		var walk func(n *huffmanNode, depth int)
+		walk = func(n *huffmanNode, depth int) {
+			if n.left == nil {
+				lengths[n.symbol] = depth
+				if depth > maxDepth {
+					maxDepth = depth
+				}
+				return
+			}
+			walk(n.left, depth+1)
+			walk(n.right, depth+1)
+		}
+		walk((*h)[0], 0)
+		if maxDepth <= maxLength {
+			return lengths
+		}
+
+		for s, n := range f {
+			if n > 0 {
+				f[s] = (n + 1) / 2
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package composite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// riffChunks returns the chunks of a RIFF WEBP file in order
+func riffChunks(t *testing.T, data []byte) []riffChunk {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		t.Fatalf("not a webp file")
+	}
+	if size := int(binary.LittleEndian.Uint32(data[4:])); size != len(data)-8 {
+		t.Fatalf("RIFF size %d, file is %d bytes", size, len(data))
+	}
+	var chunks []riffChunk
+	for pos := 12; pos < len(data); {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		chunks = append(chunks, riffChunk{string(data[pos : pos+4]), data[pos+8 : pos+8+size]})
+		pos += 8 + size + size%2
+	}
+	return chunks
+}
+
+type riffChunk struct {
+	fourCC  string
+	payload []byte
+}
+
+// noiseSheet returns an image of random pixels, the hardest case for the predictor and the
+// back references
+func noiseSheet(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	return img
+}
+
+// blankSheet returns a white image with a few frames drawn on it, like a sheet with margins
+func blankSheet(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	frame := testSheet(width/3, height/3)
+	for y := 0; y < height/3; y++ {
+		for x := 0; x < width/3; x++ {
+			img.Set(x+width/2, y+height/2, frame.At(x, y))
+			img.Set(x+4, y+4, frame.At(x, y))
+		}
+	}
+	return img
+}
+
+// sameImage returns the first pixel that differs between the images, or false if they match
+func sameImage(a, b image.Image) (image.Point, bool) {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return image.Point{}, false
+	}
+	ab, bb := a.Bounds(), b.Bounds()
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			c1 := color.NRGBAModel.Convert(a.At(ab.Min.X+x, ab.Min.Y+y))
+			c2 := color.NRGBAModel.Convert(b.At(bb.Min.X+x, bb.Min.Y+y))
+			if c1 != c2 {
+				return image.Pt(x, y), false
+			}
+		}
+	}
+	return image.Point{}, true
+}
+
+func TestEncodeWebP(t *testing.T) {
+	images := map[string]image.Image{
+		"1x1":          testSheet(1, 1),
+		"smooth":       testSheet(37, 23),
+		"smooth large": testSheet(300, 170),
+		"noise":        noiseSheet(53, 41),
+		"blank":        blankSheet(250, 120),
+		"offset":       testSheet(64, 48).SubImage(image.Rect(5, 7, 50, 40)),
+	}
+	for name, img := range images {
+		for _, dpi := range []int{0, 300} {
+			var b bytes.Buffer
+			if err := encodeWebP(&b, img, dpi, nil); err != nil {
+				t.Fatal(err)
+			}
+			out, err := webp.Decode(bytes.NewReader(b.Bytes()))
+			if err != nil {
+				t.Fatalf("%s, dpi %d: failed to decode: %s", name, dpi, err)
+			}
+			// Lossless, so every pixel comes back exactly
+			if p, ok := sameImage(img, out); !ok {
+				t.Fatalf("%s, dpi %d: pixel %v differs, or bounds %v differ from %v", name, dpi, p, out.Bounds(), img.Bounds())
+			}
+		}
+	}
+}
+
+func TestEncodeWebPChunks(t *testing.T) {
+	img := testSheet(37, 23)
+	profile := []byte("an icc profile of odd length")
+
+	tests := []struct {
+		name    string
+		dpi     int
+		profile []byte
+		chunks  []string
+		flags   byte
+	}{
+		{"simple", 0, nil, []string{"VP8L"}, 0},
+		{"dpi", 300, nil, []string{"VP8X", "VP8L", "EXIF"}, 0x08},
+		{"profile", 0, profile, []string{"VP8X", "ICCP", "VP8L"}, 0x20},
+		{"both", 600, profile, []string{"VP8X", "ICCP", "VP8L", "EXIF"}, 0x28},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := encodeWebP(&b, img, tt.dpi, tt.profile); err != nil {
+				t.Fatal(err)
+			}
+			chunks := riffChunks(t, b.Bytes())
+			if len(chunks) != len(tt.chunks) {
+				t.Fatalf("got %d chunks, want %v", len(chunks), tt.chunks)
+			}
+			for i, c := range chunks {
+				if c.fourCC != tt.chunks[i] {
+					t.Fatalf("chunk %d is %s, want %s", i, c.fourCC, tt.chunks[i])
+				}
+				switch c.fourCC {
+				case "VP8X":
+					w := int(c.payload[4]) | int(c.payload[5])<<8 | int(c.payload[6])<<16
+					h := int(c.payload[7]) | int(c.payload[8])<<8 | int(c.payload[9])<<16
+					if c.payload[0] != tt.flags || w != 36 || h != 22 {
+						t.Fatalf("got VP8X flags %#x and canvas %dx%d minus one", c.payload[0], w, h)
+					}
+				case "ICCP":
+					if !bytes.Equal(c.payload, tt.profile) {
+						t.Fatalf("got profile %q", c.payload)
+					}
+				case "EXIF":
+					checkTIFFResolution(t, c.payload, tt.dpi)
+				}
+			}
+			if _, err := webp.Decode(bytes.NewReader(b.Bytes())); err != nil {
+				t.Fatalf("failed to decode: %s", err)
+			}
+		})
+	}
+}
+
+func TestEncodeAnimatedWebP(t *testing.T) {
+	frames := []image.Image{testSheet(40, 30), noiseSheet(40, 30), blankSheet(40, 30)}
+	durations := []int{100, 40, 2000}
+	i := 0
+	next := func() (image.Image, error) {
+		if i == len(frames) {
+			return nil, nil
+		}
+		i++
+		return frames[i-1], nil
+	}
+
+	var b bytes.Buffer
+	if err := encodeAnimatedWebP(&b, 40, 30, durations, next); err != nil {
+		t.Fatal(err)
+	}
+	chunks := riffChunks(t, b.Bytes())
+	if len(chunks) != 2+len(frames) || chunks[0].fourCC != "VP8X" || chunks[1].fourCC != "ANIM" {
+		t.Fatalf("got %d chunks, want VP8X, ANIM and a frame each", len(chunks))
+	}
+	if chunks[0].payload[0] != 0x02 {
+		t.Fatalf("got VP8X flags %#x, want the animation flag", chunks[0].payload[0])
+	}
+
+	// x/image/webp doesn't decode animations, so each frame is decoded as a still image
+	for n, c := range chunks[2:] {
+		if c.fourCC != "ANMF" {
+			t.Fatalf("chunk %d is %s, want ANMF", n+2, c.fourCC)
+		}
+		d := int(c.payload[12]) | int(c.payload[13])<<8 | int(c.payload[14])<<16
+		if d != durations[n] {
+			t.Fatalf("frame %d: got duration %d, want %d", n, d, durations[n])
+		}
+		var still webpChunks
+		still.Write(c.payload[16:])
+		var f bytes.Buffer
+		if err := still.writeRIFF(&f); err != nil {
+			t.Fatal(err)
+		}
+		out, err := webp.Decode(&f)
+		if err != nil {
+			t.Fatalf("frame %d: failed to decode: %s", n, err)
+		}
+		if p, ok := sameImage(frames[n], out); !ok {
+			t.Fatalf("frame %d: pixel %v differs", n, p)
+		}
+	}
+
+	// Frames have to fill the canvas
+	i = 0
+	err := encodeAnimatedWebP(&b, 41, 30, durations, next)
+	if err == nil || err.Error() != "webp frames must all be 41x30, not 40x30" {
+		t.Fatalf("got error %v", err)
+	}
+	i = 0
+	err = encodeAnimatedWebP(&b, 40, 30, append(durations, 10), next)
+	if err == nil || err.Error() != "fewer frames than durations for the webp" {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestEncodeWebPTooLarge(t *testing.T) {
+	var b bytes.Buffer
+	err := encodeWebP(&b, image.NewRGBA(image.Rect(0, 0, vp8lMaxSize+1, 1)), 0, nil)
+	if err == nil || err.Error() != "image is too large for a webp: 16385x1" {
+		t.Fatalf("got error %v", err)
+	}
+}
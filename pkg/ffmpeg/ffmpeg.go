@@ -15,8 +15,23 @@ import (
 // VideoFilter extracts individual frames from a video source and saves them as
 // images to the specified output location
 func VideoFilter(input, output, identifier string, fps int, startTime uint, maxLength int, verLog *log.Logger) ([]os.FileInfo, error) {
-	if fps < 1 || fps > 60 {
-		return nil, fmt.Errorf("fps must be between 1 and 60, %d invalid value", fps)
+	return VideoFilterRate(input, output, identifier, fps, 1, startTime, maxLength*fps, verLog)
+}
+
+// VideoFilterRate extracts exactly nFrames frames from a video source at a frame rate
+// of fpsNum/fpsDen frames per second, starting at startTime. This allows callers to
+// hit a precise frame count over a time range, which isn't possible with a whole fps value
+func VideoFilterRate(input, output, identifier string, fpsNum, fpsDen int, startTime uint, nFrames int, verLog *log.Logger) ([]os.FileInfo, error) {
+	if fpsDen < 1 {
+		return nil, fmt.Errorf("fps denominator must be at least 1, %d invalid value", fpsDen)
+	}
+
+	if fpsNum < fpsDen || fpsNum > 60*fpsDen {
+		return nil, fmt.Errorf("fps must be between 1 and 60, %s invalid value", rateString(fpsNum, fpsDen))
+	}
+
+	if nFrames < 1 {
+		return nil, fmt.Errorf("number of frames must be at least 1, %d invalid value", nFrames)
 	}
 
 	if _, err := os.Stat(input); os.IsNotExist(err) {
@@ -37,13 +52,19 @@ func VideoFilter(input, output, identifier string, fps int, startTime uint, maxL
 
 	verLog.Println("Generating frames from:", input)
 	verLog.Println("Writing frames to:", output)
-	verLog.Println("fps=", fps)
+	verLog.Println("fps=", rateString(fpsNum, fpsDen))
+
+	// Frame numbers are padded to fit the largest, so the names sort in order even with
+	// more than 999 frames
+	width := len(strconv.Itoa(nFrames - 1))
+	if width < 3 {
+		width = 3
+	}
 
-	var maxFrames = maxLength * fps
 	const prefix = "frame-"
 	cmd := exec.Command("ffmpeg", "-ss", strconv.Itoa(int(startTime)), "-i", input,
-		"-vframes", strconv.Itoa(maxFrames), "-start_number", "0",
-		"-vf", "fps="+strconv.Itoa(fps), path.Join(output, prefix+identifier+"-%03d.png"))
+		"-vframes", strconv.Itoa(nFrames), "-start_number", "0",
+		"-vf", "fps="+rateString(fpsNum, fpsDen), path.Join(output, prefix+identifier+"-%0"+strconv.Itoa(width)+"d.png"))
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -68,6 +89,15 @@ func VideoFilter(input, output, identifier string, fps int, startTime uint, maxL
 	return filteredFiles, nil
 }
 
+// rateString returns the frame rate in the form ffmpeg expects, either a whole
+// number e.g. 15 or a rational e.g. 40/3
+func rateString(num, den int) string {
+	if den == 1 {
+		return strconv.Itoa(num)
+	}
+	return strconv.Itoa(num) + "/" + strconv.Itoa(den)
+}
+
 // FFMPEGIsInstalled returns true if the ffmpeg binary is installed, along with the path
 // to the installed binary, false if not installed
 func FFMPEGIsInstalled() (bool, string) {
@@ -77,3 +107,113 @@ func FFMPEGIsInstalled() (bool, string) {
 	}
 	return true, path
 }
+
+// HasFilter returns true if the installed ffmpeg binary supports the named filter, some
+// filters such as vidstabdetect are only available if ffmpeg was built with them enabled
+func HasFilter(name string) bool {
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-filters")
+	out, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Stabilize writes a stabilized copy of maxLength seconds of the input video, starting at
+// startTime, to output. method can be either vidstab, which gives the best results but
+// requires ffmpeg to be built with libvidstab, or deshake. The output is losslessly encoded
+// so the frames extracted from it are not degraded, it should have a .mkv extension
+func Stabilize(input, output, method string, startTime uint, maxLength int, verLog *log.Logger) error {
+	if _, err := os.Stat(input); os.IsNotExist(err) {
+		return fmt.Errorf("invalid input, file does not exist: %s", input)
+	}
+
+	if installed, _ := FFMPEGIsInstalled(); !installed {
+		return fmt.Errorf("ffmpeg is not installed, please install then re-run")
+	}
+
+	if verLog == nil {
+		verLog = log.New(ioutil.Discard, "", 0)
+	}
+
+	trim := []string{"-ss", strconv.Itoa(int(startTime)), "-t", strconv.Itoa(maxLength), "-i", input}
+
+	var filter string
+	switch method {
+	case "vidstab":
+		// vidstab is two pass, the first pass detects the motion and writes it to a file
+		transforms := path.Join(path.Dir(output), "transforms.trf")
+		verLog.Println("Detecting motion in:", input)
+		args := append(append([]string{}, trim...), "-vf", "vidstabdetect=result="+transforms, "-f", "null", "-")
+		if err := run(args); err != nil {
+			return fmt.Errorf("failed to detect motion: %s", err)
+		}
+		defer os.Remove(transforms)
+		filter = "vidstabtransform=input=" + transforms + ":smoothing=15"
+	case "deshake":
+		filter = "deshake"
+	default:
+		return fmt.Errorf("invalid stabilize method: %s, must be vidstab|deshake", method)
+	}
+
+	verLog.Println("Stabilizing:", input)
+	verLog.Println("Writing stabilized video to:", output)
+	args := append(append([]string{"-y"}, trim...), "-vf", filter, "-an", "-c:v", "ffv1", output)
+	if err := run(args); err != nil {
+		return fmt.Errorf("failed to stabilize video: %s", err)
+	}
+	return nil
+}
+
+// ExtractSubtitles writes the subtitle stream of the input video to output as an SRT file,
+// stream is the index of the subtitle stream, 0 for the first. Only text subtitles can be
+// extracted, not subtitles stored as images such as DVD subtitles
+func ExtractSubtitles(input, output string, stream int) error {
+	if _, err := os.Stat(input); os.IsNotExist(err) {
+		return fmt.Errorf("invalid input, file does not exist: %s", input)
+	}
+
+	if installed, _ := FFMPEGIsInstalled(); !installed {
+		return fmt.Errorf("ffmpeg is not installed, please install then re-run")
+	}
+
+	args := []string{"-y", "-i", input, "-map", "0:s:" + strconv.Itoa(stream), "-f", "srt", output}
+	if err := run(args); err != nil {
+		return fmt.Errorf("failed to extract subtitles: %s", err)
+	}
+	return nil
+}
+
+// EncodeVideo encodes a numbered sequence of images as an H.264 MP4 at fps frames per second,
+// pattern is a printf style pattern for the image paths e.g. frames/preview-%05d.png. Odd
+// sizes are rounded down to even, which H.264 requires
+func EncodeVideo(pattern string, fps float64, output string) error {
+	if installed, _ := FFMPEGIsInstalled(); !installed {
+		return fmt.Errorf("ffmpeg is not installed, please install then re-run")
+	}
+
+	args := []string{"-y", "-framerate", strconv.FormatFloat(fps, 'f', -1, 64), "-i", pattern,
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", "-c:v", "libx264", "-pix_fmt", "yuv420p", output}
+	if err := run(args); err != nil {
+		return fmt.Errorf("failed to encode video: %s", err)
+	}
+	return nil
+}
+
+func run(args []string) error {
+	cmd := exec.Command("ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%s, details: %s", err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ProbeInfo describes an input video, as reported by ffprobe
+type ProbeInfo struct {
+	// Format the container format e.g. mov,mp4,m4a,3gp,3g2,mj2
+	Format string `json:"format"`
+
+	// Duration the length of the video in seconds
+	Duration float64 `json:"duration"`
+
+	// Size the size of the file in bytes
+	Size int64 `json:"size"`
+
+	// BitRate the overall bit rate in bits per second
+	BitRate int64 `json:"bitRate,omitempty"`
+
+	// Codec the codec of the first video stream e.g. h264
+	Codec string `json:"codec"`
+
+	// Width, Height the size of the video frames in pixels
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	// FrameRate the average frame rate of the video stream as a fraction e.g. 30000/1001
+	FrameRate string `json:"frameRate"`
+
+	// NFrames the number of frames in the video stream, 0 if the container doesn't say
+	NFrames int `json:"nFrames,omitempty"`
+
+	// Rotation the rotation in degrees the video is shown at, from its display matrix
+	Rotation int `json:"rotation,omitempty"`
+}
+
+// FFProbeIsInstalled returns true and the path of ffprobe if it is installed
+func FFProbeIsInstalled() (bool, string) {
+	path, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return false, ""
+	}
+	return true, path
+}
+
+// Probe returns information about the input video and its first video stream
+func Probe(input string) (*ProbeInfo, error) {
+	if _, err := os.Stat(input); os.IsNotExist(err) {
+		return nil, fmt.Errorf("invalid input, file does not exist: %s", input)
+	}
+
+	if installed, _ := FFProbeIsInstalled(); !installed {
+		return nil, fmt.Errorf("ffprobe is not installed, please install then re-run")
+	}
+
+	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_format",
+		"-show_streams", "-select_streams", "v:0", input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe video: %s, details: %s", err, stderr.String())
+	}
+
+	// ffprobe writes most numbers as strings
+	var out struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+			Size       string `json:"size"`
+			BitRate    string `json:"bit_rate"`
+		} `json:"format"`
+		Streams []struct {
+			CodecName    string `json:"codec_name"`
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+			AvgFrameRate string `json:"avg_frame_rate"`
+			NbFrames     string `json:"nb_frames"`
+			Tags         struct {
+				Rotate string `json:"rotate"`
+			} `json:"tags"`
+			SideDataList []struct {
+				Rotation int `json:"rotation"`
+			} `json:"side_data_list"`
+		} `json:"streams"`
+	}
+	err = json.Unmarshal(stdout.Bytes(), &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %s", err)
+	}
+	if len(out.Streams) == 0 {
+		return nil, fmt.Errorf("no video stream found in: %s", input)
+	}
+
+	s := out.Streams[0]
+	info := &ProbeInfo{
+		Format:    out.Format.FormatName,
+		Codec:     s.CodecName,
+		Width:     s.Width,
+		Height:    s.Height,
+		FrameRate: s.AvgFrameRate,
+	}
+	info.Duration, _ = strconv.ParseFloat(out.Format.Duration, 64)
+	info.Size, _ = strconv.ParseInt(out.Format.Size, 10, 64)
+	info.BitRate, _ = strconv.ParseInt(out.Format.BitRate, 10, 64)
+	info.NFrames, _ = strconv.Atoi(s.NbFrames)
+	if rotate := strings.TrimSpace(s.Tags.Rotate); rotate != "" {
+		info.Rotation, _ = strconv.Atoi(rotate)
+	}
+	for _, d := range s.SideDataList {
+		if d.Rotation != 0 {
+			info.Rotation = d.Rotation
+		}
+	}
+	return info, nil
+}
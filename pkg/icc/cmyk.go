@@ -0,0 +1,613 @@
+package icc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+)
+
+// gridSteps the number of steps per RGB channel in the table used to speed up conversions,
+// colours between steps are interpolated
+const gridSteps = 33
+
+// OutputProfile is a parsed CMYK output profile, used to convert RGB images to CMYK
+type OutputProfile struct {
+	// Data the raw bytes of the profile, to embed in output files
+	Data []byte
+
+	pcs  string
+	pipe pipeline
+
+	// encodeLab converts Lab values to the encoding expected by the pipeline
+	encodeLab func(lab []float64) []float64
+
+	// grid caches the CMYK value of a regular grid of RGB values
+	grid [][4]float64
+}
+
+// pipeline converts a PCS value, encoded in the range 0 to 1, into device values in the range 0 to 1
+type pipeline func(in []float64) []float64
+
+// ParseOutputProfile parses a CMYK output profile. The perceptual BToA0 transform is used,
+// which can be a lut8, lut16 or lutBToA tag
+func ParseOutputProfile(data []byte) (*OutputProfile, error) {
+	if len(data) < 132 {
+		return nil, fmt.Errorf("profile is too short")
+	}
+	if string(data[36:40]) != "acsp" {
+		return nil, fmt.Errorf("not an ICC profile")
+	}
+	if cs := string(data[16:20]); cs != "CMYK" {
+		return nil, fmt.Errorf("profile must be for CMYK devices, found: %q", cs)
+	}
+
+	p := &OutputProfile{Data: data, pcs: string(data[20:24])}
+	if p.pcs != "Lab " && p.pcs != "XYZ " {
+		return nil, fmt.Errorf("unsupported profile connection space: %q", p.pcs)
+	}
+
+	tag, err := findTag(data, "B2A0")
+	if err != nil {
+		return nil, err
+	}
+
+	isXYZ := p.pcs == "XYZ "
+	switch string(tag[0:4]) {
+	case "mft1":
+		p.pipe, err = parseLut(tag, 1, isXYZ)
+		p.encodeLab = func(lab []float64) []float64 { return labLegacy(lab, 1) }
+	case "mft2":
+		p.pipe, err = parseLut(tag, 2, isXYZ)
+		p.encodeLab = func(lab []float64) []float64 { return labLegacy(lab, 2) }
+	case "mBA ":
+		p.pipe, err = parseLutBToA(tag)
+		p.encodeLab = labV4
+	default:
+		err = fmt.Errorf("unsupported BToA0 tag type: %q", string(tag[0:4]))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.buildGrid()
+	return p, nil
+}
+
+// ToCMYK converts an image, which is assumed to be sRGB, to CMYK using the profile
+func (p *OutputProfile) ToCMYK(img image.Image) *image.CMYK {
+	b := img.Bounds()
+	dst := image.NewCMYK(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			cmyk := p.lookup(float64(r>>8)/255, float64(g>>8)/255, float64(bl>>8)/255)
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(math.Floor(math.Max(0, math.Min(1, cmyk[c]))*255 + 0.5))
+			}
+		}
+	}
+	return dst
+}
+
+// NaiveCMYK converts an image to CMYK without a profile, the result will not match the
+// colours of the RGB image when printed but is useful for proofing a workflow
+func NaiveCMYK(img image.Image) *image.CMYK {
+	b := img.Bounds()
+	dst := image.NewCMYK(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			i := dst.PixOffset(x, y)
+			c, m, ye, k := rgbToCMYK(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = c, m, ye, k
+		}
+	}
+	return dst
+}
+
+func rgbToCMYK(r, g, b uint8) (uint8, uint8, uint8, uint8) {
+	max := r
+	if g > max {
+		max = g
+	}
+	if b > max {
+		max = b
+	}
+	if max == 0 {
+		return 0, 0, 0, 255
+	}
+	k := 255 - max
+	c := uint8((int(max-r) * 255) / int(max))
+	m := uint8((int(max-g) * 255) / int(max))
+	y := uint8((int(max-b) * 255) / int(max))
+	return c, m, y, k
+}
+
+func (p *OutputProfile) buildGrid() {
+	p.grid = make([][4]float64, gridSteps*gridSteps*gridSteps)
+	for bi := 0; bi < gridSteps; bi++ {
+		for gi := 0; gi < gridSteps; gi++ {
+			for ri := 0; ri < gridSteps; ri++ {
+				r := float64(ri) / (gridSteps - 1)
+				g := float64(gi) / (gridSteps - 1)
+				b := float64(bi) / (gridSteps - 1)
+				var cmyk [4]float64
+				copy(cmyk[:], p.convert(r, g, b))
+				p.grid[ri+gi*gridSteps+bi*gridSteps*gridSteps] = cmyk
+			}
+		}
+	}
+}
+
+// lookup trilinearly interpolates the cached grid
+func (p *OutputProfile) lookup(r, g, b float64) [4]float64 {
+	split := func(v float64) (int, int, float64) {
+		f := v * (gridSteps - 1)
+		i := int(f)
+		if i >= gridSteps-1 {
+			return gridSteps - 1, gridSteps - 1, 0
+		}
+		return i, i + 1, f - float64(i)
+	}
+	r0, r1, fr := split(r)
+	g0, g1, fg := split(g)
+	b0, b1, fb := split(b)
+	at := func(r, g, b int) [4]float64 {
+		return p.grid[r+g*gridSteps+b*gridSteps*gridSteps]
+	}
+
+	var out [4]float64
+	for c := 0; c < 4; c++ {
+		c00 := at(r0, g0, b0)[c]*(1-fr) + at(r1, g0, b0)[c]*fr
+		c10 := at(r0, g1, b0)[c]*(1-fr) + at(r1, g1, b0)[c]*fr
+		c01 := at(r0, g0, b1)[c]*(1-fr) + at(r1, g0, b1)[c]*fr
+		c11 := at(r0, g1, b1)[c]*(1-fr) + at(r1, g1, b1)[c]*fr
+		out[c] = (c00*(1-fg)+c10*fg)*(1-fb) + (c01*(1-fg)+c11*fg)*fb
+	}
+	return out
+}
+
+// convert runs a single sRGB value, 0 to 1, through the profile
+func (p *OutputProfile) convert(r, g, b float64) []float64 {
+	xyz := mul(srgbToXYZ, [3]float64{srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)})
+	if p.pcs == "XYZ " {
+		// XYZ is encoded as u1Fixed15, 1.0 maps to 0x8000
+		return p.pipe([]float64{
+			xyz[0] * 32768 / 65535,
+			xyz[1] * 32768 / 65535,
+			xyz[2] * 32768 / 65535,
+		})
+	}
+
+	l, a, bb := xyzToLab(xyz)
+	return p.pipe(p.encodeLab([]float64{l, a, bb}))
+}
+
+// xyzToLab returns CIE Lab values, L 0 to 100, a and b roughly -128 to 127
+func xyzToLab(xyz [3]float64) (float64, float64, float64) {
+	f := func(t float64) float64 {
+		if t > 216.0/24389.0 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27.0*t + 16) / 116
+	}
+	fx := f(xyz[0] / d50[0])
+	fy := f(xyz[1] / d50[1])
+	fz := f(xyz[2] / d50[2])
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+func findTag(data []byte, sig string) ([]byte, error) {
+	count := int(binary.BigEndian.Uint32(data[128:132]))
+	for i := 0; i < count; i++ {
+		entry := 132 + i*12
+		if entry+12 > len(data) {
+			break
+		}
+		if string(data[entry:entry+4]) != sig {
+			continue
+		}
+		offset := int(binary.BigEndian.Uint32(data[entry+4:]))
+		size := int(binary.BigEndian.Uint32(data[entry+8:]))
+		if offset+size > len(data) || size < 8 {
+			return nil, fmt.Errorf("tag %s is out of bounds", sig)
+		}
+		return data[offset : offset+size], nil
+	}
+	return nil, fmt.Errorf("profile does not contain a %s tag", sig)
+}
+
+// labLegacy encodes Lab using the v2 encoding used by lut8 and lut16 tags
+func labLegacy(lab []float64, bytesPerValue int) []float64 {
+	if bytesPerValue == 1 {
+		return []float64{lab[0] / 100, (lab[1] + 128) / 255, (lab[2] + 128) / 255}
+	}
+	return []float64{
+		lab[0] * 652.80 / 65535,
+		(lab[1] + 128) * 256 / 65535,
+		(lab[2] + 128) * 256 / 65535,
+	}
+}
+
+// labV4 encodes Lab using the v4 encoding used by lutBToA tags
+func labV4(lab []float64) []float64 {
+	return []float64{lab[0] / 100, (lab[1] + 128) / 255, (lab[2] + 128) / 255}
+}
+
+// parseLut parses a lut8Type (bytesPerValue 1) or lut16Type (bytesPerValue 2) tag
+func parseLut(tag []byte, bytesPerValue int, isXYZ bool) (pipeline, error) {
+	if len(tag) < 52 {
+		return nil, fmt.Errorf("lut tag is too short")
+	}
+	inChan := int(tag[8])
+	outChan := int(tag[9])
+	grid := int(tag[10])
+	if inChan != 3 || outChan != 4 || grid < 2 {
+		return nil, fmt.Errorf("lut must have 3 inputs and 4 outputs")
+	}
+
+	var matrix [3][3]float64
+	for i := 0; i < 9; i++ {
+		matrix[i/3][i%3] = s15Fixed16(tag[12+i*4:])
+	}
+
+	inEntries, outEntries := 256, 256
+	pos := 48
+	if bytesPerValue == 2 {
+		inEntries = int(binary.BigEndian.Uint16(tag[48:]))
+		outEntries = int(binary.BigEndian.Uint16(tag[50:]))
+		pos = 52
+	}
+
+	read := func(n int) ([]float64, error) {
+		if pos+n*bytesPerValue > len(tag) {
+			return nil, fmt.Errorf("lut tag is too short")
+		}
+		v := make([]float64, n)
+		for i := range v {
+			if bytesPerValue == 1 {
+				v[i] = float64(tag[pos+i]) / 255
+			} else {
+				v[i] = float64(binary.BigEndian.Uint16(tag[pos+i*2:])) / 65535
+			}
+		}
+		pos += n * bytesPerValue
+		return v, nil
+	}
+
+	inCurves := make([][]float64, inChan)
+	for i := range inCurves {
+		c, err := read(inEntries)
+		if err != nil {
+			return nil, err
+		}
+		inCurves[i] = c
+	}
+	clutValues, err := read(int(math.Pow(float64(grid), float64(inChan))) * outChan)
+	if err != nil {
+		return nil, err
+	}
+	outCurves := make([][]float64, outChan)
+	for i := range outCurves {
+		c, err := read(outEntries)
+		if err != nil {
+			return nil, err
+		}
+		outCurves[i] = c
+	}
+
+	c := clut{grid: []int{grid, grid, grid}, outChan: outChan, values: clutValues}
+
+	return func(in []float64) []float64 {
+		v := []float64{in[0], in[1], in[2]}
+		if isXYZ {
+			// The matrix is only applied when the PCS is XYZ
+			m := mul(matrix, [3]float64{v[0], v[1], v[2]})
+			for i := range m {
+				v[i] = clamp01(m[i])
+			}
+		}
+		for i := range v {
+			v[i] = tableCurve(inCurves[i], v[i])
+		}
+		out := c.interpolate(v)
+		for i := range out {
+			out[i] = tableCurve(outCurves[i], out[i])
+		}
+		return out
+	}, nil
+}
+
+// parseLutBToA parses a lutBToAType tag
+func parseLutBToA(tag []byte) (pipeline, error) {
+	if len(tag) < 32 {
+		return nil, fmt.Errorf("lutBToA tag is too short")
+	}
+	inChan := int(tag[8])
+	outChan := int(tag[9])
+	if inChan != 3 || outChan != 4 {
+		return nil, fmt.Errorf("lutBToA must have 3 inputs and 4 outputs")
+	}
+
+	offB := int(binary.BigEndian.Uint32(tag[12:]))
+	offMatrix := int(binary.BigEndian.Uint32(tag[16:]))
+	offM := int(binary.BigEndian.Uint32(tag[20:]))
+	offCLUT := int(binary.BigEndian.Uint32(tag[24:]))
+	offA := int(binary.BigEndian.Uint32(tag[28:]))
+
+	var err error
+	var bCurves, mCurves, aCurves []curve
+	if offB != 0 {
+		if bCurves, err = parseCurves(tag, offB, inChan); err != nil {
+			return nil, err
+		}
+	}
+	if offM != 0 {
+		if mCurves, err = parseCurves(tag, offM, inChan); err != nil {
+			return nil, err
+		}
+	}
+	if offA != 0 {
+		if aCurves, err = parseCurves(tag, offA, outChan); err != nil {
+			return nil, err
+		}
+	}
+
+	var matrix [3][3]float64
+	var offset [3]float64
+	hasMatrix := offMatrix != 0
+	if hasMatrix {
+		if offMatrix+48 > len(tag) {
+			return nil, fmt.Errorf("lutBToA matrix is out of bounds")
+		}
+		for i := 0; i < 9; i++ {
+			matrix[i/3][i%3] = s15Fixed16(tag[offMatrix+i*4:])
+		}
+		for i := 0; i < 3; i++ {
+			offset[i] = s15Fixed16(tag[offMatrix+36+i*4:])
+		}
+	}
+
+	if offCLUT == 0 {
+		return nil, fmt.Errorf("lutBToA without a CLUT is not supported")
+	}
+	c, err := parseCLUT(tag, offCLUT, inChan, outChan)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(in []float64) []float64 {
+		v := append([]float64(nil), in...)
+		applyCurves(bCurves, v)
+		if hasMatrix {
+			m := mul(matrix, [3]float64{v[0], v[1], v[2]})
+			for i := range m {
+				v[i] = clamp01(m[i] + offset[i])
+			}
+		}
+		applyCurves(mCurves, v)
+		out := c.interpolate(v)
+		applyCurves(aCurves, out)
+		return out
+	}, nil
+}
+
+type clut struct {
+	grid    []int
+	outChan int
+	values  []float64
+}
+
+// interpolate performs multilinear interpolation, the first input varies least rapidly
+func (c clut) interpolate(in []float64) []float64 {
+	n := len(in)
+	lo := make([]int, n)
+	frac := make([]float64, n)
+	for i := range in {
+		f := clamp01(in[i]) * float64(c.grid[i]-1)
+		lo[i] = int(f)
+		if lo[i] >= c.grid[i]-1 {
+			lo[i] = c.grid[i] - 1
+			frac[i] = 0
+		} else {
+			frac[i] = f - float64(lo[i])
+		}
+	}
+
+	out := make([]float64, c.outChan)
+	for corner := 0; corner < 1<<uint(n); corner++ {
+		weight := 1.0
+		index := 0
+		for i := 0; i < n; i++ {
+			idx := lo[i]
+			if corner&(1<<uint(n-1-i)) != 0 {
+				if frac[i] == 0 {
+					weight = 0
+					break
+				}
+				idx++
+				weight *= frac[i]
+			} else {
+				weight *= 1 - frac[i]
+			}
+			index = index*c.grid[i] + idx
+		}
+		if weight == 0 {
+			continue
+		}
+		for o := 0; o < c.outChan; o++ {
+			out[o] += weight * c.values[index*c.outChan+o]
+		}
+	}
+	return out
+}
+
+func parseCLUT(tag []byte, offset, inChan, outChan int) (clut, error) {
+	if offset+20 > len(tag) {
+		return clut{}, fmt.Errorf("clut is out of bounds")
+	}
+	c := clut{outChan: outChan}
+	total := 1
+	for i := 0; i < inChan; i++ {
+		g := int(tag[offset+i])
+		if g < 2 {
+			return clut{}, fmt.Errorf("clut grid must have at least 2 points")
+		}
+		c.grid = append(c.grid, g)
+		total *= g
+	}
+
+	precision := int(tag[offset+16])
+	if precision != 1 && precision != 2 {
+		return clut{}, fmt.Errorf("invalid clut precision: %d", precision)
+	}
+	pos := offset + 20
+	n := total * outChan
+	if pos+n*precision > len(tag) {
+		return clut{}, fmt.Errorf("clut is out of bounds")
+	}
+	c.values = make([]float64, n)
+	for i := range c.values {
+		if precision == 1 {
+			c.values[i] = float64(tag[pos+i]) / 255
+		} else {
+			c.values[i] = float64(binary.BigEndian.Uint16(tag[pos+i*2:])) / 65535
+		}
+	}
+	return c, nil
+}
+
+type curve func(float64) float64
+
+func applyCurves(curves []curve, v []float64) {
+	for i := range curves {
+		if i < len(v) {
+			v[i] = clamp01(curves[i](v[i]))
+		}
+	}
+}
+
+// parseCurves parses n consecutive curv or para curves starting at offset
+func parseCurves(tag []byte, offset, n int) ([]curve, error) {
+	var curves []curve
+	pos := offset
+	for i := 0; i < n; i++ {
+		if pos+12 > len(tag) {
+			return nil, fmt.Errorf("curve is out of bounds")
+		}
+
+		var c curve
+		var size int
+		switch string(tag[pos : pos+4]) {
+		case "curv":
+			count := int(binary.BigEndian.Uint32(tag[pos+8:]))
+			size = 12 + count*2
+			if pos+size > len(tag) {
+				return nil, fmt.Errorf("curve is out of bounds")
+			}
+			switch count {
+			case 0:
+				c = func(v float64) float64 { return v }
+			case 1:
+				gamma := float64(binary.BigEndian.Uint16(tag[pos+12:])) / 256
+				c = func(v float64) float64 { return math.Pow(v, gamma) }
+			default:
+				table := make([]float64, count)
+				for j := range table {
+					table[j] = float64(binary.BigEndian.Uint16(tag[pos+12+j*2:])) / 65535
+				}
+				c = func(v float64) float64 { return tableCurve(table, v) }
+			}
+		case "para":
+			fn := int(binary.BigEndian.Uint16(tag[pos+8:]))
+			nParams := []int{1, 3, 4, 5, 7}
+			if fn >= len(nParams) {
+				return nil, fmt.Errorf("unsupported parametric curve: %d", fn)
+			}
+			size = 12 + nParams[fn]*4
+			if pos+size > len(tag) {
+				return nil, fmt.Errorf("curve is out of bounds")
+			}
+			p := make([]float64, 7)
+			for j := 0; j < nParams[fn]; j++ {
+				p[j] = s15Fixed16(tag[pos+12+j*4:])
+			}
+			c = paraCurve(fn, p)
+		default:
+			return nil, fmt.Errorf("unsupported curve type: %q", string(tag[pos:pos+4]))
+		}
+
+		curves = append(curves, c)
+		pos += size
+		for pos%4 != 0 {
+			pos++
+		}
+	}
+	return curves, nil
+}
+
+func paraCurve(fn int, p []float64) curve {
+	g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+	pow := func(v float64) float64 {
+		if v <= 0 {
+			return 0
+		}
+		return math.Pow(v, g)
+	}
+	switch fn {
+	case 0:
+		return func(x float64) float64 { return pow(x) }
+	case 1:
+		return func(x float64) float64 {
+			if x >= -b/a {
+				return pow(a*x + b)
+			}
+			return 0
+		}
+	case 2:
+		return func(x float64) float64 {
+			if x >= -b/a {
+				return pow(a*x+b) + c
+			}
+			return c
+		}
+	case 3:
+		return func(x float64) float64 {
+			if x >= d {
+				return pow(a*x + b)
+			}
+			return c * x
+		}
+	default:
+		return func(x float64) float64 {
+			if x >= d {
+				return pow(a*x+b) + e
+			}
+			return c*x + f
+		}
+	}
+}
+
+// tableCurve linearly interpolates a table of evenly spaced values
+func tableCurve(table []float64, v float64) float64 {
+	if len(table) == 0 {
+		return v
+	}
+	f := clamp01(v) * float64(len(table)-1)
+	i := int(f)
+	if i >= len(table)-1 {
+		return table[len(table)-1]
+	}
+	t := f - float64(i)
+	return table[i]*(1-t) + table[i+1]*t
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
@@ -0,0 +1,255 @@
+package icc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// testGrid the number of grid points per channel in the test profiles
+const testGrid = 5
+
+// testCLUT returns a CLUT indexed by encoded Lab that maps a to cyan, b to magenta and L to
+// the inverse of black, it is linear so interpolating it is exact
+func testCLUT() []byte {
+	var v []byte
+	for l := 0; l < testGrid; l++ {
+		for a := 0; a < testGrid; a++ {
+			for b := 0; b < testGrid; b++ {
+				step := func(i int) byte { return byte(i * 255 / (testGrid - 1)) }
+				v = append(v, step(a), step(b), 0, 255-step(l))
+			}
+		}
+	}
+	return v
+}
+
+// testLut8 returns a lut8 BToA tag with identity curves around testCLUT
+func testLut8() []byte {
+	var b bytes.Buffer
+	b.WriteString("mft1")
+	b.Write(make([]byte, 4))
+	b.Write([]byte{3, 4, testGrid, 0})
+	for i := 0; i < 9; i++ {
+		m := int32(0)
+		if i%4 == 0 {
+			m = 1 << 16
+		}
+		binary.Write(&b, binary.BigEndian, m)
+	}
+	identity := make([]byte, 256)
+	for i := range identity {
+		identity[i] = byte(i)
+	}
+	for i := 0; i < 3; i++ {
+		b.Write(identity)
+	}
+	b.Write(testCLUT())
+	for i := 0; i < 4; i++ {
+		b.Write(identity)
+	}
+	return b.Bytes()
+}
+
+// testLutBToA returns a lutBToA tag with only a CLUT, the same CLUT as testLut8
+func testLutBToA() []byte {
+	var b bytes.Buffer
+	b.WriteString("mBA ")
+	b.Write(make([]byte, 4))
+	b.Write([]byte{3, 4, 0, 0})
+	// The B, matrix, M, CLUT and A offsets, only the CLUT is present
+	binary.Write(&b, binary.BigEndian, [5]uint32{0, 0, 0, 32, 0})
+	grid := make([]byte, 16)
+	grid[0], grid[1], grid[2] = testGrid, testGrid, testGrid
+	b.Write(grid)
+	b.Write([]byte{1, 0, 0, 0})
+	b.Write(testCLUT())
+	return b.Bytes()
+}
+
+// testProfile returns a CMYK output profile with the tag as its B2A0 tag
+func testProfile(pcs string, b2a0 []byte) []byte {
+	const offset = 128 + 4 + 12
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(offset+len(b2a0)))
+	b.Write(make([]byte, 4))
+	binary.Write(&b, binary.BigEndian, uint32(0x02100000))
+	b.WriteString("prtr")
+	b.WriteString("CMYK")
+	b.WriteString(pcs)
+	b.Write(make([]byte, 12))
+	b.WriteString("acsp")
+	b.Write(make([]byte, 128-b.Len()))
+	binary.Write(&b, binary.BigEndian, uint32(1))
+	b.WriteString("B2A0")
+	binary.Write(&b, binary.BigEndian, [2]uint32{offset, uint32(len(b2a0))})
+	b.Write(b2a0)
+	return b.Bytes()
+}
+
+func TestToCMYK(t *testing.T) {
+	// In D50 Lab white is 100 0 0, black 0 0 0, sRGB red about 54.3 80.8 69.9 and sRGB blue
+	// about 29.6 68.3 -112.0
+	tests := []struct {
+		in   color.NRGBA
+		want color.CMYK
+	}{
+		{color.NRGBA{255, 255, 255, 255}, color.CMYK{128, 128, 0, 0}},
+		{color.NRGBA{0, 0, 0, 255}, color.CMYK{128, 128, 0, 255}},
+		{color.NRGBA{255, 0, 0, 255}, color.CMYK{209, 198, 0, 117}},
+		{color.NRGBA{0, 0, 255, 255}, color.CMYK{196, 16, 0, 180}},
+	}
+
+	for _, tag := range []struct {
+		name string
+		data []byte
+	}{{"lut8", testLut8()}, {"lutBToA", testLutBToA()}} {
+		p, err := ParseOutputProfile(testProfile("Lab ", tag.data))
+		if err != nil {
+			t.Fatalf("%s: %s", tag.name, err)
+		}
+
+		img := image.NewNRGBA(image.Rect(0, 0, len(tests), 1))
+		for i, tt := range tests {
+			img.SetNRGBA(i, 0, tt.in)
+		}
+		out := p.ToCMYK(img)
+		for i, tt := range tests {
+			got := out.CMYKAt(i, 0)
+			// The profile is sampled on a grid and interpolated, so allow a small error
+			if !near(got.C, tt.want.C) || !near(got.M, tt.want.M) || !near(got.Y, tt.want.Y) || !near(got.K, tt.want.K) {
+				t.Errorf("%s: %v got %v, want %v", tag.name, tt.in, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestNaiveCMYK(t *testing.T) {
+	tests := []struct {
+		in   color.NRGBA
+		want color.CMYK
+	}{
+		{color.NRGBA{255, 255, 255, 255}, color.CMYK{0, 0, 0, 0}},
+		{color.NRGBA{0, 0, 0, 255}, color.CMYK{0, 0, 0, 255}},
+		{color.NRGBA{255, 0, 0, 255}, color.CMYK{0, 255, 255, 0}},
+		{color.NRGBA{0, 128, 64, 255}, color.CMYK{255, 0, 127, 127}},
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, len(tests), 1))
+	for i, tt := range tests {
+		img.SetNRGBA(i, 0, tt.in)
+	}
+	out := NaiveCMYK(img)
+	for i, tt := range tests {
+		if got := out.CMYKAt(i, 0); got != tt.want {
+			t.Errorf("%v got %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseOutputProfileErrors(t *testing.T) {
+	valid := testProfile("Lab ", testLut8())
+	edit := func(at int, b string) []byte {
+		p := append([]byte(nil), valid...)
+		copy(p[at:], b)
+		return p
+	}
+	badCurve := testLutBToA()
+	binary.BigEndian.PutUint32(badCurve[12:], 32)
+
+	tests := []struct {
+		name    string
+		profile []byte
+		err     string
+	}{
+		{"empty", nil, "profile is too short"},
+		{"header only", valid[:100], "profile is too short"},
+		{"not icc", edit(36, "x"), "not an ICC profile"},
+		{"rgb", edit(16, "RGB "), `profile must be for CMYK devices, found: "RGB "`},
+		{"pcs", edit(20, "Luv "), `unsupported profile connection space: "Luv "`},
+		{"no tag", edit(132, "A2B0"), "profile does not contain a B2A0 tag"},
+		{"tag out of bounds", valid[:len(valid)-1], "tag B2A0 is out of bounds"},
+		{"tag type", edit(144, "curv"), `unsupported BToA0 tag type: "curv"`},
+		{"lut channels", edit(144+9, "\x03"), "lut must have 3 inputs and 4 outputs"},
+		{"lutBToA short", testProfile("Lab ", append(testLutBToA()[:9], 1)), "lutBToA tag is too short"},
+		{"lutBToA channels", testProfile("Lab ", setByte(testLutBToA(), 9, 3)), "lutBToA must have 3 inputs and 4 outputs"},
+		{"lutBToA curve", testProfile("Lab ", badCurve), `unsupported curve type: "\x05\x05\x05\x00"`},
+		{"clut short", testProfile("Lab ", testLutBToA()[:60]), "clut is out of bounds"},
+		{"clut precision", testProfile("Lab ", setByte(testLutBToA(), 48, 3)), "invalid clut precision: 3"},
+		{"clut grid", testProfile("Lab ", setByte(testLutBToA(), 33, 1)), "clut grid must have at least 2 points"},
+		{"rgb profile", mustRGBProfile(t, SRGB), `profile must be for CMYK devices, found: "RGB "`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseOutputProfile(tt.profile)
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseOutputProfileMalformed(t *testing.T) {
+	profiles := [][]byte{
+		testProfile("Lab ", testLut8()),
+		testProfile("Lab ", testLutBToA()),
+		testProfile("XYZ ", testLut8()),
+	}
+
+	// Every truncation must fail cleanly, the tag is sized by the profile so a short one is
+	// out of bounds
+	for _, p := range profiles {
+		for n := 0; n < len(p); n++ {
+			if _, err := parseNoPanic(p[:n]); err == nil {
+				t.Fatalf("profile truncated to %d bytes parsed without an error", n)
+			}
+		}
+	}
+
+	// Corrupting bytes may still give a usable profile, but must never panic
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		p := append([]byte(nil), profiles[i%len(profiles)]...)
+		for j := 0; j < 1+r.Intn(4); j++ {
+			p[128+r.Intn(len(p)-128)] = byte(r.Intn(256))
+		}
+		if _, err := parseNoPanic(p); err != nil && err.Error()[:5] == "panic" {
+			t.Fatal(err)
+		}
+	}
+}
+
+// parseNoPanic parses the profile and converts a colour with it, returning any panic as an error
+func parseNoPanic(data []byte) (p *OutputProfile, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	p, err = ParseOutputProfile(data)
+	if err == nil {
+		p.ToCMYK(image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+	}
+	return p, err
+}
+
+// setByte sets a byte of the tag
+func setByte(tag []byte, at int, v byte) []byte {
+	tag[at] = v
+	return tag
+}
+
+func mustRGBProfile(t *testing.T, name string) []byte {
+	p, ok := RGBProfile(name)
+	if !ok {
+		t.Fatalf("missing builtin profile: %s", name)
+	}
+	return p
+}
+
+func near(a, b uint8) bool {
+	return int(a)-int(b) <= 2 && int(b)-int(a) <= 2
+}
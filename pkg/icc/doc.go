@@ -0,0 +1,7 @@
+package icc
+
+/*
+Package icc provides the ICC colour profiles needed for print output, builtin sRGB and
+Adobe RGB profiles, conversion to CMYK using an ICC output profile and helpers to embed
+profiles in JPEG and PNG files
+*/
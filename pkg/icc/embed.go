@@ -0,0 +1,93 @@
+package icc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// jpegICCChunk the maximum number of profile bytes in a single APP2 segment, segments are
+// limited to 65535 bytes including the length, the ICC_PROFILE identifier and sequence bytes
+const jpegICCChunk = 65519
+
+// EmbedInJPEG returns a copy of the encoded JPEG with the profile inserted as APP2 segments
+// directly after the start of image marker, or after the JFIF APP0 segment if present
+func EmbedInJPEG(jpg, profile []byte) ([]byte, error) {
+	if len(jpg) < 4 || jpg[0] != 0xFF || jpg[1] != 0xD8 {
+		return nil, fmt.Errorf("not a JPEG file")
+	}
+
+	// Keep the JFIF segment first, some readers require it
+	insertAt := 2
+	if jpg[2] == 0xFF && jpg[3] == 0xE0 && len(jpg) >= 6 {
+		insertAt = 4 + int(binary.BigEndian.Uint16(jpg[4:6]))
+		if insertAt > len(jpg) {
+			return nil, fmt.Errorf("JFIF segment is out of bounds")
+		}
+	}
+
+	nChunks := (len(profile) + jpegICCChunk - 1) / jpegICCChunk
+	if nChunks > 255 {
+		return nil, fmt.Errorf("profile is too large to embed in a JPEG")
+	}
+
+	var b bytes.Buffer
+	b.Write(jpg[:insertAt])
+	for i := 0; i < nChunks; i++ {
+		chunk := profile[i*jpegICCChunk:]
+		if len(chunk) > jpegICCChunk {
+			chunk = chunk[:jpegICCChunk]
+		}
+		b.Write([]byte{0xFF, 0xE2})
+		binary.Write(&b, binary.BigEndian, uint16(2+12+2+len(chunk)))
+		b.WriteString("ICC_PROFILE\x00")
+		b.WriteByte(byte(i + 1))
+		b.WriteByte(byte(nChunks))
+		b.Write(chunk)
+	}
+	b.Write(jpg[insertAt:])
+	return b.Bytes(), nil
+}
+
+// EmbedInPNG returns a copy of the encoded PNG with the profile inserted as an iCCP chunk
+// directly after the IHDR chunk
+func EmbedInPNG(png, profile []byte, name string) ([]byte, error) {
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(profile)
+	w.Close()
+
+	var data bytes.Buffer
+	data.WriteString(name)
+	// Null separator followed by the compression method, 0 is zlib
+	data.Write([]byte{0, 0})
+	data.Write(compressed.Bytes())
+	return InsertPNGChunk(png, "iCCP", data.Bytes())
+}
+
+// InsertPNGChunk returns a copy of the encoded PNG with the chunk inserted directly after
+// the IHDR chunk
+func InsertPNGChunk(png []byte, chunkType string, data []byte) ([]byte, error) {
+	const sigLen = 8
+	if len(png) < sigLen+8 || string(png[1:4]) != "PNG" || string(png[sigLen+4:sigLen+8]) != "IHDR" {
+		return nil, fmt.Errorf("not a PNG file")
+	}
+	ihdrEnd := sigLen + 12 + int(binary.BigEndian.Uint32(png[sigLen:]))
+	if ihdrEnd > len(png) {
+		return nil, fmt.Errorf("IHDR chunk is out of bounds")
+	}
+
+	var b bytes.Buffer
+	b.Write(png[:ihdrEnd])
+	binary.Write(&b, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(data)
+	b.WriteString(chunkType)
+	b.Write(data)
+	binary.Write(&b, binary.BigEndian, crc.Sum32())
+	b.Write(png[ihdrEnd:])
+	return b.Bytes(), nil
+}
@@ -0,0 +1,195 @@
+package icc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func testImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 16), uint8(y * 32), 128, 255})
+		}
+	}
+	return img
+}
+
+// withJFIF returns the JPEG with a JFIF segment after the start of image marker, image/jpeg
+// doesn't write one
+func withJFIF(jpg []byte) []byte {
+	app0 := []byte{0xFF, 0xE0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 1, 0, 72, 0, 72, 0, 0}
+	return append(append(append([]byte(nil), jpg[:2]...), app0...), jpg[2:]...)
+}
+
+// testProfileBytes returns n bytes standing in for a profile, large profiles are split
+// across several JPEG segments
+func testProfileBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+// jpegProfile reassembles the profile from the APP2 segments of a JPEG
+func jpegProfile(t *testing.T, jpg []byte) []byte {
+	chunks := make(map[int][]byte)
+	total := 0
+	pos := 2
+	for pos+4 <= len(jpg) && jpg[pos] == 0xFF && jpg[pos+1] != 0xDA {
+		marker := jpg[pos+1]
+		size := int(binary.BigEndian.Uint16(jpg[pos+2:]))
+		seg := jpg[pos+4 : pos+2+size]
+		if marker == 0xE2 && bytes.HasPrefix(seg, []byte("ICC_PROFILE\x00")) {
+			chunks[int(seg[12])] = seg[14:]
+			total = int(seg[13])
+		}
+		pos += 2 + size
+	}
+	var p []byte
+	for i := 1; i <= total; i++ {
+		c, ok := chunks[i]
+		if !ok {
+			t.Fatalf("missing profile chunk %d of %d", i, total)
+		}
+		p = append(p, c...)
+	}
+	return p
+}
+
+func TestEmbedInJPEG(t *testing.T) {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, jfif := range []bool{false, true} {
+		jpg := b.Bytes()
+		if jfif {
+			jpg = withJFIF(jpg)
+		}
+		for _, n := range []int{0, 560, jpegICCChunk, jpegICCChunk + 1, 3*jpegICCChunk + 7} {
+			profile := testProfileBytes(n)
+			out, err := EmbedInJPEG(jpg, profile)
+			if err != nil {
+				t.Fatalf("%d bytes: %s", n, err)
+			}
+
+			if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+				t.Fatalf("%d bytes: failed to decode: %s", n, err)
+			}
+			// The JFIF segment stays first
+			if jfif && string(out[2:4]) != "\xFF\xE0" {
+				t.Fatalf("%d bytes: the JFIF segment is not first", n)
+			}
+			if got := jpegProfile(t, out); !bytes.Equal(got, profile) {
+				t.Fatalf("%d bytes: got a %d byte profile back", n, len(got))
+			}
+		}
+	}
+}
+
+func TestEmbedInJPEGErrors(t *testing.T) {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	jpg := b.Bytes()
+
+	tests := []struct {
+		name    string
+		jpg     []byte
+		profile []byte
+		err     string
+	}{
+		{"empty", nil, nil, "not a JPEG file"},
+		{"png", []byte("\x89PNG\r\n\x1a\n"), nil, "not a JPEG file"},
+		{"truncated JFIF", withJFIF(jpg)[:10], nil, "JFIF segment is out of bounds"},
+		{"too large", jpg, make([]byte, 256*jpegICCChunk), "profile is too large to embed in a JPEG"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := EmbedInJPEG(tt.jpg, tt.profile)
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestEmbedInPNG(t *testing.T) {
+	var b bytes.Buffer
+	if err := png.Encode(&b, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	profile := mustRGBProfile(t, AdobeRGB)
+	out, err := EmbedInPNG(b.Bytes(), profile, "Adobe RGB")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoding checks the CRC of every chunk
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+
+	// The iCCP chunk comes straight after IHDR
+	pos := 8 + 12 + int(binary.BigEndian.Uint32(out[8:]))
+	size := int(binary.BigEndian.Uint32(out[pos:]))
+	if got := string(out[pos+4 : pos+8]); got != "iCCP" {
+		t.Fatalf("got a %q chunk after IHDR, want iCCP", got)
+	}
+	data := out[pos+8 : pos+8+size]
+	name := bytes.IndexByte(data, 0)
+	if got := string(data[:name]); got != "Adobe RGB" {
+		t.Fatalf("got profile name %q", got)
+	}
+	if data[name+1] != 0 {
+		t.Fatalf("got compression method %d, want 0", data[name+1])
+	}
+	r, err := zlib.NewReader(bytes.NewReader(data[name+2:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, profile) {
+		t.Fatalf("got a %d byte profile back, want %d bytes", len(got), len(profile))
+	}
+}
+
+func TestInsertPNGChunkErrors(t *testing.T) {
+	var b bytes.Buffer
+	if err := png.Encode(&b, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	bad := append([]byte(nil), b.Bytes()[:40]...)
+	binary.BigEndian.PutUint32(bad[8:], 1000)
+
+	tests := []struct {
+		name string
+		png  []byte
+		err  string
+	}{
+		{"empty", nil, "not a PNG file"},
+		{"jpeg", []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF\x00\x01\x01\x00"), "not a PNG file"},
+		{"truncated IHDR", b.Bytes()[:20], "IHDR chunk is out of bounds"},
+		{"IHDR length", bad, "IHDR chunk is out of bounds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := InsertPNGChunk(tt.png, "pHYs", make([]byte, 9))
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package icc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math"
+)
+
+// Names of the builtin RGB profiles
+const (
+	SRGB     = "srgb"
+	AdobeRGB = "adobergb"
+)
+
+// d50 the PCS illuminant, all colorants are chromatically adapted to it
+var d50 = [3]float64{0.9642, 1.0, 0.8249}
+
+// srgbToXYZ converts linear sRGB to D50 adapted XYZ, the columns are the sRGB colorants
+var srgbToXYZ = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// adobeRGBToXYZ converts linear Adobe RGB (1998) to D50 adapted XYZ
+var adobeRGBToXYZ = [3][3]float64{
+	{0.6097559, 0.2052401, 0.1492240},
+	{0.3111242, 0.6256560, 0.0632197},
+	{0.0194811, 0.0608902, 0.7448387},
+}
+
+// adobeRGBGamma the gamma of the Adobe RGB (1998) tone curve, 563/256
+const adobeRGBGamma = 2.19921875
+
+// RGBProfile returns the bytes of one of the builtin RGB profiles, either SRGB or AdobeRGB
+func RGBProfile(name string) ([]byte, bool) {
+	switch name {
+	case SRGB:
+		curve := make([]uint16, 1024)
+		for i := range curve {
+			curve[i] = uint16(math.Floor(srgbToLinear(float64(i)/float64(len(curve)-1))*65535 + 0.5))
+		}
+		return buildRGBProfile("sRGB IEC61966-2.1", srgbToXYZ, curve), true
+	case AdobeRGB:
+		// A single entry curve is a gamma value encoded as u8Fixed8
+		curve := []uint16{uint16(adobeRGBGamma * 256)}
+		return buildRGBProfile("Adobe RGB (1998) compatible", adobeRGBToXYZ, curve), true
+	default:
+		return nil, false
+	}
+}
+
+// buildRGBProfile creates a v2 matrix/TRC display profile
+func buildRGBProfile(desc string, toXYZ [3][3]float64, curve []uint16) []byte {
+	type tag struct {
+		sig  string
+		data []byte
+	}
+
+	xyz := func(x, y, z float64) []byte {
+		var b bytes.Buffer
+		b.WriteString("XYZ ")
+		b.Write(make([]byte, 4))
+		for _, v := range []float64{x, y, z} {
+			binary.Write(&b, binary.BigEndian, toS15Fixed16(v))
+		}
+		return b.Bytes()
+	}
+
+	var descData bytes.Buffer
+	descData.WriteString("desc")
+	descData.Write(make([]byte, 4))
+	binary.Write(&descData, binary.BigEndian, uint32(len(desc)+1))
+	descData.WriteString(desc)
+	descData.WriteByte(0)
+	// Empty unicode and scriptcode descriptions
+	descData.Write(make([]byte, 4+4+2+1+67))
+
+	var cprt bytes.Buffer
+	cprt.WriteString("text")
+	cprt.Write(make([]byte, 4))
+	cprt.WriteString("No copyright, use freely")
+	cprt.WriteByte(0)
+
+	var trc bytes.Buffer
+	trc.WriteString("curv")
+	trc.Write(make([]byte, 4))
+	binary.Write(&trc, binary.BigEndian, uint32(len(curve)))
+	binary.Write(&trc, binary.BigEndian, curve)
+
+	tags := []tag{
+		{"desc", descData.Bytes()},
+		{"cprt", cprt.Bytes()},
+		{"wtpt", xyz(d50[0], d50[1], d50[2])},
+		{"rXYZ", xyz(toXYZ[0][0], toXYZ[1][0], toXYZ[2][0])},
+		{"gXYZ", xyz(toXYZ[0][1], toXYZ[1][1], toXYZ[2][1])},
+		{"bXYZ", xyz(toXYZ[0][2], toXYZ[1][2], toXYZ[2][2])},
+		{"rTRC", trc.Bytes()},
+		{"gTRC", trc.Bytes()},
+		{"bTRC", trc.Bytes()},
+	}
+
+	// Lay out the tag data after the header and tag table, each tag 4 byte aligned
+	offset := 128 + 4 + 12*len(tags)
+	offsets := make([]int, len(tags))
+	var data bytes.Buffer
+	for i, t := range tags {
+		offsets[i] = offset + data.Len()
+		data.Write(t.data)
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+	}
+	size := offset + data.Len()
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(size))
+	b.Write(make([]byte, 4))
+	binary.Write(&b, binary.BigEndian, uint32(0x02100000))
+	b.WriteString("mntr")
+	b.WriteString("RGB ")
+	b.WriteString("XYZ ")
+	// A fixed creation date keeps the output deterministic
+	binary.Write(&b, binary.BigEndian, [6]uint16{2017, 1, 1, 0, 0, 0})
+	b.WriteString("acsp")
+	b.Write(make([]byte, 4+4+4+4+8+4))
+	for _, v := range d50 {
+		binary.Write(&b, binary.BigEndian, toS15Fixed16(v))
+	}
+	b.Write(make([]byte, 128-b.Len()))
+
+	binary.Write(&b, binary.BigEndian, uint32(len(tags)))
+	for i, t := range tags {
+		b.WriteString(t.sig)
+		binary.Write(&b, binary.BigEndian, uint32(offsets[i]))
+		binary.Write(&b, binary.BigEndian, uint32(len(t.data)))
+	}
+	b.Write(data.Bytes())
+	return b.Bytes()
+}
+
+// ToAdobeRGB converts an sRGB image to Adobe RGB (1998), colours outside of the Adobe RGB
+// gamut are clipped
+func ToAdobeRGB(img image.Image) *image.RGBA {
+	// Tables avoid calling math.Pow for every pixel
+	var toLinear [256]float64
+	for i := range toLinear {
+		toLinear[i] = srgbToLinear(float64(i) / 255)
+	}
+	const encSteps = 4096
+	var encode [encSteps + 1]uint8
+	for i := range encode {
+		encode[i] = uint8(math.Floor(math.Pow(float64(i)/encSteps, 1/adobeRGBGamma)*255 + 0.5))
+	}
+	m := matMul(xyzToAdobeRGB, srgbToXYZ)
+
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			lin := mul(m, [3]float64{toLinear[r>>8], toLinear[g>>8], toLinear[bl>>8]})
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 3; c++ {
+				dst.Pix[i+c] = encode[int(clamp01(lin[c])*encSteps+0.5)]
+			}
+			dst.Pix[i+3] = uint8(a >> 8)
+		}
+	}
+	return dst
+}
+
+var xyzToAdobeRGB = invert(adobeRGBToXYZ)
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func toS15Fixed16(v float64) int32 {
+	return int32(math.Floor(v*65536 + 0.5))
+}
+
+func mul(m [3][3]float64, v [3]float64) [3]float64 {
+	var out [3]float64
+	for r := 0; r < 3; r++ {
+		out[r] = m[r][0]*v[0] + m[r][1]*v[1] + m[r][2]*v[2]
+	}
+	return out
+}
+
+func matMul(a, b [3][3]float64) [3][3]float64 {
+	var out [3][3]float64
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			out[r][c] = a[r][0]*b[0][c] + a[r][1]*b[1][c] + a[r][2]*b[2][c]
+		}
+	}
+	return out
+}
+
+func invert(m [3][3]float64) [3][3]float64 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+
+	var inv [3][3]float64
+	inv[0][0] = (m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det
+	inv[0][1] = (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det
+	inv[0][2] = (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det
+	inv[1][0] = (m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det
+	inv[1][1] = (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det
+	inv[1][2] = (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det
+	inv[2][0] = (m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det
+	inv[2][1] = (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det
+	inv[2][2] = (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det
+	return inv
+}
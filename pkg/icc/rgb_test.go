@@ -0,0 +1,87 @@
+package icc
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestRGBProfile(t *testing.T) {
+	tests := []struct {
+		name  string
+		desc  string
+		toXYZ [3][3]float64
+	}{
+		{SRGB, "sRGB IEC61966-2.1", srgbToXYZ},
+		{AdobeRGB, "Adobe RGB (1998) compatible", adobeRGBToXYZ},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mustRGBProfile(t, tt.name)
+			if got := int(binary.BigEndian.Uint32(p)); got != len(p) {
+				t.Fatalf("header size %d, profile is %d bytes", got, len(p))
+			}
+			if string(p[12:24]) != "mntrRGB XYZ " || string(p[36:40]) != "acsp" {
+				t.Fatalf("invalid header %q", p[12:40])
+			}
+
+			desc, err := findTag(p, "desc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := int(binary.BigEndian.Uint32(desc[8:]))
+			if got := string(desc[12 : 12+n-1]); got != tt.desc {
+				t.Fatalf("got description %q, want %q", got, tt.desc)
+			}
+
+			// The colorants are the columns of the matrix
+			for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+				tag, err := findTag(p, sig)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for j := 0; j < 3; j++ {
+					if got := s15Fixed16(tag[8+j*4:]); math.Abs(got-tt.toXYZ[j][i]) > 1.0/65536 {
+						t.Fatalf("%s[%d] got %g, want %g", sig, j, got, tt.toXYZ[j][i])
+					}
+				}
+			}
+			for _, sig := range []string{"cprt", "wtpt", "rTRC", "gTRC", "bTRC"} {
+				if _, err := findTag(p, sig); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+
+	if _, ok := RGBProfile("prophoto"); ok {
+		t.Fatal("expected no profile for an unknown name")
+	}
+}
+
+func TestToAdobeRGB(t *testing.T) {
+	// The sRGB primaries are inside the Adobe RGB gamut, so only green and red move much
+	tests := []struct {
+		in, want color.RGBA
+	}{
+		{color.RGBA{0, 0, 0, 255}, color.RGBA{0, 0, 0, 255}},
+		{color.RGBA{255, 255, 255, 255}, color.RGBA{255, 255, 255, 255}},
+		{color.RGBA{255, 0, 0, 255}, color.RGBA{219, 0, 0, 255}},
+		{color.RGBA{0, 255, 0, 255}, color.RGBA{144, 255, 60, 255}},
+		{color.RGBA{0, 0, 255, 255}, color.RGBA{0, 0, 250, 255}},
+		{color.RGBA{128, 128, 128, 128}, color.RGBA{128, 128, 128, 128}},
+	}
+	img := image.NewRGBA(image.Rect(0, 0, len(tests), 1))
+	for i, tt := range tests {
+		img.SetRGBA(i, 0, tt.in)
+	}
+	out := ToAdobeRGB(img)
+	for i, tt := range tests {
+		got := out.RGBAAt(i, 0)
+		if !near(got.R, tt.want.R) || !near(got.G, tt.want.G) || !near(got.B, tt.want.B) || got.A != tt.want.A {
+			t.Errorf("%v got %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
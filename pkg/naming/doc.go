@@ -0,0 +1,7 @@
+package naming
+
+/*
+Package naming builds the file names of sheets, frames and covers from templates, so the
+output can match what a print service's upload tool expects. Numbers are padded so the
+names always sort in order, however many sheets there are
+*/
@@ -0,0 +1,494 @@
+package naming
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Default templates, the sheet, frame and cover defaults give the names used before templates
+// were configurable
+const (
+	DefaultSheet     = "comp-{identifier}-{index}.{ext}"
+	DefaultFrame     = "frame-{identifier}-{index}.png"
+	DefaultCover     = "cover.png"
+	DefaultBackCover = "back-cover.png"
+	DefaultPage      = "page-{identifier}-{index}.png"
+	DefaultDocument  = "book-{identifier}.{ext}"
+	DefaultPreview   = "preview-{identifier}.{ext}"
+	DefaultSimulator = "flip-{identifier}.html"
+	DefaultProof     = "proof-{identifier}-{index}.jpg"
+	DefaultAssembled = "assembled-{identifier}.gif"
+)
+
+// Manifest the name of the file describing the job, which can't be changed since tools read it
+const Manifest = "info.json"
+
+type field int
+
+const (
+	fieldLiteral field = iota
+	fieldIdentifier
+	fieldIndex
+	fieldNumber
+	fieldTotal
+	fieldExt
+)
+
+var fieldNames = map[string]field{
+	"identifier": fieldIdentifier,
+	"index":      fieldIndex,
+	"number":     fieldNumber,
+	"total":      fieldTotal,
+	"ext":        fieldExt,
+}
+
+type part struct {
+	field field
+	text  string
+	width int
+}
+
+// Template is a parsed file name template. Fields are written in braces:
+//
+//	{identifier} the identifier of the job
+//	{index}      the position of the file, starting at 0
+//	{number}     the position of the file, starting at 1
+//	{total}      the number of files of the same kind
+//	{ext}        the file extension, without the dot
+//
+// The numeric fields can be given a width which they are zero padded to e.g. {number:4}.
+// Without one, index and number are padded to the number of digits in the total, at least
+// 3, so the names sort in order
+type Template struct {
+	text  string
+	parts []part
+}
+
+// Fields the values substituted into a template
+type Fields struct {
+	Identifier string
+	Index      int
+	Total      int
+	Ext        string
+}
+
+// Parse parses a template
+func Parse(text string) (*Template, error) {
+	if text == "" {
+		return nil, fmt.Errorf("template cannot be empty")
+	}
+
+	t := &Template{text: text}
+	rest := text
+	for rest != "" {
+		open := strings.IndexAny(rest, "{}")
+		if open == -1 {
+			t.parts = append(t.parts, part{text: rest})
+			break
+		}
+		if rest[open] == '}' {
+			return nil, fmt.Errorf("invalid template: %s, unexpected }", text)
+		}
+		if open > 0 {
+			t.parts = append(t.parts, part{text: rest[:open]})
+		}
+
+		end := strings.IndexByte(rest[open:], '}')
+		if end == -1 {
+			return nil, fmt.Errorf("invalid template: %s, missing }", text)
+		}
+		p, err := parseField(rest[open+1 : open+end])
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %s, %s", text, err)
+		}
+		t.parts = append(t.parts, p)
+		rest = rest[open+end+1:]
+	}
+
+	for _, p := range t.parts {
+		if p.field == fieldLiteral && strings.ContainsAny(p.text, `/\`) {
+			return nil, fmt.Errorf("invalid template: %s, names cannot contain path separators", text)
+		}
+	}
+	return t, nil
+}
+
+func parseField(s string) (part, error) {
+	name, width := s, ""
+	if i := strings.IndexByte(s, ':'); i != -1 {
+		name, width = s[:i], s[i+1:]
+	}
+
+	f, ok := fieldNames[name]
+	if !ok {
+		return part{}, fmt.Errorf("unknown field {%s}", name)
+	}
+
+	p := part{field: f}
+	if width != "" {
+		if f != fieldIndex && f != fieldNumber && f != fieldTotal {
+			return part{}, fmt.Errorf("{%s} cannot have a width", name)
+		}
+		w, err := strconv.Atoi(width)
+		if err != nil || w < 1 || w > 20 {
+			return part{}, fmt.Errorf("invalid width for {%s}: %s, must be 1 to 20", name, width)
+		}
+		p.width = w
+	}
+	return p, nil
+}
+
+// MustParse is like Parse but panics if the template is invalid, for use with constant templates
+func MustParse(text string) *Template {
+	t, err := Parse(text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// String returns the template text
+func (t *Template) String() string {
+	return t.text
+}
+
+// Numbered returns true if the template contains {index} or {number}, without one every
+// file would have the same name
+func (t *Template) Numbered() bool {
+	for _, p := range t.parts {
+		if p.field == fieldIndex || p.field == fieldNumber {
+			return true
+		}
+	}
+	return false
+}
+
+// Execute returns the name for the fields
+func (t *Template) Execute(f Fields) string {
+	defaultWidth := len(strconv.Itoa(f.Total))
+	if defaultWidth < 3 {
+		defaultWidth = 3
+	}
+
+	var b strings.Builder
+	for _, p := range t.parts {
+		switch p.field {
+		case fieldLiteral:
+			b.WriteString(p.text)
+		case fieldIdentifier:
+			b.WriteString(f.Identifier)
+		case fieldExt:
+			b.WriteString(f.Ext)
+		case fieldIndex, fieldNumber:
+			v := f.Index
+			if p.field == fieldNumber {
+				v++
+			}
+			width := p.width
+			if width == 0 {
+				width = defaultWidth
+			}
+			fmt.Fprintf(&b, "%0*d", width, v)
+		case fieldTotal:
+			fmt.Fprintf(&b, "%0*d", p.width, f.Total)
+		}
+	}
+	return b.String()
+}
+
+// Match returns true if the name could have been made from the template for the identifier
+// and ext, with any numbers in the numeric fields
+func (t *Template) Match(name, identifier, ext string) bool {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, p := range t.parts {
+		switch p.field {
+		case fieldLiteral:
+			b.WriteString(regexp.QuoteMeta(p.text))
+		case fieldIdentifier:
+			b.WriteString(regexp.QuoteMeta(identifier))
+		case fieldExt:
+			b.WriteString(regexp.QuoteMeta(ext))
+		case fieldIndex, fieldNumber:
+			if p.width != 0 {
+				fmt.Fprintf(&b, "[0-9]{%d,}", p.width)
+			} else {
+				b.WriteString("[0-9]+")
+			}
+		case fieldTotal:
+			b.WriteString("[0-9]+")
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String()).MatchString(name)
+}
+
+// Scheme holds the templates for each kind of file a job writes, a nil template uses the default
+type Scheme struct {
+	Sheet     *Template
+	Frame     *Template
+	Cover     *Template
+	BackCover *Template
+
+	// Page names the blank and credit pages added to the end of the book
+	Page *Template
+
+	// Document names a file holding every sheet, such as a duplex PDF
+	Document *Template
+
+	// Preview names the animated previews of the book, one for each format
+	Preview *Template
+
+	// Simulator names the HTML page that simulates flipping the book
+	Simulator *Template
+
+	// Proof names the contact sheets showing every frame in order
+	Proof *Template
+
+	// Assembled names the animation of the frames cropped from the sheets when verifying them
+	Assembled *Template
+}
+
+// NewScheme parses the templates into a scheme, empty templates use the defaults
+func NewScheme(sheet, frame, cover string) (Scheme, error) {
+	var s Scheme
+	for _, t := range []struct {
+		text string
+		dst  **Template
+		kind string
+	}{
+		{sheet, &s.Sheet, "sheet"},
+		{frame, &s.Frame, "frame"},
+		{cover, &s.Cover, "cover"},
+	} {
+		if t.text == "" {
+			continue
+		}
+		tmpl, err := Parse(t.text)
+		if err != nil {
+			return Scheme{}, fmt.Errorf("invalid %s name: %s", t.kind, err)
+		}
+		*t.dst = tmpl
+	}
+	return s, nil
+}
+
+// SheetName returns the file name of a sheet
+func (s Scheme) SheetName(identifier string, index, total int, ext string) string {
+	return templateOr(s.Sheet, DefaultSheet).Execute(Fields{Identifier: identifier, Index: index, Total: total, Ext: ext})
+}
+
+// FrameName returns the file name of an extracted frame
+func (s Scheme) FrameName(identifier string, index, total int) string {
+	return templateOr(s.Frame, DefaultFrame).Execute(Fields{Identifier: identifier, Index: index, Total: total, Ext: "png"})
+}
+
+// CoverName returns the file name of the cover image
+func (s Scheme) CoverName(identifier string) string {
+	return templateOr(s.Cover, DefaultCover).Execute(Fields{Identifier: identifier, Index: 0, Total: 1, Ext: "png"})
+}
+
+// BackCoverName returns the file name of the back cover image
+func (s Scheme) BackCoverName(identifier string) string {
+	return templateOr(s.BackCover, DefaultBackCover).Execute(Fields{Identifier: identifier, Index: 0, Total: 1, Ext: "png"})
+}
+
+// PageName returns the file name of a blank or credit page
+func (s Scheme) PageName(identifier string, index, total int) string {
+	return templateOr(s.Page, DefaultPage).Execute(Fields{Identifier: identifier, Index: index, Total: total, Ext: "png"})
+}
+
+// DocumentName returns the file name of a file holding every sheet
+func (s Scheme) DocumentName(identifier, ext string) string {
+	return templateOr(s.Document, DefaultDocument).Execute(Fields{Identifier: identifier, Index: 0, Total: 1, Ext: ext})
+}
+
+// PreviewName returns the file name of an animated preview
+func (s Scheme) PreviewName(identifier, ext string) string {
+	return templateOr(s.Preview, DefaultPreview).Execute(Fields{Identifier: identifier, Index: 0, Total: 1, Ext: ext})
+}
+
+// SimulatorName returns the file name of the flip simulator page
+func (s Scheme) SimulatorName(identifier string) string {
+	return templateOr(s.Simulator, DefaultSimulator).Execute(Fields{Identifier: identifier, Index: 0, Total: 1, Ext: "html"})
+}
+
+// ProofName returns the file name of a contact sheet
+func (s Scheme) ProofName(identifier string, index, total int) string {
+	return templateOr(s.Proof, DefaultProof).Execute(Fields{Identifier: identifier, Index: index, Total: total, Ext: "jpg"})
+}
+
+// AssembledName returns the file name of the animation made when verifying the sheets
+func (s Scheme) AssembledName(identifier string) string {
+	return templateOr(s.Assembled, DefaultAssembled).Execute(Fields{Identifier: identifier, Index: 0, Total: 1, Ext: "gif"})
+}
+
+func templateOr(t *Template, def string) *Template {
+	if t == nil {
+		return MustParse(def)
+	}
+	return t
+}
+
+// Files the number of each kind of file a job writes to the output directory, a count of zero
+// skips checking that kind of file
+type Files struct {
+	Sheets int
+
+	// SheetExt the extension of the sheets, if empty the document isn't checked
+	SheetExt string
+
+	Frames int
+	Pages  int
+
+	// PreviewExts the extensions of the animated previews, one for each format
+	PreviewExts []string
+
+	Simulator bool
+	Proofs    int
+	Assembled bool
+}
+
+// Validate returns an error if any two files would have the same name, or if the sheet or
+// frame names would not sort in order. Print services and the compositing step both order
+// files by name
+func (s Scheme) Validate(identifier string, files Files) error {
+	owners := make(map[string]string)
+	claim := func(name, owner string) error {
+		if prev, ok := owners[name]; ok {
+			return fmt.Errorf("%s and %s would both be named %s", prev, owner, name)
+		}
+		owners[name] = owner
+		return nil
+	}
+
+	if err := claim(Manifest, "the manifest"); err != nil {
+		return err
+	}
+	if err := claim(s.CoverName(identifier), "the cover"); err != nil {
+		return err
+	}
+	if err := claim(s.BackCoverName(identifier), "the back cover"); err != nil {
+		return err
+	}
+	if files.SheetExt != "" {
+		if err := claim(s.DocumentName(identifier, files.SheetExt), "the document"); err != nil {
+			return err
+		}
+	}
+	for _, ext := range files.PreviewExts {
+		if err := claim(s.PreviewName(identifier, ext), "the "+ext+" preview"); err != nil {
+			return err
+		}
+	}
+	if files.Simulator {
+		if err := claim(s.SimulatorName(identifier), "the simulator"); err != nil {
+			return err
+		}
+	}
+	if files.Assembled {
+		if err := claim(s.AssembledName(identifier), "the assembled book"); err != nil {
+			return err
+		}
+	}
+
+	var prev string
+	for i := 0; i < files.Sheets; i++ {
+		name := s.SheetName(identifier, i, files.Sheets, files.SheetExt)
+		if err := claim(name, fmt.Sprintf("sheet %d", i)); err != nil {
+			return err
+		}
+		if i > 0 && name < prev {
+			return fmt.Errorf("sheet names do not sort in order, %s sorts before %s, use a wider number", name, prev)
+		}
+		prev = name
+	}
+
+	for i := 0; i < files.Frames; i++ {
+		name := s.FrameName(identifier, i, files.Frames)
+		if path.Ext(name) != ".png" {
+			return fmt.Errorf("frame names must end with .png, %s invalid name", name)
+		}
+		if err := claim(name, fmt.Sprintf("frame %d", i)); err != nil {
+			return err
+		}
+		if i > 0 && name < prev {
+			return fmt.Errorf("frame names do not sort in order, %s sorts before %s, use a wider number", name, prev)
+		}
+		prev = name
+	}
+
+	for i := 0; i < files.Pages; i++ {
+		name := s.PageName(identifier, i, files.Pages)
+		if err := claim(name, fmt.Sprintf("page %d", i)); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < files.Proofs; i++ {
+		name := s.ProofName(identifier, i, files.Proofs)
+		if err := claim(name, fmt.Sprintf("proof page %d", i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListFrames returns the files in dir named as frames of the job, in name order, which is
+// the order of the frames. Other files in dir, such as the sheets, are ignored
+func (s Scheme) ListFrames(dir, identifier string) ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read frames: %s, %s", dir, err)
+	}
+
+	t := templateOr(s.Frame, DefaultFrame)
+	var frames []os.FileInfo
+	for _, f := range files {
+		if !f.IsDir() && t.Match(f.Name(), identifier, "png") {
+			frames = append(frames, f)
+		}
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames named %s in %s", t, dir)
+	}
+	return frames, nil
+}
+
+// RenameFrames renames the frames in dir, in order, to the names given by the frame template
+// and returns the renamed frames
+func (s Scheme) RenameFrames(dir, identifier string, frames []os.FileInfo) ([]os.FileInfo, error) {
+	if err := s.Validate(identifier, Files{Frames: len(frames)}); err != nil {
+		return nil, err
+	}
+
+	// Frames are first moved to temporary names, so a new name can never overwrite a frame
+	// that hasn't been renamed yet
+	tmpNames := make([]string, len(frames))
+	for i, f := range frames {
+		tmpNames[i] = fmt.Sprintf(".rename-%d-%s", i, f.Name())
+		err := os.Rename(path.Join(dir, f.Name()), path.Join(dir, tmpNames[i]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to rename frame: %s, %s", f.Name(), err)
+		}
+	}
+
+	renamed := make([]os.FileInfo, len(frames))
+	for i := range frames {
+		p := path.Join(dir, s.FrameName(identifier, i, len(frames)))
+		err := os.Rename(path.Join(dir, tmpNames[i]), p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rename frame: %s, %s", frames[i].Name(), err)
+		}
+		renamed[i], err = os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat frame: %s, %s", p, err)
+		}
+	}
+	return renamed, nil
+}
@@ -0,0 +1,216 @@
+package naming
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		err  string
+	}{
+		{"", "template cannot be empty"},
+		{"a}b", "invalid template: a}b, unexpected }"},
+		{"a{index", "invalid template: a{index, missing }"},
+		{"{foo}.png", "invalid template: {foo}.png, unknown field {foo}"},
+		{"{ext:3}", "invalid template: {ext:3}, {ext} cannot have a width"},
+		{"{identifier:3}", "invalid template: {identifier:3}, {identifier} cannot have a width"},
+		{"{index:0}", "invalid template: {index:0}, invalid width for {index}: 0, must be 1 to 20"},
+		{"{number:21}", "invalid template: {number:21}, invalid width for {number}: 21, must be 1 to 20"},
+		{"{index:x}", "invalid template: {index:x}, invalid width for {index}: x, must be 1 to 20"},
+		{"out/{index}.png", "invalid template: out/{index}.png, names cannot contain path separators"},
+		{`out\{index}.png`, `invalid template: out\{index}.png, names cannot contain path separators`},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.text)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: got error %v, want %q", tt.text, err, tt.err)
+		}
+	}
+
+	_, err := NewScheme("", "{bad}", "")
+	if want := "invalid frame name: invalid template: {bad}, unknown field {bad}"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		template string
+		index    int
+		total    int
+		want     string
+	}{
+		{DefaultFrame, 5, 10, "frame-x-005.png"},
+		{DefaultFrame, 5, 999, "frame-x-005.png"},
+		// Past 999 the numbers widen so every name in the set still sorts in order
+		{DefaultFrame, 5, 1000, "frame-x-0005.png"},
+		{DefaultFrame, 999, 1000, "frame-x-0999.png"},
+		{DefaultFrame, 5, 12345, "frame-x-00005.png"},
+		{"{number}-of-{total}.{ext}", 0, 12, "001-of-12.jpg"},
+		{"{number:2}-of-{total:4}.{ext}", 0, 12, "01-of-0012.jpg"},
+		// A width too narrow for the number doesn't cut it short
+		{"{number:2}.{ext}", 100, 200, "101.jpg"},
+		{"{identifier}{identifier}", 0, 1, "xx"},
+	}
+	for _, tt := range tests {
+		got := MustParse(tt.template).Execute(Fields{Identifier: "x", Index: tt.index, Total: tt.total, Ext: "jpg"})
+		if got != tt.want {
+			t.Errorf("%s, %d of %d: got %s, want %s", tt.template, tt.index, tt.total, got, tt.want)
+		}
+	}
+
+	if MustParse("cover.png").Numbered() || MustParse("{total}.png").Numbered() || !MustParse("{number:2}.png").Numbered() {
+		t.Errorf("only templates with {index} or {number} are numbered")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	all := Files{Sheets: 10, SheetExt: "jpg", Frames: 1000, Pages: 2, PreviewExts: []string{"gif", "png"}, Simulator: true, Proofs: 3, Assembled: true}
+	tests := []struct {
+		name   string
+		scheme Scheme
+		files  Files
+		err    string
+	}{
+		{"defaults", Scheme{}, all, ""},
+		{"nothing", Scheme{Sheet: MustParse("sheet.{ext}")}, Files{}, ""},
+		{"unnumbered sheets", Scheme{Sheet: MustParse("sheet.{ext}")}, Files{Sheets: 2, SheetExt: "jpg"},
+			"sheet 0 and sheet 1 would both be named sheet.jpg"},
+		{"sheet sort", Scheme{Sheet: MustParse("s-{number:1}.{ext}")}, Files{Sheets: 10, SheetExt: "jpg"},
+			"sheet names do not sort in order, s-10.jpg sorts before s-9.jpg, use a wider number"},
+		{"frame sort", Scheme{Frame: MustParse("f-{index:2}.png")}, Files{Frames: 101},
+			"frame names do not sort in order, f-100.png sorts before f-99.png, use a wider number"},
+		{"frame ext", Scheme{Frame: MustParse("f-{index}.jpg")}, Files{Frames: 1},
+			"frame names must end with .png, f-000.jpg invalid name"},
+		{"manifest", Scheme{Cover: MustParse("info.json")}, Files{},
+			"the manifest and the cover would both be named info.json"},
+		{"frame and cover", Scheme{Frame: MustParse("cover.png")}, Files{Frames: 1},
+			"the cover and frame 0 would both be named cover.png"},
+		{"proof and sheet", Scheme{Sheet: MustParse("proof-{identifier}-{index}.{ext}")}, Files{Sheets: 2, SheetExt: "jpg", Proofs: 1},
+			"sheet 0 and proof page 0 would both be named proof-x-000.jpg"},
+		{"preview and document", Scheme{Document: MustParse("anim.{ext}"), Preview: MustParse("anim.{ext}")}, Files{SheetExt: "gif", PreviewExts: []string{"gif"}},
+			"the document and the gif preview would both be named anim.gif"},
+		{"previews", Scheme{Preview: MustParse("preview.webm")}, Files{PreviewExts: []string{"gif", "webp"}},
+			"the gif preview and the webp preview would both be named preview.webm"},
+		{"simulator and assembled", Scheme{Simulator: MustParse("x.html"), Assembled: MustParse("x.html")}, Files{Simulator: true, Assembled: true},
+			"the simulator and the assembled book would both be named x.html"},
+		{"page and frame", Scheme{Page: MustParse("frame-{identifier}-{index}.png")}, Files{Frames: 2, Pages: 1},
+			"frame 0 and page 0 would both be named frame-x-000.png"},
+		// Kinds of file that aren't written are not claimed
+		{"no proofs", Scheme{Sheet: MustParse("proof-{identifier}-{index}.{ext}")}, Files{Sheets: 2, SheetExt: "jpg"}, ""},
+	}
+	for _, tt := range tests {
+		err := tt.scheme.Validate("x", tt.files)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %s", tt.name, err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestRenameFrames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "naming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The frames are given in the reverse order of their names, so each is renamed to the
+	// name of another and must not overwrite it
+	var frames []os.FileInfo
+	for _, name := range []string{"frame-x-002.png", "frame-x-001.png", "frame-x-000.png"} {
+		p := path.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, info)
+	}
+
+	renamed, err := Scheme{}.RenameFrames(dir, "x", frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range renamed {
+		if want := fmt.Sprintf("frame-x-%03d.png", i); f.Name() != want {
+			t.Errorf("frame %d: got %s, want %s", i, f.Name(), want)
+		}
+		b, err := ioutil.ReadFile(path.Join(dir, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != frames[i].Name() {
+			t.Errorf("%s holds %s, want %s", f.Name(), b, frames[i].Name())
+		}
+	}
+
+	_, err = Scheme{Frame: MustParse("frame.png")}.RenameFrames(dir, "x", renamed)
+	if want := "frame 0 and frame 1 would both be named frame.png"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		template string
+		name     string
+		want     bool
+	}{
+		{DefaultFrame, "frame-x-000.png", true},
+		{DefaultFrame, "frame-x-1234.png", true},
+		{DefaultFrame, "frame-y-000.png", false},
+		{DefaultFrame, "frame-x-.png", false},
+		{DefaultFrame, "frame-x-00a.png", false},
+		{DefaultFrame, "frame-x-000.png.tmp", false},
+		{DefaultSheet, "comp-x-000.png", true},
+		{"f{number:4}.png", "f0001.png", true},
+		{"f{number:4}.png", "f001.png", false},
+		{"f.{index}.png", "fx000.png", false},
+		{"{identifier}-{index}-of-{total}.png", "x-003-of-12.png", true},
+	}
+	for _, tt := range tests {
+		got := MustParse(tt.template).Match(tt.name, "x", "png")
+		if got != tt.want {
+			t.Errorf("%s matching %s: got %t, want %t", tt.template, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestListFrames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "naming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The frames of an earlier job alongside its sheets, cover and manifest
+	for _, name := range []string{"frame-x-001.png", "frame-x-000.png", "comp-x-000.jpg", "cover.png", Manifest, "frame-y-000.png"} {
+		err = ioutil.WriteFile(path.Join(dir, name), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	frames, err := Scheme{}.ListFrames(dir, "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || frames[0].Name() != "frame-x-000.png" || frames[1].Name() != "frame-x-001.png" {
+		t.Fatalf("got %d frames, want frame-x-000.png and frame-x-001.png", len(frames))
+	}
+
+	_, err = Scheme{}.ListFrames(dir, "z")
+	if want := "no frames named frame-{identifier}-{index}.png in " + dir; err == nil || err.Error() != want {
+		t.Fatalf("got error %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,6 @@
+package plan
+
+/*
+Package plan works out the frame rate and number of sheets needed to produce a
+flipbook of a requested size from a time range of a video
+*/
@@ -0,0 +1,181 @@
+package plan
+
+import (
+	"fmt"
+	"math"
+)
+
+// MinFPS and MaxFPS are the frame rate limits supported when extracting frames
+const (
+	MinFPS = 1
+	MaxFPS = 60
+)
+
+// Request describes the book the caller wants, only one of Sheets, Frames or Thickness
+// should be set, the others must be zero
+type Request struct {
+	// Sheets the number of printed sheets the book should use
+	Sheets int
+
+	// Frames the number of frames (pages) in the final book
+	Frames int
+
+	// Thickness the thickness of the final book in inches
+	Thickness float64
+
+	// PaperThickness the thickness of a single page in inches, required when Thickness is set
+	PaperThickness float64
+
+	// FramesPerSheet the number of frames the layout prints on one side of each sheet
+	FramesPerSheet int
+
+	// Duplex if true both sides of each sheet are printed, so a sheet holds twice as many
+	// frames and each page of the book holds two
+	Duplex bool
+
+	// StartTime the time in seconds in the video where the book starts
+	StartTime int
+
+	// Duration the length in seconds of the video to use
+	Duration int
+}
+
+// Plan contains the computed values needed to render a book matching a Request
+type Plan struct {
+	// StartTime the time in seconds in the video where the book starts
+	StartTime int `json:"startTime"`
+
+	// Duration the length in seconds of the video being used
+	Duration int `json:"duration"`
+
+	// FPSNum the numerator of the frame rate
+	FPSNum int `json:"fpsNum"`
+
+	// FPSDen the denominator of the frame rate
+	FPSDen int `json:"fpsDen"`
+
+	// FPS the frame rate as a decimal, for display purposes
+	FPS float64 `json:"fps"`
+
+	// Frames the number of frames that will be extracted
+	Frames int `json:"frames"`
+
+	// FramesPerSheet the number of frames printed on each sheet, on both sides for duplex books
+	FramesPerSheet int `json:"framesPerSheet"`
+
+	// Duplex true if both sides of each sheet are printed
+	Duplex bool `json:"duplex,omitempty"`
+
+	// Sheets the number of sheets that will be printed
+	Sheets int `json:"sheets"`
+
+	// Thickness the estimated thickness of the book in inches, only set if PaperThickness was specified
+	Thickness float64 `json:"thickness,omitempty"`
+}
+
+// New computes a plan from the request. Since only whole sheets are printed the number
+// of frames is rounded to the nearest multiple of FramesPerSheet, the frame rate is then
+// chosen so that exactly that many frames span the requested duration
+func New(req Request) (Plan, error) {
+	nSet := 0
+	if req.Sheets != 0 {
+		nSet++
+	}
+	if req.Frames != 0 {
+		nSet++
+	}
+	if req.Thickness != 0 {
+		nSet++
+	}
+	if nSet != 1 {
+		return Plan{}, fmt.Errorf("exactly one of sheets, frames or thickness must be specified")
+	}
+
+	if req.Sheets < 0 || req.Frames < 0 || req.Thickness < 0 {
+		return Plan{}, fmt.Errorf("sheets, frames and thickness must be positive values")
+	}
+
+	if req.FramesPerSheet < 1 {
+		return Plan{}, fmt.Errorf("frames per sheet must be at least 1, %d invalid value", req.FramesPerSheet)
+	}
+
+	if req.Duration < 1 {
+		return Plan{}, fmt.Errorf("duration must be at least 1 second, %d invalid value", req.Duration)
+	}
+
+	if req.StartTime < 0 {
+		return Plan{}, fmt.Errorf("start time must not be negative, %d invalid value", req.StartTime)
+	}
+
+	// Double sided pages hold two frames each
+	perSheet, perPage := req.FramesPerSheet, 1
+	if req.Duplex {
+		perSheet, perPage = perSheet*2, 2
+	}
+
+	var frames float64
+	switch {
+	case req.Sheets > 0:
+		frames = float64(req.Sheets * perSheet)
+	case req.Frames > 0:
+		frames = float64(req.Frames)
+	default:
+		if req.PaperThickness <= 0 {
+			return Plan{}, fmt.Errorf("paper thickness must be specified when planning by thickness")
+		}
+		frames = req.Thickness / req.PaperThickness * float64(perPage)
+	}
+
+	sheets := int(math.Max(1, math.Floor(frames/float64(perSheet)+0.5)))
+	nFrames := sheets * perSheet
+
+	d := gcd(nFrames, req.Duration)
+	num := nFrames / d
+	den := req.Duration / d
+
+	if num < MinFPS*den {
+		return Plan{}, fmt.Errorf("%d frames over %ds needs %.2ffps, below the minimum of %dfps, use a time range of at most %ds",
+			nFrames, req.Duration, float64(num)/float64(den), MinFPS, nFrames/MinFPS)
+	}
+	if num > MaxFPS*den {
+		minDuration := int(math.Ceil(float64(nFrames) / MaxFPS))
+		return Plan{}, fmt.Errorf("%d frames over %ds needs %.2ffps, above the maximum of %dfps, use a time range of at least %ds",
+			nFrames, req.Duration, float64(num)/float64(den), MaxFPS, minDuration)
+	}
+
+	p := Plan{
+		StartTime:      req.StartTime,
+		Duration:       req.Duration,
+		FPSNum:         num,
+		FPSDen:         den,
+		FPS:            float64(num) / float64(den),
+		Frames:         nFrames,
+		FramesPerSheet: perSheet,
+		Duplex:         req.Duplex,
+		Sheets:         sheets,
+	}
+	if req.PaperThickness > 0 {
+		p.Thickness = float64(nFrames/perPage) * req.PaperThickness
+	}
+	return p, nil
+}
+
+// String returns a human readable summary of the plan
+func (p Plan) String() string {
+	s := fmt.Sprintf("%d frames from %ds to %ds at %.3gfps, %d sheets of %d frames",
+		p.Frames, p.StartTime, p.StartTime+p.Duration, p.FPS, p.Sheets, p.FramesPerSheet)
+	if p.Duplex {
+		s += " printed on both sides"
+	}
+	if p.Thickness > 0 {
+		s += fmt.Sprintf(", approx %.2fin thick", p.Thickness)
+	}
+	return s
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,98 @@
+package plan
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name      string
+		req       Request
+		num, den  int
+		frames    int
+		sheets    int
+		perSheet  int
+		thickness float64
+	}{
+		// 48 frames over 10s is 4.8fps, reduced to 24/5
+		{"sheets", Request{Sheets: 4, FramesPerSheet: 12, Duration: 10}, 24, 5, 48, 4, 12, 0},
+		{"whole fps", Request{Frames: 48, FramesPerSheet: 12, Duration: 6}, 8, 1, 48, 4, 12, 0},
+		{"no common factor", Request{Frames: 60, FramesPerSheet: 12, Duration: 7}, 60, 7, 60, 5, 12, 0},
+		// Frames are rounded to the nearest whole sheet
+		{"frames round down", Request{Frames: 50, FramesPerSheet: 12, Duration: 6}, 8, 1, 48, 4, 12, 0},
+		{"frames round up", Request{Frames: 54, FramesPerSheet: 12, Duration: 6}, 10, 1, 60, 5, 12, 0},
+		{"at least a sheet", Request{Frames: 1, FramesPerSheet: 12, Duration: 12}, 1, 1, 12, 1, 12, 0},
+		{"thickness", Request{Thickness: 0.5, PaperThickness: 0.01, FramesPerSheet: 12, Duration: 10}, 24, 5, 48, 4, 12, 0.48},
+		// A duplex sheet holds twice the frames and each page holds two, so the book is half as thick
+		{"duplex sheets", Request{Sheets: 2, FramesPerSheet: 12, Duplex: true, Duration: 4}, 12, 1, 48, 2, 24, 0},
+		{"duplex thickness", Request{Thickness: 0.25, PaperThickness: 0.01, FramesPerSheet: 12, Duplex: true, Duration: 4}, 12, 1, 48, 2, 24, 0.24},
+		{"min fps", Request{Sheets: 1, FramesPerSheet: 12, Duration: 12}, 1, 1, 12, 1, 12, 0},
+		{"max fps", Request{Sheets: 5, FramesPerSheet: 12, Duration: 1}, 60, 1, 60, 5, 12, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.FPSNum != tt.num || p.FPSDen != tt.den {
+				t.Errorf("got %d/%d fps, want %d/%d", p.FPSNum, p.FPSDen, tt.num, tt.den)
+			}
+			if p.FPS != float64(tt.num)/float64(tt.den) {
+				t.Errorf("got %g fps, want %d/%d", p.FPS, tt.num, tt.den)
+			}
+			if p.Frames != tt.frames || p.Sheets != tt.sheets || p.FramesPerSheet != tt.perSheet {
+				t.Errorf("got %d frames on %d sheets of %d, want %d on %d of %d",
+					p.Frames, p.Sheets, p.FramesPerSheet, tt.frames, tt.sheets, tt.perSheet)
+			}
+			if math.Abs(p.Thickness-tt.thickness) > 1e-9 {
+				t.Errorf("got %gin thick, want %gin", p.Thickness, tt.thickness)
+			}
+			if p.Duplex != tt.req.Duplex || p.StartTime != tt.req.StartTime || p.Duration != tt.req.Duration {
+				t.Errorf("the request was not carried into the plan: %+v", p)
+			}
+		})
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		req  Request
+		err  string
+	}{
+		{"none", Request{FramesPerSheet: 12, Duration: 10}, "exactly one of sheets, frames or thickness must be specified"},
+		{"two", Request{Sheets: 1, Frames: 12, FramesPerSheet: 12, Duration: 10}, "exactly one of sheets, frames or thickness must be specified"},
+		{"negative", Request{Sheets: -1, FramesPerSheet: 12, Duration: 10}, "sheets, frames and thickness must be positive values"},
+		{"frames per sheet", Request{Sheets: 1, Duration: 10}, "frames per sheet must be at least 1, 0 invalid value"},
+		{"duration", Request{Sheets: 1, FramesPerSheet: 12}, "duration must be at least 1 second, 0 invalid value"},
+		{"start", Request{Sheets: 1, FramesPerSheet: 12, Duration: 12, StartTime: -1}, "start time must not be negative, -1 invalid value"},
+		{"paper", Request{Thickness: 0.5, FramesPerSheet: 12, Duration: 10}, "paper thickness must be specified when planning by thickness"},
+		{"below min fps", Request{Sheets: 1, FramesPerSheet: 12, Duration: 20},
+			"12 frames over 20s needs 0.60fps, below the minimum of 1fps, use a time range of at most 12s"},
+		{"above max fps", Request{Sheets: 10, FramesPerSheet: 12, Duration: 1},
+			"120 frames over 1s needs 120.00fps, above the maximum of 60fps, use a time range of at least 2s"},
+		{"above max fps duplex", Request{Sheets: 3, FramesPerSheet: 12, Duplex: true, Duration: 1},
+			"72 frames over 1s needs 72.00fps, above the maximum of 60fps, use a time range of at least 2s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.req)
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	p, err := New(Request{Sheets: 2, FramesPerSheet: 12, Duplex: true, Duration: 4, StartTime: 3, PaperThickness: 0.01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "48 frames from 3s to 7s at 12fps, 2 sheets of 24 frames printed on both sides, approx 0.24in thick"
+	if got := p.String(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
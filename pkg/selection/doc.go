@@ -0,0 +1,6 @@
+package selection
+
+/*
+Package selection picks which of the extracted video frames end up in the flipbook,
+based on the motion between frames, scene cuts and near duplicate frames
+*/
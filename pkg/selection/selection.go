@@ -0,0 +1,253 @@
+package selection
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path"
+
+	"github.com/disintegration/imaging"
+)
+
+// thumbWidth is the width frames are scaled down to before being compared, large enough
+// to capture motion but small enough to keep comparing hundreds of frames fast
+const thumbWidth = 64
+
+// histBins the number of luminance buckets used to detect scene cuts
+const histBins = 32
+
+// Options allows callers to define how frames are selected, the zero value keeps every frame
+type Options struct {
+	// Frames if greater than zero, the number of frames to keep. Frames are picked so that
+	// there is roughly the same amount of motion between each kept frame, so fast sections
+	// get more pages and static sections fewer
+	Frames int
+
+	// DuplicateThreshold frames that differ from the last kept frame by less than this
+	// value are dropped. Values are between 0 and 1, where 0 disables dropping, 0.01 is a
+	// good starting point
+	DuplicateThreshold float64
+
+	// CutThreshold the difference in brightness distribution between two consecutive frames
+	// above which the change is treated as a scene cut. Values are between 0 and 1, where 0
+	// disables scene cut detection, 0.4 is a good starting point
+	CutThreshold float64
+
+	// StopAtCut if true, all frames from the first scene cut onwards are dropped
+	StopAtCut bool
+
+	// VerLog a logger that will receive verbose information
+	VerLog *log.Logger
+}
+
+// Result contains the outcome of the selection
+type Result struct {
+	// Kept the frames that should be used in the flipbook, in order
+	Kept []os.FileInfo
+
+	// KeptIndex the index in the input frames of each of the kept frames
+	KeptIndex []int
+
+	// Dropped the frames that were not selected
+	Dropped []os.FileInfo
+
+	// Cuts indices into Kept of frames that start a new scene
+	Cuts []int
+}
+
+type thumb struct {
+	pix  []uint8
+	hist [histBins]float64
+}
+
+// Select analyses the frames, which must be in playback order and located in inputDir,
+// and returns which frames should be kept
+func Select(inputDir string, frames []os.FileInfo, opts Options) (Result, error) {
+	if opts.VerLog == nil {
+		opts.VerLog = log.New(ioutil.Discard, "", 0)
+	}
+
+	if opts.DuplicateThreshold < 0 || opts.DuplicateThreshold > 1 {
+		return Result{}, fmt.Errorf("duplicate threshold must be between 0 and 1, %f invalid value", opts.DuplicateThreshold)
+	}
+
+	if opts.CutThreshold < 0 || opts.CutThreshold > 1 {
+		return Result{}, fmt.Errorf("cut threshold must be between 0 and 1, %f invalid value", opts.CutThreshold)
+	}
+
+	if opts.Frames < 0 {
+		return Result{}, fmt.Errorf("frames must not be negative, %d invalid value", opts.Frames)
+	}
+
+	thumbs := make([]thumb, len(frames))
+	for i, f := range frames {
+		p := path.Join(inputDir, f.Name())
+		opts.VerLog.Println("analysing:", p)
+		t, err := loadThumb(p)
+		if err != nil {
+			return Result{}, err
+		}
+		thumbs[i] = t
+	}
+
+	// Find the scene cuts in the source sequence
+	isCut := make([]bool, len(frames))
+	end := len(frames)
+	if opts.CutThreshold > 0 {
+		for i := 1; i < len(frames); i++ {
+			if histDiff(thumbs[i-1], thumbs[i]) < opts.CutThreshold {
+				continue
+			}
+
+			opts.VerLog.Println("scene cut at:", frames[i].Name())
+			isCut[i] = true
+			if opts.StopAtCut {
+				end = i
+				break
+			}
+		}
+	}
+
+	// Drop frames that are too similar to the last frame we kept, a cut always starts
+	// a new run so the first frame of a scene is never dropped
+	var candidates []int
+	for i := 0; i < end; i++ {
+		if len(candidates) > 0 && !isCut[i] && opts.DuplicateThreshold > 0 {
+			last := candidates[len(candidates)-1]
+			if pixelDiff(thumbs[last], thumbs[i]) < opts.DuplicateThreshold {
+				opts.VerLog.Println("dropping duplicate frame:", frames[i].Name())
+				continue
+			}
+		}
+		candidates = append(candidates, i)
+	}
+
+	if opts.Frames > 0 && len(candidates) > opts.Frames {
+		candidates = selectByMotion(candidates, thumbs, opts.Frames)
+	}
+
+	var res Result
+	keep := make(map[int]bool, len(candidates))
+	for _, ci := range candidates {
+		keep[ci] = true
+	}
+
+	// A cut is marked on the first kept frame at or after the cut, since the frame
+	// where the cut happened may itself have been dropped
+	pendingCut := false
+	for i, f := range frames {
+		if i < end && isCut[i] {
+			pendingCut = true
+		}
+		if !keep[i] {
+			res.Dropped = append(res.Dropped, f)
+			continue
+		}
+		if pendingCut {
+			res.Cuts = append(res.Cuts, len(res.Kept))
+			pendingCut = false
+		}
+		res.Kept = append(res.Kept, f)
+		res.KeptIndex = append(res.KeptIndex, i)
+	}
+
+	opts.VerLog.Println(len(res.Kept), "frames selected,", len(res.Dropped), "dropped,", len(res.Cuts), "scene cuts")
+	return res, nil
+}
+
+// selectByMotion picks n of the candidate frames, spaced so the cumulative motion between
+// each picked frame is as even as possible. The first candidate is always kept
+func selectByMotion(candidates []int, thumbs []thumb, n int) []int {
+	cum := make([]float64, len(candidates))
+	for i := 1; i < len(candidates); i++ {
+		cum[i] = cum[i-1] + pixelDiff(thumbs[candidates[i-1]], thumbs[candidates[i]])
+	}
+
+	total := cum[len(cum)-1]
+	picked := make([]int, 0, n)
+	next := 0
+	for j := 0; j < n; j++ {
+		// Leave enough candidates for the remaining picks so we always return n frames
+		maxIndex := len(candidates) - (n - j)
+
+		var ci int
+		if total == 0 || n == 1 {
+			ci = j * (len(candidates) - 1) / int(math.Max(1, float64(n-1)))
+		} else {
+			target := total * float64(j) / float64(n-1)
+			ci = next
+			for ci < maxIndex && cum[ci] < target {
+				ci++
+			}
+		}
+
+		if ci < next {
+			ci = next
+		}
+		if ci > maxIndex {
+			ci = maxIndex
+		}
+		picked = append(picked, candidates[ci])
+		next = ci + 1
+	}
+	return picked
+}
+
+func loadThumb(p string) (thumb, error) {
+	img, err := imaging.Open(p)
+	if err != nil {
+		return thumb{}, fmt.Errorf("failed to load frame: %s, %s", p, err)
+	}
+
+	small := imaging.Grayscale(imaging.Resize(img, thumbWidth, 0, imaging.Box))
+	t := thumb{pix: make([]uint8, 0, small.Bounds().Dx()*small.Bounds().Dy())}
+	b := small.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			// Grayscale images have the same value in the R, G and B channels
+			v := small.Pix[small.PixOffset(x, y)]
+			t.pix = append(t.pix, v)
+			t.hist[int(v)*histBins/256]++
+		}
+	}
+
+	n := float64(len(t.pix))
+	for i := range t.hist {
+		t.hist[i] /= n
+	}
+	return t, nil
+}
+
+// pixelDiff returns the mean absolute difference between two thumbnails, in the range 0 to 1
+func pixelDiff(a, b thumb) float64 {
+	n := len(a.pix)
+	if len(b.pix) < n {
+		n = len(b.pix)
+	}
+	if n == 0 {
+		return 0
+	}
+
+	var sum int
+	for i := 0; i < n; i++ {
+		d := int(a.pix[i]) - int(b.pix[i])
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+	return float64(sum) / float64(n) / 255.0
+}
+
+// histDiff returns how different the brightness distributions of two thumbnails are,
+// in the range 0 to 1. Unlike pixelDiff this is largely unaffected by motion, so a large
+// value indicates the content of the frame changed completely
+func histDiff(a, b thumb) float64 {
+	var sum float64
+	for i := range a.hist {
+		sum += math.Abs(a.hist[i] - b.hist[i])
+	}
+	return sum / 2
+}
@@ -0,0 +1,171 @@
+package selection
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// greyFrames writes a solid grey frame for each value and returns them in order. Greys within
+// 8 of each other share a histogram bucket, so only larger steps are scene cuts
+func greyFrames(t *testing.T, dir string, greys []uint8) []os.FileInfo {
+	t.Helper()
+	var frames []os.FileInfo
+	for i, g := range greys {
+		img := image.NewGray(image.Rect(0, 0, 64, 36))
+		for j := range img.Pix {
+			img.Pix[j] = g
+		}
+		p := path.Join(dir, fmt.Sprintf("frame-%03d.png", i))
+		if err := imaging.Save(img, p); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, info)
+	}
+	return frames
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		name    string
+		greys   []uint8
+		opts    Options
+		kept    []int
+		dropped []int
+		cuts    []int
+	}{
+		{"keep all", []uint8{10, 10, 200}, Options{}, []int{0, 1, 2}, nil, nil},
+		{
+			// Near duplicates are dropped, but the first frame of a scene never is
+			"dedupe and cuts",
+			[]uint8{10, 10, 12, 240, 240, 100, 100},
+			Options{DuplicateThreshold: 0.01, CutThreshold: 0.4},
+			[]int{0, 3, 5}, []int{1, 2, 4, 6}, []int{1, 2},
+		},
+		{
+			// The big step between 20 and 200 gets the extra page
+			"by motion",
+			[]uint8{0, 10, 20, 200, 210},
+			Options{Frames: 3},
+			[]int{0, 3, 4}, []int{1, 2}, nil,
+		},
+		{
+			// The cut at frame 3 is dropped, so the next kept frame starts the scene
+			"dropped cut",
+			[]uint8{0, 2, 4, 200, 202},
+			Options{Frames: 2, CutThreshold: 0.4},
+			[]int{0, 4}, []int{1, 2, 3}, []int{1},
+		},
+		{
+			"stop at cut",
+			[]uint8{0, 2, 4, 200, 202},
+			Options{CutThreshold: 0.4, StopAtCut: true},
+			[]int{0, 1, 2}, []int{3, 4}, nil,
+		},
+		{"fewer than frames", []uint8{0, 100}, Options{Frames: 5}, []int{0, 1}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "selection")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			frames := greyFrames(t, dir, tt.greys)
+
+			res, err := Select(dir, frames, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(res.KeptIndex, tt.kept) {
+				t.Errorf("got kept %v, want %v", res.KeptIndex, tt.kept)
+			}
+			for i, index := range res.KeptIndex {
+				if res.Kept[i] != frames[index] {
+					t.Errorf("kept frame %d is %s, want %s", i, res.Kept[i].Name(), frames[index].Name())
+				}
+			}
+			var dropped []int
+			for _, f := range res.Dropped {
+				for i := range frames {
+					if frames[i] == f {
+						dropped = append(dropped, i)
+					}
+				}
+			}
+			if !reflect.DeepEqual(dropped, tt.dropped) {
+				t.Errorf("got dropped %v, want %v", dropped, tt.dropped)
+			}
+			if !reflect.DeepEqual(res.Cuts, tt.cuts) {
+				t.Errorf("got cuts %v, want %v", res.Cuts, tt.cuts)
+			}
+		})
+	}
+}
+
+func TestSelectErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	frames := greyFrames(t, dir, []uint8{0})
+
+	tests := []struct {
+		name string
+		opts Options
+		err  string
+	}{
+		{"duplicate", Options{DuplicateThreshold: -0.1}, "duplicate threshold must be between 0 and 1, -0.100000 invalid value"},
+		{"cut", Options{CutThreshold: 1.5}, "cut threshold must be between 0 and 1, 1.500000 invalid value"},
+		{"frames", Options{Frames: -1}, "frames must not be negative, -1 invalid value"},
+	}
+	for _, tt := range tests {
+		_, err := Select(dir, frames, tt.opts)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	os.Remove(path.Join(dir, frames[0].Name()))
+	_, err = Select(dir, frames, Options{})
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to load frame: ") {
+		t.Errorf("missing frame: got error %v", err)
+	}
+}
+
+func TestDiffs(t *testing.T) {
+	black := thumb{pix: []uint8{0, 0, 0, 0}}
+	black.hist[0] = 1
+	white := thumb{pix: []uint8{255, 255, 255, 255}}
+	white.hist[histBins-1] = 1
+	half := thumb{pix: []uint8{0, 0, 255, 255}}
+	half.hist[0], half.hist[histBins-1] = 0.5, 0.5
+
+	if d := pixelDiff(black, white); d != 1 {
+		t.Errorf("black to white pixel diff %g, want 1", d)
+	}
+	if d := pixelDiff(black, half); d != 0.5 {
+		t.Errorf("black to half pixel diff %g, want 0.5", d)
+	}
+	if d := histDiff(black, white); d != 1 {
+		t.Errorf("black to white histogram diff %g, want 1", d)
+	}
+	if d := histDiff(black, half); d != 0.5 {
+		t.Errorf("black to half histogram diff %g, want 0.5", d)
+	}
+	if d := pixelDiff(black, black); d != 0 {
+		t.Errorf("black to black pixel diff %g, want 0", d)
+	}
+}
@@ -0,0 +1,7 @@
+package stabilize
+
+/*
+Package stabilize removes camera shake from extracted frames by estimating the translation
+of each frame relative to a reference frame with phase correlation and cropping each frame
+to a stable window
+*/
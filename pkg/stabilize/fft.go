@@ -0,0 +1,65 @@
+package stabilize
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// fft performs an in place radix-2 fast fourier transform, len(x) must be a power of 2
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+
+	// Bit reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	sign := -1.0
+	if inverse {
+		sign = 1.0
+	}
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			wk := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a := x[start+k]
+				b := x[start+k+size/2] * wk
+				x[start+k] = a + b
+				x[start+k+size/2] = a - b
+				wk *= w
+			}
+		}
+	}
+
+	if inverse {
+		for i := range x {
+			x[i] /= complex(float64(n), 0)
+		}
+	}
+}
+
+// fft2 performs a 2D fft on a square n x n grid stored in row major order
+func fft2(x []complex128, n int, inverse bool) {
+	for r := 0; r < n; r++ {
+		fft(x[r*n:(r+1)*n], inverse)
+	}
+
+	col := make([]complex128, n)
+	for c := 0; c < n; c++ {
+		for r := 0; r < n; r++ {
+			col[r] = x[r*n+c]
+		}
+		fft(col, inverse)
+		for r := 0; r < n; r++ {
+			x[r*n+c] = col[r]
+		}
+	}
+}
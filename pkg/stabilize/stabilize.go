@@ -0,0 +1,330 @@
+package stabilize
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/cmplx"
+	"os"
+	"path"
+
+	"github.com/disintegration/imaging"
+)
+
+// gridSize the size of the grid frames are scaled to fit before estimating motion, must be a
+// power of 2
+const gridSize = 256
+
+// minPeak the height of the correlation peak below which a frame no longer matches the
+// reference frame well enough to measure against it, a perfect match is 1
+const minPeak = 0.2
+
+// lowPass the frequency, in cycles across the grid, the correlation is faded out above
+const lowPass = gridSize / 8
+
+// Options allows callers to define how the frames are stabilized
+type Options struct {
+	// Smoothing the number of frames either side of a frame used to smooth the camera path,
+	// larger values remove more shake but also more intentional camera movement. If 0 the
+	// camera is locked to the position of the first frame
+	Smoothing int
+
+	// MaxCrop the maximum fraction of the width and height that may be cropped from each edge
+	// of the frames, corrections that need more than this are clamped. If 0, 0.1 is used
+	MaxCrop float64
+
+	// VerLog a logger that will receive verbose information
+	VerLog *log.Logger
+}
+
+// Frames stabilizes the frames in inputDir, which must be in playback order. Each frame is
+// overwritten with a crop of the original, all frames are cropped to the same size
+func Frames(inputDir string, frames []os.FileInfo, opts Options) error {
+	if opts.VerLog == nil {
+		opts.VerLog = log.New(ioutil.Discard, "", 0)
+	}
+	if opts.Smoothing < 0 {
+		return fmt.Errorf("smoothing must not be negative, %d invalid value", opts.Smoothing)
+	}
+	if opts.MaxCrop == 0 {
+		opts.MaxCrop = 0.1
+	}
+	if opts.MaxCrop < 0 || opts.MaxCrop >= 0.5 {
+		return fmt.Errorf("max crop must be between 0 and 0.5, %f invalid value", opts.MaxCrop)
+	}
+	if len(frames) < 2 {
+		return nil
+	}
+
+	trajX, trajY, bounds, err := trajectory(inputDir, frames, opts)
+	if err != nil {
+		return err
+	}
+
+	smoothX := smooth(trajX, opts.Smoothing)
+	smoothY := smooth(trajY, opts.Smoothing)
+
+	maxX := opts.MaxCrop * float64(bounds.Dx())
+	maxY := opts.MaxCrop * float64(bounds.Dy())
+	offX := make([]int, len(frames))
+	offY := make([]int, len(frames))
+	marginX, marginY := 0, 0
+	for i := range frames {
+		// The crop window follows the content, minus the smoothed camera movement we want to keep
+		offX[i] = int(math.Floor(clamp(trajX[i]-smoothX[i], maxX) + 0.5))
+		offY[i] = int(math.Floor(clamp(trajY[i]-smoothY[i], maxY) + 0.5))
+		marginX = maxInt(marginX, absInt(offX[i]))
+		marginY = maxInt(marginY, absInt(offY[i]))
+	}
+
+	// The margins grow to the same fraction of each side, so the frames keep their shape
+	w, h := bounds.Dx(), bounds.Dy()
+	if marginX*h > marginY*w {
+		marginY = (marginX*h + w - 1) / w
+	} else {
+		marginX = (marginY*w + h - 1) / h
+	}
+
+	opts.VerLog.Printf("stabilizing, cropping %dpx horizontally and %dpx vertically from each edge", marginX, marginY)
+	if marginX == 0 && marginY == 0 {
+		return nil
+	}
+
+	for i, f := range frames {
+		p := path.Join(inputDir, f.Name())
+		img, err := imaging.Open(p)
+		if err != nil {
+			return fmt.Errorf("failed to load frame: %s, %s", p, err)
+		}
+
+		b := img.Bounds()
+		crop := image.Rect(
+			b.Min.X+marginX+offX[i],
+			b.Min.Y+marginY+offY[i],
+			b.Max.X-marginX+offX[i],
+			b.Max.Y-marginY+offY[i])
+		err = imaging.Save(imaging.Crop(img, crop), p)
+		if err != nil {
+			return fmt.Errorf("failed to save stabilized frame: %s, %s", p, err)
+		}
+	}
+	return nil
+}
+
+// trajectory returns the position of the camera in each frame relative to the first frame,
+// in pixels, and the bounds of the frames. Each frame is measured against a reference frame
+// rather than the frame before it, so errors in the measurements don't add up along the
+// clip. Once the content has moved or changed too far to match the reference, the previous
+// frame becomes the reference
+func trajectory(inputDir string, frames []os.FileInfo, opts Options) ([]float64, []float64, image.Rectangle, error) {
+	trajX := make([]float64, len(frames))
+	trajY := make([]float64, len(frames))
+	var ref, prev []complex128
+	var refX, refY float64
+	var bounds image.Rectangle
+	for i, f := range frames {
+		p := path.Join(inputDir, f.Name())
+		opts.VerLog.Println("estimating motion:", p)
+
+		img, err := imaging.Open(p)
+		if err != nil {
+			return nil, nil, image.Rectangle{}, fmt.Errorf("failed to load frame: %s, %s", p, err)
+		}
+		if i == 0 {
+			bounds = img.Bounds()
+		} else if img.Bounds().Size() != bounds.Size() {
+			return nil, nil, image.Rectangle{}, fmt.Errorf("all frames must be the same size to stabilize: %s", p)
+		}
+
+		spectrum, scaleX, scaleY := toSpectrum(img)
+		if ref == nil {
+			ref = spectrum
+		} else {
+			dx, dy, peak := phaseCorrelate(ref, spectrum)
+			if peak < minPeak {
+				ref, refX, refY = prev, trajX[i-1], trajY[i-1]
+				dx, dy, _ = phaseCorrelate(ref, spectrum)
+			}
+			trajX[i] = refX + dx*scaleX
+			trajY[i] = refY + dy*scaleY
+		}
+		prev = spectrum
+	}
+	return trajX, trajY, bounds, nil
+}
+
+// toSpectrum returns the 2D fourier transform of a windowed, grayscale version of the image,
+// scaled to fit a gridSize x gridSize grid keeping its aspect ratio, and the size of a grid
+// pixel in image pixels across and down
+func toSpectrum(img image.Image) ([]complex128, float64, float64) {
+	b := img.Bounds()
+	w, h := gridSize, gridSize
+	if b.Dx() > b.Dy() {
+		h = int(math.Max(1, math.Round(float64(gridSize*b.Dy())/float64(b.Dx()))))
+	} else {
+		w = int(math.Max(1, math.Round(float64(gridSize*b.Dx())/float64(b.Dy()))))
+	}
+	small := imaging.Grayscale(imaging.Resize(img, w, h, imaging.Box))
+
+	grid := make([]complex128, gridSize*gridSize)
+	for y := 0; y < h; y++ {
+		// A Hann window stops the edges of the image dominating the correlation
+		wy := hann(y, h)
+		for x := 0; x < w; x++ {
+			v := float64(small.Pix[small.PixOffset(x, y)]) / 255.0
+			grid[y*gridSize+x] = complex(v*hann(x, w)*wy, 0)
+		}
+	}
+	fft2(grid, gridSize, false)
+	return grid, float64(b.Dx()) / float64(w), float64(b.Dy()) / float64(h)
+}
+
+func hann(i, n int) float64 {
+	if n < 2 {
+		return 1
+	}
+	return 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+}
+
+// phaseCorrelate returns how far the content of b has moved relative to a, in grid units,
+// and the height of the correlation peak, 1 if b is exactly a moved by a whole grid pixel
+func phaseCorrelate(a, b []complex128) (float64, float64, float64) {
+	// Whitening gives every frequency the same weight, the highest ones carry little but
+	// noise so they are faded out, otherwise the peak snaps to whole grid pixels
+	cross := make([]complex128, len(a))
+	total := 0.0
+	for i := range a {
+		v := b[i] * cmplx.Conj(a[i])
+		if m := cmplx.Abs(v); m > 1e-12 {
+			v /= complex(m, 0)
+		}
+		u, w := float64(i%gridSize), float64(i/gridSize)
+		if u > gridSize/2 {
+			u -= gridSize
+		}
+		if w > gridSize/2 {
+			w -= gridSize
+		}
+		weight := math.Exp(-(u*u + w*w) / (lowPass * lowPass))
+		total += weight
+		cross[i] = v * complex(weight, 0)
+	}
+	r := append([]complex128(nil), cross...)
+	fft2(r, gridSize, true)
+
+	peak := 0
+	for i := range r {
+		if real(r[i]) > real(r[peak]) {
+			peak = i
+		}
+	}
+
+	// Shifts past the half way point wrap around and are really negative
+	px, py := peak%gridSize, peak/gridSize
+	if px > gridSize/2 {
+		px -= gridSize
+	}
+	if py > gridSize/2 {
+		py -= gridSize
+	}
+
+	// The peak is found to a tenth, then a hundredth of a grid pixel
+	dx, dy := refinePeak(cross, float64(px), float64(py), 0.1)
+	dx, dy = refinePeak(cross, dx, dy, 0.01)
+	// Scaled so frames that match exactly have a peak of 1
+	return dx, dy, real(r[peak]) * float64(len(r)) / total
+}
+
+// refinePeak returns the highest point of the correlation within 10 steps of x, y. The
+// correlation is the inverse fourier transform of the cross power spectrum, which is
+// evaluated directly at the fractional positions, so unlike fitting a curve through the
+// whole pixel samples the result isn't biased towards them
+func refinePeak(cross []complex128, x, y, step float64) (float64, float64) {
+	const k = 10
+	n := gridSize
+	freq := func(u int) float64 {
+		if u >= n/2 {
+			return float64(u - n)
+		}
+		return float64(u)
+	}
+
+	// Summing over the columns first for each x leaves a sum over the rows for each y
+	cols := make([]complex128, n*(2*k+1))
+	e := make([]complex128, n)
+	for j := 0; j <= 2*k; j++ {
+		px := x + float64(j-k)*step
+		for u := 0; u < n; u++ {
+			e[u] = cmplx.Rect(1, 2*math.Pi*freq(u)*px/float64(n))
+		}
+		for v := 0; v < n; v++ {
+			var sum complex128
+			row := cross[v*n : (v+1)*n]
+			for u, c := range row {
+				sum += c * e[u]
+			}
+			cols[j*n+v] = sum
+		}
+	}
+
+	bestX, bestY, best := x, y, math.Inf(-1)
+	for i := 0; i <= 2*k; i++ {
+		py := y + float64(i-k)*step
+		for v := 0; v < n; v++ {
+			e[v] = cmplx.Rect(1, 2*math.Pi*freq(v)*py/float64(n))
+		}
+		for j := 0; j <= 2*k; j++ {
+			var sum float64
+			col := cols[j*n : (j+1)*n]
+			for v, c := range col {
+				sum += real(c * e[v])
+			}
+			if sum > best {
+				best = sum
+				bestX, bestY = x+float64(j-k)*step, py
+			}
+		}
+	}
+	return bestX, bestY
+}
+
+// smooth returns a moving average of the values with the specified radius, a radius of 0
+// returns all zeros which locks the camera in place
+func smooth(v []float64, radius int) []float64 {
+	out := make([]float64, len(v))
+	if radius == 0 {
+		return out
+	}
+	for i := range v {
+		var sum float64
+		var n int
+		for j := i - radius; j <= i+radius; j++ {
+			if j >= 0 && j < len(v) {
+				sum += v[j]
+				n++
+			}
+		}
+		out[i] = sum / float64(n)
+	}
+	return out
+}
+
+func clamp(v, max float64) float64 {
+	return math.Max(-max, math.Min(max, v))
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,147 @@
+package stabilize
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// shifts how far the camera moves in each synthetic frame, in pixels from the first frame
+var shifts = []image.Point{
+	{0, 0}, {3, -2}, {7, 1}, {5, 5}, {-4, 6}, {-8, -3}, {10, -7}, {2, 9}, {-6, 4}, {1, -1},
+}
+
+// writeShiftedFrames writes frames cut from a random texture, each moved by its shift, and
+// returns them in order
+func writeShiftedFrames(t *testing.T, dir string, width, height int) []os.FileInfo {
+	const border = 16
+	r := rand.New(rand.NewSource(1))
+	base := image.NewNRGBA(image.Rect(0, 0, width+2*border, height+2*border))
+	for i := range base.Pix {
+		base.Pix[i] = uint8(r.Intn(256))
+	}
+	for i := 3; i < len(base.Pix); i += 4 {
+		base.Pix[i] = 0xff
+	}
+	// Blurring gives features a few pixels across, like a real scene
+	texture := imaging.Blur(base, 2)
+
+	var frames []os.FileInfo
+	for i, s := range shifts {
+		// The camera moving right moves the content left
+		crop := image.Rect(border+s.X, border+s.Y, border+s.X+width, border+s.Y+height)
+		p := path.Join(dir, fmt.Sprintf("frame-%03d.png", i))
+		if err := imaging.Save(imaging.Crop(texture, crop), p); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, fi)
+	}
+	return frames
+}
+
+func TestTrajectory(t *testing.T) {
+	for _, size := range []image.Point{{320, 180}, {180, 320}, {256, 256}, {640, 360}} {
+		t.Run(fmt.Sprintf("%dx%d", size.X, size.Y), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "stabilize")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			frames := writeShiftedFrames(t, dir, size.X, size.Y)
+			trajX, trajY, _, err := trajectory(dir, frames, Options{VerLog: discard()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, s := range shifts {
+				// The content moves the opposite way to the camera
+				wantX, wantY := -float64(s.X), -float64(s.Y)
+				if math.Abs(trajX[i]-wantX) > 0.25 || math.Abs(trajY[i]-wantY) > 0.25 {
+					t.Errorf("frame %d: got (%.2f, %.2f), want (%g, %g)", i, trajX[i], trajY[i], wantX, wantY)
+				}
+			}
+		})
+	}
+}
+
+func TestFramesLocksCamera(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stabilize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	frames := writeShiftedFrames(t, dir, 320, 180)
+	err = Frames(dir, frames, Options{Smoothing: 0, VerLog: discard()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// With the camera locked every stabilized frame shows exactly what the first one does
+	first, err := imaging.Open(path.Join(dir, frames[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The largest shift down is 9px, so the crop is 9px from the top and bottom and, to
+	// keep the 16:9 shape, 16px from the sides
+	if got, want := first.Bounds().Size(), image.Pt(288, 162); got != want {
+		t.Fatalf("got size %v, want %v", got, want)
+	}
+
+	for _, f := range frames[1:] {
+		img, err := imaging.Open(path.Join(dir, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds() != first.Bounds() {
+			t.Fatalf("%s: got bounds %v, want %v", f.Name(), img.Bounds(), first.Bounds())
+		}
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if !sameColor(img.At(x, y), first.At(x, y)) {
+					t.Fatalf("%s: pixel %d,%d differs from the first frame", f.Name(), x, y)
+				}
+			}
+		}
+	}
+}
+
+func TestSmooth(t *testing.T) {
+	got := smooth([]float64{0, 3, 6, 3, 0}, 1)
+	want := []float64{1.5, 3, 4, 3, 1.5}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	for _, v := range smooth([]float64{1, 2, 3}, 0) {
+		if v != 0 {
+			t.Fatal("a radius of 0 must lock the camera in place")
+		}
+	}
+}
+
+func sameColor(a, b color.Color) bool {
+	r1, g1, b1, a1 := a.RGBA()
+	r2, g2, b2, a2 := b.RGBA()
+	return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+}
+
+func discard() *log.Logger {
+	return log.New(ioutil.Discard, "", 0)
+}
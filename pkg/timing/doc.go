@@ -0,0 +1,6 @@
+package timing
+
+/*
+Package timing reshapes the extracted frame sequence using a timing script, so parts of
+the video can be sampled faster or slower, eased between rates, or held for several pages
+*/
@@ -0,0 +1,201 @@
+package timing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Range changes the sampling rate of the frames between Start and End
+type Range struct {
+	// Start the time in seconds in the video where the range starts
+	Start float64 `json:"start" yaml:"start"`
+
+	// End the time in seconds in the video where the range ends, exclusive
+	End float64 `json:"end" yaml:"end"`
+
+	// FPS the number of frames per second of video to use in this range. If this is higher
+	// than the rate frames were extracted at, frames are repeated to give a slow motion effect
+	FPS float64 `json:"fps" yaml:"fps"`
+
+	// EndFPS if set, the rate changes smoothly from FPS at the start of the range to EndFPS
+	// at the end, to ease in or out of a section
+	EndFPS float64 `json:"endFps,omitempty" yaml:"endFps,omitempty"`
+}
+
+// Hold repeats a single frame for several pages
+type Hold struct {
+	// At the time in seconds in the video of the frame to hold, the closest frame is used
+	At float64 `json:"at" yaml:"at"`
+
+	// Pages the number of pages the frame should be shown for
+	Pages int `json:"pages" yaml:"pages"`
+}
+
+// Script describes how the extracted frames should be reshaped. Times are in seconds from
+// the start of the video, not from the start time of the flipbook. Frames outside of all
+// ranges are used as extracted
+type Script struct {
+	Ranges []Range `json:"ranges" yaml:"ranges"`
+	Holds  []Hold  `json:"holds" yaml:"holds"`
+}
+
+// Load reads a timing script from a .json, .yaml or .yml file
+func Load(p string) (Script, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return Script{}, fmt.Errorf("failed to read timing script: %s", err)
+	}
+
+	var s Script
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&s)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(b, &s)
+	default:
+		return Script{}, fmt.Errorf("timing script must be a .json, .yaml or .yml file: %s", p)
+	}
+	if err != nil {
+		return Script{}, fmt.Errorf("failed to parse timing script: %s, %s", p, err)
+	}
+
+	return s, s.Validate()
+}
+
+// Validate returns an error if any of the ranges or holds are invalid
+func (s Script) Validate() error {
+	ranges := append([]Range(nil), s.Ranges...)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+
+	for i, r := range ranges {
+		if r.Start < 0 || r.End <= r.Start {
+			return fmt.Errorf("invalid range %g-%g, end must be after start", r.Start, r.End)
+		}
+		if r.FPS <= 0 || r.FPS > 60 {
+			return fmt.Errorf("invalid range %g-%g, fps must be greater than 0 and at most 60", r.Start, r.End)
+		}
+		if r.EndFPS < 0 || r.EndFPS > 60 {
+			return fmt.Errorf("invalid range %g-%g, endFps must not be negative or above 60", r.Start, r.End)
+		}
+		if i > 0 && r.Start < ranges[i-1].End {
+			return fmt.Errorf("range %g-%g overlaps range %g-%g", r.Start, r.End, ranges[i-1].Start, ranges[i-1].End)
+		}
+	}
+
+	for _, h := range s.Holds {
+		if h.At < 0 {
+			return fmt.Errorf("invalid hold at %g, time must not be negative", h.At)
+		}
+		if h.Pages < 1 {
+			return fmt.Errorf("invalid hold at %g, pages must be at least 1", h.At)
+		}
+	}
+	return nil
+}
+
+// Apply reshapes the frames according to the script. times contains the time in seconds in
+// the video of each frame and fps the rate the frames were extracted at. The returned slice
+// may contain the same frame more than once
+func Apply(s Script, frames []os.FileInfo, times []float64, fps float64) ([]os.FileInfo, error) {
+	if len(frames) != len(times) {
+		return nil, fmt.Errorf("expected a time for each frame, got %d frames and %d times", len(frames), len(times))
+	}
+	if fps <= 0 {
+		return nil, fmt.Errorf("fps must be greater than 0, %f invalid value", fps)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	holds := make(map[int]int)
+	for _, h := range s.Holds {
+		if len(frames) == 0 {
+			break
+		}
+		// The last frame is shown until the frame after it would have been
+		end := times[len(times)-1] + 1/fps
+		if h.At < times[0] || h.At >= end {
+			return nil, fmt.Errorf("invalid hold at %g, the frames are from %g to %g", h.At, times[0], end)
+		}
+		holds[closest(times, h.At)] = h.Pages
+	}
+
+	var out []os.FileInfo
+	var current *Range
+	var pos, next float64
+	for i, f := range frames {
+		r := rangeAt(s.Ranges, times[i])
+		if r != current {
+			// Entering a new range, always start with the first frame in the range
+			current = r
+			pos = 0
+			next = 0
+		} else if current != nil {
+			// Frames may not be evenly spaced if some were dropped, so the position moves on
+			// by the time since the previous frame rather than by 1/fps. Gaps of whole frames
+			// are counted in frames, so rounding in the times doesn't move frames about
+			dt := times[i] - times[i-1]
+			if steps := math.Round(dt * fps); math.Abs(dt*fps-steps) < 1e-6 {
+				pos += current.rateAt(times[i-1]) * steps / fps
+			} else {
+				pos += current.rateAt(times[i-1]) * dt
+			}
+		}
+
+		count := 1
+		if current != nil {
+			count = 0
+			for pos >= next {
+				count++
+				next++
+			}
+		}
+
+		if pages, ok := holds[i]; ok {
+			count = pages
+		}
+
+		for c := 0; c < count; c++ {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+func (r *Range) rateAt(t float64) float64 {
+	if r.EndFPS == 0 {
+		return r.FPS
+	}
+	p := (t - r.Start) / (r.End - r.Start)
+	return r.FPS + (r.EndFPS-r.FPS)*p
+}
+
+func rangeAt(ranges []Range, t float64) *Range {
+	for i := range ranges {
+		if t >= ranges[i].Start && t < ranges[i].End {
+			return &ranges[i]
+		}
+	}
+	return nil
+}
+
+func closest(times []float64, t float64) int {
+	best := 0
+	for i := range times {
+		if math.Abs(times[i]-t) < math.Abs(times[best]-t) {
+			best = i
+		}
+	}
+	return best
+}
@@ -0,0 +1,196 @@
+package timing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// namedFrame a frame with just a name, Apply only passes the frames through
+type namedFrame struct {
+	os.FileInfo
+	index int
+}
+
+// testFrames returns n frames extracted at fps from the start of the video, and their times
+func testFrames(n int, fps float64) ([]os.FileInfo, []float64) {
+	frames := make([]os.FileInfo, n)
+	times := make([]float64, n)
+	for i := range frames {
+		frames[i] = namedFrame{index: i}
+		times[i] = float64(i) / fps
+	}
+	return frames, times
+}
+
+func indices(frames []os.FileInfo) []int {
+	var out []int
+	for _, f := range frames {
+		out = append(out, f.(namedFrame).index)
+	}
+	return out
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		script Script
+		want   []int
+	}{
+		{"no script", Script{}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+		// Half the extracted rate keeps every other frame in the range
+		{"slower", Script{Ranges: []Range{{Start: 0, End: 1, FPS: 5}}}, []int{0, 2, 4, 6, 8, 10, 11}},
+		// Twice the extracted rate repeats the frames for slow motion
+		{"faster", Script{Ranges: []Range{{Start: 0.5, End: 0.8, FPS: 20}}}, []int{0, 1, 2, 3, 4, 5, 6, 6, 7, 7, 8, 9, 10, 11}},
+		// The rate rises from 5 to 15 across the range, so frames are skipped at the start
+		// and repeated at the end
+		{"ease", Script{Ranges: []Range{{Start: 0, End: 1, FPS: 5, EndFPS: 15}}}, []int{0, 2, 4, 5, 6, 7, 8, 9, 9, 10, 11}},
+		// The hold replaces what the range would have done with the closest frame
+		{"hold", Script{Holds: []Hold{{At: 0.52, Pages: 3}}}, []int{0, 1, 2, 3, 4, 5, 5, 5, 6, 7, 8, 9, 10, 11}},
+		{"hold in a range", Script{Ranges: []Range{{Start: 0, End: 1, FPS: 5}}, Holds: []Hold{{At: 0.1, Pages: 2}}},
+			[]int{0, 1, 1, 2, 4, 6, 8, 10, 11}},
+		{"hold last frame", Script{Holds: []Hold{{At: 1.15, Pages: 2}}}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 11}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, times := testFrames(12, 10)
+			out, err := Apply(tt.script, frames, times, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := indices(out); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyDroppedFrames(t *testing.T) {
+	// Frames 2 and 3 were dropped, the position moves on by the time since the previous frame
+	// so the range keeps the rate of the video rather than of the frames that are left
+	frames, _ := testFrames(4, 10)
+	times := []float64{0, 0.1, 0.4, 0.5}
+	out, err := Apply(Script{Ranges: []Range{{Start: 0, End: 1, FPS: 5}}}, frames, times, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := indices(out), []int{0, 2, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	frames, times := testFrames(12, 10)
+	tests := []struct {
+		name   string
+		script Script
+		times  []float64
+		fps    float64
+		err    string
+	}{
+		{"times", Script{}, times[:1], 10, "expected a time for each frame, got 12 frames and 1 times"},
+		{"fps", Script{}, times, 0, "fps must be greater than 0, 0.000000 invalid value"},
+		{"invalid", Script{Ranges: []Range{{Start: 0, End: 1}}}, times, 10, "invalid range 0-1, fps must be greater than 0 and at most 60"},
+	}
+	for _, tt := range tests {
+		_, err := Apply(tt.script, frames, tt.times, tt.fps)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	// The frames start a second into the video and the last is shown until 4s, a hold outside
+	// of that can't be shown
+	frames, times = testFrames(12, 4)
+	for i := range times {
+		times[i]++
+	}
+	for _, at := range []float64{0.5, 4} {
+		_, err := Apply(Script{Holds: []Hold{{At: at, Pages: 2}}}, frames, times, 4)
+		if want := fmt.Sprintf("invalid hold at %g, the frames are from 1 to 4", at); err == nil || err.Error() != want {
+			t.Errorf("got error %v, want %q", err, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		script Script
+		err    string
+	}{
+		{"empty", Script{}, ""},
+		{"touching", Script{Ranges: []Range{{Start: 1, End: 2, FPS: 5}, {Start: 0, End: 1, FPS: 5}}}, ""},
+		{"start", Script{Ranges: []Range{{Start: -1, End: 1, FPS: 5}}}, "invalid range -1-1, end must be after start"},
+		{"end", Script{Ranges: []Range{{Start: 1, End: 1, FPS: 5}}}, "invalid range 1-1, end must be after start"},
+		{"fps", Script{Ranges: []Range{{Start: 0, End: 1, FPS: 61}}}, "invalid range 0-1, fps must be greater than 0 and at most 60"},
+		{"end fps", Script{Ranges: []Range{{Start: 0, End: 1, FPS: 5, EndFPS: -1}}}, "invalid range 0-1, endFps must not be negative or above 60"},
+		// Ranges are checked in time order, whatever order they are given in
+		{"overlap", Script{Ranges: []Range{{Start: 1, End: 3, FPS: 5}, {Start: 0, End: 2, FPS: 5}}}, "range 1-3 overlaps range 0-2"},
+		{"hold time", Script{Holds: []Hold{{At: -1, Pages: 2}}}, "invalid hold at -1, time must not be negative"},
+		{"hold pages", Script{Holds: []Hold{{At: 1}}}, "invalid hold at 1, pages must be at least 1"},
+	}
+	for _, tt := range tests {
+		err := tt.script.Validate()
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %s", tt.name, err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "timing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	want := Script{
+		Ranges: []Range{{Start: 0, End: 2, FPS: 5, EndFPS: 15}},
+		Holds:  []Hold{{At: 3.5, Pages: 6}},
+	}
+	tests := []struct {
+		file string
+		text string
+		err  string
+	}{
+		{"script.yaml", "ranges:\n  - start: 0\n    end: 2\n    fps: 5\n    endFps: 15\nholds:\n  - at: 3.5\n    pages: 6\n", ""},
+		{"script.YML", "ranges: [{start: 0, end: 2, fps: 5, endFps: 15}]\nholds: [{at: 3.5, pages: 6}]\n", ""},
+		{"script.json", `{"ranges": [{"start": 0, "end": 2, "fps": 5, "endFps": 15}], "holds": [{"at": 3.5, "pages": 6}]}`, ""},
+		// Unknown keys are mistakes, not ignored
+		{"typo.yaml", "ranges:\n  - start: 0\n    end: 2\n    fsp: 5\n", "field fsp not found"},
+		{"typo.json", `{"ranges": [{"start": 0, "end": 2, "fsp": 5}]}`, `unknown field "fsp"`},
+		{"invalid.yaml", "ranges:\n  - start: 2\n    end: 1\n    fps: 5\n", "invalid range 2-1, end must be after start"},
+		{"script.txt", "", "timing script must be a .json, .yaml or .yml file: "},
+		{"missing.json", "", "failed to read timing script: "},
+	}
+	for _, tt := range tests {
+		p := path.Join(dir, tt.file)
+		if tt.file != "missing.json" {
+			if err := ioutil.WriteFile(p, []byte(tt.text), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s, err := Load(p)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.file, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.file, err)
+			continue
+		}
+		if !reflect.DeepEqual(s, want) {
+			t.Errorf("%s: got %+v, want %+v", tt.file, s, want)
+		}
+	}
+}
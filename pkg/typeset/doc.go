@@ -0,0 +1,8 @@
+package typeset
+
+/*
+Package typeset lays out and draws text for covers and pages. Text is shaped with an
+OpenType shaper, so scripts like Arabic and Devanagari join correctly, mixed left to right
+and right to left text is put in visual order, and characters missing from a font are drawn
+with the next font in a fallback chain, which is how CJK and emoji titles are supported
+*/
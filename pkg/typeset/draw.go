@@ -0,0 +1,185 @@
+package typeset
+
+import (
+	"bytes"
+	"image"
+	_ "image/jpeg" // bitmap glyphs can be JPEG
+	_ "image/png"  // bitmap glyphs are usually PNG, e.g. colour emoji
+	"math"
+
+	"github.com/go-text/typesetting/font"
+	ot "github.com/go-text/typesetting/font/opentype"
+	"github.com/go-text/typesetting/shaping"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/fixed"
+	_ "golang.org/x/image/tiff" // bitmap glyphs can be TIFF
+	"golang.org/x/image/vector"
+)
+
+// Draw draws the text aligned within the box. Outline glyphs are filled with src, colour
+// bitmap glyphs such as emoji are drawn with their own colours. Nothing is drawn outside of
+// the destination image, but text bigger than the box may be drawn outside of the box
+func (t *Text) Draw(dst draw.Image, box image.Rectangle, align Align, valign VAlign, src image.Image) {
+	y := fixed.I(box.Min.Y)
+	switch valign {
+	case VAlignMiddle:
+		y += fixed.I(box.Dy()-t.Height()) / 2
+	case VAlignBottom:
+		y += fixed.I(box.Dy() - t.Height())
+	}
+
+	for i, l := range t.lines {
+		if i > 0 {
+			y += t.lines[i-1].gap
+		}
+
+		x := fixed.I(box.Min.X)
+		switch {
+		case align == AlignCenter:
+			x += (fixed.I(box.Dx()) - l.width) / 2
+		case align == AlignRight, align == AlignStart && l.rtl:
+			x += fixed.I(box.Dx()) - l.width
+		}
+
+		baseline := y + l.ascent
+		for ri := range l.runs {
+			drawRun(dst, &l.runs[ri], x, baseline, src)
+			x += l.runs[ri].Advance
+		}
+		y += l.height()
+	}
+}
+
+// drawRun draws the glyphs of a horizontal run, harfbuzz returns the glyphs in visual order
+// even for right to left runs
+func drawRun(dst draw.Image, run *shaping.Output, x, baseline fixed.Int26_6, src image.Image) {
+	for _, g := range run.Glyphs {
+		// Offsets are y up, images are y down
+		gx := x + g.XOffset
+		gy := baseline - g.YOffset
+
+		switch data := run.Face.GlyphData(g.GlyphID).(type) {
+		case font.GlyphOutline:
+			drawOutline(dst, run, data, gx, gy, src)
+		case font.GlyphSVG:
+			drawOutline(dst, run, data.Outline, gx, gy, src)
+		case font.GlyphBitmap:
+			drawBitmap(dst, run, g, data, gx, gy, src)
+		}
+		x += g.XAdvance
+	}
+}
+
+// drawOutline fills the outline of a glyph, whose origin is at x, y
+func drawOutline(dst draw.Image, run *shaping.Output, outline font.GlyphOutline, x, y fixed.Int26_6, src image.Image) {
+	if len(outline.Segments) == 0 {
+		return
+	}
+
+	scale := float32(run.Size) / 64 / float32(run.Face.Upem())
+
+	// The glyph is rasterized into a mask just big enough to hold it
+	minX, minY := float32(1e9), float32(1e9)
+	maxX, maxY := float32(-1e9), float32(-1e9)
+	for _, s := range outline.Segments {
+		for _, p := range s.ArgsSlice() {
+			px, py := p.X*scale, -p.Y*scale
+			if px < minX {
+				minX = px
+			}
+			if px > maxX {
+				maxX = px
+			}
+			if py < minY {
+				minY = py
+			}
+			if py > maxY {
+				maxY = py
+			}
+		}
+	}
+
+	ox := float32(x) / 64
+	oy := float32(y) / 64
+	bounds := image.Rect(floor(ox+minX)-1, floor(oy+minY)-1, floor(ox+maxX)+2, floor(oy+maxY)+2)
+	if !bounds.Overlaps(dst.Bounds()) {
+		return
+	}
+
+	// Positions relative to the top left of the mask
+	dx := ox - float32(bounds.Min.X)
+	dy := oy - float32(bounds.Min.Y)
+	pt := func(p ot.SegmentPoint) (float32, float32) {
+		return dx + p.X*scale, dy - p.Y*scale
+	}
+
+	r := vector.NewRasterizer(bounds.Dx(), bounds.Dy())
+	started := false
+	for _, s := range outline.Segments {
+		switch s.Op {
+		case ot.SegmentOpMoveTo:
+			if started {
+				r.ClosePath()
+			}
+			r.MoveTo(pt(s.Args[0]))
+			started = true
+		case ot.SegmentOpLineTo:
+			r.LineTo(pt(s.Args[0]))
+		case ot.SegmentOpQuadTo:
+			bx, by := pt(s.Args[0])
+			cx, cy := pt(s.Args[1])
+			r.QuadTo(bx, by, cx, cy)
+		case ot.SegmentOpCubeTo:
+			bx, by := pt(s.Args[0])
+			cx, cy := pt(s.Args[1])
+			ex, ey := pt(s.Args[2])
+			r.CubeTo(bx, by, cx, cy, ex, ey)
+		}
+	}
+	r.ClosePath()
+
+	mask := image.NewAlpha(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	r.Draw(mask, mask.Bounds(), image.Opaque, image.Point{})
+	draw.DrawMask(dst, bounds, src, bounds.Min, mask, image.Point{}, draw.Over)
+}
+
+// drawBitmap scales a bitmap glyph to the glyph's extents, whose origin is at x, y
+func drawBitmap(dst draw.Image, run *shaping.Output, g shaping.Glyph, bitmap font.GlyphBitmap, x, y fixed.Int26_6, src image.Image) {
+	bounds := image.Rect(
+		(x + g.XBearing).Floor(),
+		(y - g.YBearing).Floor(),
+		(x + g.XBearing + g.Width).Ceil(),
+		(y - g.YBearing - g.Height).Ceil(),
+	)
+	if bounds.Empty() || !bounds.Overlaps(dst.Bounds()) {
+		return
+	}
+
+	if bitmap.Format == font.BlackAndWhite {
+		// A 1 bit image, drawn as a mask like an outline glyph
+		mask := image.NewAlpha(image.Rect(0, 0, bitmap.Width, bitmap.Height))
+		for i := 0; i < bitmap.Width*bitmap.Height; i++ {
+			if i/8 < len(bitmap.Data) && bitmap.Data[i/8]&(0x80>>uint(i%8)) != 0 {
+				mask.Pix[i] = 0xff
+			}
+		}
+		scaled := image.NewAlpha(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.BiLinear.Scale(scaled, scaled.Bounds(), mask, mask.Bounds(), draw.Src, nil)
+		draw.DrawMask(dst, bounds, src, bounds.Min, scaled, image.Point{}, draw.Over)
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(bitmap.Data))
+	if err != nil {
+		// Fonts can give an outline to use when the bitmap can't be drawn
+		if bitmap.Outline != nil {
+			drawOutline(dst, run, *bitmap.Outline, x, y, src)
+		}
+		return
+	}
+	draw.BiLinear.Scale(dst, bounds, img, img.Bounds(), draw.Over, nil)
+}
+
+func floor(v float32) int {
+	return int(math.Floor(float64(v)))
+}
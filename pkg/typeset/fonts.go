@@ -0,0 +1,75 @@
+package typeset
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-text/typesetting/font"
+)
+
+// Fonts is a fallback chain of fonts. Each character is drawn with the first font in the
+// chain that has a glyph for it, characters no font has are drawn with the first font
+type Fonts []*font.Face
+
+// ResolveFace returns the first font in the chain with a glyph for r
+func (f Fonts) ResolveFace(r rune) *font.Face {
+	for _, face := range f {
+		if _, ok := face.NominalGlyph(r); ok {
+			return face
+		}
+	}
+	return f[0]
+}
+
+// Parse parses a TrueType or OpenType font, a collection returns every font in it
+func Parse(b []byte) (Fonts, error) {
+	faces, err := font.ParseTTC(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font: %s", err)
+	}
+	return faces, nil
+}
+
+// Load reads the fonts at each path into a chain, in order. A directory adds every font
+// file in it, sorted by name
+func Load(paths ...string) (Fonts, error) {
+	var fonts Fonts
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read font: %s", err)
+		}
+
+		files := []string{p}
+		if info.IsDir() {
+			files = nil
+			entries, err := ioutil.ReadDir(p)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read font directory: %s", err)
+			}
+			for _, e := range entries {
+				switch strings.ToLower(filepath.Ext(e.Name())) {
+				case ".ttf", ".otf", ".ttc", ".otc":
+					files = append(files, filepath.Join(p, e.Name()))
+				}
+			}
+		}
+
+		for _, file := range files {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read font: %s", err)
+			}
+			f, err := Parse(b)
+			if err != nil {
+				return nil, fmt.Errorf("%s, %s", err, file)
+			}
+			fonts = append(fonts, f...)
+		}
+	}
+	return fonts, nil
+}
@@ -0,0 +1,217 @@
+package typeset
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/go-text/typesetting/di"
+	"github.com/go-text/typesetting/shaping"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/text/unicode/bidi"
+)
+
+// Align the horizontal alignment of lines within a box
+type Align string
+
+// Values of Align, AlignStart aligns each line to the side its paragraph starts on, the left
+// for left to right text and the right for right to left text
+const (
+	AlignStart  Align = ""
+	AlignLeft   Align = "left"
+	AlignCenter Align = "center"
+	AlignRight  Align = "right"
+)
+
+// VAlign the vertical alignment of text within a box
+type VAlign string
+
+// Values of VAlign
+const (
+	VAlignTop    VAlign = "top"
+	VAlignMiddle VAlign = "middle"
+	VAlignBottom VAlign = "bottom"
+)
+
+// Text is text that has been shaped and wrapped into lines, ready to be measured and drawn
+type Text struct {
+	lines []line
+}
+
+type line struct {
+	// runs the shaped runs of the line, in visual order from left to right
+	runs    []shaping.Output
+	width   fixed.Int26_6
+	ascent  fixed.Int26_6
+	descent fixed.Int26_6
+	gap     fixed.Int26_6
+	rtl     bool
+}
+
+func (l line) height() fixed.Int26_6 {
+	return l.ascent + l.descent
+}
+
+// Layout shapes the text with the fonts at size pixels and wraps it into lines no wider than
+// maxWidth pixels. Lines are broken at newlines and between words, or between characters in
+// scripts such as Chinese and Japanese, a word wider than maxWidth is left on its own line.
+// A maxWidth of zero or less never wraps
+func Layout(text string, fonts Fonts, size float64, maxWidth int) (*Text, error) {
+	if len(fonts) == 0 {
+		return nil, fmt.Errorf("no fonts to lay out the text with")
+	}
+	if maxWidth <= 0 {
+		maxWidth = math.MaxInt32
+	}
+
+	var (
+		shaper  shaping.HarfbuzzShaper
+		seg     shaping.Segmenter
+		wrapper shaping.LineWrapper
+		t       Text
+	)
+	for _, para := range strings.Split(text, "\n") {
+		runes := []rune(para)
+		dir := paragraphDirection(runes)
+		if len(runes) == 0 {
+			t.lines = append(t.lines, emptyLine(fonts, size))
+			continue
+		}
+
+		input := shaping.Input{
+			Text:      runes,
+			RunEnd:    len(runes),
+			Direction: dir,
+			Size:      fixed.Int26_6(size * 64),
+		}
+		var runs []shaping.Output
+		for _, in := range seg.Split(input, fonts) {
+			runs = append(runs, shaper.Shape(in))
+		}
+
+		config := shaping.WrapConfig{Direction: dir, BreakPolicy: shaping.Never}
+		wrapped, _ := wrapper.WrapParagraph(config, maxWidth, runes, shaping.NewSliceIterator(runs))
+		for _, l := range wrapped {
+			t.lines = append(t.lines, newLine(l, dir))
+		}
+	}
+	return &t, nil
+}
+
+// Fit lays out the text at the largest size no bigger than size, in pixels, that fits in a
+// box of the given size. The size is reduced 10% at a time but never below minSize, so very
+// long text may still overflow the box
+func Fit(text string, fonts Fonts, size, minSize float64, box image.Point) (*Text, error) {
+	for {
+		t, err := Layout(text, fonts, size, box.X)
+		if err != nil {
+			return nil, err
+		}
+		if size <= minSize || t.Width() <= box.X && t.Height() <= box.Y {
+			return t, nil
+		}
+
+		size *= 0.9
+		if size < minSize {
+			size = minSize
+		}
+	}
+}
+
+// Width returns the width of the widest line in pixels
+func (t *Text) Width() int {
+	var w fixed.Int26_6
+	for _, l := range t.lines {
+		if l.width > w {
+			w = l.width
+		}
+	}
+	return w.Ceil()
+}
+
+// Height returns the height of all of the lines in pixels
+func (t *Text) Height() int {
+	var h fixed.Int26_6
+	for i, l := range t.lines {
+		h += l.height()
+		if i > 0 {
+			h += t.lines[i-1].gap
+		}
+	}
+	return h.Ceil()
+}
+
+// LineHeight returns the height of the first line in pixels, useful for scaling decorations
+// such as shadows and outlines with the text
+func (t *Text) LineHeight() int {
+	if len(t.lines) == 0 {
+		return 0
+	}
+	return t.lines[0].height().Ceil()
+}
+
+// Lines returns the number of lines the text was wrapped into
+func (t *Text) Lines() int {
+	return len(t.lines)
+}
+
+// Truncate removes any lines after the first n
+func (t *Text) Truncate(n int) {
+	if n >= 0 && n < len(t.lines) {
+		t.lines = t.lines[:n]
+	}
+}
+
+// newLine copies a wrapped line, the wrapper reuses its buffers
+func newLine(wrapped shaping.Line, dir di.Direction) line {
+	l := line{
+		runs: append([]shaping.Output(nil), wrapped...),
+		rtl:  dir.Progression() == di.TowardTopLeft,
+	}
+	sort.SliceStable(l.runs, func(i, j int) bool {
+		return l.runs[i].VisualIndex < l.runs[j].VisualIndex
+	})
+
+	for _, r := range l.runs {
+		l.width += r.Advance
+		if r.LineBounds.Ascent > l.ascent {
+			l.ascent = r.LineBounds.Ascent
+		}
+		if -r.LineBounds.Descent > l.descent {
+			l.descent = -r.LineBounds.Descent
+		}
+		if r.LineBounds.Gap > l.gap {
+			l.gap = r.LineBounds.Gap
+		}
+	}
+	return l
+}
+
+// emptyLine returns a blank line, as tall as a line of the first font
+func emptyLine(fonts Fonts, size float64) line {
+	extents, _ := fonts[0].FontHExtents()
+	scale := size / float64(fonts[0].Upem())
+	return line{
+		ascent:  fixed.Int26_6(float64(extents.Ascender) * scale * 64),
+		descent: fixed.Int26_6(-float64(extents.Descender) * scale * 64),
+		gap:     fixed.Int26_6(float64(extents.LineGap) * scale * 64),
+	}
+}
+
+// paragraphDirection returns the direction of the first strongly directional character,
+// so a paragraph of Arabic or Hebrew is laid out right to left even if it contains numbers
+// or Latin words
+func paragraphDirection(runes []rune) di.Direction {
+	for _, r := range runes {
+		props, _ := bidi.LookupRune(r)
+		switch props.Class() {
+		case bidi.L:
+			return di.DirectionLTR
+		case bidi.R, bidi.AL:
+			return di.DirectionRTL
+		}
+	}
+	return di.DirectionLTR
+}